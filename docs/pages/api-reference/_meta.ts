@@ -6,5 +6,6 @@ export default {
   mirrors: 'Mirrors',
   'mirror-control': 'Mirror Control',
   logs: 'Logs',
+  'dead-letter-queue': 'Dead-Letter Queue',
   health: 'Health',
 }
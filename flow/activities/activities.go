@@ -3,15 +3,20 @@ package activities
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 
+	"github.com/bunnydb/bunnydb/flow/connectors"
 	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
 	"github.com/bunnydb/bunnydb/flow/model"
 	"github.com/bunnydb/bunnydb/flow/shared"
@@ -78,6 +83,221 @@ func (a *Activities) WriteLog(ctx context.Context, mirrorName, level, message st
 	}
 }
 
+// ErrTypeApplyHalted is the application error type returned by SyncFlow when a
+// record fails under the halt policy
+const ErrTypeApplyHalted = "ApplyHalted"
+
+// applyHaltedError aborts a destination transaction under the halt policy
+type applyHaltedError struct {
+	rec *postgres.CDCRecord
+	err error
+}
+
+func (e *applyHaltedError) Error() string {
+	return fmt.Sprintf("%s on %s.%s at LSN %d failed: %v", e.rec.Operation, e.rec.Schema, e.rec.Table, e.rec.LSN, e.err)
+}
+
+func (e *applyHaltedError) Unwrap() error {
+	return e.err
+}
+
+// haltMirror records a failure under the halt policy and returns the
+// non-retryable error that makes the workflow pause the mirror. The failing
+// transaction was rolled back and not checkpointed, so it is applied again
+// on resume.
+func (a *Activities) haltMirror(ctx context.Context, mirrorName string, haltErr *applyHaltedError) error {
+	msg := fmt.Sprintf("mirror halted: %v", haltErr)
+
+	_, err := a.CatalogPool.Exec(ctx, `
+		UPDATE bunny_internal.mirror_state
+		SET status = 'PAUSED', error_message = $2, error_count = error_count + 1, last_error_at = NOW(), updated_at = NOW()
+		WHERE mirror_name = $1
+	`, mirrorName, msg)
+	if err != nil {
+		slog.Error("failed to update mirror state", slog.Any("error", err))
+	}
+
+	a.WriteLog(ctx, mirrorName, "ERROR", "Mirror halted on apply failure", map[string]interface{}{
+		"operation": haltErr.rec.Operation,
+		"table":     fmt.Sprintf("%s.%s", haltErr.rec.Schema, haltErr.rec.Table),
+		"lsn":       haltErr.rec.LSN,
+		"error":     haltErr.err.Error(),
+	})
+
+	return temporal.NewNonRetryableApplicationError(msg, ErrTypeApplyHalted, haltErr)
+}
+
+// deadLetter is a record that failed to apply, waiting to be written to the
+// dead-letter queue
+type deadLetter struct {
+	payload *postgres.RecordPayload
+	err     error
+}
+
+// writeDeadLetters stores failed records in the dead-letter queue
+func (a *Activities) writeDeadLetters(ctx context.Context, mirrorName string, letters []deadLetter) error {
+	for _, letter := range letters {
+		payloadJSON, err := json.Marshal(letter.payload)
+		if err != nil {
+			return fmt.Errorf("failed to encode dead-letter payload: %w", err)
+		}
+
+		_, err = a.CatalogPool.Exec(ctx, `
+			INSERT INTO bunny_internal.dead_letter_queue
+				(mirror_name, table_name, operation, lsn, commit_lsn, xid, payload, error_message)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, mirrorName, letter.payload.Schema+"."+letter.payload.Table, letter.payload.Operation,
+			letter.payload.LSN, letter.payload.CommitLSN, int64(letter.payload.XID), payloadJSON, letter.err.Error())
+		if err != nil {
+			return fmt.Errorf("failed to write dead-letter record: %w", err)
+		}
+	}
+
+	if len(letters) > 0 {
+		a.WriteLog(ctx, mirrorName, "WARN", "Records moved to dead-letter queue", map[string]interface{}{
+			"count": len(letters),
+		})
+	}
+	return nil
+}
+
+// recordSchemaDelta writes each change of a schema delta applied by CDC to
+// the schema deltas audit log, and warns of columns probably renamed
+func (a *Activities) recordSchemaDelta(ctx context.Context, mirrorName string, delta *postgres.SchemaDelta) {
+	type auditEntry struct {
+		deltaType string
+		info      map[string]interface{}
+	}
+
+	var entries []auditEntry
+	for _, col := range delta.AddedColumns {
+		entries = append(entries, auditEntry{"ADD_COLUMN", map[string]interface{}{
+			"column": col.Name,
+			"type":   col.Type,
+		}})
+	}
+	for _, change := range delta.TypeChanges {
+		entries = append(entries, auditEntry{"ALTER_COLUMN_TYPE", map[string]interface{}{
+			"column":   change.ColumnName,
+			"old_type": change.OldType,
+			"new_type": change.NewType,
+		}})
+	}
+	for _, name := range delta.DroppedColumns {
+		entries = append(entries, auditEntry{"DROP_COLUMN", map[string]interface{}{
+			"column": name,
+		}})
+	}
+
+	for _, entry := range entries {
+		entry.info["source_table"] = delta.SourceTable
+		infoJSON, _ := json.Marshal(entry.info)
+		_, err := a.CatalogPool.Exec(ctx, `
+			INSERT INTO bunny_stats.schema_deltas_audit_log (mirror_name, table_name, delta_type, delta_info)
+			VALUES ($1, $2, $3, $4)
+		`, mirrorName, delta.DestinationTable, entry.deltaType, infoJSON)
+		if err != nil {
+			slog.Error("failed to write schema delta audit log", slog.Any("error", err))
+		}
+	}
+
+	a.WriteLog(ctx, mirrorName, "INFO", "Schema change replicated", map[string]interface{}{
+		"table":           delta.DestinationTable,
+		"added_columns":   len(delta.AddedColumns),
+		"dropped_columns": len(delta.DroppedColumns),
+		"type_changes":    len(delta.TypeChanges),
+	})
+	for _, rename := range delta.LikelyRenames {
+		a.WriteLog(ctx, mirrorName, "WARN", "Column probably renamed on source; its values were dropped, resync the table to copy them", map[string]interface{}{
+			"table":          delta.DestinationTable,
+			"dropped_column": rename.OldName,
+			"added_column":   rename.NewName,
+		})
+	}
+}
+
+// relationLayout is a source table's column layout as stored in
+// bunny_internal.table_schema_mapping, with the LSN it took effect at
+type relationLayout struct {
+	LSN     int64                 `json:"lsn"`
+	Columns []postgres.ColumnInfo `json:"columns"`
+}
+
+// saveRelationLayout stores the column layout the destination table of a
+// source table matches from lsn on
+func (a *Activities) saveRelationLayout(ctx context.Context, mirrorName, table string, lsn int64, columns []postgres.ColumnInfo) {
+	layoutJSON, err := json.Marshal(relationLayout{LSN: lsn, Columns: columns})
+	if err != nil {
+		slog.Error("failed to encode relation layout", slog.Any("error", err))
+		return
+	}
+	_, err = a.CatalogPool.Exec(ctx, `
+		INSERT INTO bunny_internal.table_schema_mapping (mirror_name, table_name, table_schema)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (mirror_name, table_name) DO UPDATE
+		SET table_schema = EXCLUDED.table_schema, updated_at = NOW()
+	`, mirrorName, table, layoutJSON)
+	if err != nil {
+		slog.Error("failed to save relation layout", slog.String("table", table), slog.Any("error", err))
+	}
+}
+
+// relationBaseline returns the column layouts the reader diffs the first
+// Relation message of each table against. The layout stored when a column
+// change was last applied is used, unless the stream restarts before that
+// change, which is then replayed. Tables with no stored layout get the one
+// they are published with now, stored for the next start.
+func (a *Activities) relationBaseline(ctx context.Context, input *SyncInput, src connectors.Source) (map[string][]postgres.ColumnInfo, error) {
+	srcConn, ok := src.(*connectors.PostgresSource)
+	if !ok {
+		return nil, nil
+	}
+	published, err := srcConn.PublishedRelations(ctx, input.PublicationName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := a.CatalogPool.Query(ctx, `
+		SELECT table_name, table_schema
+		FROM bunny_internal.table_schema_mapping
+		WHERE mirror_name = $1
+	`, input.MirrorName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query relation layouts: %w", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string]relationLayout)
+	for rows.Next() {
+		var table string
+		var layoutJSON []byte
+		if err := rows.Scan(&table, &layoutJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan relation layout: %w", err)
+		}
+		var layout relationLayout
+		if err := json.Unmarshal(layoutJSON, &layout); err != nil {
+			return nil, fmt.Errorf("invalid relation layout of %s: %w", table, err)
+		}
+		stored[table] = layout
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate relation layouts: %w", err)
+	}
+
+	baseline := make(map[string][]postgres.ColumnInfo, len(published))
+	for table, columns := range published {
+		layout, ok := stored[table]
+		switch {
+		case !ok:
+			baseline[table] = columns
+			a.saveRelationLayout(ctx, input.MirrorName, table, input.LastLSN, columns)
+		case layout.LSN <= input.LastLSN:
+			baseline[table] = layout.Columns
+		}
+	}
+	return baseline, nil
+}
+
 // ============================================================================
 // Setup Activities
 // ============================================================================
@@ -90,6 +310,10 @@ type SetupInput struct {
 	TableMappings        []model.TableMapping
 	ReplicateIndexes     bool
 	ReplicateForeignKeys bool
+
+	// SetReplicaIdentityFull sets REPLICA IDENTITY FULL on tables that have
+	// no primary key or replica identity index, instead of only warning
+	SetReplicaIdentityFull bool
 }
 
 // SetupOutput is the output of SetupMirror
@@ -149,6 +373,7 @@ func (a *Activities) SetupMirror(ctx context.Context, input *SetupInput) (*Setup
 
 	// Build table list
 	var tables []string
+	var pubTables []postgres.PublicationTable
 	srcTableIDMapping := make(map[uint32]string)
 	for _, tm := range input.TableMappings {
 		tableName := tm.FullSourceName()
@@ -164,6 +389,43 @@ func (a *Activities) SetupMirror(ctx context.Context, input *SetupInput) (*Setup
 			return nil, fmt.Errorf("failed to get OID for table %s: %w", tableName, err)
 		}
 		srcTableIDMapping[oid] = tableName
+
+		// Without a replica identity the source rejects UPDATE/DELETE once
+		// the table is published
+		schema, err := srcConn.GetTableSchema(ctx, tm.SourceSchema, tm.SourceTable)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get schema for table %s: %w", tableName, err)
+		}
+		if !schema.SupportsUpdates() {
+			if input.SetReplicaIdentityFull {
+				if err := srcConn.SetReplicaIdentityFull(ctx, tm.SourceSchema, tm.SourceTable); err != nil {
+					a.WriteLog(ctx, input.MirrorName, "ERROR", "Failed to set replica identity full", map[string]interface{}{
+						"error": err.Error(),
+						"table": tableName,
+					})
+					return nil, err
+				}
+				schema.ReplicaIdentity = postgres.ReplicaIdentityFull
+				a.WriteLog(ctx, input.MirrorName, "INFO", "Set replica identity full on table without primary key", map[string]interface{}{
+					"table": tableName,
+				})
+			} else {
+				a.WriteLog(ctx, input.MirrorName, "WARN", "Table has no primary key or replica identity; UPDATE and DELETE on the source will fail", map[string]interface{}{
+					"table":            tableName,
+					"replica_identity": schema.ReplicaIdentity,
+				})
+			}
+		}
+
+		pubTable, err := srcConn.PublicationTableFor(ctx, schema, tm.ExcludeColumns, tm.RowFilter)
+		if err != nil {
+			a.WriteLog(ctx, input.MirrorName, "ERROR", "Invalid excluded columns or row filter", map[string]interface{}{
+				"error": err.Error(),
+				"table": tableName,
+			})
+			return nil, fmt.Errorf("invalid table mapping for %s: %w", tableName, err)
+		}
+		pubTables = append(pubTables, pubTable)
 	}
 
 	// Sanitize mirror name for use in PostgreSQL identifiers (no hyphens allowed)
@@ -175,7 +437,7 @@ func (a *Activities) SetupMirror(ctx context.Context, input *SetupInput) (*Setup
 
 	// Create publication
 	publicationName := fmt.Sprintf("bunny_pub_%s", safeName)
-	if err := srcConn.CreatePublication(ctx, publicationName, tables); err != nil {
+	if err := srcConn.CreatePublication(ctx, publicationName, pubTables); err != nil {
 		a.WriteLog(ctx, input.MirrorName, "ERROR", "Failed to create publication", map[string]interface{}{
 			"error":       err.Error(),
 			"publication": publicationName,
@@ -234,15 +496,23 @@ func (a *Activities) SetupMirror(ctx context.Context, input *SetupInput) (*Setup
 
 // SyncInput is the input for SyncFlow
 type SyncInput struct {
-	MirrorName      string
-	SourcePeer      string
-	DestinationPeer string
-	SlotName        string
-	PublicationName string
-	LastLSN         int64
-	BatchSize       uint32
-	IdleTimeout     uint64
-	TableMappings   []model.TableMapping
+	MirrorName              string
+	SourcePeer              string
+	DestinationPeer         string
+	SlotName                string
+	PublicationName         string
+	LastLSN                 int64
+	BatchSize               uint32
+	IdleTimeout             uint64
+	TableMappings           []model.TableMapping
+	IgnoreTruncate          bool
+	StreamLargeTransactions bool
+	BinaryFormat            bool
+	ApplyErrorPolicy        model.ApplyErrorPolicy
+	BulkApply               bool
+	ApplyWorkers            uint32
+	SoftDeleteColName       string
+	SyncedAtColName         string
 }
 
 // SyncOutput is the output of SyncFlow
@@ -259,61 +529,149 @@ func (a *Activities) SyncFlow(ctx context.Context, input *SyncInput) (*SyncOutpu
 		slog.String("slot", input.SlotName),
 		slog.String("publication", input.PublicationName))
 
-	// Get peer configs
-	srcConfig, err := a.getPeerConfig(ctx, input.SourcePeer)
+	// Connect to source and start replication
+	src, err := a.connectSource(ctx, input.SourcePeer)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get source peer config: %w", err)
+		return nil, fmt.Errorf("failed to connect to source: %w", err)
 	}
+	defer src.Close()
 
-	dstConfig, err := a.getPeerConfig(ctx, input.DestinationPeer)
+	relations, err := a.relationBaseline(ctx, input, src)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get destination peer config: %w", err)
+		return nil, err
 	}
 
-	// Connect to source for replication
-	srcConn, err := postgres.NewPostgresConnector(ctx, srcConfig)
+	replOpts := postgres.ReplicationOptions{
+		StreamInProgress: input.StreamLargeTransactions,
+		Binary:           input.BinaryFormat,
+		Relations:        relations,
+	}
+	cdcReader, err := src.OpenChangeStream(ctx, input.SlotName, input.PublicationName, input.LastLSN, replOpts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to source: %w", err)
+		return nil, err
 	}
-	defer srcConn.Close()
+	defer cdcReader.Close()
 
-	// Setup replication connection
-	if err := srcConn.SetupReplConn(ctx); err != nil {
-		return nil, fmt.Errorf("failed to setup replication connection: %w", err)
+	// Connect to destination
+	dst, err := a.connectDestination(ctx, input.DestinationPeer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to destination: %w", err)
 	}
+	defer dst.Close()
 
-	// Get PG version for proper protocol handling
-	if _, err := srcConn.GetPGVersion(ctx); err != nil {
-		logger.Warn("failed to get PG version", slog.Any("error", err))
-	}
+	// Changelog and history tables, the error policy, bulk apply and apply
+	// workers are features of Postgres destinations. Other destinations
+	// take each batch whole through ApplyChanges.
+	dstConn, _ := dst.(*postgres.PostgresConnector)
 
-	// Start replication
-	if err := srcConn.StartReplication(ctx, input.SlotName, input.PublicationName, input.LastLSN); err != nil {
-		return nil, fmt.Errorf("failed to start replication: %w", err)
+	// Publications only filter rows on PG15+; older sources are filtered here
+	filterRows := false
+	var typeMap *pgtype.Map
+	srcConn, postgresSrc := src.(*connectors.PostgresSource)
+	if postgresSrc {
+		version, _ := srcConn.GetPGVersion(ctx)
+		filterRows = version < shared.POSTGRES_15
+		typeMap = srcConn.Conn().TypeMap()
 	}
 
-	// Connect to destination
-	dstConn, err := postgres.NewPostgresConnector(ctx, dstConfig)
+	copyLSNs, err := a.copyLSNs(ctx, input.MirrorName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to destination: %w", err)
+		return nil, err
 	}
-	defer dstConn.Close()
 
-	// Build table to PK columns mapping
-	tablePKs := make(map[string][]string)
+	// Build source table to destination table and PK columns mapping
+	applyTargets := make(map[string]*postgres.ApplyTarget)
+	rowFilters := make(map[string]*postgres.RowFilter)
+	transforms := make(map[string]*tableTransform)
 	for _, tm := range input.TableMappings {
-		schema, err := srcConn.GetTableSchema(ctx, tm.SourceSchema, tm.SourceTable)
+		if dstConn == nil && !tm.MirrorsRows() {
+			return nil, fmt.Errorf("%s table %s needs a Postgres destination", tm.Mode, tm.FullDestinationName())
+		}
+
+		target := &postgres.ApplyTarget{
+			Schema:           tm.DestinationSchema,
+			Table:            tm.DestinationTable,
+			SoftDeleteColumn: input.SoftDeleteColName,
+			SyncedAtColumn:   input.SyncedAtColName,
+			CopyLSN:          copyLSNs[tm.FullDestinationName()],
+		}
+		applyTargets[tm.FullSourceName()] = target
+
+		// Changelog tables are created here, as the snapshot copies no rows
+		// into them
+		if tm.Mode == model.TableModeChangelog {
+			target.SoftDeleteColumn = ""
+			target.SyncedAtColumn = ""
+			target.Changelog = true
+			if err := dstConn.EnsureSchemaExists(ctx, tm.DestinationSchema); err != nil {
+				return nil, fmt.Errorf("failed to create destination schema: %w", err)
+			}
+			if err := dstConn.CreateChangelogTable(ctx, tm.DestinationSchema, tm.DestinationTable); err != nil {
+				return nil, err
+			}
+		}
+		if tm.Mode == model.TableModeHistory {
+			target.SoftDeleteColumn = ""
+			target.History = true
+		}
+
+		schema, err := src.GetTableSchema(ctx, tm.SourceSchema, tm.SourceTable)
 		if err != nil {
+			if filterRows && tm.RowFilter != "" {
+				return nil, fmt.Errorf("failed to get table schema for row filter of %s: %w", tm.FullSourceName(), err)
+			}
+			if len(tm.ColumnTransforms) > 0 {
+				return nil, fmt.Errorf("failed to get table schema for column transforms of %s: %w", tm.FullSourceName(), err)
+			}
 			logger.Warn("failed to get table schema",
 				slog.String("table", tm.FullSourceName()),
 				slog.Any("error", err))
 			continue
 		}
-		tablePKs[tm.FullSourceName()] = schema.PrimaryKeyColumns
+		target.PKColumns = schema.ReplicaIdentityColumns
+		if len(target.PKColumns) == 0 {
+			target.PKColumns = schema.PrimaryKeyColumns
+		}
+		target.IdentityFull = schema.IsReplicaIdentityFull
+		if target.History {
+			// Versions are keyed by the primary key the table was created with
+			target.PKColumns = schema.PrimaryKeyColumns
+		}
+
+		if filterRows && tm.RowFilter != "" {
+			rowFilters[tm.FullSourceName()] = srcConn.NewRowFilter(schema, tm.RowFilter)
+		}
+
+		// Other destinations may be written to without a snapshot first
+		if dstConn == nil {
+			destSchema, err := destinationSchema(schema, &tm)
+			if err != nil {
+				return nil, err
+			}
+			mirrorCols := mirrorColumns(&tm, input.SoftDeleteColName, input.SyncedAtColName)
+			if err := dst.EnsureTable(ctx, destSchema, tm.DestinationSchema, tm.DestinationTable, mirrorCols); err != nil {
+				return nil, fmt.Errorf("failed to create destination table: %w", err)
+			}
+		}
+
+		// Binary values are masked in their text form, as in the snapshot
+		transform, err := newTableTransform(schema, tm.ColumnTransforms, a.Config.TransformSecret, typeMap)
+		if err != nil {
+			return nil, err
+		}
+		if transform != nil {
+			transforms[tm.FullSourceName()] = transform
+		}
 	}
 
-	// Create CDC reader
-	cdcReader := postgres.NewCDCReader(srcConn)
+	prep := &recordPrep{
+		logger:         logger,
+		ignoreTruncate: input.IgnoreTruncate,
+		rowFilters:     rowFilters,
+		excluded:       excludedColumns(input.TableMappings),
+		transforms:     transforms,
+	}
+	partitions := postgres.NewChangelogPartitions()
 
 	// Heartbeat and sync configuration
 	batchSize := 1000
@@ -326,13 +684,16 @@ func (a *Activities) SyncFlow(ctx context.Context, input *SyncInput) (*SyncOutpu
 		idleTimeout = time.Duration(input.IdleTimeout) * time.Second
 	}
 
+	errorPolicy := input.ApplyErrorPolicy
+	if errorPolicy == "" {
+		errorPolicy = model.ApplyErrorPolicyDLQ
+	}
+
 	lastLSN := input.LastLSN
 	lastHeartbeat := time.Now()
 	recordsProcessed := int64(0)
 	batchID := int64(0)
-	tableRowCounts := make(map[string]int64)    // Track total rows per table
-	tableInsertCounts := make(map[string]int64) // Track inserts per table
-	tableUpdateCounts := make(map[string]int64) // Track updates per table
+	counts := newSyncCounts()
 
 	logger.Info("CDC sync loop started",
 		slog.Int("batchSize", batchSize),
@@ -352,6 +713,66 @@ func (a *Activities) SyncFlow(ctx context.Context, input *SyncInput) (*SyncOutpu
 	// Send initial heartbeat immediately
 	activity.RecordHeartbeat(ctx, fmt.Sprintf("starting CDC sync: LSN=%d", lastLSN))
 
+	if dstConn == nil && (input.ApplyWorkers > 1 || input.BulkApply) {
+		logger.Warn("bulk apply and apply workers need a Postgres destination, applying batches whole")
+	}
+
+	// With several apply workers, reading and applying run as a pipeline
+	if input.ApplyWorkers > 1 && dstConn != nil {
+		dstConfig, err := a.getPeerConfig(ctx, input.DestinationPeer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get destination peer config: %w", err)
+		}
+		dstConns := []*postgres.PostgresConnector{dstConn}
+		for len(dstConns) < int(input.ApplyWorkers) {
+			conn, err := postgres.NewPostgresConnector(ctx, dstConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to connect to destination: %w", err)
+			}
+			defer conn.Close()
+			dstConns = append(dstConns, conn)
+		}
+
+		pipeline := &applyPipeline{
+			a:           a,
+			input:       input,
+			logger:      logger,
+			cdcReader:   cdcReader,
+			dstConns:    dstConns,
+			targets:     applyTargets,
+			batchSize:   batchSize,
+			errorPolicy: errorPolicy,
+			typeMap:     typeMap,
+			prep:        prep,
+			partitions:  partitions,
+		}
+		return pipeline.run(ctx, lastLSN, batchID)
+	}
+
+	buffered, _ := dst.(connectors.BufferingDestination)
+	checkpoint := func(newLSN int64) {
+		if newLSN <= lastLSN {
+			return
+		}
+		lastLSN = newLSN
+		batchID++
+
+		// Offsets of destinations such as logs are kept with the LSN they
+		// were written up to
+		var offsets map[string]int64
+		if od, ok := dst.(connectors.OffsetDestination); ok {
+			offsets = od.Offsets()
+		}
+		if err := a.saveCheckpoint(ctx, input.MirrorName, lastLSN, batchID, offsets); err != nil {
+			// Keep the slot where it is; the batch is replayed if we crash
+			logger.Warn("failed to update mirror checkpoint", slog.Any("error", err))
+		} else {
+			// Destination commit and catalog checkpoint are both durable,
+			// so the source may now release WAL up to this point
+			cdcReader.AckLSN(lastLSN)
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -368,21 +789,24 @@ func (a *Activities) SyncFlow(ctx context.Context, input *SyncInput) (*SyncOutpu
 			lastHeartbeat = time.Now()
 		}
 
-		// Pull records from replication stream (with short timeout to allow heartbeats)
-		records, newLSN, err := cdcReader.PullRecords(ctx, batchSize, 3*time.Second)
+		// Pull committed transactions from replication stream (with short timeout to allow heartbeats)
+		txns, newLSN, err := cdcReader.PullRecords(ctx, batchSize, 3*time.Second)
 		if err != nil {
 			if ctx.Err() != nil {
 				return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, ctx.Err()
 			}
-			errMsg := err.Error()
-			// Connection closed errors are fatal (slot dropped or signal-triggered cancellation)
-			if strings.Contains(errMsg, "conn closed") || strings.Contains(errMsg, "connection reset") ||
-				strings.Contains(errMsg, "use of closed network connection") {
+			if isReplicationConnLost(err) {
 				logger.Info("replication connection closed, stopping sync",
 					slog.Int64("lastLSN", lastLSN),
 					slog.Int64("recordsProcessed", recordsProcessed))
 				return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, fmt.Errorf("replication connection lost: %w", err)
 			}
+			// The reader cannot go on past a change it failed to read; the
+			// retry restarts replication from the checkpoint
+			var streamErr *postgres.StreamError
+			if errors.As(err, &streamErr) {
+				return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, err
+			}
 			logger.Error("failed to pull records", slog.Any("error", err))
 			// Send heartbeat on error to stay alive
 			activity.RecordHeartbeat(ctx, fmt.Sprintf("error pulling records, retrying: %v", err))
@@ -396,7 +820,23 @@ func (a *Activities) SyncFlow(ctx context.Context, input *SyncInput) (*SyncOutpu
 			continue
 		}
 
-		if len(records) == 0 {
+		numRecords := 0
+		for _, txn := range txns {
+			numRecords += txn.NumRecords()
+		}
+
+		if len(txns) == 0 {
+			// Send what a buffering destination held back once it is due
+			if buffered != nil {
+				if err := buffered.Flush(ctx, false); err != nil {
+					return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, fmt.Errorf("failed to flush destination: %w", err)
+				}
+				if err := a.handleRejected(ctx, input.MirrorName, logger, dst, errorPolicy, typeMap); err != nil {
+					return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, err
+				}
+				checkpoint(buffered.FlushedLSN())
+			}
+
 			// No records - still send heartbeat to stay alive
 			if time.Since(lastHeartbeat) > 5*time.Second {
 				activity.RecordHeartbeat(ctx, fmt.Sprintf("waiting for changes: LSN=%d", lastLSN))
@@ -405,89 +845,86 @@ func (a *Activities) SyncFlow(ctx context.Context, input *SyncInput) (*SyncOutpu
 			continue
 		}
 
-		// Apply records to destination
-		for i, rec := range records {
-			tableKey := fmt.Sprintf("%s.%s", rec.Schema, rec.Table)
-			pkCols := tablePKs[tableKey]
+		if err := prep.prepare(ctx, txns); err != nil {
+			return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, err
+		}
 
-			if err := postgres.ApplyRecord(ctx, dstConn, rec, pkCols); err != nil {
-				logger.Error("failed to apply record",
-					slog.String("operation", rec.Operation),
-					slog.String("table", tableKey),
-					slog.Any("error", err))
-				// Continue with next record - don't fail entire sync
-				continue
+		// failed collects the records skipped under the error policy. The
+		// records of spooled transactions are counted as each chunk is applied.
+		failed := make(map[*postgres.CDCRecord]bool)
+		applied := 0
+		prep.prepareChunks(ctx, txns, func(chunk *postgres.CDCTransaction) {
+			recordsProcessed += a.countApplied(ctx, input.MirrorName, chunk, failed, counts)
+			applied += len(chunk.Records)
+			if time.Since(lastHeartbeat) > 10*time.Second {
+				activity.RecordHeartbeat(ctx, fmt.Sprintf("applying streamed transaction %d: %d/%d records, LSN=%d",
+					chunk.XID, applied, numRecords, lastLSN))
+				lastHeartbeat = time.Now()
 			}
+		})
 
-			recordsProcessed++
-			tableRowCounts[tableKey]++
+		// In bulk mode the whole batch is applied at once. If that fails, it is
+		// applied again transaction by transaction below, so that the failing
+		// records go through the error policy. Other destinations always take
+		// the whole batch, and a batch they fail is replayed on restart.
+		batchApplied := false
+		if dstConn == nil {
+			if err := dst.ApplyChanges(ctx, txns, applyTargets); err != nil {
+				return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, fmt.Errorf("failed to apply batch: %w", err)
+			}
+			batchApplied = true
+		} else {
+			if err := partitions.Ensure(ctx, dstConn, txns, applyTargets); err != nil {
+				return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, err
+			}
+			if input.BulkApply && !postgres.HasSpooled(txns) {
+				if err := postgres.ApplyBatch(ctx, dstConn, txns, applyTargets, batchSize); err != nil {
+					logger.Warn("bulk apply failed, applying transactions one by one", slog.Any("error", err))
+				} else {
+					batchApplied = true
+				}
+			}
+		}
 
-			// Track inserts vs updates
-			switch rec.Operation {
-			case "INSERT":
-				tableInsertCounts[tableKey]++
-			case "UPDATE":
-				tableUpdateCounts[tableKey]++
+		// Apply each source transaction to destination in its own transaction
+		for _, txn := range txns {
+			if !batchApplied {
+				err := a.applySourceTransaction(ctx, input.MirrorName, logger, dstConn, txn, applyTargets, errorPolicy, typeMap, failed)
+				if err != nil {
+					return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, err
+				}
 			}
 
-			if recordsProcessed%100 == 0 {
+			before := recordsProcessed
+			recordsProcessed += a.countApplied(ctx, input.MirrorName, txn, failed, counts)
+			applied += len(txn.Records)
+			if recordsProcessed/100 != before/100 {
 				logger.Debug("CDC progress",
 					slog.Int64("records", recordsProcessed),
-					slog.Int64("lsn", rec.LSN))
+					slog.Int64("lsn", txn.CommitLSN))
 			}
 
 			// Send heartbeat during large batch applies to avoid timeout
 			if time.Since(lastHeartbeat) > 10*time.Second {
-				activity.RecordHeartbeat(ctx, fmt.Sprintf("applying batch: %d/%d records, LSN=%d", i+1, len(records), lastLSN))
+				activity.RecordHeartbeat(ctx, fmt.Sprintf("applying batch: %d/%d records, LSN=%d", applied, numRecords, lastLSN))
 				lastHeartbeat = time.Now()
 			}
 		}
 
 		// Update table sync status periodically (every batch)
-		if len(records) > 0 {
-			for tableName, rowCount := range tableRowCounts {
-				insertCount := tableInsertCounts[tableName]
-				updateCount := tableUpdateCounts[tableName]
-				_, err := a.CatalogPool.Exec(ctx, `
-					INSERT INTO bunny_stats.table_sync_status (mirror_name, table_name, status, rows_synced, rows_inserted, rows_updated, last_synced_at)
-					VALUES ($1, $2, 'RUNNING', $3, $4, $5, NOW())
-					ON CONFLICT (mirror_name, table_name) DO UPDATE SET
-						status = 'RUNNING',
-						rows_synced = bunny_stats.table_sync_status.rows_synced + $3,
-						rows_inserted = COALESCE(bunny_stats.table_sync_status.rows_inserted, 0) + $4,
-						rows_updated = COALESCE(bunny_stats.table_sync_status.rows_updated, 0) + $5,
-						last_synced_at = NOW(),
-						updated_at = NOW()
-				`, input.MirrorName, tableName, rowCount, insertCount, updateCount)
-				if err != nil {
-					logger.Warn("failed to update table sync status", slog.String("table", tableName), slog.Any("error", err))
-				}
-			}
-			// Reset counts after updating
-			tableRowCounts = make(map[string]int64)
-			tableInsertCounts = make(map[string]int64)
-			tableUpdateCounts = make(map[string]int64)
-		}
-
-		// Update LSN and batch ID
-		if newLSN > lastLSN {
-			lastLSN = newLSN
-			batchID++
-			srcConn.UpdateLastOffset(lastLSN)
-
-			// Update checkpoint and status in mirror_state
-			_, err := a.CatalogPool.Exec(ctx, `
-				UPDATE bunny_internal.mirror_state
-				SET last_lsn = $2,
-				    last_sync_batch_id = $3,
-				    status = 'RUNNING',
-				    updated_at = NOW()
-				WHERE mirror_name = $1
-			`, input.MirrorName, lastLSN, batchID)
-			if err != nil {
-				logger.Warn("failed to update mirror checkpoint", slog.Any("error", err))
+		if numRecords > 0 {
+			a.flushSyncCounts(ctx, input.MirrorName, logger, counts)
+		}
+
+		// Update LSN and batch ID. Buffering destinations are checkpointed
+		// only up to the changes they have written.
+		if buffered != nil {
+			if err := a.handleRejected(ctx, input.MirrorName, logger, dst, errorPolicy, typeMap); err != nil {
+				return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, err
 			}
+			newLSN = buffered.FlushedLSN()
 		}
+		checkpoint(newLSN)
 
 		// Heartbeat after batch processing + DB updates
 		if time.Since(lastHeartbeat) > 10*time.Second {
@@ -496,16 +933,18 @@ func (a *Activities) SyncFlow(ctx context.Context, input *SyncInput) (*SyncOutpu
 		}
 
 		// Log batch completion
-		if len(records) > 0 {
+		if numRecords > 0 {
 			logger.Info("batch processed",
-				slog.Int("records", len(records)),
+				slog.Int("transactions", len(txns)),
+				slog.Int("records", numRecords),
 				slog.Int64("lastLSN", lastLSN),
 				slog.Int64("batchID", batchID),
 				slog.Int64("totalProcessed", recordsProcessed))
 
 			// Write to mirror logs
 			a.WriteLog(ctx, input.MirrorName, "DEBUG", "CDC batch processed", map[string]interface{}{
-				"records":        len(records),
+				"transactions":   len(txns),
+				"records":        numRecords,
 				"lastLSN":        lastLSN,
 				"totalProcessed": recordsProcessed,
 			})
@@ -513,6 +952,322 @@ func (a *Activities) SyncFlow(ctx context.Context, input *SyncInput) (*SyncOutpu
 	}
 }
 
+// isReplicationConnLost reports whether a pull failed because the
+// replication connection is gone (slot dropped or signal-triggered
+// cancellation), which is fatal for the sync flow
+func isReplicationConnLost(err error) bool {
+	errMsg := err.Error()
+	return strings.Contains(errMsg, "conn closed") || strings.Contains(errMsg, "connection reset") ||
+		strings.Contains(errMsg, "use of closed network connection")
+}
+
+// recordPrep drops, filters and transforms pulled records before they are
+// applied
+type recordPrep struct {
+	logger         *slog.Logger
+	ignoreTruncate bool
+	rowFilters     map[string]*postgres.RowFilter
+	excluded       map[string]map[string]bool // Excluded columns per source table
+	transforms     map[string]*tableTransform
+}
+
+// prepare prepares the records of a batch. The row filters query the
+// source, so nothing else may use the source connection meanwhile.
+func (p *recordPrep) prepare(ctx context.Context, txns []*postgres.CDCTransaction) error {
+	// Drop truncates for mirrors that keep the destination as an archive
+	if p.ignoreTruncate {
+		dropTruncates(p.logger, txns)
+	}
+	if err := postgres.FilterRows(ctx, txns, p.rowFilters); err != nil {
+		return err
+	}
+	dropExcludedColumns(txns, p.excluded)
+	return transformRecords(txns, p.transforms)
+}
+
+// prepareChunks sets up the spooled transactions of a batch, whose records
+// are only read back while they are applied, to be prepared a chunk at a
+// time. applied is called with each chunk once it has been applied.
+func (p *recordPrep) prepareChunks(ctx context.Context, txns []*postgres.CDCTransaction, applied func(chunk *postgres.CDCTransaction)) {
+	for _, txn := range txns {
+		if !txn.Spooled() {
+			continue
+		}
+		txn.PrepareChunk = func(chunk *postgres.CDCTransaction) error {
+			return p.prepare(ctx, []*postgres.CDCTransaction{chunk})
+		}
+		txn.ChunkApplied = applied
+	}
+}
+
+// dropTruncates removes TRUNCATE records, for mirrors that keep the
+// destination as an archive
+func dropTruncates(logger *slog.Logger, txns []*postgres.CDCTransaction) {
+	for _, txn := range txns {
+		kept := txn.Records[:0]
+		for _, rec := range txn.Records {
+			if rec.Operation == "TRUNCATE" {
+				logger.Info("ignoring truncate", slog.String("table", fmt.Sprintf("%s.%s", rec.Schema, rec.Table)))
+				continue
+			}
+			kept = append(kept, rec)
+		}
+		txn.Records = kept
+	}
+}
+
+// mirrorColumns returns the columns a mirror adds to the destination table of
+// a mapping. History tables close versions instead of soft deleting rows.
+func mirrorColumns(tm *model.TableMapping, softDelete, syncedAt string) postgres.MirrorColumns {
+	if tm.Mode == model.TableModeHistory {
+		return postgres.MirrorColumns{SyncedAt: syncedAt, History: true}
+	}
+	return postgres.MirrorColumns{SoftDelete: softDelete, SyncedAt: syncedAt}
+}
+
+// excludedColumns returns the excluded columns of each source table
+func excludedColumns(mappings []model.TableMapping) map[string]map[string]bool {
+	excluded := make(map[string]map[string]bool)
+	for _, tm := range mappings {
+		if len(tm.ExcludeColumns) == 0 {
+			continue
+		}
+		cols := make(map[string]bool, len(tm.ExcludeColumns))
+		for _, col := range tm.ExcludeColumns {
+			cols[col] = true
+		}
+		excluded[tm.FullSourceName()] = cols
+	}
+	return excluded
+}
+
+// dropExcludedColumns removes excluded columns from the records, for sources
+// that cannot leave them out of the publication
+func dropExcludedColumns(txns []*postgres.CDCTransaction, excluded map[string]map[string]bool) {
+	if len(excluded) == 0 {
+		return
+	}
+	for _, txn := range txns {
+		for _, rec := range txn.Records {
+			if cols, ok := excluded[rec.Schema+"."+rec.Table]; ok {
+				rec.DropColumns(cols)
+			}
+		}
+	}
+}
+
+// applySourceTransaction applies one source transaction under the mirror's
+// error policy and adds the records that failed and were skipped to failed.
+// Records for the dead-letter queue are written before it returns, so the
+// checkpoint may move past them.
+func (a *Activities) applySourceTransaction(
+	ctx context.Context,
+	mirrorName string,
+	logger *slog.Logger,
+	dstConn *postgres.PostgresConnector,
+	txn *postgres.CDCTransaction,
+	targets map[string]*postgres.ApplyTarget,
+	errorPolicy model.ApplyErrorPolicy,
+	typeMap *pgtype.Map,
+	failed map[*postgres.CDCRecord]bool,
+) error {
+	var deadLetters []deadLetter
+	err := postgres.ApplyTransaction(ctx, dstConn, txn, targets, func(rec *postgres.CDCRecord, target *postgres.ApplyTarget, err error) error {
+		logger.Error("failed to apply record",
+			slog.String("operation", rec.Operation),
+			slog.String("table", fmt.Sprintf("%s.%s", rec.Schema, rec.Table)),
+			slog.Uint64("xid", uint64(txn.XID)),
+			slog.String("policy", string(errorPolicy)),
+			slog.Any("error", err))
+
+		switch errorPolicy {
+		case model.ApplyErrorPolicyHalt:
+			return &applyHaltedError{rec: rec, err: err}
+		case model.ApplyErrorPolicyDLQ:
+			payload, perr := postgres.NewRecordPayload(rec, target, typeMap)
+			if perr != nil {
+				return fmt.Errorf("failed to keep record for the dead-letter queue: %w", perr)
+			}
+			deadLetters = append(deadLetters, deadLetter{payload: payload, err: err})
+		}
+		failed[rec] = true
+		return nil
+	})
+	var haltErr *applyHaltedError
+	if errors.As(err, &haltErr) {
+		return a.haltMirror(ctx, mirrorName, haltErr)
+	}
+	if err != nil {
+		// The destination transaction was rolled back; stop before moving the
+		// checkpoint past it so it is replayed on restart
+		return fmt.Errorf("failed to apply transaction %d: %w", txn.XID, err)
+	}
+
+	// The records must be in the dead-letter queue before the checkpoint
+	// moves past them, or they would be lost
+	return a.writeDeadLetters(ctx, mirrorName, deadLetters)
+}
+
+// handleRejected puts the changes a destination refused for good through
+// the mirror's error policy. Like failed records of Postgres destinations,
+// they must be in the dead-letter queue before the checkpoint moves past
+// them.
+func (a *Activities) handleRejected(
+	ctx context.Context,
+	mirrorName string,
+	logger *slog.Logger,
+	dst connectors.Destination,
+	errorPolicy model.ApplyErrorPolicy,
+	typeMap *pgtype.Map,
+) error {
+	rd, ok := dst.(connectors.RejectingDestination)
+	if !ok {
+		return nil
+	}
+	rejected := rd.TakeRejected()
+	if len(rejected) == 0 {
+		return nil
+	}
+
+	var deadLetters []deadLetter
+	for _, rc := range rejected {
+		logger.Error("destination rejected record",
+			slog.String("operation", rc.Record.Operation),
+			slog.String("table", fmt.Sprintf("%s.%s", rc.Record.Schema, rc.Record.Table)),
+			slog.Uint64("xid", uint64(rc.Record.XID)),
+			slog.String("policy", string(errorPolicy)),
+			slog.Any("error", rc.Err))
+
+		switch errorPolicy {
+		case model.ApplyErrorPolicyHalt:
+			return a.haltMirror(ctx, mirrorName, &applyHaltedError{rec: rc.Record, err: rc.Err})
+		case model.ApplyErrorPolicyDLQ:
+			payload, err := postgres.NewRecordPayload(rc.Record, rc.Target, typeMap)
+			if err != nil {
+				return fmt.Errorf("failed to keep record for the dead-letter queue: %w", err)
+			}
+			deadLetters = append(deadLetters, deadLetter{payload: payload, err: rc.Err})
+		}
+	}
+	return a.writeDeadLetters(ctx, mirrorName, deadLetters)
+}
+
+// syncCounts tracks the rows applied per table since the last update of
+// the table sync status
+type syncCounts struct {
+	rows    map[string]int64
+	inserts map[string]int64
+	updates map[string]int64
+}
+
+func newSyncCounts() *syncCounts {
+	return &syncCounts{
+		rows:    make(map[string]int64),
+		inserts: make(map[string]int64),
+		updates: make(map[string]int64),
+	}
+}
+
+// add adds other counts to these
+func (c *syncCounts) add(other *syncCounts) {
+	for table, n := range other.rows {
+		c.rows[table] += n
+	}
+	for table, n := range other.inserts {
+		c.inserts[table] += n
+	}
+	for table, n := range other.updates {
+		c.updates[table] += n
+	}
+}
+
+// countApplied adds the applied records of a transaction to the counts and
+// returns how many rows were applied. Schema changes and truncates are
+// written to the mirror logs instead.
+func (a *Activities) countApplied(
+	ctx context.Context,
+	mirrorName string,
+	txn *postgres.CDCTransaction,
+	failed map[*postgres.CDCRecord]bool,
+	counts *syncCounts,
+) int64 {
+	var processed int64
+	for _, rec := range txn.Records {
+		if failed[rec] {
+			continue
+		}
+
+		tableKey := fmt.Sprintf("%s.%s", rec.Schema, rec.Table)
+		if rec.Operation == "SCHEMA" {
+			a.recordSchemaDelta(ctx, mirrorName, rec.SchemaDelta)
+			if cols := rec.SchemaDelta.SourceColumns; len(cols) > 0 {
+				a.saveRelationLayout(ctx, mirrorName, tableKey, rec.LSN, cols)
+			}
+			continue
+		}
+		processed++
+		counts.rows[tableKey]++
+
+		// Track inserts vs updates
+		switch rec.Operation {
+		case "INSERT":
+			counts.inserts[tableKey]++
+		case "UPDATE":
+			counts.updates[tableKey]++
+		case "TRUNCATE":
+			a.WriteLog(ctx, mirrorName, "INFO", "Truncate replicated", map[string]interface{}{
+				"table":            tableKey,
+				"cascade":          rec.TruncateCascade,
+				"restart_identity": rec.TruncateRestartIdentity,
+			})
+		}
+	}
+	return processed
+}
+
+// flushSyncCounts adds the counts to the table sync status and resets them
+func (a *Activities) flushSyncCounts(ctx context.Context, mirrorName string, logger *slog.Logger, counts *syncCounts) {
+	for tableName, rowCount := range counts.rows {
+		_, err := a.CatalogPool.Exec(ctx, `
+			INSERT INTO bunny_stats.table_sync_status (mirror_name, table_name, status, rows_synced, rows_inserted, rows_updated, last_synced_at)
+			VALUES ($1, $2, 'RUNNING', $3, $4, $5, NOW())
+			ON CONFLICT (mirror_name, table_name) DO UPDATE SET
+				status = 'RUNNING',
+				rows_synced = bunny_stats.table_sync_status.rows_synced + $3,
+				rows_inserted = COALESCE(bunny_stats.table_sync_status.rows_inserted, 0) + $4,
+				rows_updated = COALESCE(bunny_stats.table_sync_status.rows_updated, 0) + $5,
+				last_synced_at = NOW(),
+				updated_at = NOW()
+		`, mirrorName, tableName, rowCount, counts.inserts[tableName], counts.updates[tableName])
+		if err != nil {
+			logger.Warn("failed to update table sync status", slog.String("table", tableName), slog.Any("error", err))
+		}
+	}
+	*counts = *newSyncCounts()
+}
+
+// saveCheckpoint records the LSN up to which the destination is durable,
+// and merges the destination's own offsets, if any, into the stored ones
+func (a *Activities) saveCheckpoint(ctx context.Context, mirrorName string, lsn int64, batchID int64, offsets map[string]int64) error {
+	var offsetsJSON []byte
+	if len(offsets) > 0 {
+		var err error
+		if offsetsJSON, err = json.Marshal(offsets); err != nil {
+			return err
+		}
+	}
+	_, err := a.CatalogPool.Exec(ctx, `
+		UPDATE bunny_internal.mirror_state
+		SET last_lsn = $2,
+		    last_sync_batch_id = $3,
+		    destination_offsets = COALESCE(destination_offsets, '{}'::jsonb) || COALESCE($4::jsonb, '{}'::jsonb),
+		    status = 'RUNNING',
+		    updated_at = NOW()
+		WHERE mirror_name = $1
+	`, mirrorName, lsn, batchID, offsetsJSON)
+	return err
+}
+
 // ============================================================================
 // Foreign Key Activities
 // ============================================================================
@@ -529,6 +1284,15 @@ func (a *Activities) DropForeignKeys(ctx context.Context, input *DropFKInput) er
 	logger := slog.Default().With(slog.String("mirror", input.MirrorName))
 	logger.Info("dropping foreign keys on destination")
 
+	postgresDst, err := a.isPostgresPeer(ctx, input.DestinationPeer)
+	if err != nil {
+		return err
+	}
+	if !postgresDst {
+		logger.Info("destination is not a Postgres database, skipping foreign keys")
+		return nil
+	}
+
 	// Get destination peer config
 	dstConfig, err := a.getPeerConfig(ctx, input.DestinationPeer)
 	if err != nil {
@@ -544,6 +1308,9 @@ func (a *Activities) DropForeignKeys(ctx context.Context, input *DropFKInput) er
 
 	// Drop FKs for each table
 	for _, tm := range input.TableMappings {
+		if !tm.MirrorsRows() {
+			continue
+		}
 		fks, err := dstConn.GetForeignKeys(ctx, tm.DestinationSchema, tm.DestinationTable)
 		if err != nil {
 			logger.Warn("failed to get FKs for table",
@@ -593,6 +1360,15 @@ func (a *Activities) RecreateForeignKeys(ctx context.Context, input *RecreateFKI
 	logger := slog.Default().With(slog.String("mirror", input.MirrorName))
 	logger.Info("recreating foreign keys on destination")
 
+	postgresDst, err := a.isPostgresPeer(ctx, input.DestinationPeer)
+	if err != nil {
+		return err
+	}
+	if !postgresDst {
+		logger.Info("destination is not a Postgres database, skipping foreign keys")
+		return nil
+	}
+
 	// Get peer configs
 	srcConfig, err := a.getPeerConfig(ctx, input.SourcePeer)
 	if err != nil {
@@ -620,9 +1396,13 @@ func (a *Activities) RecreateForeignKeys(ctx context.Context, input *RecreateFKI
 	// Create FK replicator
 	fkReplicator := postgres.NewFKReplicator(srcConn, dstConn)
 
-	// Get tables to process
+	// Get tables to process; changelog and history tables take no
+	// constraints from the source
 	var tables []string
 	for _, tm := range input.TableMappings {
+		if !tm.MirrorsRows() {
+			continue
+		}
 		tables = append(tables, tm.FullDestinationName())
 	}
 
@@ -662,6 +1442,15 @@ func (a *Activities) CreateIndexes(ctx context.Context, input *CreateIndexesInpu
 	logger := slog.Default().With(slog.String("mirror", input.MirrorName))
 	logger.Info("creating indexes on destination")
 
+	postgresDst, err := a.isPostgresPeer(ctx, input.DestinationPeer)
+	if err != nil {
+		return err
+	}
+	if !postgresDst {
+		logger.Info("destination is not a Postgres database, skipping indexes")
+		return nil
+	}
+
 	// Get peer configs
 	srcConfig, err := a.getPeerConfig(ctx, input.SourcePeer)
 	if err != nil {
@@ -686,8 +1475,12 @@ func (a *Activities) CreateIndexes(ctx context.Context, input *CreateIndexesInpu
 	}
 	defer dstConn.Close()
 
-	// Replicate indexes for each table
+	// Replicate indexes for each table. Unique source indexes would reject
+	// the versions of a history table.
 	for _, tm := range input.TableMappings {
+		if !tm.MirrorsRows() {
+			continue
+		}
 		if err := srcConn.ReplicateIndexes(ctx, dstConn, tm.DestinationSchema, tm.DestinationTable, input.Concurrent); err != nil {
 			logger.Warn("failed to replicate indexes for table",
 				slog.String("table", tm.FullDestinationName()),
@@ -711,6 +1504,11 @@ type CopyTableInput struct {
 	DestinationPeer string
 	TableMapping    model.TableMapping
 	SnapshotName    string
+	SnapshotLSN     int64 // Source WAL position of the snapshot
+
+	// Mirror columns added to the destination table
+	SoftDeleteColName string
+	SyncedAtColName   string
 }
 
 // CopyTable copies a table from source to destination
@@ -720,17 +1518,18 @@ func (a *Activities) CopyTable(ctx context.Context, input *CopyTableInput) error
 		slog.String("table", input.TableMapping.FullSourceName()))
 	logger.Info("copying table")
 
+	// Changelog tables only record the changes made after the snapshot
+	if input.TableMapping.Mode == model.TableModeChangelog {
+		logger.Info("skipping copy into changelog table")
+		return nil
+	}
+
 	// Get peer configs
 	srcConfig, err := a.getPeerConfig(ctx, input.SourcePeer)
 	if err != nil {
 		return fmt.Errorf("failed to get source peer config: %w", err)
 	}
 
-	dstConfig, err := a.getPeerConfig(ctx, input.DestinationPeer)
-	if err != nil {
-		return fmt.Errorf("failed to get destination peer config: %w", err)
-	}
-
 	// Connect - each copy operation uses its own dedicated connections
 	srcConn, err := postgres.NewPostgresConnector(ctx, srcConfig)
 	if err != nil {
@@ -738,31 +1537,33 @@ func (a *Activities) CopyTable(ctx context.Context, input *CopyTableInput) error
 	}
 	defer srcConn.Close()
 
-	dstConn, err := postgres.NewPostgresConnector(ctx, dstConfig)
+	dst, err := a.connectDestination(ctx, input.DestinationPeer)
 	if err != nil {
 		return fmt.Errorf("failed to connect to destination: %w", err)
 	}
-	defer dstConn.Close()
-
-	// Ensure destination schema exists
-	if err := dstConn.EnsureSchemaExists(ctx, input.TableMapping.DestinationSchema); err != nil {
-		return fmt.Errorf("failed to create destination schema: %w", err)
-	}
+	defer dst.Close()
 
 	// Get source table schema and create destination table if needed
 	srcSchema, err := srcConn.GetTableSchema(ctx, input.TableMapping.SourceSchema, input.TableMapping.SourceTable)
 	if err != nil {
 		return fmt.Errorf("failed to get source table schema: %w", err)
 	}
+	transform, err := newTableTransform(srcSchema, input.TableMapping.ColumnTransforms, a.Config.TransformSecret, nil)
+	if err != nil {
+		return err
+	}
+	srcSchema, err = destinationSchema(srcSchema, &input.TableMapping)
+	if err != nil {
+		return err
+	}
 
-	if err := dstConn.CreateTableFromSchema(ctx, srcSchema, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable); err != nil {
+	mirrorCols := mirrorColumns(&input.TableMapping, input.SoftDeleteColName, input.SyncedAtColName)
+	if err := dst.EnsureTable(ctx, srcSchema, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable, mirrorCols); err != nil {
 		return fmt.Errorf("failed to create destination table: %w", err)
 	}
 
 	// Truncate destination table before copying (in case it already has data)
-	truncateSQL := fmt.Sprintf("TRUNCATE TABLE %s.%s CASCADE",
-		input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable)
-	if _, err := dstConn.Conn().Exec(ctx, truncateSQL); err != nil {
+	if err := dst.TruncateTable(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable); err != nil {
 		logger.Warn("failed to truncate destination table (may not exist)", slog.Any("error", err))
 	}
 
@@ -780,24 +1581,27 @@ func (a *Activities) CopyTable(ctx context.Context, input *CopyTableInput) error
 		return fmt.Errorf("failed to set isolation level: %w", err)
 	}
 
-	// If we have a snapshot name, import it into this transaction
+	// If we have a snapshot name, import it into this transaction. Without
+	// one the transaction takes its own snapshot at its first query.
+	copyLSN := input.SnapshotLSN
 	if input.SnapshotName != "" {
 		_, err = srcTx.Exec(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", input.SnapshotName))
 		if err != nil {
 			return fmt.Errorf("failed to set snapshot: %w", err)
 		}
 		logger.Info("snapshot imported", slog.String("snapshot", input.SnapshotName))
+	} else if copyLSN, err = currentWALLSN(ctx, srcTx); err != nil {
+		return err
 	}
 
 	// Build query
 	srcTable := input.TableMapping.FullSourceName()
-	dstTable := input.TableMapping.FullDestinationName()
-
-	// Get columns (excluding any excluded columns)
-	columns := "*"
-	// In real implementation: filter excluded columns
 
-	query := fmt.Sprintf("SELECT %s FROM %s", columns, srcTable)
+	// Excluded columns and filtered rows are never read from the source
+	query := fmt.Sprintf("SELECT %s FROM %s", selectList(srcSchema, input.TableMapping.ColumnTransforms), srcTable)
+	if input.TableMapping.RowFilter != "" {
+		query += " WHERE " + postgres.WrapRowFilter(input.TableMapping.RowFilter)
+	}
 
 	// Copy data using COPY protocol for efficiency
 	logger.Info("copying data", slog.String("query", query))
@@ -809,22 +1613,20 @@ func (a *Activities) CopyTable(ctx context.Context, input *CopyTableInput) error
 	}
 	defer rows.Close()
 
-	// Get column descriptions for building insert
+	// Get column descriptions for loading the destination
 	fieldDescs := rows.FieldDescriptions()
 	colNames := make([]string, len(fieldDescs))
 	for i, fd := range fieldDescs {
 		colNames[i] = string(fd.Name)
 	}
 
-	// Build insert statement
-	placeholders := make([]string, len(colNames))
-	for i := range placeholders {
-		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	// Destinations writing partitions as files get the table as one
+	partDst, _ := dst.(connectors.PartitionDestination)
+	if partDst != nil {
+		if err := partDst.StartPartition(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable, 0); err != nil {
+			return err
+		}
 	}
-	insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		dstTable,
-		strings.Join(colNames, ", "),
-		strings.Join(placeholders, ", "))
 
 	// Copy rows in batches
 	batchSize := 1000
@@ -835,10 +1637,13 @@ func (a *Activities) CopyTable(ctx context.Context, input *CopyTableInput) error
 		if err != nil {
 			return fmt.Errorf("failed to get row values: %w", err)
 		}
+		if err := transform.transformRow(colNames, values); err != nil {
+			return fmt.Errorf("failed to transform row: %w", err)
+		}
 		batch = append(batch, values)
 
 		if len(batch) >= batchSize {
-			if err := a.insertBatch(ctx, dstConn.Conn(), insertSQL, batch); err != nil {
+			if err := dst.BulkLoad(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable, colNames, batch); err != nil {
 				return fmt.Errorf("failed to insert batch: %w", err)
 			}
 			batch = batch[:0]
@@ -848,7 +1653,7 @@ func (a *Activities) CopyTable(ctx context.Context, input *CopyTableInput) error
 
 	// Insert remaining rows
 	if len(batch) > 0 {
-		if err := a.insertBatch(ctx, dstConn.Conn(), insertSQL, batch); err != nil {
+		if err := dst.BulkLoad(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable, colNames, batch); err != nil {
 			return fmt.Errorf("failed to insert final batch: %w", err)
 		}
 	}
@@ -856,22 +1661,92 @@ func (a *Activities) CopyTable(ctx context.Context, input *CopyTableInput) error
 	if err := rows.Err(); err != nil {
 		return fmt.Errorf("error reading rows: %w", err)
 	}
+	if partDst != nil {
+		if err := partDst.FinishPartition(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable); err != nil {
+			return fmt.Errorf("failed to finish table copy: %w", err)
+		}
+	}
+
+	if err := a.recordCopyLSN(ctx, input.MirrorName, input.TableMapping.FullDestinationName(), copyLSN); err != nil {
+		return err
+	}
 
 	logger.Info("table copy completed", slog.String("table", srcTable))
 	return nil
 }
 
-// insertBatch inserts a batch of rows
-func (a *Activities) insertBatch(ctx context.Context, conn *pgx.Conn, insertSQL string, batch [][]interface{}) error {
-	for _, values := range batch {
-		_, err := conn.Exec(ctx, insertSQL, values...)
-		if err != nil {
-			return err
-		}
+// currentWALLSN returns the source's current WAL position. As the first
+// query of a REPEATABLE READ transaction it is no earlier than the
+// transaction's snapshot.
+func currentWALLSN(ctx context.Context, tx pgx.Tx) (int64, error) {
+	var lsn int64
+	if err := tx.QueryRow(ctx, "SELECT (pg_current_wal_lsn() - '0/0')::bigint").Scan(&lsn); err != nil {
+		return 0, fmt.Errorf("failed to get current WAL position: %w", err)
+	}
+	return lsn, nil
+}
+
+// recordCopyLSN stores the source WAL position a table was copied at. CDC
+// replays changes from before it over the copied rows, where a row changed
+// by them may already be gone.
+func (a *Activities) recordCopyLSN(ctx context.Context, mirrorName, tableName string, lsn int64) error {
+	_, err := a.CatalogPool.Exec(ctx, `
+		INSERT INTO bunny_stats.table_sync_status (mirror_name, table_name, copy_lsn)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (mirror_name, table_name) DO UPDATE SET
+			copy_lsn = $3,
+			updated_at = NOW()
+	`, mirrorName, tableName, lsn)
+	if err != nil {
+		return fmt.Errorf("failed to record copy position: %w", err)
 	}
 	return nil
 }
 
+// copyLSNs returns the WAL positions the tables of a mirror were copied at,
+// by destination table
+func (a *Activities) copyLSNs(ctx context.Context, mirrorName string) (map[string]int64, error) {
+	rows, err := a.CatalogPool.Query(ctx, `
+		SELECT table_name, copy_lsn FROM bunny_stats.table_sync_status
+		WHERE mirror_name = $1 AND copy_lsn IS NOT NULL
+	`, mirrorName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load copy positions: %w", err)
+	}
+	defer rows.Close()
+
+	lsns := make(map[string]int64)
+	for rows.Next() {
+		var table string
+		var lsn int64
+		if err := rows.Scan(&table, &lsn); err != nil {
+			return nil, err
+		}
+		lsns[table] = lsn
+	}
+	return lsns, rows.Err()
+}
+
+// selectList renders the columns of a destination schema for a snapshot
+// SELECT, reading transformed columns in their text form
+func selectList(schema *postgres.TableSchema, transforms []model.ColumnTransform) string {
+	transformed := make(map[string]bool, len(transforms))
+	for _, ct := range transforms {
+		transformed[ct.Column] = true
+	}
+
+	cols := make([]string, len(schema.Columns))
+	for i, col := range schema.Columns {
+		name := postgres.QuoteIdentifier(col.Name)
+		if transformed[col.Name] {
+			cols[i] = fmt.Sprintf("%s::text AS %s", name, name)
+		} else {
+			cols[i] = name
+		}
+	}
+	return strings.Join(cols, ", ")
+}
+
 // ============================================================================
 // Table Status Activities
 // ============================================================================
@@ -1013,12 +1888,20 @@ func (a *Activities) CleanupCatalog(ctx context.Context, input *CleanupCatalogIn
 		if err != nil {
 			return err
 		}
+
+		_, err = a.CatalogPool.Exec(ctx, `
+			DELETE FROM bunny_internal.dead_letter_queue WHERE mirror_name = $1
+		`, input.MirrorName)
+		if err != nil {
+			return err
+		}
 	} else {
 		// Just reset state for resync
 		_, err := a.CatalogPool.Exec(ctx, `
 			UPDATE bunny_internal.mirror_state SET
 				last_lsn = 0,
 				last_sync_batch_id = 0,
+				destination_offsets = NULL,
 				status = 'CREATED',
 				error_message = NULL,
 				error_count = 0,
@@ -1030,6 +1913,14 @@ func (a *Activities) CleanupCatalog(ctx context.Context, input *CleanupCatalogIn
 		}
 	}
 
+	// Destination tables are recreated from the current source schema
+	_, err := a.CatalogPool.Exec(ctx, `
+		DELETE FROM bunny_internal.table_schema_mapping WHERE mirror_name = $1
+	`, input.MirrorName)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -1037,31 +1928,18 @@ func (a *Activities) CleanupCatalog(ctx context.Context, input *CleanupCatalogIn
 // Helper Functions
 // ============================================================================
 
+// getPeerConfig returns the connection settings of a peer that must be a
+// Postgres database, for work specific to Postgres: publications, snapshots,
+// indexes, foreign keys and resync tables
 func (a *Activities) getPeerConfig(ctx context.Context, peerName string) (*postgres.PostgresConfig, error) {
-	var host, username, database, sslMode string
-	var port int
-	var password *string
-
-	err := a.CatalogPool.QueryRow(ctx, `
-		SELECT host, port, username, password, database, COALESCE(ssl_mode, 'disable')
-		FROM bunny_internal.peers WHERE name = $1
-	`, peerName).Scan(&host, &port, &username, &password, &database, &sslMode)
-
+	peer, err := connectors.LoadPeer(ctx, a.CatalogPool, peerName)
 	if err != nil {
-		return nil, fmt.Errorf("peer not found: %s: %w", peerName, err)
+		return nil, err
 	}
-
-	config := &postgres.PostgresConfig{
-		Host:     host,
-		Port:     port,
-		User:     username,
-		Database: database,
-		SSLMode:  sslMode,
-	}
-
-	if password != nil {
-		config.Password = *password
+	if peer.Type != connectors.PeerTypePostgres {
+		return nil, fmt.Errorf("peer %s is a %s peer, not a Postgres database", peerName, peer.Type)
 	}
+	config := connectors.PostgresConfig(peer)
 
 	slog.Info("loaded peer config",
 		slog.String("peer", peerName),
@@ -1074,6 +1952,36 @@ func (a *Activities) getPeerConfig(ctx context.Context, peerName string) (*postg
 	return config, nil
 }
 
+// isPostgresPeer reports whether a peer is a Postgres database. Destination
+// features only Postgres has, such as foreign keys and indexes, are skipped
+// for other peers.
+func (a *Activities) isPostgresPeer(ctx context.Context, peerName string) (bool, error) {
+	peer, err := connectors.LoadPeer(ctx, a.CatalogPool, peerName)
+	if err != nil {
+		return false, err
+	}
+	return peer.Type == connectors.PeerTypePostgres, nil
+}
+
+// connectSource connects to the source of a mirror through its peer type
+func (a *Activities) connectSource(ctx context.Context, peerName string) (connectors.Source, error) {
+	peer, err := connectors.LoadPeer(ctx, a.CatalogPool, peerName)
+	if err != nil {
+		return nil, err
+	}
+	return connectors.NewSource(ctx, peer)
+}
+
+// connectDestination connects to the destination of a mirror through its
+// peer type
+func (a *Activities) connectDestination(ctx context.Context, peerName string) (connectors.Destination, error) {
+	peer, err := connectors.LoadPeer(ctx, a.CatalogPool, peerName)
+	if err != nil {
+		return nil, err
+	}
+	return connectors.NewDestination(ctx, peer)
+}
+
 // getMirrorPeers gets the source and destination peer names for a mirror
 func (a *Activities) getMirrorPeers(ctx context.Context, mirrorName string) (sourcePeer, destPeer string, err error) {
 	err = a.CatalogPool.QueryRow(ctx, `
@@ -1149,11 +2057,16 @@ type CopyPartitionInput struct {
 	DestinationPeer string
 	TableMapping    model.TableMapping
 	SnapshotName    string
+	SnapshotLSN     int64 // Source WAL position of the snapshot
 	PartitionKey    string
 	PartitionNum    uint32
 	TotalPartitions uint32
 	MinValue        interface{}
 	MaxValue        interface{}
+
+	// Mirror columns added to the destination table
+	SoftDeleteColName string
+	SyncedAtColName   string
 }
 
 // TruncateTable truncates a table on the destination
@@ -1163,23 +2076,17 @@ func (a *Activities) TruncateTable(ctx context.Context, input *TruncateTableInpu
 		slog.String("table", input.TableName))
 	logger.Info("truncating table")
 
-	// Get destination peer config
-	dstConfig, err := a.getPeerConfig(ctx, input.DestinationPeer)
-	if err != nil {
-		return fmt.Errorf("failed to get destination peer config: %w", err)
-	}
-
 	// Connect to destination
-	dstConn, err := postgres.NewPostgresConnector(ctx, dstConfig)
+	dst, err := a.connectDestination(ctx, input.DestinationPeer)
 	if err != nil {
 		return fmt.Errorf("failed to connect to destination: %w", err)
 	}
-	defer dstConn.Close()
+	defer dst.Close()
 
-	// Truncate the table with CASCADE to handle FK dependencies
-	_, err = dstConn.Conn().Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s CASCADE", input.TableName))
-	if err != nil {
-		return fmt.Errorf("failed to truncate table %s: %w", input.TableName, err)
+	// Postgres truncates with CASCADE to handle FK dependencies
+	schemaName, tableName, _ := strings.Cut(input.TableName, ".")
+	if err := dst.TruncateTable(ctx, schemaName, tableName); err != nil {
+		return err
 	}
 
 	logger.Info("table truncated successfully")
@@ -1235,6 +2142,15 @@ func (a *Activities) DropTableForeignKeys(ctx context.Context, input *DropTableF
 		slog.String("table", input.TableName))
 	logger.Info("dropping foreign keys for table")
 
+	postgresDst, err := a.isPostgresPeer(ctx, input.DestinationPeer)
+	if err != nil {
+		return err
+	}
+	if !postgresDst {
+		logger.Info("destination is not a Postgres database, skipping foreign keys")
+		return nil
+	}
+
 	// Get destination peer config
 	dstConfig, err := a.getPeerConfig(ctx, input.DestinationPeer)
 	if err != nil {
@@ -1298,6 +2214,20 @@ func (a *Activities) CreateTableIndexes(ctx context.Context, input *CreateTableI
 		slog.String("table", input.TableMapping.FullSourceName()))
 	logger.Info("creating indexes for table")
 
+	if !input.TableMapping.MirrorsRows() {
+		logger.Info("skipping indexes of changelog or history table")
+		return nil
+	}
+
+	postgresDst, err := a.isPostgresPeer(ctx, input.DestinationPeer)
+	if err != nil {
+		return err
+	}
+	if !postgresDst {
+		logger.Info("destination is not a Postgres database, skipping indexes")
+		return nil
+	}
+
 	// Get peer configs
 	srcConfig, err := a.getPeerConfig(ctx, input.SourcePeer)
 	if err != nil {
@@ -1341,6 +2271,15 @@ func (a *Activities) RecreateTableForeignKeys(ctx context.Context, input *Recrea
 		slog.String("table", input.TableName))
 	logger.Info("recreating foreign keys for table")
 
+	postgresDst, err := a.isPostgresPeer(ctx, input.DestinationPeer)
+	if err != nil {
+		return err
+	}
+	if !postgresDst {
+		logger.Info("destination is not a Postgres database, skipping foreign keys")
+		return nil
+	}
+
 	// Get peer configs
 	srcConfig, err := a.getPeerConfig(ctx, input.SourcePeer)
 	if err != nil {
@@ -1386,6 +2325,15 @@ func (a *Activities) DropDestinationTables(ctx context.Context, input *DropDesti
 		return nil // Don't fail the whole operation
 	}
 
+	postgresDst, err := a.isPostgresPeer(ctx, destPeer)
+	if err != nil {
+		return err
+	}
+	if !postgresDst {
+		logger.Info("destination is not a Postgres database, leaving its tables in place")
+		return nil
+	}
+
 	dstConfig, err := a.getPeerConfig(ctx, destPeer)
 	if err != nil {
 		return fmt.Errorf("failed to get destination peer config: %w", err)
@@ -1448,7 +2396,11 @@ func (a *Activities) GetPartitionInfo(ctx context.Context, input *GetPartitionIn
 	// Get row count for the table
 	var rowCount int64
 	tableName := input.TableMapping.FullSourceName()
-	err = srcConn.Conn().QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)).Scan(&rowCount)
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)
+	if input.TableMapping.RowFilter != "" {
+		countQuery += " WHERE " + postgres.WrapRowFilter(input.TableMapping.RowFilter)
+	}
+	err = srcConn.Conn().QueryRow(ctx, countQuery).Scan(&rowCount)
 	if err != nil {
 		return nil, fmt.Errorf("failed to count rows: %w", err)
 	}
@@ -1473,17 +2425,18 @@ func (a *Activities) CopyPartition(ctx context.Context, input *CopyPartitionInpu
 		slog.Uint64("partition", uint64(input.PartitionNum)))
 	logger.Info("copying partition")
 
+	// Changelog tables only record the changes made after the snapshot
+	if input.TableMapping.Mode == model.TableModeChangelog {
+		logger.Info("skipping copy into changelog table")
+		return nil
+	}
+
 	// Get peer configs
 	srcConfig, err := a.getPeerConfig(ctx, input.SourcePeer)
 	if err != nil {
 		return fmt.Errorf("failed to get source peer config: %w", err)
 	}
 
-	dstConfig, err := a.getPeerConfig(ctx, input.DestinationPeer)
-	if err != nil {
-		return fmt.Errorf("failed to get destination peer config: %w", err)
-	}
-
 	// Connect - each partition copy uses its own dedicated connections
 	srcConn, err := postgres.NewPostgresConnector(ctx, srcConfig)
 	if err != nil {
@@ -1491,33 +2444,39 @@ func (a *Activities) CopyPartition(ctx context.Context, input *CopyPartitionInpu
 	}
 	defer srcConn.Close()
 
-	dstConn, err := postgres.NewPostgresConnector(ctx, dstConfig)
+	dst, err := a.connectDestination(ctx, input.DestinationPeer)
 	if err != nil {
 		return fmt.Errorf("failed to connect to destination: %w", err)
 	}
-	defer dstConn.Close()
-
-	// For first partition, ensure schema and table exist
-	if input.PartitionNum == 0 {
-		if err := dstConn.EnsureSchemaExists(ctx, input.TableMapping.DestinationSchema); err != nil {
-			return fmt.Errorf("failed to create destination schema: %w", err)
-		}
+	defer dst.Close()
 
-		srcSchema, err := srcConn.GetTableSchema(ctx, input.TableMapping.SourceSchema, input.TableMapping.SourceTable)
-		if err != nil {
-			return fmt.Errorf("failed to get source table schema: %w", err)
-		}
+	srcSchema, err := srcConn.GetTableSchema(ctx, input.TableMapping.SourceSchema, input.TableMapping.SourceTable)
+	if err != nil {
+		return fmt.Errorf("failed to get source table schema: %w", err)
+	}
+	transform, err := newTableTransform(srcSchema, input.TableMapping.ColumnTransforms, a.Config.TransformSecret, nil)
+	if err != nil {
+		return err
+	}
+	srcSchema, err = destinationSchema(srcSchema, &input.TableMapping)
+	if err != nil {
+		return err
+	}
 
-		if err := dstConn.CreateTableFromSchema(ctx, srcSchema, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable); err != nil {
+	// The first partition sets the table up and empties it. The others are
+	// copied on connections of their own, which only learn its schema.
+	if input.PartitionNum == 0 {
+		mirrorCols := mirrorColumns(&input.TableMapping, input.SoftDeleteColName, input.SyncedAtColName)
+		if err := dst.EnsureTable(ctx, srcSchema, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable, mirrorCols); err != nil {
 			return fmt.Errorf("failed to create destination table: %w", err)
 		}
 
 		// Truncate destination table before copying (in case it already has data)
-		truncateSQL := fmt.Sprintf("TRUNCATE TABLE %s.%s CASCADE",
-			input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable)
-		if _, err := dstConn.Conn().Exec(ctx, truncateSQL); err != nil {
+		if err := dst.TruncateTable(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable); err != nil {
 			logger.Warn("failed to truncate destination table (may not exist)", slog.Any("error", err))
 		}
+	} else if schemaDst, ok := dst.(connectors.SchemaDestination); ok {
+		schemaDst.RegisterTable(srcSchema, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable)
 	}
 
 	// Start a REPEATABLE READ transaction on source for snapshot consistency
@@ -1534,24 +2493,34 @@ func (a *Activities) CopyPartition(ctx context.Context, input *CopyPartitionInpu
 		return fmt.Errorf("failed to set isolation level: %w", err)
 	}
 
-	// If we have a snapshot name, import it into this transaction
+	// If we have a snapshot name, import it into this transaction. Without
+	// one the transaction takes its own snapshot at its first query.
+	copyLSN := input.SnapshotLSN
 	if input.SnapshotName != "" {
 		_, err = srcTx.Exec(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", input.SnapshotName))
 		if err != nil {
 			return fmt.Errorf("failed to set snapshot: %w", err)
 		}
 		logger.Info("snapshot imported", slog.String("snapshot", input.SnapshotName))
+	} else if copyLSN, err = currentWALLSN(ctx, srcTx); err != nil {
+		return err
 	}
 
 	// Build query for this partition
 	srcTable := input.TableMapping.FullSourceName()
-	dstTable := input.TableMapping.FullDestinationName()
 
-	query := fmt.Sprintf("SELECT * FROM %s", srcTable)
+	var conds []string
 	if input.PartitionKey != "" && input.TotalPartitions > 1 {
 		// Use modulo-based partitioning
-		query = fmt.Sprintf("SELECT * FROM %s WHERE MOD(HASHTEXT(%s::text), %d) = %d",
-			srcTable, input.PartitionKey, input.TotalPartitions, input.PartitionNum)
+		conds = append(conds, fmt.Sprintf("MOD(HASHTEXT(%s::text), %d) = %d",
+			input.PartitionKey, input.TotalPartitions, input.PartitionNum))
+	}
+	if input.TableMapping.RowFilter != "" {
+		conds = append(conds, postgres.WrapRowFilter(input.TableMapping.RowFilter))
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", selectList(srcSchema, input.TableMapping.ColumnTransforms), srcTable)
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
 	}
 
 	// Read from source within the snapshot transaction
@@ -1561,22 +2530,20 @@ func (a *Activities) CopyPartition(ctx context.Context, input *CopyPartitionInpu
 	}
 	defer rows.Close()
 
-	// Get column descriptions for building insert
+	// Get column descriptions for loading the destination
 	fieldDescs := rows.FieldDescriptions()
 	colNames := make([]string, len(fieldDescs))
 	for i, fd := range fieldDescs {
 		colNames[i] = string(fd.Name)
 	}
 
-	// Build insert statement
-	placeholders := make([]string, len(colNames))
-	for i := range placeholders {
-		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	// Destinations writing partitions as files get one per partition
+	partDst, _ := dst.(connectors.PartitionDestination)
+	if partDst != nil {
+		if err := partDst.StartPartition(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable, input.PartitionNum); err != nil {
+			return err
+		}
 	}
-	insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		dstTable,
-		strings.Join(colNames, ", "),
-		strings.Join(placeholders, ", "))
 
 	// Copy rows in batches
 	batchSize := 1000
@@ -1588,11 +2555,14 @@ func (a *Activities) CopyPartition(ctx context.Context, input *CopyPartitionInpu
 		if err != nil {
 			return fmt.Errorf("failed to get row values: %w", err)
 		}
+		if err := transform.transformRow(colNames, values); err != nil {
+			return fmt.Errorf("failed to transform row: %w", err)
+		}
 		batch = append(batch, values)
 		rowCount++
 
 		if len(batch) >= batchSize {
-			if err := a.insertBatch(ctx, dstConn.Conn(), insertSQL, batch); err != nil {
+			if err := dst.BulkLoad(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable, colNames, batch); err != nil {
 				return fmt.Errorf("failed to insert batch: %w", err)
 			}
 			batch = batch[:0]
@@ -1603,7 +2573,7 @@ func (a *Activities) CopyPartition(ctx context.Context, input *CopyPartitionInpu
 
 	// Insert remaining rows
 	if len(batch) > 0 {
-		if err := a.insertBatch(ctx, dstConn.Conn(), insertSQL, batch); err != nil {
+		if err := dst.BulkLoad(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable, colNames, batch); err != nil {
 			return fmt.Errorf("failed to insert final batch: %w", err)
 		}
 	}
@@ -1611,6 +2581,15 @@ func (a *Activities) CopyPartition(ctx context.Context, input *CopyPartitionInpu
 	if err := rows.Err(); err != nil {
 		return fmt.Errorf("error reading rows: %w", err)
 	}
+	if partDst != nil {
+		if err := partDst.FinishPartition(ctx, input.TableMapping.DestinationSchema, input.TableMapping.DestinationTable); err != nil {
+			return fmt.Errorf("failed to finish partition copy: %w", err)
+		}
+	}
+
+	if err := a.recordCopyLSN(ctx, input.MirrorName, input.TableMapping.FullDestinationName(), copyLSN); err != nil {
+		return err
+	}
 
 	logger.Info("partition copy completed",
 		slog.Int("partition", int(input.PartitionNum)+1),
@@ -1630,6 +2609,10 @@ type SyncSchemaInput struct {
 	DestinationPeer string
 	TableMappings   []model.TableMapping
 	ReplicateIndexes bool
+
+	// Mirror columns, which are not dropped for missing on the source
+	SoftDeleteColName string
+	SyncedAtColName   string
 }
 
 // SyncSchemaOutput is the output of the SyncSchema activity
@@ -1648,6 +2631,17 @@ func (a *Activities) SyncSchema(ctx context.Context, input *SyncSchemaInput) (*S
 		"table_count": len(input.TableMappings),
 	})
 
+	// Other destinations follow column changes through the schema changes
+	// CDC carries
+	postgresDst, err := a.isPostgresPeer(ctx, input.DestinationPeer)
+	if err != nil {
+		return nil, err
+	}
+	if !postgresDst {
+		logger.Info("destination is not a Postgres database, skipping schema sync")
+		return &SyncSchemaOutput{}, nil
+	}
+
 	srcConfig, err := a.getPeerConfig(ctx, input.SourcePeer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get source peer config: %w", err)
@@ -1673,6 +2667,11 @@ func (a *Activities) SyncSchema(ctx context.Context, input *SyncSchemaInput) (*S
 	output := &SyncSchemaOutput{}
 
 	for _, tm := range input.TableMappings {
+		// Changelog tables keep row values as JSON and have no source columns
+		if tm.Mode == model.TableModeChangelog {
+			continue
+		}
+
 		activity.RecordHeartbeat(ctx, fmt.Sprintf("syncing schema for %s", tm.FullSourceName()))
 
 		// Get source schema
@@ -1684,6 +2683,10 @@ func (a *Activities) SyncSchema(ctx context.Context, input *SyncSchemaInput) (*S
 			})
 			return nil, fmt.Errorf("failed to get source schema for %s: %w", tm.FullSourceName(), err)
 		}
+		srcSchema, err = destinationSchema(srcSchema, &tm)
+		if err != nil {
+			return nil, err
+		}
 
 		// Get destination schema
 		dstSchema, err := dstConn.GetTableSchema(ctx, tm.DestinationSchema, tm.DestinationTable)
@@ -1695,8 +2698,16 @@ func (a *Activities) SyncSchema(ctx context.Context, input *SyncSchemaInput) (*S
 			return nil, fmt.Errorf("failed to get destination schema for %s: %w", tm.FullDestinationName(), err)
 		}
 
-		// Compare schemas
+		// Compare schemas, keeping the mirror columns the source does not have
 		delta := postgres.CompareSchemas(srcSchema, dstSchema)
+		mirrorCols := mirrorColumns(&tm, input.SoftDeleteColName, input.SyncedAtColName)
+		dropped := delta.DroppedColumns[:0]
+		for _, name := range delta.DroppedColumns {
+			if !slices.Contains(mirrorCols.Names(), name) {
+				dropped = append(dropped, name)
+			}
+		}
+		delta.DroppedColumns = dropped
 
 		// Compare indexes if enabled
 		if input.ReplicateIndexes {
@@ -1770,6 +2781,10 @@ type CreateResyncTableInput struct {
 	SourcePeer      string
 	DestinationPeer string
 	TableMapping    model.TableMapping
+
+	// Mirror columns added to the resync table
+	SoftDeleteColName string
+	SyncedAtColName   string
 }
 
 // CreateResyncTable creates a _resync shadow table with the same structure as the source
@@ -1806,6 +2821,10 @@ func (a *Activities) CreateResyncTable(ctx context.Context, input *CreateResyncT
 	if err != nil {
 		return fmt.Errorf("failed to get source schema: %w", err)
 	}
+	srcSchema, err = destinationSchema(srcSchema, &input.TableMapping)
+	if err != nil {
+		return err
+	}
 
 	resyncTableName := input.TableMapping.DestinationTable + "_resync"
 
@@ -1823,7 +2842,8 @@ func (a *Activities) CreateResyncTable(ctx context.Context, input *CreateResyncT
 	}
 
 	// Create the resync table with source schema
-	if err := dstConn.CreateTableFromSchema(ctx, srcSchema, input.TableMapping.DestinationSchema, resyncTableName); err != nil {
+	mirrorCols := mirrorColumns(&input.TableMapping, input.SoftDeleteColName, input.SyncedAtColName)
+	if err := dstConn.CreateTableFromSchema(ctx, srcSchema, input.TableMapping.DestinationSchema, resyncTableName, mirrorCols); err != nil {
 		return fmt.Errorf("failed to create resync table: %w", err)
 	}
 
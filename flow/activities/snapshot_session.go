@@ -16,6 +16,7 @@ import (
 type SnapshotSession struct {
 	MirrorName   string
 	SnapshotName string
+	SnapshotLSN  int64
 	conn         *pgx.Conn
 	tx           pgx.Tx
 	mu           sync.Mutex
@@ -37,6 +38,8 @@ type StartSnapshotSessionInput struct {
 // StartSnapshotSessionOutput is the output of starting a snapshot session
 type StartSnapshotSessionOutput struct {
 	SnapshotName string
+	// SnapshotLSN is the source WAL position when the snapshot was taken
+	SnapshotLSN int64
 }
 
 // StartSnapshotSession creates a long-lived connection and exports a snapshot.
@@ -51,7 +54,7 @@ func (a *Activities) StartSnapshotSession(ctx context.Context, input *StartSnaps
 	if existing, ok := snapshotSessions[input.MirrorName]; ok && !existing.closed {
 		snapshotSessionsMu.RUnlock()
 		logger.Info("reusing existing snapshot session", slog.String("snapshot", existing.SnapshotName))
-		return &StartSnapshotSessionOutput{SnapshotName: existing.SnapshotName}, nil
+		return &StartSnapshotSessionOutput{SnapshotName: existing.SnapshotName, SnapshotLSN: existing.SnapshotLSN}, nil
 	}
 	snapshotSessionsMu.RUnlock()
 
@@ -115,10 +118,20 @@ func (a *Activities) StartSnapshotSession(ctx context.Context, input *StartSnaps
 		return nil, fmt.Errorf("failed to export snapshot: %w", err)
 	}
 
+	// Changes the slot streams from before this position may already be in
+	// the copied rows
+	snapshotLSN, err := currentWALLSN(ctx, tx)
+	if err != nil {
+		tx.Rollback(ctx)
+		conn.Close(ctx)
+		return nil, err
+	}
+
 	// Create and store the session
 	session := &SnapshotSession{
 		MirrorName:   input.MirrorName,
 		SnapshotName: snapshotName,
+		SnapshotLSN:  snapshotLSN,
 		conn:         conn,
 		tx:           tx,
 		closed:       false,
@@ -136,7 +149,7 @@ func (a *Activities) StartSnapshotSession(ctx context.Context, input *StartSnaps
 		"snapshot": snapshotName,
 	})
 
-	return &StartSnapshotSessionOutput{SnapshotName: snapshotName}, nil
+	return &StartSnapshotSessionOutput{SnapshotName: snapshotName, SnapshotLSN: snapshotLSN}, nil
 }
 
 // HoldSnapshotSessionInput is the input for holding a snapshot session open
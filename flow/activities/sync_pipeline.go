@@ -0,0 +1,444 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.temporal.io/sdk/activity"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+	"github.com/bunnydb/bunnydb/flow/model"
+)
+
+// pipelineDepth is how many pulled batches may wait for the apply workers
+// before the reader stops pulling
+const pipelineDepth = 4
+
+// applyPipeline runs CDC with reading and applying overlapped: a reader
+// goroutine pulls batches from the source into a bounded channel, and a
+// dispatcher splits each batch over the apply workers by table and primary
+// key, so changes to the same row are applied in order by the same worker.
+//
+// Workers apply their parts independently, so a source transaction whose
+// rows land on several workers is not applied atomically on the destination.
+// The checkpoint only moves past a batch once every part of it and of all
+// earlier batches has been applied.
+//
+// For the same reason a child row may be applied before the parent row it
+// references. The worker connections run with session_replication_role set
+// to replica, which skips foreign key checks and the destination's ordinary
+// triggers. Setting it needs superuser, or the SET privilege on the
+// parameter; without it, mirrors whose destination tables have foreign keys
+// refuse to run with several workers.
+//
+// A batch with a spooled transaction goes to a single worker, which prepares
+// and applies its records a chunk at a time. The row filters query the
+// source connection, so the reader waits for such a batch to be applied.
+type applyPipeline struct {
+	a           *Activities
+	input       *SyncInput
+	logger      *slog.Logger
+	cdcReader   connectors.ChangeReader
+	dstConns    []*postgres.PostgresConnector // One per worker
+	targets     map[string]*postgres.ApplyTarget
+	batchSize   int
+	errorPolicy model.ApplyErrorPolicy
+	typeMap     *pgtype.Map // Source type map, to keep dead letters as text
+	prep        *recordPrep
+	partitions  *postgres.ChangelogPartitions
+}
+
+// pulledBatch is a batch of committed transactions, numbered in pull order.
+// done is closed once a batch with a spooled transaction has been applied.
+type pulledBatch struct {
+	seq    int64
+	txns   []*postgres.CDCTransaction
+	endLSN int64
+	done   chan struct{}
+}
+
+// applyTask is the part of a batch assigned to one worker
+type applyTask struct {
+	batch *pulledBatch
+	parts int // Number of tasks the batch was split into
+	txns  []*postgres.CDCTransaction
+}
+
+// applyResult reports a finished task. The records of spooled transactions
+// are counted by the worker, as they are applied.
+type applyResult struct {
+	task      *applyTask
+	failed    map[*postgres.CDCRecord]bool
+	counts    *syncCounts
+	processed int64
+	err       error
+}
+
+// run applies changes until the context ends or an error stops the mirror
+func (p *applyPipeline) run(ctx context.Context, lastLSN, batchID int64) (*SyncOutput, error) {
+	if err := p.skipForeignKeyChecks(ctx); err != nil {
+		return nil, err
+	}
+
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+
+	numWorkers := len(p.dstConns)
+	batches := make(chan *pulledBatch, pipelineDepth)
+	results := make(chan applyResult, numWorkers)
+	errs := make(chan error, 2)
+	var acked atomic.Int64
+	acked.Store(lastLSN)
+
+	var wg sync.WaitGroup
+	var inflight sync.WaitGroup
+	tasks := make([]chan *applyTask, numWorkers)
+	for i := range tasks {
+		tasks[i] = make(chan *applyTask, 1)
+		wg.Add(1)
+		go func(conn *postgres.PostgresConnector, tasks <-chan *applyTask) {
+			defer wg.Done()
+			p.work(ctx, conn, tasks, results, &inflight)
+		}(p.dstConns[i], tasks[i])
+	}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs <- p.read(ctx, batches, &acked)
+	}()
+	go func() {
+		defer wg.Done()
+		errs <- p.dispatch(ctx, batches, tasks, &inflight)
+	}()
+
+	// Stop and wait for the goroutines before the connections they use close
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	p.logger.Info("CDC apply pipeline started", slog.Int("workers", numWorkers))
+
+	remaining := make(map[int64]int)
+	completed := make(map[int64]*pulledBatch)
+	var nextSeq int64
+	recordsProcessed := int64(0)
+	counts := newSyncCounts()
+	heartbeat := time.NewTicker(10 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-parentCtx.Done():
+			p.logger.Info("sync flow stopped",
+				slog.Int64("lastLSN", lastLSN),
+				slog.Int64("recordsProcessed", recordsProcessed))
+			return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, parentCtx.Err()
+
+		case err := <-errs:
+			if parentCtx.Err() != nil {
+				return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, parentCtx.Err()
+			}
+			return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, err
+
+		case <-heartbeat.C:
+			activity.RecordHeartbeat(ctx, fmt.Sprintf("syncing: LSN=%d, records=%d", lastLSN, recordsProcessed))
+
+		case res := <-results:
+			if res.err != nil {
+				return &SyncOutput{LastLSN: lastLSN, BatchID: batchID}, res.err
+			}
+
+			for _, txn := range res.task.txns {
+				recordsProcessed += p.a.countApplied(ctx, p.input.MirrorName, txn, res.failed, counts)
+			}
+			recordsProcessed += res.processed
+			counts.add(res.counts)
+
+			batch := res.task.batch
+			if _, ok := remaining[batch.seq]; !ok {
+				remaining[batch.seq] = res.task.parts
+			}
+			remaining[batch.seq]--
+			if remaining[batch.seq] > 0 {
+				continue
+			}
+			delete(remaining, batch.seq)
+			completed[batch.seq] = batch
+			if batch.done != nil {
+				close(batch.done)
+			}
+
+			// Only a run of completed batches from the last checkpoint on can
+			// be checkpointed
+			newLSN := lastLSN
+			numBatches := 0
+			for done, ok := completed[nextSeq]; ok; done, ok = completed[nextSeq] {
+				delete(completed, nextSeq)
+				nextSeq++
+				numBatches++
+				if done.endLSN > newLSN {
+					newLSN = done.endLSN
+				}
+			}
+			if numBatches == 0 {
+				continue
+			}
+
+			p.a.flushSyncCounts(ctx, p.input.MirrorName, p.logger, counts)
+
+			if newLSN > lastLSN {
+				lastLSN = newLSN
+				batchID++
+
+				if err := p.a.saveCheckpoint(ctx, p.input.MirrorName, lastLSN, batchID, nil); err != nil {
+					// Keep the slot where it is; the batches are replayed if we crash
+					p.logger.Warn("failed to update mirror checkpoint", slog.Any("error", err))
+				} else {
+					// The reader acknowledges it to the source on its next pull
+					acked.Store(lastLSN)
+				}
+			}
+
+			p.logger.Info("batches applied",
+				slog.Int("batches", numBatches),
+				slog.Int64("lastLSN", lastLSN),
+				slog.Int64("batchID", batchID),
+				slog.Int64("totalProcessed", recordsProcessed))
+		}
+	}
+}
+
+// read pulls batches from the source until the context ends or the
+// replication connection is lost. While the workers are backed up it keeps
+// the replication connection alive instead of pulling.
+func (p *applyPipeline) read(ctx context.Context, batches chan<- *pulledBatch, acked *atomic.Int64) error {
+	var seq, lastAck int64
+	ack := func() {
+		if lsn := acked.Load(); lsn > lastAck {
+			p.cdcReader.AckLSN(lsn)
+			lastAck = lsn
+		}
+	}
+
+	keepAlive := time.NewTicker(5 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		ack()
+
+		txns, endLSN, err := p.cdcReader.PullRecords(ctx, p.batchSize, 3*time.Second)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if isReplicationConnLost(err) {
+				return fmt.Errorf("replication connection lost: %w", err)
+			}
+			var streamErr *postgres.StreamError
+			if errors.As(err, &streamErr) {
+				return err
+			}
+			p.logger.Error("failed to pull records", slog.Any("error", err))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(1 * time.Second):
+			}
+			continue
+		}
+		if len(txns) == 0 {
+			continue
+		}
+
+		// The row filters query the source, which only this goroutine uses
+		// unless a spooled transaction is being applied
+		if err := p.prep.prepare(ctx, txns); err != nil {
+			return err
+		}
+
+		batch := &pulledBatch{seq: seq, txns: txns, endLSN: endLSN}
+		if postgres.HasSpooled(txns) {
+			batch.done = make(chan struct{})
+		}
+		seq++
+		for sent := false; !sent; {
+			select {
+			case batches <- batch:
+				sent = true
+			case <-keepAlive.C:
+				ack()
+				if err := p.cdcReader.KeepAlive(ctx); err != nil {
+					p.logger.Warn("failed to send standby status", slog.Any("error", err))
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		// The worker reads the spooled records from the reader, and prepares
+		// them with the source connection
+		for batch.done != nil {
+			select {
+			case <-batch.done:
+				batch.done = nil
+			case <-keepAlive.C:
+				ack()
+				if err := p.cdcReader.KeepAlive(ctx); err != nil {
+					p.logger.Warn("failed to send standby status", slog.Any("error", err))
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// dispatch splits batches over the workers. A batch that cannot be split is
+// applied by a single worker once everything before it has been applied, and
+// nothing after it starts before it is done.
+func (p *applyPipeline) dispatch(
+	ctx context.Context,
+	batches <-chan *pulledBatch,
+	tasks []chan *applyTask,
+	inflight *sync.WaitGroup,
+) error {
+	defer func() {
+		for _, ch := range tasks {
+			close(ch)
+		}
+	}()
+
+	for {
+		var batch *pulledBatch
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case batch = <-batches:
+		}
+
+		parts, ok := postgres.PartitionBatch(batch.txns, p.targets, len(tasks))
+		if !ok {
+			inflight.Wait()
+			parts = [][]*postgres.CDCTransaction{batch.txns}
+		}
+
+		assigned := make(map[int][]*postgres.CDCTransaction)
+		for i, part := range parts {
+			if len(part) > 0 {
+				assigned[i] = part
+			}
+		}
+		// A batch left without records still has to reach the checkpoint
+		if len(assigned) == 0 {
+			assigned[0] = nil
+		}
+
+		inflight.Add(len(assigned))
+		for i, part := range assigned {
+			task := &applyTask{batch: batch, parts: len(assigned), txns: part}
+			select {
+			case tasks[i] <- task:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if !ok {
+			inflight.Wait()
+		}
+	}
+}
+
+// work applies tasks on one destination connection, in the order received
+func (p *applyPipeline) work(
+	ctx context.Context,
+	conn *postgres.PostgresConnector,
+	tasks <-chan *applyTask,
+	results chan<- applyResult,
+	inflight *sync.WaitGroup,
+) {
+	for task := range tasks {
+		res := p.apply(ctx, conn, task)
+		select {
+		case results <- res:
+		case <-ctx.Done():
+		}
+		inflight.Done()
+	}
+}
+
+// skipForeignKeyChecks sets session_replication_role to replica on the
+// worker connections, as rows of one transaction applied by different
+// workers may arrive child first. Where the role cannot be set, it fails if
+// any destination table has foreign keys.
+func (p *applyPipeline) skipForeignKeyChecks(ctx context.Context) error {
+	var setErr error
+	for _, conn := range p.dstConns {
+		if setErr = conn.SetSessionReplicationRole(ctx, "replica"); setErr != nil {
+			break
+		}
+	}
+	if setErr == nil {
+		return nil
+	}
+
+	tables := make([]string, 0, len(p.targets))
+	for _, target := range p.targets {
+		tables = append(tables, target.Schema+"."+target.Table)
+	}
+	fks, err := p.dstConns[0].GetAllForeignKeysForTables(ctx, tables)
+	if err != nil {
+		return err
+	}
+	for table, tableFKs := range fks {
+		if len(tableFKs) > 0 {
+			return fmt.Errorf("cannot apply changes to %s with %d workers: it has foreign keys, and setting session_replication_role to replica to skip them failed: %w",
+				table, len(p.dstConns), setErr)
+		}
+	}
+	p.logger.Info("could not set session_replication_role, destination tables have no foreign keys", slog.Any("error", setErr))
+	return nil
+}
+
+// apply applies the transactions of a task, in bulk if enabled
+func (p *applyPipeline) apply(ctx context.Context, conn *postgres.PostgresConnector, task *applyTask) applyResult {
+	res := applyResult{task: task, failed: make(map[*postgres.CDCRecord]bool), counts: newSyncCounts()}
+	if ctx.Err() != nil {
+		res.err = ctx.Err()
+		return res
+	}
+	p.prep.prepareChunks(ctx, task.txns, func(chunk *postgres.CDCTransaction) {
+		res.processed += p.a.countApplied(ctx, p.input.MirrorName, chunk, res.failed, res.counts)
+	})
+
+	if err := p.partitions.Ensure(ctx, conn, task.txns, p.targets); err != nil {
+		res.err = err
+		return res
+	}
+
+	if p.input.BulkApply && len(task.txns) > 0 && !postgres.HasSpooled(task.txns) {
+		err := postgres.ApplyBatch(ctx, conn, task.txns, p.targets, p.batchSize)
+		if err == nil {
+			return res
+		}
+		p.logger.Warn("bulk apply failed, applying transactions one by one", slog.Any("error", err))
+	}
+
+	for _, txn := range task.txns {
+		err := p.a.applySourceTransaction(ctx, p.input.MirrorName, p.logger, conn, txn, p.targets, p.errorPolicy, p.typeMap, res.failed)
+		if err != nil {
+			res.err = err
+			return res
+		}
+	}
+	return res
+}
@@ -0,0 +1,335 @@
+package activities
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+	"github.com/bunnydb/bunnydb/flow/model"
+)
+
+// fakeEmailDomain is the domain of every fake_email value
+const fakeEmailDomain = "example.com"
+
+// tableTransform rewrites the transformed columns of one table.
+//
+// Every transform works on the PostgreSQL text form of a value: the snapshot
+// selects transformed columns cast to text, and CDC receives text values or
+// re-encodes binary ones with the source's type map. A value is therefore
+// masked the same way by the initial load and by every later change, which
+// keeps hashed and tokenized keys joinable.
+type tableTransform struct {
+	columns map[string]*columnTransform
+	typeMap *pgtype.Map
+}
+
+// columnTransform is a validated transform of one column
+type columnTransform struct {
+	model.ColumnTransform
+	typeOID uint32
+	secret  []byte
+}
+
+// newTableTransform validates the transforms of a table against its source
+// schema. Key columns may only be hashed or tokenized, which keeps them
+// unique and lets CDC find the rows they identify. It returns nil if the
+// table has no transforms.
+func newTableTransform(
+	schema *postgres.TableSchema,
+	transforms []model.ColumnTransform,
+	secret string,
+	typeMap *pgtype.Map,
+) (*tableTransform, error) {
+	if len(transforms) == 0 {
+		return nil, nil
+	}
+
+	table := schema.SchemaName + "." + schema.TableName
+	keys := make(map[string]bool)
+	for _, col := range schema.PrimaryKeyColumns {
+		keys[col] = true
+	}
+	for _, col := range schema.ReplicaIdentityColumns {
+		keys[col] = true
+	}
+	columns := make(map[string]postgres.ColumnDefinition, len(schema.Columns))
+	for _, col := range schema.Columns {
+		columns[col.Name] = col
+	}
+
+	t := &tableTransform{columns: make(map[string]*columnTransform), typeMap: typeMap}
+	for _, ct := range transforms {
+		col, ok := columns[ct.Column]
+		if !ok {
+			return nil, fmt.Errorf("transformed column %s not found in %s", ct.Column, table)
+		}
+
+		switch ct.Type {
+		case model.ColumnTransformHash, model.ColumnTransformTokenize:
+		case model.ColumnTransformRedact, model.ColumnTransformFixed,
+			model.ColumnTransformFakeEmail, model.ColumnTransformFakePhone, model.ColumnTransformTruncate:
+			if keys[ct.Column] {
+				return nil, fmt.Errorf("key column %s of %s can only be hashed or tokenized", ct.Column, table)
+			}
+		default:
+			return nil, fmt.Errorf("unknown transform %q for %s.%s", ct.Type, table, ct.Column)
+		}
+
+		if ct.Type == model.ColumnTransformTruncate {
+			if ct.Length <= 0 {
+				return nil, fmt.Errorf("truncate transform of %s.%s needs a positive length", table, ct.Column)
+			}
+			if !isTextType(col.Type) {
+				return nil, fmt.Errorf("cannot truncate %s.%s of type %s", table, ct.Column, col.Type)
+			}
+		}
+		if ct.Type == model.ColumnTransformTokenize && secret == "" {
+			return nil, fmt.Errorf("tokenize transform of %s.%s requires BUNNY_TRANSFORM_SECRET on the worker", table, ct.Column)
+		}
+
+		c := &columnTransform{ColumnTransform: ct, typeOID: col.TypeOID}
+		if secret != "" {
+			c.secret = []byte(secret)
+		}
+		t.columns[ct.Column] = c
+	}
+	return t, nil
+}
+
+// isTextType reports whether a formatted type holds character strings
+func isTextType(typ string) bool {
+	for _, prefix := range []string{"text", "character", "citext", "name"} {
+		if strings.HasPrefix(typ, prefix) && !strings.HasSuffix(typ, "[]") {
+			return true
+		}
+	}
+	return false
+}
+
+// outputsText reports whether a transform writes text whatever the column's
+// type, so the destination column is created as text
+func (c *columnTransform) outputsText() bool {
+	return c.Type != model.ColumnTransformFixed && c.Type != model.ColumnTransformTruncate
+}
+
+// destinationSchema returns the schema a mapped table has on the
+// destination: the source schema without its excluded columns, with the
+// columns whose transform writes text retyped to text.
+func destinationSchema(schema *postgres.TableSchema, tm *model.TableMapping) (*postgres.TableSchema, error) {
+	schema, err := schema.ExcludeColumns(tm.ExcludeColumns)
+	if err != nil {
+		return nil, err
+	}
+	if len(tm.ColumnTransforms) == 0 {
+		return schema, nil
+	}
+
+	transformed := make(map[string]bool)
+	for _, ct := range tm.ColumnTransforms {
+		c := columnTransform{ColumnTransform: ct}
+		transformed[ct.Column] = c.outputsText()
+	}
+
+	retyped := *schema
+	retyped.Columns = make([]postgres.ColumnDefinition, len(schema.Columns))
+	for i, col := range schema.Columns {
+		if transformed[col.Name] {
+			col.Type = "text"
+			col.TypeOID = pgtype.TextOID
+			col.TypeModifier = -1
+			col.DefaultValue = nil
+		}
+		retyped.Columns[i] = col
+	}
+	return &retyped, nil
+}
+
+// transformRow rewrites the transformed columns of a snapshot row in place
+func (t *tableTransform) transformRow(colNames []string, values []interface{}) error {
+	if t == nil {
+		return nil
+	}
+	for i, name := range colNames {
+		if c, ok := t.columns[name]; ok {
+			v, err := t.apply(c, values[i])
+			if err != nil {
+				return err
+			}
+			values[i] = v
+		}
+	}
+	return nil
+}
+
+// transformRecord rewrites the transformed columns of a CDC record in place.
+// A column added by a schema change is created with its transformed type,
+// and type changes of columns stored as text are not replicated.
+func (t *tableTransform) transformRecord(rec *postgres.CDCRecord) error {
+	for _, values := range []map[string]interface{}{rec.OldValues, rec.NewValues} {
+		for name, val := range values {
+			if c, ok := t.columns[name]; ok {
+				v, err := t.apply(c, val)
+				if err != nil {
+					return err
+				}
+				values[name] = v
+			}
+		}
+	}
+
+	if delta := rec.SchemaDelta; delta != nil {
+		for i, col := range delta.AddedColumns {
+			if c, ok := t.columns[col.Name]; ok && c.outputsText() {
+				delta.AddedColumns[i].Type = "text"
+				delta.AddedColumns[i].DefaultValue = nil
+			}
+		}
+		changes := delta.TypeChanges[:0]
+		for _, change := range delta.TypeChanges {
+			if c, ok := t.columns[change.ColumnName]; !ok || !c.outputsText() {
+				changes = append(changes, change)
+			}
+		}
+		delta.TypeChanges = changes
+	}
+	return nil
+}
+
+// apply transforms one value. NULL stays NULL except for fixed values.
+func (t *tableTransform) apply(c *columnTransform, val interface{}) (interface{}, error) {
+	if c.Type == model.ColumnTransformFixed {
+		if c.Value == "" {
+			return nil, nil
+		}
+		return c.Value, nil
+	}
+	if val == nil {
+		return nil, nil
+	}
+
+	text, err := t.text(c, val)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Type {
+	case model.ColumnTransformHash:
+		sum := sha256.Sum256([]byte(text))
+		return hex.EncodeToString(sum[:]), nil
+
+	case model.ColumnTransformTokenize:
+		mac := hmac.New(sha256.New, c.secret)
+		mac.Write([]byte(text))
+		return "tok_" + hex.EncodeToString(mac.Sum(nil)[:16]), nil
+
+	case model.ColumnTransformRedact:
+		runes := []rune(text)
+		keep := c.Length
+		if keep > len(runes) {
+			keep = len(runes)
+		}
+		return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:]), nil
+
+	case model.ColumnTransformTruncate:
+		runes := []rune(text)
+		if len(runes) <= c.Length {
+			return text, nil
+		}
+		return string(runes[:c.Length]), nil
+
+	case model.ColumnTransformFakeEmail:
+		local := text
+		if at := strings.LastIndex(text, "@"); at >= 0 {
+			local = text[:at]
+		}
+		n := len([]rune(local))
+		if n == 0 {
+			n = 1
+		}
+		const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+		stream := c.keystream(text, n)
+		fake := make([]byte, n)
+		for i, b := range stream {
+			fake[i] = alphabet[int(b)%len(alphabet)]
+		}
+		// Start with a letter, as most local parts do
+		fake[0] = alphabet[int(stream[0])%26]
+		return string(fake) + "@" + fakeEmailDomain, nil
+
+	case model.ColumnTransformFakePhone:
+		stream := c.keystream(text, len(text))
+		var fake strings.Builder
+		for i, r := range text {
+			if r >= '0' && r <= '9' {
+				fake.WriteByte('0' + stream[i]%10)
+			} else {
+				fake.WriteRune(r)
+			}
+		}
+		return fake.String(), nil
+	}
+	return nil, fmt.Errorf("unknown transform %q", c.Type)
+}
+
+// text returns the PostgreSQL text form of a value
+func (t *tableTransform) text(c *columnTransform, val interface{}) (string, error) {
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	if t.typeMap == nil {
+		return fmt.Sprint(val), nil
+	}
+	buf, err := t.typeMap.Encode(c.typeOID, pgtype.TextFormatCode, val, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s for transform: %w", c.Column, err)
+	}
+	return string(buf), nil
+}
+
+// keystream derives n pseudo-random bytes from a value, keyed by the
+// transform secret when one is set
+func (c *columnTransform) keystream(text string, n int) []byte {
+	stream := make([]byte, 0, n+sha256.Size)
+	var counter [4]byte
+	for i := uint32(0); len(stream) < n; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		if c.secret != nil {
+			mac := hmac.New(sha256.New, c.secret)
+			mac.Write(counter[:])
+			mac.Write([]byte(text))
+			stream = mac.Sum(stream)
+		} else {
+			h := sha256.New()
+			h.Write(counter[:])
+			h.Write([]byte(text))
+			stream = h.Sum(stream)
+		}
+	}
+	return stream[:n]
+}
+
+// transformRecords applies column transforms to a batch of CDC records
+func transformRecords(txns []*postgres.CDCTransaction, transforms map[string]*tableTransform) error {
+	if len(transforms) == 0 {
+		return nil
+	}
+	for _, txn := range txns {
+		for _, rec := range txn.Records {
+			if t, ok := transforms[rec.Schema+"."+rec.Table]; ok {
+				if err := t.transformRecord(rec); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package activities
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+	"github.com/bunnydb/bunnydb/flow/model"
+)
+
+var transformSchema = &postgres.TableSchema{
+	SchemaName: "public",
+	TableName:  "users",
+	Columns: []postgres.ColumnDefinition{
+		{Name: "id", Type: "bigint", TypeOID: pgtype.Int8OID, IsPrimaryKey: true},
+		{Name: "email", Type: "text", TypeOID: pgtype.TextOID},
+		{Name: "phone", Type: "character varying(20)", TypeOID: pgtype.VarcharOID},
+		{Name: "name", Type: "text", TypeOID: pgtype.TextOID},
+		{Name: "bio", Type: "text", TypeOID: pgtype.TextOID},
+		{Name: "ssn", Type: "text", TypeOID: pgtype.TextOID},
+		{Name: "plan", Type: "text", TypeOID: pgtype.TextOID},
+	},
+	PrimaryKeyColumns: []string{"id"},
+}
+
+var userTransforms = []model.ColumnTransform{
+	{Column: "id", Type: model.ColumnTransformHash},
+	{Column: "email", Type: model.ColumnTransformFakeEmail},
+	{Column: "phone", Type: model.ColumnTransformFakePhone},
+	{Column: "name", Type: model.ColumnTransformRedact, Length: 2},
+	{Column: "bio", Type: model.ColumnTransformTruncate, Length: 5},
+	{Column: "ssn", Type: model.ColumnTransformTokenize},
+	{Column: "plan", Type: model.ColumnTransformFixed, Value: "free"},
+}
+
+func TestTransformsAreDeterministic(t *testing.T) {
+	transform, err := newTableTransform(transformSchema, userTransforms, "secret", pgtype.NewMap())
+	if err != nil {
+		t.Fatalf("failed to set up transforms: %v", err)
+	}
+	other, err := newTableTransform(transformSchema, userTransforms, "other secret", pgtype.NewMap())
+	if err != nil {
+		t.Fatalf("failed to set up transforms: %v", err)
+	}
+
+	tests := []struct {
+		column   string
+		snapshot interface{} // As selected by the snapshot, in text form
+		change   interface{} // As decoded by CDC, possibly from binary format
+		want     func(string) bool
+		secret   bool // Whether the output depends on the transform secret
+	}{
+		{"id", "42", int64(42), func(s string) bool { return len(s) == 64 }, false},
+		{"email", "ada@lovelace.org", "ada@lovelace.org", func(s string) bool {
+			return len(s) == len("ada@example.com") && strings.HasSuffix(s, "@example.com") && s != "ada@example.com"
+		}, true},
+		{"phone", "+44 (20) 7946-0958", "+44 (20) 7946-0958", func(s string) bool {
+			return len(s) == len("+44 (20) 7946-0958") && s[0] == '+' && s[4] == '(' && s[13] == '-'
+		}, true},
+		{"name", "Ada Lovelace", "Ada Lovelace", func(s string) bool { return s == "**********ce" }, false},
+		{"bio", "Mathematician", "Mathematician", func(s string) bool { return s == "Mathe" }, false},
+		{"ssn", "078-05-1120", "078-05-1120", func(s string) bool { return strings.HasPrefix(s, "tok_") && len(s) == 36 }, true},
+		{"plan", "pro", "pro", func(s string) bool { return s == "free" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.column, func(t *testing.T) {
+			row := []interface{}{tt.snapshot}
+			if err := transform.transformRow([]string{tt.column}, row); err != nil {
+				t.Fatalf("failed to transform snapshot value: %v", err)
+			}
+			snapshot, _ := row[0].(string)
+			if !tt.want(snapshot) {
+				t.Errorf("snapshot value %v is transformed to %q", tt.snapshot, snapshot)
+			}
+
+			// Old and new values of a change match the snapshot, so rows
+			// stay joinable on transformed keys
+			rec := &postgres.CDCRecord{
+				Operation: "UPDATE",
+				OldValues: map[string]interface{}{tt.column: tt.change},
+				NewValues: map[string]interface{}{tt.column: tt.change},
+			}
+			if err := transform.transformRecord(rec); err != nil {
+				t.Fatalf("failed to transform change: %v", err)
+			}
+			if rec.OldValues[tt.column] != snapshot || rec.NewValues[tt.column] != snapshot {
+				t.Errorf("change of %v is transformed to %q and %q, snapshot to %q",
+					tt.change, rec.OldValues[tt.column], rec.NewValues[tt.column], snapshot)
+			}
+
+			row = []interface{}{tt.snapshot}
+			if err := other.transformRow([]string{tt.column}, row); err != nil {
+				t.Fatalf("failed to transform snapshot value: %v", err)
+			}
+			if changed := row[0] != snapshot; changed != tt.secret {
+				t.Errorf("output changes with the secret: %v, want %v", changed, tt.secret)
+			}
+		})
+	}
+
+	// NULL stays NULL, except for fixed values
+	row := []interface{}{nil, nil}
+	if err := transform.transformRow([]string{"email", "plan"}, row); err != nil {
+		t.Fatalf("failed to transform NULLs: %v", err)
+	}
+	if row[0] != nil || row[1] != "free" {
+		t.Errorf("NULLs are transformed to %v", row)
+	}
+}
@@ -0,0 +1,339 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// ============================================================================
+// Dead-Letter Queue
+// ============================================================================
+
+// DeadLetterEntry is a CDC record that failed to apply
+type DeadLetterEntry struct {
+	ID           int64           `json:"id"`
+	TableName    string          `json:"table_name"`
+	Operation    string          `json:"operation"`
+	LSN          int64           `json:"lsn"`
+	CommitLSN    *int64          `json:"commit_lsn,omitempty"`
+	XID          *int64          `json:"xid,omitempty"`
+	Payload      json.RawMessage `json:"payload"`
+	ErrorMessage string          `json:"error_message"`
+	Attempts     int             `json:"attempts"`
+	Status       string          `json:"status"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// RetryDeadLetterRequest optionally replaces the record's values before it is
+// applied again (edit-and-retry). Values are PostgreSQL text; numbers and
+// other JSON values are taken as written.
+type RetryDeadLetterRequest struct {
+	NewValues postgres.TextValues `json:"new_values,omitempty"`
+	OldValues postgres.TextValues `json:"old_values,omitempty"`
+}
+
+// ListDeadLetters returns the dead-letter records of a mirror
+func (h *Handler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	mirrorName := r.PathValue("name")
+
+	if mirrorName == "" {
+		writeError(w, http.StatusBadRequest, "mirror name is required")
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := fmt.Sscanf(l, "%d", &limit); err == nil && parsed > 0 {
+			if limit > 500 {
+				limit = 500
+			}
+		}
+	}
+
+	offset := 0
+	if o := r.URL.Query().Get("offset"); o != "" {
+		fmt.Sscanf(o, "%d", &offset)
+		if offset < 0 {
+			offset = 0
+		}
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "PENDING"
+	}
+	table := r.URL.Query().Get("table")
+
+	where := "WHERE mirror_name = $1"
+	args := []interface{}{mirrorName}
+	argIdx := 2
+
+	if status != "ALL" {
+		where += fmt.Sprintf(" AND status = $%d", argIdx)
+		args = append(args, status)
+		argIdx++
+	}
+
+	if table != "" {
+		where += fmt.Sprintf(" AND table_name = $%d", argIdx)
+		args = append(args, table)
+		argIdx++
+	}
+
+	var total int
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM bunny_internal.dead_letter_queue %s", where)
+	if err := h.CatalogPool.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		slog.Error("failed to count dead-letter records", slog.Any("error", err))
+		writeError(w, http.StatusInternalServerError, "failed to fetch dead-letter records")
+		return
+	}
+
+	dataQuery := fmt.Sprintf(`
+		SELECT id, table_name, operation, lsn, commit_lsn, xid, payload, error_message,
+			attempts, status, created_at, updated_at
+		FROM bunny_internal.dead_letter_queue
+		%s
+		ORDER BY id
+		LIMIT $%d OFFSET $%d
+	`, where, argIdx, argIdx+1)
+	args = append(args, limit, offset)
+
+	rows, err := h.CatalogPool.Query(ctx, dataQuery, args...)
+	if err != nil {
+		slog.Error("failed to fetch dead-letter records", slog.Any("error", err))
+		writeError(w, http.StatusInternalServerError, "failed to fetch dead-letter records")
+		return
+	}
+	defer rows.Close()
+
+	entries := []DeadLetterEntry{}
+	for rows.Next() {
+		var e DeadLetterEntry
+		var payload []byte
+		err := rows.Scan(&e.ID, &e.TableName, &e.Operation, &e.LSN, &e.CommitLSN, &e.XID, &payload,
+			&e.ErrorMessage, &e.Attempts, &e.Status, &e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			slog.Error("failed to scan dead-letter row", slog.Any("error", err))
+			continue
+		}
+		e.Payload = payload
+		entries = append(entries, e)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"records": entries,
+		"total":   total,
+		"offset":  offset,
+		"limit":   limit,
+	})
+}
+
+// RetryDeadLetter applies a dead-letter record to the destination again,
+// optionally with edited values. The record is marked RESOLVED on success;
+// on failure it stays PENDING with the new error and attempt count.
+func (h *Handler) RetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	mirrorName := r.PathValue("name")
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if mirrorName == "" || err != nil {
+		writeError(w, http.StatusBadRequest, "mirror name and numeric record id are required")
+		return
+	}
+
+	var req RetryDeadLetterRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	var status string
+	var payloadJSON []byte
+	err = h.CatalogPool.QueryRow(ctx, `
+		SELECT status, payload FROM bunny_internal.dead_letter_queue
+		WHERE id = $1 AND mirror_name = $2
+	`, id, mirrorName).Scan(&status, &payloadJSON)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "dead-letter record not found")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to load dead-letter record", slog.Any("error", err))
+		writeError(w, http.StatusInternalServerError, "failed to load dead-letter record")
+		return
+	}
+	if status != "PENDING" {
+		writeError(w, http.StatusConflict, fmt.Sprintf("dead-letter record is %s", status))
+		return
+	}
+
+	var payload postgres.RecordPayload
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		writeError(w, http.StatusInternalServerError, "dead-letter payload is not valid")
+		return
+	}
+
+	edited := false
+	if req.NewValues != nil {
+		payload.NewValues = req.NewValues
+		edited = true
+	}
+	if req.OldValues != nil {
+		payload.OldValues = req.OldValues
+		edited = true
+	}
+
+	dst, err := h.connectMirrorDestination(ctx, mirrorName)
+	if err != nil {
+		slog.Error("failed to connect to destination", slog.Any("error", err))
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("failed to connect to destination: %v", err))
+		return
+	}
+	defer dst.Close()
+
+	rec, target := payload.Record()
+	var applyErr error
+	if dstConn, ok := dst.(*postgres.PostgresConnector); ok {
+		applyErr = postgres.ApplyRecord(ctx, dstConn, rec, target)
+	} else {
+		applyErr = replayRecord(ctx, dst, rec, target)
+	}
+
+	newPayload, _ := json.Marshal(&payload)
+	newStatus := "RESOLVED"
+	errMsg := ""
+	if applyErr != nil {
+		newStatus = "PENDING"
+		errMsg = applyErr.Error()
+	}
+
+	_, err = h.CatalogPool.Exec(ctx, `
+		UPDATE bunny_internal.dead_letter_queue
+		SET status = $3, attempts = attempts + 1, payload = $4,
+			error_message = CASE WHEN $5 = '' THEN error_message ELSE $5 END
+		WHERE id = $1 AND mirror_name = $2
+	`, id, mirrorName, newStatus, newPayload, errMsg)
+	if err != nil {
+		slog.Error("failed to update dead-letter record", slog.Any("error", err))
+	}
+
+	h.WriteMirrorLog(ctx, mirrorName, "INFO", "User triggered: Retry Dead-Letter Record", map[string]interface{}{
+		"id":      id,
+		"edited":  edited,
+		"success": applyErr == nil,
+	})
+
+	if applyErr != nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":      id,
+			"success": false,
+			"status":  newStatus,
+			"error":   errMsg,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":      id,
+		"success": true,
+		"status":  newStatus,
+	})
+}
+
+// DiscardDeadLetter marks a dead-letter record as discarded
+func (h *Handler) DiscardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	mirrorName := r.PathValue("name")
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if mirrorName == "" || err != nil {
+		writeError(w, http.StatusBadRequest, "mirror name and numeric record id are required")
+		return
+	}
+
+	tag, err := h.CatalogPool.Exec(ctx, `
+		UPDATE bunny_internal.dead_letter_queue SET status = 'DISCARDED'
+		WHERE id = $1 AND mirror_name = $2 AND status = 'PENDING'
+	`, id, mirrorName)
+	if err != nil {
+		slog.Error("failed to discard dead-letter record", slog.Any("error", err))
+		writeError(w, http.StatusInternalServerError, "failed to discard dead-letter record")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeError(w, http.StatusNotFound, "pending dead-letter record not found")
+		return
+	}
+
+	h.WriteMirrorLog(ctx, mirrorName, "INFO", "User triggered: Discard Dead-Letter Record", map[string]interface{}{
+		"id": id,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"status": "DISCARDED",
+	})
+}
+
+// connectMirrorDestination opens a connection to a mirror's destination peer
+func (h *Handler) connectMirrorDestination(ctx context.Context, mirrorName string) (connectors.Destination, error) {
+	var peerName string
+	err := h.CatalogPool.QueryRow(ctx, `
+		SELECT p.name
+		FROM bunny_internal.mirrors m
+		JOIN bunny_internal.peers p ON p.id = m.destination_peer_id
+		WHERE m.name = $1
+	`, mirrorName).Scan(&peerName)
+	if err != nil {
+		return nil, fmt.Errorf("destination peer not found for mirror %s: %w", mirrorName, err)
+	}
+
+	peer, err := connectors.LoadPeer(ctx, h.CatalogPool, peerName)
+	if err != nil {
+		return nil, err
+	}
+	return connectors.NewDestination(ctx, peer)
+}
+
+// replayRecord writes a dead-letter record to a destination other than
+// Postgres as a transaction of its own, waiting until a buffering
+// destination has sent it
+func replayRecord(ctx context.Context, dst connectors.Destination, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	txn := &postgres.CDCTransaction{
+		XID:        rec.XID,
+		CommitLSN:  rec.CommitLSN,
+		EndLSN:     rec.CommitLSN,
+		CommitTime: rec.CommitTime,
+		Records:    []*postgres.CDCRecord{rec},
+	}
+	targets := map[string]*postgres.ApplyTarget{rec.Schema + "." + rec.Table: target}
+	if err := dst.ApplyChanges(ctx, []*postgres.CDCTransaction{txn}, targets); err != nil {
+		return err
+	}
+	if bd, ok := dst.(connectors.BufferingDestination); ok {
+		if err := bd.Flush(ctx, true); err != nil {
+			return err
+		}
+	}
+	if rd, ok := dst.(connectors.RejectingDestination); ok {
+		if rejected := rd.TakeRejected(); len(rejected) > 0 {
+			return rejected[0].Err
+		}
+	}
+	return nil
+}
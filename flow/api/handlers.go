@@ -14,6 +14,8 @@ import (
 	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/client"
 
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
 	"github.com/bunnydb/bunnydb/flow/model"
 	"github.com/bunnydb/bunnydb/flow/shared"
 	"github.com/bunnydb/bunnydb/flow/workflows"
@@ -58,6 +60,40 @@ type CreateMirrorRequest struct {
 
 	// ResyncStrategy: "truncate" (default) or "swap" (zero-downtime)
 	ResyncStrategy string `json:"resync_strategy,omitempty"`
+
+	// IgnoreTruncate skips source TRUNCATEs (destination used as an archive)
+	IgnoreTruncate bool `json:"ignore_truncate,omitempty"`
+
+	// StreamLargeTransactions streams in-progress transactions from the
+	// source (PG14+) instead of waiting for their commit
+	StreamLargeTransactions bool `json:"stream_large_transactions,omitempty"`
+
+	// BinaryFormat decodes replicated values from binary (PG14+) into typed
+	// values instead of passing text through
+	BinaryFormat bool `json:"binary_format,omitempty"`
+
+	// ApplyErrorPolicy: "dlq" (default), "skip" or "halt"
+	ApplyErrorPolicy string `json:"apply_error_policy,omitempty"`
+
+	// SetReplicaIdentityFull sets REPLICA IDENTITY FULL on source tables that
+	// have no primary key or replica identity index
+	SetReplicaIdentityFull bool `json:"set_replica_identity_full,omitempty"`
+
+	// BulkApply applies each CDC batch with COPY into staging tables and one
+	// MERGE/upsert and DELETE per table instead of a statement per record
+	BulkApply bool `json:"bulk_apply,omitempty"`
+
+	// ApplyWorkers applies CDC changes with this many parallel workers,
+	// keeping changes to the same row in order (0 or 1: sequential)
+	ApplyWorkers uint32 `json:"apply_workers,omitempty"`
+
+	// SoftDeleteColName keeps deleted rows on the destination, with this
+	// boolean column set to true
+	SoftDeleteColName string `json:"soft_delete_col_name,omitempty"`
+
+	// SyncedAtColName stamps every applied row with the apply time in this
+	// timestamptz column
+	SyncedAtColName string `json:"synced_at_col_name,omitempty"`
 }
 
 // TableMappingInput is the input for table mapping
@@ -68,6 +104,22 @@ type TableMappingInput struct {
 	DestinationTable  string   `json:"destination_table"`
 	PartitionKey      string   `json:"partition_key,omitempty"`
 	ExcludeColumns    []string `json:"exclude_columns,omitempty"`
+	RowFilter         string   `json:"row_filter,omitempty"`
+
+	ColumnTransforms []ColumnTransformInput `json:"column_transforms,omitempty"`
+
+	// Mode: "mirror" (default), "changelog" (append every change) or
+	// "history" (keep every row version)
+	Mode string `json:"mode,omitempty"`
+}
+
+// ColumnTransformInput is the input for a column transform
+type ColumnTransformInput struct {
+	Column string `json:"column"`
+	// Type: hash, redact, fixed, truncate, fake_email, fake_phone or tokenize
+	Type   string `json:"type"`
+	Value  string `json:"value,omitempty"`
+	Length int    `json:"length,omitempty"`
 }
 
 // MirrorResponse is the response for mirror operations
@@ -80,15 +132,18 @@ type MirrorResponse struct {
 
 // MirrorStatusResponse is the response for mirror status
 type MirrorStatusResponse struct {
-	Name            string                `json:"name"`
-	Status          string                `json:"status"`
-	SlotName        string                `json:"slot_name"`
-	PublicationName string                `json:"publication_name"`
-	LastLSN         int64                 `json:"last_lsn"`
-	LastSyncBatchID int64                 `json:"last_sync_batch_id"`
-	ErrorMessage    string                `json:"error_message,omitempty"`
-	ErrorCount      int                   `json:"error_count"`
-	Tables          []TableStatusResponse `json:"tables,omitempty"`
+	Name            string `json:"name"`
+	Status          string `json:"status"`
+	SlotName        string `json:"slot_name"`
+	PublicationName string `json:"publication_name"`
+	LastLSN         int64  `json:"last_lsn"`
+	LastSyncBatchID int64  `json:"last_sync_batch_id"`
+	// DestinationOffsets are the positions of the last changes written to
+	// destinations that assign their own, such as Kafka offsets
+	DestinationOffsets map[string]int64      `json:"destination_offsets,omitempty"`
+	ErrorMessage       string                `json:"error_message,omitempty"`
+	ErrorCount         int                   `json:"error_count"`
+	Tables             []TableStatusResponse `json:"tables,omitempty"`
 }
 
 // TableStatusResponse is the status of a single table
@@ -108,19 +163,22 @@ type ResyncRequest struct {
 
 // CreatePeerRequest is the request to create a peer
 type CreatePeerRequest struct {
-	Name     string `json:"name"`
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	Database string `json:"database"`
-	SSLMode  string `json:"ssl_mode,omitempty"`
+	Name     string                 `json:"name"`
+	PeerType string                 `json:"peer_type,omitempty"` // Default: POSTGRES
+	Host     string                 `json:"host"`
+	Port     int                    `json:"port"`
+	User     string                 `json:"user"`
+	Password string                 `json:"password"`
+	Database string                 `json:"database"`
+	SSLMode  string                 `json:"ssl_mode,omitempty"`
+	Config   map[string]interface{} `json:"config,omitempty"` // Settings specific to the peer type
 }
 
 // PeerResponse is the response for peer operations
 type PeerResponse struct {
 	ID       int64  `json:"id"`
 	Name     string `json:"name"`
+	PeerType string `json:"peer_type"`
 	Host     string `json:"host"`
 	Port     int    `json:"port"`
 	User     string `json:"user"`
@@ -164,6 +222,34 @@ func (h *Handler) CreateMirror(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate apply error policy
+	switch model.ApplyErrorPolicy(req.ApplyErrorPolicy) {
+	case "", model.ApplyErrorPolicyDLQ, model.ApplyErrorPolicySkip, model.ApplyErrorPolicyHalt:
+	default:
+		writeError(w, http.StatusBadRequest, "apply_error_policy must be 'dlq', 'skip' or 'halt'")
+		return
+	}
+
+	if req.ApplyWorkers > 32 {
+		writeError(w, http.StatusBadRequest, "apply_workers must be at most 32")
+		return
+	}
+
+	if err := validateColumnTransforms(req.TableMappings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := validateTableModes(req.TableMappings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.SoftDeleteColName != "" && req.SoftDeleteColName == req.SyncedAtColName {
+		writeError(w, http.StatusBadRequest, "soft_delete_col_name and synced_at_col_name must differ")
+		return
+	}
+
 	// Set defaults
 	if req.MaxBatchSize == 0 {
 		req.MaxBatchSize = 1000
@@ -182,24 +268,42 @@ func (h *Handler) CreateMirror(w http.ResponseWriter, r *http.Request) {
 			DestinationTable:  tm.DestinationTable,
 			PartitionKey:      tm.PartitionKey,
 			ExcludeColumns:    tm.ExcludeColumns,
+			RowFilter:         tm.RowFilter,
+			ColumnTransforms:  columnTransforms(tm.ColumnTransforms),
+			Mode:              model.TableMode(tm.Mode),
 		})
 	}
 
 	// Get peer IDs for storing in mirrors table
 	var sourcePeerID, destPeerID int
-	err := h.CatalogPool.QueryRow(ctx, `SELECT id FROM bunny_internal.peers WHERE name = $1`, req.SourcePeer).Scan(&sourcePeerID)
+	var sourceType, destType connectors.PeerType
+	err := h.CatalogPool.QueryRow(ctx, `SELECT id, peer_type FROM bunny_internal.peers WHERE name = $1`, req.SourcePeer).Scan(&sourcePeerID, &sourceType)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, fmt.Sprintf("source peer not found: %s", req.SourcePeer))
 		return
 	}
-	err = h.CatalogPool.QueryRow(ctx, `SELECT id FROM bunny_internal.peers WHERE name = $1`, req.DestinationPeer).Scan(&destPeerID)
+	err = h.CatalogPool.QueryRow(ctx, `SELECT id, peer_type FROM bunny_internal.peers WHERE name = $1`, req.DestinationPeer).Scan(&destPeerID, &destType)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, fmt.Sprintf("destination peer not found: %s", req.DestinationPeer))
 		return
 	}
 
+	if reg, ok := connectors.Lookup(sourceType); !ok || reg.NewSource == nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("peer %s of type %s cannot be a mirror source", req.SourcePeer, sourceType))
+		return
+	}
+	if reg, ok := connectors.Lookup(destType); !ok || reg.NewDestination == nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("peer %s of type %s cannot be a mirror destination", req.DestinationPeer, destType))
+		return
+	}
+	if err := validateDestinationModes(destType, req.TableMappings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Store mirror config in mirrors table
 	configJSON, _ := json.Marshal(map[string]interface{}{
+		"table_mappings":                  req.TableMappings,
 		"do_initial_snapshot":             req.DoInitialSnapshot,
 		"max_batch_size":                  req.MaxBatchSize,
 		"idle_timeout_seconds":            req.IdleTimeoutSeconds,
@@ -209,6 +313,15 @@ func (h *Handler) CreateMirror(w http.ResponseWriter, r *http.Request) {
 		"replicate_indexes":               req.ReplicateIndexes,
 		"replicate_foreign_keys":          req.ReplicateForeignKeys,
 		"resync_strategy":                 req.ResyncStrategy,
+		"ignore_truncate":                 req.IgnoreTruncate,
+		"stream_large_transactions":       req.StreamLargeTransactions,
+		"binary_format":                   req.BinaryFormat,
+		"apply_error_policy":              req.ApplyErrorPolicy,
+		"set_replica_identity_full":       req.SetReplicaIdentityFull,
+		"bulk_apply":                      req.BulkApply,
+		"apply_workers":                   req.ApplyWorkers,
+		"soft_delete_col_name":            req.SoftDeleteColName,
+		"synced_at_col_name":              req.SyncedAtColName,
 	})
 
 	_, err = h.CatalogPool.Exec(ctx, `
@@ -268,6 +381,15 @@ func (h *Handler) CreateMirror(w http.ResponseWriter, r *http.Request) {
 		ReplicateIndexes:              req.ReplicateIndexes,
 		ReplicateForeignKeys:          req.ReplicateForeignKeys,
 		ResyncStrategy:                resyncStrategy,
+		IgnoreTruncate:                req.IgnoreTruncate,
+		StreamLargeTransactions:       req.StreamLargeTransactions,
+		BinaryFormat:                  req.BinaryFormat,
+		ApplyErrorPolicy:              model.ApplyErrorPolicy(req.ApplyErrorPolicy),
+		SetReplicaIdentityFull:        req.SetReplicaIdentityFull,
+		BulkApply:                     req.BulkApply,
+		ApplyWorkers:                  req.ApplyWorkers,
+		SoftDeleteColName:             req.SoftDeleteColName,
+		SyncedAtColName:               req.SyncedAtColName,
 	}
 
 	we, err := h.TemporalClient.ExecuteWorkflow(ctx, workflowOptions, workflows.CDCFlowWorkflow, input, nil)
@@ -320,13 +442,14 @@ func (h *Handler) GetMirrorStatus(w http.ResponseWriter, r *http.Request) {
 			COALESCE(publication_name, ''),
 			COALESCE(last_lsn, 0),
 			COALESCE(last_sync_batch_id, 0),
+			destination_offsets,
 			error_message,
 			COALESCE(error_count, 0)
 		FROM bunny_internal.mirror_state
 		WHERE mirror_name = $1
 	`, mirrorName).Scan(
 		&response.Name, &response.Status, &response.SlotName, &response.PublicationName,
-		&response.LastLSN, &response.LastSyncBatchID, &errMsg, &response.ErrorCount)
+		&response.LastLSN, &response.LastSyncBatchID, &response.DestinationOffsets, &errMsg, &response.ErrorCount)
 
 	if err != nil {
 		writeError(w, http.StatusNotFound, "mirror not found")
@@ -502,6 +625,16 @@ func (h *Handler) DeleteMirror(w http.ResponseWriter, r *http.Request) {
 		slog.Warn("failed to delete index definitions", slog.Any("error", err))
 	}
 
+	_, err = h.CatalogPool.Exec(ctx, `DELETE FROM bunny_internal.dead_letter_queue WHERE mirror_name = $1`, mirrorName)
+	if err != nil {
+		slog.Warn("failed to delete dead-letter records", slog.Any("error", err))
+	}
+
+	_, err = h.CatalogPool.Exec(ctx, `DELETE FROM bunny_internal.table_schema_mapping WHERE mirror_name = $1`, mirrorName)
+	if err != nil {
+		slog.Warn("failed to delete relation layouts", slog.Any("error", err))
+	}
+
 	_, err = h.CatalogPool.Exec(ctx, `DELETE FROM bunny_internal.mirror_state WHERE mirror_name = $1`, mirrorName)
 	if err != nil {
 		slog.Error("failed to delete mirror state", slog.Any("error", err))
@@ -674,6 +807,10 @@ func (h *Handler) restartMirrorWorkflow(ctx context.Context, w http.ResponseWrit
 					DestinationSchema: getString(tmap, "destination_schema"),
 					DestinationTable:  getString(tmap, "destination_table"),
 					PartitionKey:      getString(tmap, "partition_key"),
+					ExcludeColumns:    getStringSlice(tmap, "exclude_columns"),
+					RowFilter:         getString(tmap, "row_filter"),
+					ColumnTransforms:  getColumnTransforms(tmap, "column_transforms"),
+					Mode:              model.TableMode(getString(tmap, "mode")),
 				})
 			}
 		}
@@ -733,13 +870,22 @@ func (h *Handler) restartMirrorWorkflow(ctx context.Context, w http.ResponseWrit
 	}
 
 	input := &workflows.CDCFlowInput{
-		MirrorName:         mirrorName,
-		SourcePeer:         sourcePeerName,
-		DestinationPeer:    destPeerName,
-		TableMappings:      tableMappings,
-		DoInitialSnapshot:  false, // Don't re-snapshot on restart
-		MaxBatchSize:       uint32(getInt(config, "max_batch_size", 1000)),
-		IdleTimeoutSeconds: uint64(getInt(config, "idle_timeout_seconds", 60)),
+		MirrorName:              mirrorName,
+		SourcePeer:              sourcePeerName,
+		DestinationPeer:         destPeerName,
+		TableMappings:           tableMappings,
+		DoInitialSnapshot:       false, // Don't re-snapshot on restart
+		MaxBatchSize:            uint32(getInt(config, "max_batch_size", 1000)),
+		IdleTimeoutSeconds:      uint64(getInt(config, "idle_timeout_seconds", 60)),
+		IgnoreTruncate:          getBool(config, "ignore_truncate"),
+		StreamLargeTransactions: getBool(config, "stream_large_transactions"),
+		BinaryFormat:            getBool(config, "binary_format"),
+		ApplyErrorPolicy:        model.ApplyErrorPolicy(getString(config, "apply_error_policy")),
+		SetReplicaIdentityFull:  getBool(config, "set_replica_identity_full"),
+		BulkApply:               getBool(config, "bulk_apply"),
+		ApplyWorkers:            uint32(getInt(config, "apply_workers", 0)),
+		SoftDeleteColName:       getString(config, "soft_delete_col_name"),
+		SyncedAtColName:         getString(config, "synced_at_col_name"),
 	}
 
 	// Create initial state with last LSN/BatchID to resume CDC
@@ -776,7 +922,120 @@ func (h *Handler) restartMirrorWorkflow(ctx context.Context, w http.ResponseWrit
 	})
 }
 
+// validateColumnTransforms checks the column transforms of table mappings.
+// Whether the columns exist and may be transformed is checked by the worker
+// against the source schema.
+func validateColumnTransforms(mappings []TableMappingInput) error {
+	for _, tm := range mappings {
+		seen := make(map[string]bool)
+		for _, t := range tm.ColumnTransforms {
+			table := tm.SourceSchema + "." + tm.SourceTable
+			if t.Column == "" {
+				return fmt.Errorf("column transform of %s is missing a column", table)
+			}
+			if seen[t.Column] {
+				return fmt.Errorf("column %s of %s has more than one transform", t.Column, table)
+			}
+			seen[t.Column] = true
+
+			switch model.ColumnTransformType(t.Type) {
+			case model.ColumnTransformHash, model.ColumnTransformRedact, model.ColumnTransformFixed,
+				model.ColumnTransformFakeEmail, model.ColumnTransformFakePhone, model.ColumnTransformTokenize:
+			case model.ColumnTransformTruncate:
+				if t.Length <= 0 {
+					return fmt.Errorf("truncate transform of %s.%s needs a positive length", table, t.Column)
+				}
+			default:
+				return fmt.Errorf("unknown transform %q for %s.%s: must be 'hash', 'redact', 'fixed', "+
+					"'truncate', 'fake_email', 'fake_phone' or 'tokenize'", t.Type, table, t.Column)
+			}
+			if t.Length < 0 {
+				return fmt.Errorf("transform length of %s.%s must not be negative", table, t.Column)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTableModes checks the modes of table mappings
+func validateTableModes(mappings []TableMappingInput) error {
+	for _, tm := range mappings {
+		switch model.TableMode(tm.Mode) {
+		case "", model.TableModeMirror, model.TableModeChangelog, model.TableModeHistory:
+		default:
+			return fmt.Errorf("unknown mode %q for %s.%s: must be 'mirror', 'changelog' or 'history'",
+				tm.Mode, tm.SourceSchema, tm.SourceTable)
+		}
+	}
+	return nil
+}
+
+// validateDestinationModes rejects changelog and history tables on
+// destinations other than Postgres, which only mirror rows
+func validateDestinationModes(destType connectors.PeerType, mappings []TableMappingInput) error {
+	if destType == connectors.PeerTypePostgres {
+		return nil
+	}
+	for _, tm := range mappings {
+		switch model.TableMode(tm.Mode) {
+		case "", model.TableModeMirror:
+		default:
+			return fmt.Errorf("mode %q for %s.%s needs a POSTGRES destination, not %s",
+				tm.Mode, tm.SourceSchema, tm.SourceTable, destType)
+		}
+	}
+	return nil
+}
+
+// columnTransforms converts column transform inputs to the model
+func columnTransforms(inputs []ColumnTransformInput) []model.ColumnTransform {
+	var transforms []model.ColumnTransform
+	for _, t := range inputs {
+		transforms = append(transforms, model.ColumnTransform{
+			Column: t.Column,
+			Type:   model.ColumnTransformType(t.Type),
+			Value:  t.Value,
+			Length: t.Length,
+		})
+	}
+	return transforms
+}
+
 // Helper functions for config parsing
+func getStringSlice(m map[string]interface{}, key string) []string {
+	items, ok := m[key].([]interface{})
+	if !ok {
+		return nil
+	}
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// getColumnTransforms parses the column transforms of a stored table mapping
+func getColumnTransforms(m map[string]interface{}, key string) []model.ColumnTransform {
+	items, ok := m[key].([]interface{})
+	if !ok {
+		return nil
+	}
+	result := make([]model.ColumnTransform, 0, len(items))
+	for _, item := range items {
+		if t, ok := item.(map[string]interface{}); ok {
+			result = append(result, model.ColumnTransform{
+				Column: getString(t, "column"),
+				Type:   model.ColumnTransformType(getString(t, "type")),
+				Value:  getString(t, "value"),
+				Length: getInt(t, "length", 0),
+			})
+		}
+	}
+	return result
+}
+
 func getString(m map[string]interface{}, key string) string {
 	if v, ok := m[key].(string); ok {
 		return v
@@ -791,6 +1050,13 @@ func getInt(m map[string]interface{}, key string, defaultVal int) int {
 	return defaultVal
 }
 
+func getBool(m map[string]interface{}, key string) bool {
+	if v, ok := m[key].(bool); ok {
+		return v
+	}
+	return false
+}
+
 // SyncSchema triggers a schema sync operation
 func (h *Handler) SyncSchema(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -1112,6 +1378,31 @@ func (h *Handler) UpdateMirrorTables(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validateColumnTransforms(req.TableMappings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := validateTableModes(req.TableMappings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var destType connectors.PeerType
+	err = h.CatalogPool.QueryRow(ctx, `
+		SELECT p.peer_type FROM bunny_internal.mirrors m
+		JOIN bunny_internal.peers p ON m.destination_peer_id = p.id
+		WHERE m.name = $1
+	`, mirrorName).Scan(&destType)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get destination peer")
+		return
+	}
+	if err := validateDestinationModes(destType, req.TableMappings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Get source peer to update publication
 	var sourcePeerName string
 	err = h.CatalogPool.QueryRow(ctx, `
@@ -1153,25 +1444,43 @@ func (h *Handler) UpdateMirrorTables(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Connect to source and update publication
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
-		user, password, host, port, database, sslMode)
-
 	updateCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	pool, err := pgxpool.New(updateCtx, connStr)
+	srcConn, err := postgres.NewPostgresConnector(updateCtx, &postgres.PostgresConfig{
+		Host:     host,
+		Port:     port,
+		User:     user,
+		Password: password,
+		Database: database,
+		SSLMode:  sslMode,
+	})
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to connect to source: %s", err.Error()))
 		return
 	}
-	defer pool.Close()
+	defer srcConn.Close()
+
+	// Excluded columns are left out of the publication where the source allows
+	var pubTables []postgres.PublicationTable
+	for _, tm := range req.TableMappings {
+		schema, err := srcConn.GetTableSchema(updateCtx, tm.SourceSchema, tm.SourceTable)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to get schema of %s.%s: %s", tm.SourceSchema, tm.SourceTable, err.Error()))
+			return
+		}
+		pubTable, err := srcConn.PublicationTableFor(updateCtx, schema, tm.ExcludeColumns, tm.RowFilter)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		pubTables = append(pubTables, pubTable)
+	}
 
 	// Update publication to use new tables
-	alterSQL := fmt.Sprintf("ALTER PUBLICATION %s SET TABLE %s", publicationName, joinTables(tables))
-	_, err = pool.Exec(updateCtx, alterSQL)
-	if err != nil {
+	if err := srcConn.SetPublicationTables(updateCtx, publicationName, pubTables); err != nil {
 		slog.Error("failed to update publication", slog.Any("error", err))
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to update publication: %s", err.Error()))
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -1216,18 +1525,6 @@ func (h *Handler) UpdateMirrorTables(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func joinTables(tables []string) string {
-	if len(tables) == 0 {
-		return ""
-	}
-	result := make([]string, len(tables))
-	for i, t := range tables {
-		// Tables should already be in schema.table format
-		result[i] = t
-	}
-	return strings.Join(result, ", ")
-}
-
 // ListMirrors lists all mirrors
 func (h *Handler) ListMirrors(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -1385,24 +1682,46 @@ func (h *Handler) CreatePeer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Name == "" || req.Host == "" || req.Database == "" {
-		writeError(w, http.StatusBadRequest, "name, host, and database are required")
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
 		return
 	}
 
-	if req.Port == 0 {
+	peerType, err := connectors.ParsePeerType(req.PeerType)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Port == 0 && peerType == connectors.PeerTypePostgres {
 		req.Port = 5432
 	}
 	if req.SSLMode == "" {
 		req.SSLMode = "prefer"
 	}
 
+	peer := &connectors.Peer{
+		Name:     req.Name,
+		Type:     peerType,
+		Host:     req.Host,
+		Port:     req.Port,
+		User:     req.User,
+		Password: req.Password,
+		Database: req.Database,
+		SSLMode:  req.SSLMode,
+		Config:   req.Config,
+	}
+	if err := connectors.Validate(peer); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s peer: %v", peerType, err))
+		return
+	}
+
 	var peerID int64
-	err := h.CatalogPool.QueryRow(ctx, `
-		INSERT INTO bunny_internal.peers (name, host, port, username, password, database, ssl_mode)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	err = h.CatalogPool.QueryRow(ctx, `
+		INSERT INTO bunny_internal.peers (name, peer_type, host, port, username, password, database, ssl_mode, config)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id
-	`, req.Name, req.Host, req.Port, req.User, req.Password, req.Database, req.SSLMode).Scan(&peerID)
+	`, req.Name, peerType, req.Host, req.Port, req.User, req.Password, req.Database, req.SSLMode, req.Config).Scan(&peerID)
 
 	if err != nil {
 		slog.Error("failed to create peer", slog.Any("error", err))
@@ -1413,6 +1732,7 @@ func (h *Handler) CreatePeer(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, PeerResponse{
 		ID:       peerID,
 		Name:     req.Name,
+		PeerType: string(peerType),
 		Host:     req.Host,
 		Port:     req.Port,
 		User:     req.User,
@@ -1426,7 +1746,7 @@ func (h *Handler) ListPeers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	rows, err := h.CatalogPool.Query(ctx, `
-		SELECT id, name, host, port, username, database, ssl_mode
+		SELECT id, name, peer_type, host, port, username, database, ssl_mode
 		FROM bunny_internal.peers
 		ORDER BY name
 	`)
@@ -1439,7 +1759,7 @@ func (h *Handler) ListPeers(w http.ResponseWriter, r *http.Request) {
 	var peers []PeerResponse
 	for rows.Next() {
 		var p PeerResponse
-		rows.Scan(&p.ID, &p.Name, &p.Host, &p.Port, &p.User, &p.Database, &p.SSLMode)
+		rows.Scan(&p.ID, &p.Name, &p.PeerType, &p.Host, &p.Port, &p.User, &p.Database, &p.SSLMode)
 		peers = append(peers, p)
 	}
 
@@ -1462,10 +1782,10 @@ func (h *Handler) GetPeer(w http.ResponseWriter, r *http.Request) {
 
 	var p PeerResponse
 	err := h.CatalogPool.QueryRow(ctx, `
-		SELECT id, name, host, port, username, database, ssl_mode
+		SELECT id, name, peer_type, host, port, username, database, ssl_mode
 		FROM bunny_internal.peers
 		WHERE name = $1
-	`, peerName).Scan(&p.ID, &p.Name, &p.Host, &p.Port, &p.User, &p.Database, &p.SSLMode)
+	`, peerName).Scan(&p.ID, &p.Name, &p.PeerType, &p.Host, &p.Port, &p.User, &p.Database, &p.SSLMode)
 
 	if err != nil {
 		writeError(w, http.StatusNotFound, "peer not found")
@@ -1500,9 +1820,10 @@ func (h *Handler) UpdatePeer(w http.ResponseWriter, r *http.Request) {
 
 	result, err := h.CatalogPool.Exec(ctx, `
 		UPDATE bunny_internal.peers
-		SET host = $1, port = $2, username = $3, password = $4, database = $5, ssl_mode = $6
+		SET host = $1, port = $2, username = $3, password = $4, database = $5, ssl_mode = $6,
+			config = COALESCE($8, config)
 		WHERE name = $7
-	`, req.Host, req.Port, req.User, req.Password, req.Database, req.SSLMode, peerName)
+	`, req.Host, req.Port, req.User, req.Password, req.Database, req.SSLMode, peerName, req.Config)
 
 	if err != nil {
 		slog.Error("failed to update peer", slog.Any("error", err))
@@ -1518,10 +1839,10 @@ func (h *Handler) UpdatePeer(w http.ResponseWriter, r *http.Request) {
 	// Fetch updated peer to return
 	var p PeerResponse
 	err = h.CatalogPool.QueryRow(ctx, `
-		SELECT id, name, host, port, username, database, ssl_mode
+		SELECT id, name, peer_type, host, port, username, database, ssl_mode
 		FROM bunny_internal.peers
 		WHERE name = $1
-	`, peerName).Scan(&p.ID, &p.Name, &p.Host, &p.Port, &p.User, &p.Database, &p.SSLMode)
+	`, peerName).Scan(&p.ID, &p.Name, &p.PeerType, &p.Host, &p.Port, &p.User, &p.Database, &p.SSLMode)
 
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to fetch updated peer")
@@ -1570,17 +1891,23 @@ func (h *Handler) TestPeer(w http.ResponseWriter, r *http.Request) {
 
 	var host, user, password, database, sslMode string
 	var port int
+	var peerType connectors.PeerType
 	err := h.CatalogPool.QueryRow(ctx, `
-		SELECT host, port, username, password, database, ssl_mode
+		SELECT peer_type, host, port, username, password, database, ssl_mode
 		FROM bunny_internal.peers
 		WHERE name = $1
-	`, peerName).Scan(&host, &port, &user, &password, &database, &sslMode)
+	`, peerName).Scan(&peerType, &host, &port, &user, &password, &database, &sslMode)
 
 	if err != nil {
 		writeError(w, http.StatusNotFound, "peer not found")
 		return
 	}
 
+	if peerType != connectors.PeerTypePostgres {
+		h.testConnectorPeer(w, r, peerName)
+		return
+	}
+
 	connStr := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
 		user, password, host, port, database, sslMode)
 
@@ -1613,6 +1940,48 @@ func (h *Handler) TestPeer(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// testConnectorPeer tests connectivity to a peer of another type than
+// Postgres by connecting to it through its connector
+func (h *Handler) testConnectorPeer(w http.ResponseWriter, r *http.Request, peerName string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := h.connectPeer(ctx, peerName); err != nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// connectPeer connects to a peer as a destination, or as a source if its
+// type cannot be a destination, and closes the connection again
+func (h *Handler) connectPeer(ctx context.Context, peerName string) error {
+	peer, err := connectors.LoadPeer(ctx, h.CatalogPool, peerName)
+	if err != nil {
+		return err
+	}
+
+	if reg, ok := connectors.Lookup(peer.Type); ok && reg.NewSource != nil && reg.NewDestination == nil {
+		src, err := connectors.NewSource(ctx, peer)
+		if err != nil {
+			return err
+		}
+		return src.Close()
+	}
+
+	dst, err := connectors.NewDestination(ctx, peer)
+	if err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
 // ============================================================================
 // Mirror Logs
 // ============================================================================
@@ -1817,6 +2186,11 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("POST /v1/mirrors/{name}/sync-schema", h.adminOnly(h.SyncSchema))
 	mux.HandleFunc("PUT /v1/mirrors/{name}/tables", h.adminOnly(h.UpdateMirrorTables))
 
+	// Dead-letter queue — reads are authed, retry/discard are admin
+	mux.HandleFunc("GET /v1/mirrors/{name}/dlq", h.authed(h.ListDeadLetters))
+	mux.HandleFunc("POST /v1/mirrors/{name}/dlq/{id}/retry", h.adminOnly(h.RetryDeadLetter))
+	mux.HandleFunc("DELETE /v1/mirrors/{name}/dlq/{id}", h.adminOnly(h.DiscardDeadLetter))
+
 	// Health check (open)
 	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
@@ -16,6 +16,12 @@ import (
 
 	"github.com/bunnydb/bunnydb/flow/activities"
 	"github.com/bunnydb/bunnydb/flow/api"
+	_ "github.com/bunnydb/bunnydb/flow/connectors/clickhouse"
+	_ "github.com/bunnydb/bunnydb/flow/connectors/kafka"
+	_ "github.com/bunnydb/bunnydb/flow/connectors/mysql"
+	_ "github.com/bunnydb/bunnydb/flow/connectors/s3"
+	_ "github.com/bunnydb/bunnydb/flow/connectors/sqlite"
+	_ "github.com/bunnydb/bunnydb/flow/connectors/webhook"
 	"github.com/bunnydb/bunnydb/flow/shared"
 	"github.com/bunnydb/bunnydb/flow/workflows"
 )
@@ -0,0 +1,744 @@
+// Package clickhouse is a destination that mirrors tables into ClickHouse
+// for analytics.
+//
+// Tables with a key are ReplacingMergeTree tables ordered by the key, with
+// a version column holding the LSN of each change and an is-deleted
+// column. Changes are appended as rows rather than applied in place:
+// updates add the new version of a row and deletes a row flagged as
+// deleted, and merges keep the latest version of each key. Queries see
+// the current rows with FINAL. Snapshots and batches of changes are
+// written with native inserts.
+package clickhouse
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// PeerType is the peer type of ClickHouse peers
+const PeerType connectors.PeerType = "CLICKHOUSE"
+
+// Default names of the columns ClickHouse dedupes rows with
+const (
+	DefaultVersionColumn   = "_bunny_version"
+	DefaultIsDeletedColumn = "_bunny_is_deleted"
+)
+
+func init() {
+	connectors.Register(PeerType, connectors.Registration{
+		Validate: func(peer *connectors.Peer) error {
+			_, err := NewConfig(peer)
+			return err
+		},
+		NewDestination: func(ctx context.Context, peer *connectors.Peer) (connectors.Destination, error) {
+			return NewClickHouseConnector(ctx, peer)
+		},
+	})
+}
+
+// Config holds the settings of a ClickHouse peer, from its config
+type Config struct {
+	// TypeMapping replaces the ClickHouse type of Postgres types, by type
+	// name without modifiers, e.g. {"jsonb": "JSON"}. Nullable columns get
+	// the type wrapped in Nullable.
+	TypeMapping map[string]string `json:"type_mapping"`
+
+	// ColumnTypes sets the exact ClickHouse type of single columns, by
+	// <schema>.<table>.<column> of the destination table
+	ColumnTypes map[string]string `json:"column_types"`
+
+	// VersionColumn holds the LSN of the change that wrote a row, 0 for
+	// rows copied by the snapshot. Default: _bunny_version.
+	VersionColumn string `json:"version_column"`
+
+	// IsDeletedColumn is 1 for rows written by a DELETE. Default:
+	// _bunny_is_deleted.
+	IsDeletedColumn string `json:"is_deleted_column"`
+}
+
+// NewConfig reads and checks the settings of a ClickHouse peer
+func NewConfig(peer *connectors.Peer) (*Config, error) {
+	config := &Config{}
+	if err := peer.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+
+	if peer.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	for pgType, chType := range config.TypeMapping {
+		if strings.TrimSpace(chType) == "" {
+			return nil, fmt.Errorf("type_mapping of %s is empty", pgType)
+		}
+	}
+	for column, chType := range config.ColumnTypes {
+		if strings.Count(column, ".") < 2 {
+			return nil, fmt.Errorf("column_types key %q is not <schema>.<table>.<column>", column)
+		}
+		if strings.TrimSpace(chType) == "" {
+			return nil, fmt.Errorf("column_types of %s is empty", column)
+		}
+	}
+	if config.VersionColumn == "" {
+		config.VersionColumn = DefaultVersionColumn
+	}
+	if config.IsDeletedColumn == "" {
+		config.IsDeletedColumn = DefaultIsDeletedColumn
+	}
+	if config.VersionColumn == config.IsDeletedColumn {
+		return nil, errors.New("version_column and is_deleted_column must differ")
+	}
+	return config, nil
+}
+
+// table is a destination table, with its columns as ClickHouse has them
+type table struct {
+	name    string // Quoted <database>.<table>
+	columns []string
+	types   map[string]string
+	// oids holds the Postgres types of the replicated columns where known
+	oids map[string]uint32
+}
+
+// ClickHouseConnector writes changes to a ClickHouse database
+type ClickHouseConnector struct {
+	logger   *slog.Logger
+	config   *Config
+	conn     driver.Conn
+	database string
+
+	// schemas holds the source schema of each destination table set up
+	// through EnsureTable, by <schema>.<table>, following the schema
+	// changes applied since; tables caches the columns read from
+	// ClickHouse
+	schemas map[string]*postgres.TableSchema
+	tables  map[string]*table
+}
+
+// NewClickHouseConnector connects to a ClickHouse server over the native
+// protocol
+func NewClickHouseConnector(ctx context.Context, peer *connectors.Peer) (*ClickHouseConnector, error) {
+	config, err := NewConfig(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	port := peer.Port
+	if port == 0 {
+		port = 9000
+	}
+	database := peer.Database
+	if database == "" {
+		database = "default"
+	}
+	user := peer.User
+	if user == "" {
+		user = "default"
+	}
+	opts := &clickhouse.Options{
+		Addr: []string{fmt.Sprintf("%s:%d", peer.Host, port)},
+		Auth: clickhouse.Auth{
+			Database: database,
+			Username: user,
+			Password: peer.Password,
+		},
+		Compression: &clickhouse.Compression{Method: clickhouse.CompressionLZ4},
+		ClientInfo: clickhouse.ClientInfo{
+			Products: []struct{ Name, Version string }{{Name: "bunnydb"}},
+		},
+	}
+	switch peer.SSLMode {
+	case "require":
+		opts.TLS = &tls.Config{InsecureSkipVerify: true}
+	case "verify-ca", "verify-full":
+		opts.TLS = &tls.Config{}
+	}
+
+	conn, err := clickhouse.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ClickHouse connection settings: %w", err)
+	}
+	if err := conn.Ping(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", opts.Addr[0], err)
+	}
+
+	return &ClickHouseConnector{
+		logger:   slog.Default().With(slog.String("component", "clickhouse-connector"), slog.String("peer", peer.Name)),
+		config:   config,
+		conn:     conn,
+		database: database,
+		schemas:  make(map[string]*postgres.TableSchema),
+		tables:   make(map[string]*table),
+	}, nil
+}
+
+// Close closes the connections to the server
+func (c *ClickHouseConnector) Close() error {
+	return c.conn.Close()
+}
+
+// quoteIdentifier quotes a ClickHouse identifier
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(strings.ReplaceAll(name, `\`, `\\`), "`", "\\`") + "`"
+}
+
+func quoteIdentifiers(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdentifier(name)
+	}
+	return quoted
+}
+
+// databaseFor returns the database a destination schema is mirrored
+// into: the peer's database for public, a database of its name otherwise
+func (c *ClickHouseConnector) databaseFor(destSchema string) string {
+	if destSchema == "" || destSchema == "public" {
+		return c.database
+	}
+	return destSchema
+}
+
+// tableName returns the quoted name of a destination table
+func (c *ClickHouseConnector) tableName(destSchema, destTable string) string {
+	return quoteIdentifier(c.databaseFor(destSchema)) + "." + quoteIdentifier(destTable)
+}
+
+// columnType returns the ClickHouse type of a column of a destination
+// table. Key columns are never Nullable, since ClickHouse does not sort
+// on Nullable columns.
+func (c *ClickHouseConnector) columnType(destSchema, destTable string, col postgres.ColumnDefinition, key bool) string {
+	if t, ok := c.config.ColumnTypes[destSchema+"."+destTable+"."+col.Name]; ok {
+		return t
+	}
+	return clickhouseType(col.Type, col.Nullable && !key, c.config.TypeMapping)
+}
+
+// sortingKey returns the columns rows of a table are deduped on: its
+// primary key, or its replica identity index
+func sortingKey(schema *postgres.TableSchema) []string {
+	if len(schema.PrimaryKeyColumns) > 0 {
+		return schema.PrimaryKeyColumns
+	}
+	return schema.ReplicaIdentityColumns
+}
+
+// EnsureTable creates the database and table a source table is mirrored
+// into. Tables with a primary key or replica identity index are
+// ReplacingMergeTree tables ordered by it; other tables are MergeTree
+// tables keeping every change as a row. With a soft delete column, deleted
+// rows stay visible with the column set instead of being dropped by
+// merges.
+func (c *ClickHouseConnector) EnsureTable(ctx context.Context, schema *postgres.TableSchema, destSchema, destTable string, mirrorCols postgres.MirrorColumns) error {
+	if mirrorCols.History {
+		return errors.New("ClickHouse destinations do not support history tables")
+	}
+	reserved := append(mirrorCols.Names(), c.config.VersionColumn, c.config.IsDeletedColumn)
+	for _, name := range reserved {
+		for _, col := range schema.Columns {
+			if col.Name == name {
+				return fmt.Errorf("column %s of %s.%s clashes with a column the mirror maintains", name, schema.SchemaName, schema.TableName)
+			}
+		}
+	}
+
+	database := c.databaseFor(destSchema)
+	if database != c.database {
+		if err := c.conn.Exec(ctx, "CREATE DATABASE IF NOT EXISTS "+quoteIdentifier(database)); err != nil {
+			return fmt.Errorf("failed to create database %s: %w", database, err)
+		}
+	}
+
+	key := sortingKey(schema)
+	isKey := make(map[string]bool, len(key))
+	for _, col := range key {
+		isKey[col] = true
+	}
+
+	var columnDefs []string
+	for _, col := range schema.Columns {
+		columnDefs = append(columnDefs, quoteIdentifier(col.Name)+" "+c.columnType(destSchema, destTable, col, isKey[col.Name]))
+	}
+	version := quoteIdentifier(c.config.VersionColumn)
+	columnDefs = append(columnDefs, version+" UInt64")
+
+	engine := "MergeTree"
+	orderBy := "tuple()"
+	if len(key) > 0 {
+		engine = "ReplacingMergeTree(" + version + ")"
+		orderBy = "(" + strings.Join(quoteIdentifiers(key), ", ") + ")"
+	}
+	if mirrorCols.SoftDelete != "" {
+		columnDefs = append(columnDefs, quoteIdentifier(mirrorCols.SoftDelete)+" Bool DEFAULT false")
+	} else {
+		isDeleted := quoteIdentifier(c.config.IsDeletedColumn)
+		columnDefs = append(columnDefs, isDeleted+" UInt8 DEFAULT 0")
+		if len(key) > 0 {
+			engine = "ReplacingMergeTree(" + version + ", " + isDeleted + ")"
+		}
+	}
+	if mirrorCols.SyncedAt != "" {
+		columnDefs = append(columnDefs, quoteIdentifier(mirrorCols.SyncedAt)+" DateTime64(6, 'UTC') DEFAULT now64(6)")
+	}
+
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n) ENGINE = %s ORDER BY %s",
+		c.tableName(destSchema, destTable), strings.Join(columnDefs, ",\n  "), engine, orderBy)
+	if err := c.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to create table %s.%s: %w", database, destTable, err)
+	}
+
+	c.RegisterTable(schema, destSchema, destTable)
+	return nil
+}
+
+// RegisterTable remembers the source schema of a table, whose types values
+// are converted by. The columns of the table are read again when next
+// needed.
+func (c *ClickHouseConnector) RegisterTable(schema *postgres.TableSchema, destSchema, destTable string) {
+	c.schemas[destSchema+"."+destTable] = schema
+	delete(c.tables, destSchema+"."+destTable)
+}
+
+// table returns the columns of a destination table as ClickHouse has them
+func (c *ClickHouseConnector) table(ctx context.Context, destSchema, destTable string) (*table, error) {
+	key := destSchema + "." + destTable
+	if t, ok := c.tables[key]; ok {
+		return t, nil
+	}
+
+	rows, err := c.conn.Query(ctx,
+		"SELECT name, type FROM system.columns WHERE database = ? AND table = ? ORDER BY position",
+		c.databaseFor(destSchema), destTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", key, err)
+	}
+	defer rows.Close()
+
+	t := &table{
+		name:  c.tableName(destSchema, destTable),
+		types: make(map[string]string),
+		oids:  make(map[string]uint32),
+	}
+	for rows.Next() {
+		var name, chType string
+		if err := rows.Scan(&name, &chType); err != nil {
+			return nil, err
+		}
+		t.columns = append(t.columns, name)
+		t.types[name] = chType
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.columns) == 0 {
+		return nil, fmt.Errorf("table %s does not exist", key)
+	}
+
+	if schema := c.schemas[key]; schema != nil {
+		for _, col := range schema.Columns {
+			t.oids[col.Name] = col.TypeOID
+		}
+	}
+	c.tables[key] = t
+	return t, nil
+}
+
+// TruncateTable removes the rows of a destination table
+func (c *ClickHouseConnector) TruncateTable(ctx context.Context, destSchema, destTable string) error {
+	if err := c.conn.Exec(ctx, "TRUNCATE TABLE IF EXISTS "+c.tableName(destSchema, destTable)); err != nil {
+		return fmt.Errorf("failed to truncate %s.%s: %w", destSchema, destTable, err)
+	}
+	return nil
+}
+
+// BulkLoad inserts rows read by the snapshot, at version 0 so that every
+// change replaces them. A partition copied again adds rows of the same
+// version, which merges dedupe.
+func (c *ClickHouseConnector) BulkLoad(ctx context.Context, destSchema, destTable string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	t, err := c.table(ctx, destSchema, destTable)
+	if err != nil {
+		return err
+	}
+
+	insertCols := append(append([]string{}, columns...), c.config.VersionColumn)
+	_, hasIsDeleted := t.types[c.config.IsDeletedColumn]
+	if hasIsDeleted {
+		insertCols = append(insertCols, c.config.IsDeletedColumn)
+	}
+
+	batch, err := c.conn.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s (%s)", t.name, strings.Join(quoteIdentifiers(insertCols), ", ")))
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert into %s.%s: %w", destSchema, destTable, err)
+	}
+	defer batch.Abort()
+
+	for _, row := range rows {
+		values := make([]interface{}, 0, len(insertCols))
+		for i, v := range row {
+			value, err := clickhouseValue(t.types[columns[i]], t.oids[columns[i]], v)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", columns[i], err)
+			}
+			values = append(values, value)
+		}
+		values = append(values, uint64(0))
+		if hasIsDeleted {
+			values = append(values, uint8(0))
+		}
+		if err := batch.Append(values...); err != nil {
+			return fmt.Errorf("failed to add row to insert into %s.%s: %w", destSchema, destTable, err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert into %s.%s: %w", destSchema, destTable, err)
+	}
+	return nil
+}
+
+// pendingRows are the rows of changes to a table not inserted yet
+type pendingRows struct {
+	table *table
+	rows  [][]interface{}
+	// latest indexes the last row of each key, for changes that need the
+	// current row
+	latest map[string]int
+}
+
+// ApplyChanges appends the changes of a batch to their tables, with one
+// insert per table. Schema changes and TRUNCATEs insert the rows before
+// them first. A batch written again after a crash adds rows of the same
+// versions, which merges dedupe.
+func (c *ClickHouseConnector) ApplyChanges(ctx context.Context, txns []*postgres.CDCTransaction, targets map[string]*postgres.ApplyTarget) error {
+	pending := make(map[string]*pendingRows)
+
+	for _, txn := range txns {
+		// The chunks of a spooled transaction are inserted one at a time
+		err := txn.Chunks(func() error {
+			for _, rec := range txn.Records {
+				target := postgres.ApplyTargetFor(targets, rec)
+				if target.Changelog || target.History {
+					return errors.New("ClickHouse destinations do not support changelog or history tables")
+				}
+				dest := target.Schema + "." + target.Table
+
+				switch rec.Operation {
+				case "SCHEMA":
+					if err := c.insertPending(ctx, pending); err != nil {
+						return err
+					}
+					if rec.SchemaDelta == nil {
+						continue
+					}
+					rec.SchemaDelta.DestinationTable = dest
+					if err := c.alterTable(ctx, target.Schema, target.Table, rec.SchemaDelta); err != nil {
+						return fmt.Errorf("failed to apply schema change for %s.%s: %w", rec.Schema, rec.Table, err)
+					}
+
+				case "TRUNCATE":
+					if err := c.insertPending(ctx, pending); err != nil {
+						return err
+					}
+					if err := c.truncate(ctx, target); err != nil {
+						return fmt.Errorf("TRUNCATE on %s.%s at LSN %d failed: %w", rec.Schema, rec.Table, rec.LSN, err)
+					}
+
+				case "INSERT", "UPDATE", "DELETE":
+					p := pending[dest]
+					if p == nil {
+						t, err := c.table(ctx, target.Schema, target.Table)
+						if err != nil {
+							return err
+						}
+						p = &pendingRows{table: t, latest: make(map[string]int)}
+						pending[dest] = p
+					}
+					if err := c.addChange(ctx, p, rec, target); err != nil {
+						return fmt.Errorf("%s on %s.%s at LSN %d failed: %w", rec.Operation, rec.Schema, rec.Table, rec.LSN, err)
+					}
+
+				default:
+					return fmt.Errorf("unknown operation: %s", rec.Operation)
+				}
+			}
+			if txn.Spooled() {
+				return c.insertPending(ctx, pending)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return c.insertPending(ctx, pending)
+}
+
+// rowKey identifies the row of values by its key columns, "" without them
+func rowKey(pkColumns []string, values map[string]interface{}) string {
+	if len(pkColumns) == 0 || values == nil {
+		return ""
+	}
+	parts := make([]string, len(pkColumns))
+	for i, pk := range pkColumns {
+		parts[i] = fmt.Sprint(values[pk])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// addChange adds the rows of a change: the new version of the row for
+// INSERT and UPDATE, a deleted row for DELETE, and both for an UPDATE that
+// changed the key. Columns the change lacks, such as unchanged TOAST
+// columns, are taken from the current row.
+func (c *ClickHouseConnector) addChange(ctx context.Context, p *pendingRows, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	newKey := rowKey(target.PKColumns, rec.NewValues)
+	oldKey := rowKey(target.PKColumns, rec.OldValues)
+
+	switch rec.Operation {
+	case "INSERT":
+		return c.addRow(p, target, rec.NewValues, nil, rec.LSN, false, newKey)
+
+	case "UPDATE":
+		var current []interface{}
+		if len(rec.UnchangedToastColumns) > 0 {
+			var err error
+			lookupKey, lookupValues := newKey, rec.NewValues
+			if oldKey != "" {
+				lookupKey, lookupValues = oldKey, rec.OldValues
+			}
+			if current, err = c.currentRow(ctx, p, target, lookupKey, lookupValues); err != nil {
+				return err
+			}
+			// Without its row the change is skipped, as an UPDATE of no
+			// row would be, if it may have been deleted since
+			if current == nil {
+				return postgres.MissingRowError(rec, target)
+			}
+		}
+		if oldKey != "" && oldKey != newKey {
+			if err := c.addRow(p, target, rec.OldValues, nil, rec.LSN, true, oldKey); err != nil {
+				return err
+			}
+		}
+		return c.addRow(p, target, rec.NewValues, current, rec.LSN, false, newKey)
+
+	case "DELETE":
+		if rec.OldValues == nil {
+			return errors.New("DELETE record has no old values")
+		}
+		// Soft deleted rows stay visible, so they keep their values
+		var current []interface{}
+		if target.SoftDeleteColumn != "" && !target.IdentityFull {
+			var err error
+			if current, err = c.currentRow(ctx, p, target, oldKey, rec.OldValues); err != nil {
+				return err
+			}
+		}
+		return c.addRow(p, target, rec.OldValues, current, rec.LSN, true, oldKey)
+	}
+	return nil
+}
+
+// addRow adds a row of values to the pending rows, at version lsn. Columns
+// without a value are taken from base if given, and left empty otherwise.
+func (c *ClickHouseConnector) addRow(p *pendingRows, target *postgres.ApplyTarget, values map[string]interface{}, base []interface{}, lsn int64, deleted bool, key string) error {
+	t := p.table
+	row := make([]interface{}, len(t.columns))
+	for i, col := range t.columns {
+		switch col {
+		case c.config.VersionColumn:
+			row[i] = uint64(lsn)
+		case c.config.IsDeletedColumn:
+			row[i] = uint8(0)
+			if deleted {
+				row[i] = uint8(1)
+			}
+		case target.SoftDeleteColumn:
+			row[i] = deleted
+		case target.SyncedAtColumn:
+			row[i] = time.Now().UTC()
+		default:
+			v, ok := values[col]
+			if !ok {
+				if base != nil {
+					row[i] = base[i]
+				}
+				continue
+			}
+			value, err := clickhouseValue(t.types[col], t.oids[col], v)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col, err)
+			}
+			row[i] = value
+		}
+	}
+
+	p.rows = append(p.rows, row)
+	if key != "" {
+		p.latest[key] = len(p.rows) - 1
+	}
+	return nil
+}
+
+// currentRow returns the current row of a key: the last pending row of it,
+// or the row in the table. It returns nil if the row does not exist.
+func (c *ClickHouseConnector) currentRow(ctx context.Context, p *pendingRows, target *postgres.ApplyTarget, key string, values map[string]interface{}) ([]interface{}, error) {
+	if key == "" {
+		return nil, nil
+	}
+	if i, ok := p.latest[key]; ok {
+		return p.rows[i], nil
+	}
+
+	t := p.table
+	conds := make([]string, len(target.PKColumns))
+	args := make([]interface{}, len(target.PKColumns))
+	for i, pk := range target.PKColumns {
+		conds[i] = quoteIdentifier(pk) + " = ?"
+		value, err := clickhouseValue(t.types[pk], t.oids[pk], values[pk])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", pk, err)
+		}
+		args[i] = value
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s FINAL WHERE %s LIMIT 1",
+		strings.Join(quoteIdentifiers(t.columns), ", "), t.name, strings.Join(conds, " AND "))
+
+	rows, err := c.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current row: %w", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	// Scanned into the driver's own types, which it appends as they are
+	dests := make([]interface{}, len(t.columns))
+	for i, ct := range rows.ColumnTypes() {
+		dests[i] = reflect.New(ct.ScanType()).Interface()
+	}
+	if err := rows.Scan(dests...); err != nil {
+		return nil, fmt.Errorf("failed to read current row: %w", err)
+	}
+	row := make([]interface{}, len(dests))
+	for i, dest := range dests {
+		row[i] = reflect.ValueOf(dest).Elem().Interface()
+	}
+	return row, nil
+}
+
+// insertPending inserts the pending rows of each table and clears them
+func (c *ClickHouseConnector) insertPending(ctx context.Context, pending map[string]*pendingRows) error {
+	for dest, p := range pending {
+		if err := c.insertRows(ctx, p.table, p.rows); err != nil {
+			return fmt.Errorf("failed to insert changes into %s: %w", dest, err)
+		}
+		delete(pending, dest)
+	}
+	return nil
+}
+
+// insertRows inserts rows of all columns of a table in one native insert
+func (c *ClickHouseConnector) insertRows(ctx context.Context, t *table, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	batch, err := c.conn.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s (%s)", t.name, strings.Join(quoteIdentifiers(t.columns), ", ")))
+	if err != nil {
+		return err
+	}
+	defer batch.Abort()
+	for _, row := range rows {
+		if err := batch.Append(row...); err != nil {
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+// truncate removes all rows of a table, or flags them as deleted. Flagging
+// is a mutation, which is waited for so later changes are not flagged.
+func (c *ClickHouseConnector) truncate(ctx context.Context, target *postgres.ApplyTarget) error {
+	name := c.tableName(target.Schema, target.Table)
+	if target.SoftDeleteColumn == "" {
+		return c.conn.Exec(ctx, "TRUNCATE TABLE "+name)
+	}
+	col := quoteIdentifier(target.SoftDeleteColumn)
+	sets := col + " = true"
+	if target.SyncedAtColumn != "" {
+		sets += ", " + quoteIdentifier(target.SyncedAtColumn) + " = now64(6)"
+	}
+	return c.conn.Exec(ctx, fmt.Sprintf("ALTER TABLE %s UPDATE %s WHERE NOT %s SETTINGS mutations_sync = 2", name, sets, col))
+}
+
+// ApplySchemaDelta changes a table to follow a change of its source. Index
+// and foreign key changes are not carried over.
+func (c *ClickHouseConnector) ApplySchemaDelta(ctx context.Context, delta *postgres.SchemaDelta) error {
+	destSchema, destTable, ok := strings.Cut(delta.DestinationTable, ".")
+	if !ok {
+		destSchema, destTable = "public", delta.DestinationTable
+	}
+	return c.alterTable(ctx, destSchema, destTable, delta)
+}
+
+// alterTable adds, retypes and drops the columns of a schema change.
+// Columns of the sorting key cannot change in ClickHouse, so changes to
+// them fail.
+func (c *ClickHouseConnector) alterTable(ctx context.Context, destSchema, destTable string, delta *postgres.SchemaDelta) error {
+	key := destSchema + "." + destTable
+	name := c.tableName(destSchema, destTable)
+
+	for _, col := range delta.AddedColumns {
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+			name, quoteIdentifier(col.Name), c.columnType(destSchema, destTable, col, false))
+		if err := c.conn.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", col.Name, err)
+		}
+		c.logger.Info("added column", slog.String("table", key), slog.String("column", col.Name))
+	}
+
+	for _, change := range delta.TypeChanges {
+		// The source does not tell nullability with a type change, so the
+		// column keeps it
+		nullable := true
+		if t, err := c.table(ctx, destSchema, destTable); err == nil {
+			if chType, ok := t.types[change.ColumnName]; ok {
+				nullable = strings.HasPrefix(chType, "Nullable(")
+			}
+		}
+		col := postgres.ColumnDefinition{Name: change.ColumnName, Type: change.NewType, Nullable: nullable}
+		query := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s",
+			name, quoteIdentifier(col.Name), c.columnType(destSchema, destTable, col, false))
+		if err := c.conn.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to change type of column %s: %w", change.ColumnName, err)
+		}
+	}
+
+	for _, colName := range delta.DroppedColumns {
+		query := fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", name, quoteIdentifier(colName))
+		if err := c.conn.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to drop column %s: %w", colName, err)
+		}
+	}
+
+	if schema := c.schemas[key]; schema != nil {
+		c.schemas[key] = delta.ApplyTo(schema)
+	}
+	delete(c.tables, key)
+	return nil
+}
@@ -0,0 +1,174 @@
+//go:build integration
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// The server of the clickhouse profile of docker-compose.yml, unless
+// BUNNY_TEST_CLICKHOUSE_HOST and BUNNY_TEST_CLICKHOUSE_PORT are set:
+//
+//	docker compose --profile clickhouse up -d clickhouse
+//	go test -tags integration ./connectors/clickhouse/
+func testPeer(t *testing.T) *connectors.Peer {
+	host := os.Getenv("BUNNY_TEST_CLICKHOUSE_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	port := 19100
+	if p := os.Getenv("BUNNY_TEST_CLICKHOUSE_PORT"); p != "" {
+		var err error
+		if port, err = strconv.Atoi(p); err != nil {
+			t.Fatalf("invalid BUNNY_TEST_CLICKHOUSE_PORT: %v", err)
+		}
+	}
+	return &connectors.Peer{
+		Name:     "clickhouse_test",
+		Type:     PeerType,
+		Host:     host,
+		Port:     port,
+		User:     "bunny",
+		Password: "bunny",
+		Database: "analytics",
+	}
+}
+
+func TestMergesKeepLatestVersionAndDropDeletedRowsFromFinal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn, err := NewClickHouseConnector(ctx, testPeer(t))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	table := fmt.Sprintf("bunnytest_%d", time.Now().UnixNano())
+	schema := &postgres.TableSchema{
+		SchemaName: "public",
+		TableName:  table,
+		Columns: []postgres.ColumnDefinition{
+			{Name: "id", Type: "bigint", TypeOID: pgtype.Int8OID, IsPrimaryKey: true},
+			{Name: "name", Type: "text", TypeOID: pgtype.TextOID, Nullable: true},
+		},
+		PrimaryKeyColumns: []string{"id"},
+	}
+	if err := conn.EnsureTable(ctx, schema, "public", table, postgres.MirrorColumns{}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	name := conn.tableName("public", table)
+	defer func() {
+		_ = conn.conn.Exec(context.Background(), "DROP TABLE IF EXISTS "+name)
+	}()
+
+	rows := [][]interface{}{{int64(1), "a"}, {int64(2), "b"}, {int64(3), "c"}}
+	if err := conn.BulkLoad(ctx, "public", table, []string{"id", "name"}, rows); err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+
+	commitTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	change := func(op string, lsn int64, values map[string]interface{}) *postgres.CDCRecord {
+		rec := &postgres.CDCRecord{Operation: op, Schema: "public", Table: table, LSN: lsn, Columns: []string{"id", "name"}, CommitTime: commitTime}
+		if op == "DELETE" {
+			rec.OldValues = values
+		} else {
+			rec.NewValues = values
+		}
+		return rec
+	}
+	// The name of row 3 is TOASTed and unchanged, so it comes from the
+	// snapshot row
+	toasted := change("UPDATE", 201, map[string]interface{}{"id": int64(3)})
+	toasted.UnchangedToastColumns = []string{"name"}
+	txns := []*postgres.CDCTransaction{
+		{XID: 10, CommitLSN: 150, EndLSN: 158, Records: []*postgres.CDCRecord{
+			change("UPDATE", 101, map[string]interface{}{"id": int64(1), "name": "a2"}),
+			change("DELETE", 102, map[string]interface{}{"id": int64(2)}),
+			change("INSERT", 103, map[string]interface{}{"id": int64(4), "name": "d"}),
+		}},
+		{XID: 11, CommitLSN: 250, EndLSN: 258, Records: []*postgres.CDCRecord{toasted}},
+	}
+	targets := map[string]*postgres.ApplyTarget{
+		"public." + table: {Schema: "public", Table: table, PKColumns: []string{"id"}},
+	}
+
+	// A batch written again after a crash adds rows of the same versions
+	for i := 0; i < 2; i++ {
+		if err := conn.ApplyChanges(ctx, txns, targets); err != nil {
+			t.Fatalf("failed to apply changes (pass %d): %v", i+1, err)
+		}
+	}
+
+	if err := conn.conn.Exec(ctx, "OPTIMIZE TABLE "+name+" FINAL"); err != nil {
+		t.Fatalf("failed to merge table: %v", err)
+	}
+
+	// Merged, every key keeps only its latest version, deleted or not
+	type version struct {
+		id        int64
+		name      string
+		version   uint64
+		isDeleted uint8
+	}
+	query := fmt.Sprintf("SELECT id, ifNull(name, ''), %s, %s FROM %s ORDER BY id",
+		quoteIdentifier(DefaultVersionColumn), quoteIdentifier(DefaultIsDeletedColumn), name)
+	result, err := conn.conn.Query(ctx, query)
+	if err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+	var got []version
+	for result.Next() {
+		var v version
+		if err := result.Scan(&v.id, &v.name, &v.version, &v.isDeleted); err != nil {
+			t.Fatalf("failed to read row: %v", err)
+		}
+		got = append(got, v)
+	}
+	result.Close()
+	if err := result.Err(); err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+
+	want := []version{
+		{id: 1, name: "a2", version: 101},
+		{id: 2, version: 102, isDeleted: 1},
+		{id: 3, name: "c", version: 201},
+		{id: 4, name: "d", version: 103},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("merged rows = %+v, want %+v", got, want)
+	}
+
+	// FINAL leaves out rows whose latest version is a delete
+	var ids []int64
+	result, err = conn.conn.Query(ctx, "SELECT id FROM "+name+" FINAL ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to read table with FINAL: %v", err)
+	}
+	defer result.Close()
+	for result.Next() {
+		var id int64
+		if err := result.Scan(&id); err != nil {
+			t.Fatalf("failed to read row: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("failed to read table with FINAL: %v", err)
+	}
+	if !slices.Equal(ids, []int64{1, 3, 4}) {
+		t.Errorf("ids with FINAL = %v, want [1 3 4]", ids)
+	}
+}
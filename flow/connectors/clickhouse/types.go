@@ -0,0 +1,281 @@
+package clickhouse
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// clickhouseType returns the ClickHouse type of a Postgres type, wrapped in
+// Nullable for nullable columns that are not part of the sorting key.
+// Overrides replace the default mapping by Postgres type name without
+// modifiers.
+func clickhouseType(pgType string, nullable bool, overrides map[string]string) string {
+	t := baseClickhouseType(pgType, overrides)
+	if !nullable || strings.HasPrefix(t, "Nullable(") {
+		return t
+	}
+	// LowCardinality wraps Nullable, not the other way round
+	if inner, ok := strings.CutPrefix(t, "LowCardinality("); ok {
+		return "LowCardinality(Nullable(" + inner + ")"
+	}
+	return "Nullable(" + t + ")"
+}
+
+func baseClickhouseType(pgType string, overrides map[string]string) string {
+	name, mods := postgres.BaseType(pgType)
+	if t, ok := overrides[name]; ok {
+		return t
+	}
+
+	// Arrays are kept in their Postgres text form
+	if strings.HasSuffix(name, "[]") {
+		return "String"
+	}
+
+	switch name {
+	case "smallint":
+		return "Int16"
+	case "integer":
+		return "Int32"
+	case "bigint":
+		return "Int64"
+	case "real":
+		return "Float32"
+	case "double precision":
+		return "Float64"
+	case "numeric":
+		if len(mods) == 2 && mods[0] <= 76 {
+			return fmt.Sprintf("Decimal(%d, %d)", mods[0], mods[1])
+		}
+		if len(mods) == 1 && mods[0] <= 76 {
+			return fmt.Sprintf("Decimal(%d, 0)", mods[0])
+		}
+		return "Decimal(76, 38)"
+	case "money":
+		return "Decimal(18, 2)"
+	case "boolean":
+		return "Bool"
+	case "uuid":
+		return "UUID"
+	case "date":
+		return "Date32"
+	case "timestamp without time zone":
+		return "DateTime64(6)"
+	case "timestamp with time zone":
+		return "DateTime64(6, 'UTC')"
+	}
+	return "String"
+}
+
+// unwrapType strips Nullable and LowCardinality from a ClickHouse type
+func unwrapType(chType string) string {
+	for {
+		inner, ok := strings.CutPrefix(chType, "Nullable(")
+		if !ok {
+			inner, ok = strings.CutPrefix(chType, "LowCardinality(")
+		}
+		if !ok {
+			return chType
+		}
+		chType = strings.TrimSuffix(inner, ")")
+	}
+}
+
+// textValue returns the Postgres text form of a value of a type, nil for
+// NULL
+func textValue(oid uint32, v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if oid != 0 {
+		buf, err := postgres.EncodeText(oid, v)
+		if err == nil {
+			if buf == nil {
+				return nil
+			}
+			return string(buf)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// Bounds of Date32 and DateTime64, which infinite Postgres dates and
+// timestamps are clamped to
+var (
+	minTime = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxTime = time.Date(2299, 12, 31, 23, 59, 59, 999999000, time.UTC)
+)
+
+// Layouts of the Postgres text forms of dates and timestamps
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999-07",
+	"2006-01-02 15:04:05.999999-07:00",
+	"2006-01-02 15:04:05.999999-07:00:00",
+	"2006-01-02 15:04:05.999999",
+	"2006-01-02",
+}
+
+// clickhouseValue converts a value, typed or in Postgres text form, of a
+// column of Postgres type oid to the Go type the driver appends to a
+// column of type chType
+func clickhouseValue(chType string, oid uint32, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	text, isText := v.(string)
+	base := unwrapType(chType)
+
+	switch {
+	case strings.HasPrefix(base, "Int") || strings.HasPrefix(base, "UInt"):
+		var n int64
+		switch x := v.(type) {
+		case int16:
+			n = int64(x)
+		case int32:
+			n = int64(x)
+		case int64:
+			n = x
+		case float64:
+			// Values decoded from JSON, as in replayed dead letters
+			n = int64(x)
+		case bool:
+			if x {
+				n = 1
+			}
+		case string:
+			if x == "t" || x == "f" {
+				if x == "t" {
+					n = 1
+				}
+				break
+			}
+			parsed, err := strconv.ParseInt(x, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q", base, x)
+			}
+			n = parsed
+		default:
+			return nil, fmt.Errorf("cannot write %T as %s", v, base)
+		}
+		switch base {
+		case "Int8":
+			return int8(n), nil
+		case "Int16":
+			return int16(n), nil
+		case "Int32":
+			return int32(n), nil
+		case "Int64":
+			return n, nil
+		case "UInt8":
+			return uint8(n), nil
+		case "UInt16":
+			return uint16(n), nil
+		case "UInt32":
+			return uint32(n), nil
+		case "UInt64":
+			return uint64(n), nil
+		}
+		return textValue(oid, v), nil
+
+	case base == "Float32" || base == "Float64":
+		var f float64
+		switch x := v.(type) {
+		case float32:
+			f = float64(x)
+		case float64:
+			f = x
+		case string:
+			parsed, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q", base, x)
+			}
+			f = parsed
+		default:
+			return nil, fmt.Errorf("cannot write %T as %s", v, base)
+		}
+		if base == "Float32" {
+			return float32(f), nil
+		}
+		return f, nil
+
+	case base == "Bool":
+		if isText {
+			return text == "t" || text == "true", nil
+		}
+		return v, nil
+
+	case strings.HasPrefix(base, "Decimal"):
+		s := textValue(oid, v)
+		// Decimals have no NaN
+		if s == nil || s == "NaN" {
+			return nil, nil
+		}
+		return s, nil
+
+	case strings.HasPrefix(base, "Date"):
+		var t time.Time
+		switch x := v.(type) {
+		case time.Time:
+			t = x
+		case pgtype.InfinityModifier:
+			return clampInfinity(x), nil
+		case string:
+			switch x {
+			case "infinity":
+				return maxTime, nil
+			case "-infinity":
+				return minTime, nil
+			}
+			var err error
+			for _, layout := range timeLayouts {
+				if t, err = time.Parse(layout, x); err == nil {
+					break
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q", base, x)
+			}
+		default:
+			return nil, fmt.Errorf("cannot write %T as %s", v, base)
+		}
+		t = t.UTC()
+		if t.Before(minTime) {
+			return minTime, nil
+		}
+		if t.After(maxTime) {
+			return maxTime, nil
+		}
+		return t, nil
+
+	case base == "String" && oid == pgtype.ByteaOID:
+		if isText {
+			b, err := hex.DecodeString(strings.TrimPrefix(text, `\x`))
+			if err != nil {
+				return nil, fmt.Errorf("invalid bytea value: %w", err)
+			}
+			return b, nil
+		}
+		return v, nil
+	}
+
+	// Strings, UUIDs and overridden types take the Postgres text form
+	return textValue(oid, v), nil
+}
+
+// clampInfinity returns the time bound of an infinite date or timestamp
+func clampInfinity(mod pgtype.InfinityModifier) interface{} {
+	switch mod {
+	case pgtype.Infinity:
+		return maxTime
+	case pgtype.NegativeInfinity:
+		return minTime
+	}
+	return nil
+}
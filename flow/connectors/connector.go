@@ -0,0 +1,186 @@
+// Package connectors defines the interfaces mirrors read changes through and
+// write them through, and a registry of the peer types that implement them.
+//
+// Changes are carried in the record types of the postgres package, which is
+// also the only source: creating publications and slots and reading
+// snapshots stay specific to Postgres sources. Destinations only see
+// CDCRecords and TableSchemas, so a new kind of destination registers a
+// peer type and implements Destination without touching the workflows.
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// PeerType is the kind of system a peer connects to, as stored in
+// bunny_internal.peers.peer_type
+type PeerType string
+
+// Peer types
+const (
+	PeerTypePostgres PeerType = "POSTGRES"
+)
+
+// Peer is a peer as configured in the catalog
+type Peer struct {
+	Name     string
+	Type     PeerType
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+	SSLMode  string
+
+	// Config holds the settings specific to the peer type
+	Config map[string]interface{}
+}
+
+// DecodeConfig decodes the type-specific settings of a peer into v, a
+// pointer to a struct with json tags
+func (p *Peer) DecodeConfig(v interface{}) error {
+	if len(p.Config) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(p.Config)
+	if err != nil {
+		return fmt.Errorf("failed to encode config of peer %s: %w", p.Name, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid config for peer %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+// Source is a peer changes are captured from
+type Source interface {
+	Close() error
+
+	// GetTableSchema returns the columns and keys of a source table
+	GetTableSchema(ctx context.Context, schemaName, tableName string) (*postgres.TableSchema, error)
+
+	// OpenChangeStream sets up replication and starts streaming the changes
+	// committed after startLSN
+	OpenChangeStream(
+		ctx context.Context,
+		slotName string,
+		publicationName string,
+		startLSN int64,
+		opts postgres.ReplicationOptions,
+	) (ChangeReader, error)
+}
+
+// ChangeReader reads committed transactions from a change stream
+type ChangeReader interface {
+	// PullRecords returns whole transactions, collected until timeout or
+	// until at least maxRecords records have arrived, and the end LSN of
+	// the last one
+	PullRecords(ctx context.Context, maxRecords int, timeout time.Duration) ([]*postgres.CDCTransaction, int64, error)
+
+	// AckLSN records that everything up to lsn is durable on the
+	// destination and checkpointed, so the source may release it
+	AckLSN(lsn int64)
+
+	// KeepAlive keeps the stream open while the caller is not pulling
+	KeepAlive(ctx context.Context) error
+
+	Close()
+}
+
+// Destination is a peer changes are written to
+type Destination interface {
+	Close() error
+
+	// EnsureTable creates the table a source table is mirrored into, if it
+	// does not exist
+	EnsureTable(ctx context.Context, schema *postgres.TableSchema, destSchema, destTable string, mirrorCols postgres.MirrorColumns) error
+
+	// TruncateTable removes the rows of a table before a snapshot is
+	// loaded into it
+	TruncateTable(ctx context.Context, destSchema, destTable string) error
+
+	// BulkLoad writes rows read by the snapshot
+	BulkLoad(ctx context.Context, destSchema, destTable string, columns []string, rows [][]interface{}) error
+
+	// ApplyChanges writes committed source transactions, keyed to their
+	// tables by targets. Once it returns nil the changes are durable and
+	// the checkpoint may move past them; a batch may be written again
+	// after a crash.
+	ApplyChanges(ctx context.Context, txns []*postgres.CDCTransaction, targets map[string]*postgres.ApplyTarget) error
+
+	// ApplySchemaDelta changes a table to follow a change of its source
+	ApplySchemaDelta(ctx context.Context, delta *postgres.SchemaDelta) error
+}
+
+// OffsetDestination is a Destination that assigns its own positions to the
+// changes it writes, such as the offsets of a log. SyncFlow checkpoints
+// them in mirror_state with the LSN they were written up to.
+type OffsetDestination interface {
+	Destination
+
+	// Offsets returns the position of the last change written to each
+	// stream of the destination since it was connected to
+	Offsets() map[string]int64
+}
+
+// BufferingDestination is a Destination that may hold changes back after
+// ApplyChanges returns, to write them in larger batches. Held changes are
+// not durable: SyncFlow checkpoints up to FlushedLSN rather than to the end
+// of each batch, and calls Flush while no changes arrive.
+type BufferingDestination interface {
+	Destination
+
+	// Flush writes the held changes that are due, or all of them if all is
+	// set
+	Flush(ctx context.Context, all bool) error
+
+	// FlushedLSN returns the end LSN of the last transaction passed to
+	// ApplyChanges whose changes are all written
+	FlushedLSN() int64
+}
+
+// RejectedChange is a change a destination refused for good
+type RejectedChange struct {
+	Record *postgres.CDCRecord
+	Target *postgres.ApplyTarget
+	Err    error
+}
+
+// RejectingDestination is a Destination that sets aside changes it refuses
+// for good instead of failing the batch, counting them as written. SyncFlow
+// handles them with the mirror's error policy before checkpointing past
+// them.
+type RejectingDestination interface {
+	Destination
+
+	// TakeRejected returns the changes set aside since the last call
+	TakeRejected() []RejectedChange
+}
+
+// PartitionDestination is a Destination that writes each snapshot
+// partition as a unit, such as a file. The snapshot calls StartPartition
+// before the first BulkLoad of a partition and FinishPartition after its
+// last; a partition copied again replaces the earlier copy.
+type PartitionDestination interface {
+	Destination
+
+	StartPartition(ctx context.Context, destSchema, destTable string, partition uint32) error
+	FinishPartition(ctx context.Context, destSchema, destTable string) error
+}
+
+// SchemaDestination is a Destination that keeps the schema of the tables
+// set up through EnsureTable to write their rows. The partitions of a
+// snapshot after the first are copied on connections of their own, which
+// learn the schema with RegisterTable rather than setting the table up
+// again.
+type SchemaDestination interface {
+	Destination
+
+	// RegisterTable remembers the schema of a table set up elsewhere
+	RegisterTable(schema *postgres.TableSchema, destSchema, destTable string)
+}
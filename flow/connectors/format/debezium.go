@@ -0,0 +1,453 @@
+package format
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// debeziumVersion is reported as source.version, the Debezium release whose
+// Postgres connector events look like these
+const debeziumVersion = "2.7.0.Final"
+
+// debeziumOps maps operations to the op of Debezium events
+var debeziumOps = map[string]string{
+	"INSERT":          "c",
+	"UPDATE":          "u",
+	"DELETE":          "d",
+	"TRUNCATE":        "t",
+	OperationSnapshot: "r",
+}
+
+// debeziumFormatter renders events as the Debezium Postgres connector does
+// with the JSON converter and schemas enabled: an envelope of before, after,
+// source, op and ts_ms, with a schema section describing it. Column values
+// follow the connector's defaults, except that numeric values are strings
+// as with decimal.handling.mode=string.
+type debeziumFormatter struct {
+	name     string
+	database string
+}
+
+// debeziumField is a field of a Kafka Connect schema
+type debeziumField struct {
+	Type       string            `json:"type"`
+	Optional   bool              `json:"optional"`
+	Name       string            `json:"name,omitempty"`
+	Version    int               `json:"version,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Default    interface{}       `json:"default,omitempty"`
+	Fields     []debeziumField   `json:"fields,omitempty"`
+	Field      string            `json:"field,omitempty"`
+}
+
+// debeziumMessage is a key or value with its schema
+type debeziumMessage struct {
+	Schema  debeziumField `json:"schema"`
+	Payload interface{}   `json:"payload"`
+}
+
+// debeziumSource is the source block of an envelope
+type debeziumSource struct {
+	Version   string  `json:"version"`
+	Connector string  `json:"connector"`
+	Name      string  `json:"name"`
+	TsMs      int64   `json:"ts_ms"`
+	Snapshot  string  `json:"snapshot"`
+	DB        string  `json:"db"`
+	Sequence  *string `json:"sequence"`
+	Schema    string  `json:"schema"`
+	Table     string  `json:"table"`
+	TxID      *int64  `json:"txId"`
+	LSN       *int64  `json:"lsn"`
+	Xmin      *int64  `json:"xmin"`
+}
+
+// debeziumEnvelope is the payload of a value
+type debeziumEnvelope struct {
+	Before      map[string]interface{} `json:"before"`
+	After       map[string]interface{} `json:"after"`
+	Source      debeziumSource         `json:"source"`
+	Op          string                 `json:"op"`
+	TsMs        int64                  `json:"ts_ms"`
+	Transaction interface{}            `json:"transaction"`
+}
+
+func (f debeziumFormatter) Key(ev *Event) ([]byte, error) {
+	if len(ev.KeyColumns) == 0 {
+		return nil, nil
+	}
+	columns := f.columns(ev)
+	byName := make(map[string]postgres.ColumnDefinition, len(columns))
+	for _, col := range columns {
+		byName[col.Name] = col
+	}
+
+	row := ev.Row()
+	schema := debeziumField{Type: "struct", Name: f.recordName(ev) + ".Key"}
+	payload := make(map[string]interface{}, len(ev.KeyColumns))
+	for _, name := range ev.KeyColumns {
+		col, ok := byName[name]
+		if !ok {
+			col = postgres.ColumnDefinition{Name: name}
+		}
+		field := debeziumColumnField(col)
+		field.Optional = false
+		schema.Fields = append(schema.Fields, field)
+
+		v, err := debeziumValue(col, row[name])
+		if err != nil {
+			return nil, err
+		}
+		payload[name] = v
+	}
+	return json.Marshal(debeziumMessage{Schema: schema, Payload: payload})
+}
+
+func (f debeziumFormatter) Value(ev *Event) ([]byte, error) {
+	rec := ev.Record
+	op, ok := debeziumOps[ev.Operation()]
+	if !ok {
+		return nil, fmt.Errorf("operation %s has no Debezium op", ev.Operation())
+	}
+	columns := f.columns(ev)
+
+	before, err := debeziumRow(columns, rec.OldValues, nil)
+	if err != nil {
+		return nil, err
+	}
+	after, err := debeziumRow(columns, rec.NewValues, rec.UnchangedToastColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	source := debeziumSource{
+		Version:   debeziumVersion,
+		Connector: "postgresql",
+		Name:      f.name,
+		TsMs:      now.UnixMilli(),
+		Snapshot:  "false",
+		DB:        f.database,
+		Schema:    rec.Schema,
+		Table:     rec.Table,
+	}
+	if ev.Snapshot {
+		source.Snapshot = "true"
+	} else {
+		source.TsMs = rec.CommitTime.UnixMilli()
+		sequence := fmt.Sprintf(`["%d","%d"]`, rec.CommitLSN, rec.LSN)
+		txID := int64(rec.XID)
+		lsn := rec.LSN
+		source.Sequence = &sequence
+		source.TxID = &txID
+		source.LSN = &lsn
+	}
+
+	rowSchema := debeziumField{Type: "struct", Optional: true, Name: f.recordName(ev) + ".Value"}
+	for _, col := range columns {
+		rowSchema.Fields = append(rowSchema.Fields, debeziumColumnField(col))
+	}
+	beforeField, afterField := rowSchema, rowSchema
+	beforeField.Field, afterField.Field = "before", "after"
+
+	schema := debeziumField{
+		Type:    "struct",
+		Name:    f.recordName(ev) + ".Envelope",
+		Version: 1,
+		Fields: []debeziumField{
+			beforeField,
+			afterField,
+			debeziumSourceSchema,
+			{Type: "string", Field: "op"},
+			{Type: "int64", Optional: true, Field: "ts_ms"},
+			debeziumTransactionSchema,
+		},
+	}
+
+	return json.Marshal(debeziumMessage{
+		Schema: schema,
+		Payload: debeziumEnvelope{
+			Before: before,
+			After:  after,
+			Source: source,
+			Op:     op,
+			TsMs:   now.UnixMilli(),
+		},
+	})
+}
+
+func (debeziumFormatter) ContentType() string {
+	return "application/json"
+}
+
+// recordName is the prefix of the schema names of a table's events,
+// <name>.<schema>.<table> like the topic Debezium writes them to
+func (f debeziumFormatter) recordName(ev *Event) string {
+	return fmt.Sprintf("%s.%s.%s", f.name, ev.Record.Schema, ev.Record.Table)
+}
+
+// columns returns the columns of the event's table, or columns without
+// type known only by name if the table was not set up
+func (f debeziumFormatter) columns(ev *Event) []postgres.ColumnDefinition {
+	if ev.Table != nil {
+		return ev.Table.Columns
+	}
+	names := ev.Record.Columns
+	if len(names) == 0 {
+		for name := range ev.Row() {
+			names = append(names, name)
+		}
+	}
+	columns := make([]postgres.ColumnDefinition, len(names))
+	for i, name := range names {
+		columns[i] = postgres.ColumnDefinition{Name: name, Nullable: true}
+	}
+	return columns
+}
+
+// debeziumUnavailableValue stands for the value of an unchanged TOAST
+// column, which the source did not send
+const debeziumUnavailableValue = "__debezium_unavailable_value"
+
+// debeziumRow converts the values of a row, or returns nil for no row.
+// Columns without value are null, or unavailable if listed in unchanged.
+func debeziumRow(columns []postgres.ColumnDefinition, values map[string]interface{}, unchanged []string) (map[string]interface{}, error) {
+	if values == nil {
+		return nil, nil
+	}
+	row := make(map[string]interface{}, len(columns))
+	for _, col := range columns {
+		v, ok := values[col.Name]
+		if !ok {
+			row[col.Name] = nil
+			if slices.Contains(unchanged, col.Name) {
+				row[col.Name] = debeziumUnavailableValue
+			}
+			continue
+		}
+		converted, err := debeziumValue(col, v)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		row[col.Name] = converted
+	}
+	return row, nil
+}
+
+// debeziumColumnField returns the schema field of a column. Types the
+// connector maps to logical types carry their name.
+func debeziumColumnField(col postgres.ColumnDefinition) debeziumField {
+	field := debeziumField{Field: col.Name, Optional: col.Nullable}
+	switch col.TypeOID {
+	case pgtype.BoolOID:
+		field.Type = "boolean"
+	case pgtype.Int2OID:
+		field.Type = "int16"
+	case pgtype.Int4OID, pgtype.OIDOID:
+		field.Type = "int32"
+	case pgtype.Int8OID:
+		field.Type = "int64"
+	case pgtype.Float4OID:
+		field.Type = "float"
+	case pgtype.Float8OID:
+		field.Type = "double"
+	case pgtype.ByteaOID:
+		field.Type = "bytes"
+	case pgtype.DateOID:
+		field.Type, field.Name, field.Version = "int32", "io.debezium.time.Date", 1
+	case pgtype.TimestampOID:
+		field.Type, field.Name, field.Version = "int64", "io.debezium.time.MicroTimestamp", 1
+	case pgtype.TimestamptzOID:
+		field.Type, field.Name, field.Version = "string", "io.debezium.time.ZonedTimestamp", 1
+	case pgtype.TimeOID:
+		field.Type, field.Name, field.Version = "int64", "io.debezium.time.MicroTime", 1
+	case pgtype.UUIDOID:
+		field.Type, field.Name, field.Version = "string", "io.debezium.data.Uuid", 1
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		field.Type, field.Name, field.Version = "string", "io.debezium.data.Json", 1
+	default:
+		field.Type = "string"
+	}
+	return field
+}
+
+// debeziumValue converts a value to the representation of its schema
+// field. Values arrive typed from the snapshot and binary replication, and
+// as Postgres text otherwise.
+func debeziumValue(col postgres.ColumnDefinition, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	text, isText := v.(string)
+
+	switch col.TypeOID {
+	case pgtype.BoolOID:
+		if isText {
+			return text == "t" || text == "true", nil
+		}
+		return v, nil
+
+	case pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID, pgtype.OIDOID:
+		if isText {
+			return strconv.ParseInt(text, 10, 64)
+		}
+		return v, nil
+
+	case pgtype.Float4OID, pgtype.Float8OID:
+		if isText {
+			return strconv.ParseFloat(text, 64)
+		}
+		return v, nil
+
+	case pgtype.ByteaOID:
+		if isText {
+			b, err := hex.DecodeString(strings.TrimPrefix(text, `\x`))
+			if err != nil {
+				return nil, fmt.Errorf("invalid bytea value: %w", err)
+			}
+			return base64.StdEncoding.EncodeToString(b), nil
+		}
+		return v, nil
+
+	case pgtype.DateOID:
+		t, inf, err := debeziumTime(v, "2006-01-02")
+		if err != nil {
+			return nil, err
+		}
+		if inf != pgtype.Finite {
+			return clampInfinity(inf, math.MinInt32, math.MaxInt32), nil
+		}
+		return t.Unix() / 86400, nil
+
+	case pgtype.TimestampOID:
+		t, inf, err := debeziumTime(v, "2006-01-02 15:04:05.999999")
+		if err != nil {
+			return nil, err
+		}
+		if inf != pgtype.Finite {
+			return clampInfinity(inf, math.MinInt64, math.MaxInt64), nil
+		}
+		return t.UnixMicro(), nil
+
+	case pgtype.TimestamptzOID:
+		t, inf, err := debeziumTime(v, "2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00", "2006-01-02 15:04:05.999999-07:00:00")
+		if err != nil {
+			return nil, err
+		}
+		// Zoned timestamps are strings, which keep infinity as Debezium does
+		if inf != pgtype.Finite {
+			return inf.String(), nil
+		}
+		return t.UTC().Format("2006-01-02T15:04:05.999999Z"), nil
+
+	case pgtype.TimeOID:
+		if isText {
+			t, err := time.Parse("15:04:05.999999", text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid time value: %w", err)
+			}
+			return int64(t.Hour())*3600e6 + int64(t.Minute())*60e6 + int64(t.Second())*1e6 + int64(t.Nanosecond())/1e3, nil
+		}
+		if t, ok := v.(pgtype.Time); ok {
+			return t.Microseconds, nil
+		}
+		return v, nil
+	}
+
+	// Other types are strings in their Postgres text form
+	if isText {
+		return text, nil
+	}
+	buf, err := postgres.EncodeText(col.TypeOID, v)
+	if err != nil {
+		return fmt.Sprint(v), nil
+	}
+	return string(buf), nil
+}
+
+// debeziumTime reads a date or timestamp, typed or in Postgres text form in
+// one of the layouts. An infinite value is returned as its modifier, with a
+// zero time.
+func debeziumTime(v interface{}, layouts ...string) (time.Time, pgtype.InfinityModifier, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, pgtype.Finite, nil
+	case pgtype.InfinityModifier:
+		return time.Time{}, t, nil
+	case string:
+		switch t {
+		case "infinity":
+			return time.Time{}, pgtype.Infinity, nil
+		case "-infinity":
+			return time.Time{}, pgtype.NegativeInfinity, nil
+		}
+		for _, layout := range layouts {
+			if parsed, err := time.Parse(layout, t); err == nil {
+				return parsed, pgtype.Finite, nil
+			}
+		}
+		return time.Time{}, pgtype.Finite, fmt.Errorf("invalid date/time value %q", t)
+	default:
+		return time.Time{}, pgtype.Finite, fmt.Errorf("unexpected date/time value of type %T", v)
+	}
+}
+
+// clampInfinity returns the bound of a field's range that stands for an
+// infinite date or timestamp
+func clampInfinity(inf pgtype.InfinityModifier, lowest, highest int64) int64 {
+	if inf == pgtype.NegativeInfinity {
+		return lowest
+	}
+	return highest
+}
+
+// debeziumSourceSchema is the schema of the source block
+var debeziumSourceSchema = debeziumField{
+	Type:  "struct",
+	Name:  "io.debezium.connector.postgresql.Source",
+	Field: "source",
+	Fields: []debeziumField{
+		{Type: "string", Field: "version"},
+		{Type: "string", Field: "connector"},
+		{Type: "string", Field: "name"},
+		{Type: "int64", Field: "ts_ms"},
+		{
+			Type: "string", Optional: true, Name: "io.debezium.data.Enum", Version: 1,
+			Parameters: map[string]string{"allowed": "true,last,false,incremental"},
+			Default:    "false", Field: "snapshot",
+		},
+		{Type: "string", Field: "db"},
+		{Type: "string", Optional: true, Field: "sequence"},
+		{Type: "string", Field: "schema"},
+		{Type: "string", Field: "table"},
+		{Type: "int64", Optional: true, Field: "txId"},
+		{Type: "int64", Optional: true, Field: "lsn"},
+		{Type: "int64", Optional: true, Field: "xmin"},
+	},
+}
+
+// debeziumTransactionSchema is the schema of the transaction block, which
+// is always null as transaction metadata topics are not written
+var debeziumTransactionSchema = debeziumField{
+	Type:     "struct",
+	Optional: true,
+	Name:     "event.block",
+	Version:  1,
+	Field:    "transaction",
+	Fields: []debeziumField{
+		{Type: "string", Field: "id"},
+		{Type: "int64", Field: "total_order"},
+		{Type: "int64", Field: "data_collection_order"},
+	},
+}
@@ -0,0 +1,154 @@
+// Package format renders change records as messages, for destinations that
+// publish changes instead of applying them to tables, such as message
+// brokers. Every such destination offers the same serializations, chosen
+// with the "serialization" setting of its peer.
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// Serializations
+const (
+	SerializationJSON     = "json"
+	SerializationDebezium = "debezium"
+)
+
+// Options describe where events are published, for serializations that
+// name their origin
+type Options struct {
+	// Name identifies the events of a destination, like the topic prefix
+	// of a Debezium connector
+	Name string
+
+	// Database is reported as the database the events come from
+	Database string
+}
+
+// Event is a change to be published: a CDC record, or a row read by the
+// snapshot
+type Event struct {
+	Record *postgres.CDCRecord
+
+	// Snapshot is set for rows copied by the initial load or a resync
+	Snapshot bool
+
+	// Schema of the destination table the event is published for; nil if
+	// the table was not set up by this connection
+	Table *postgres.TableSchema
+
+	// KeyColumns identify the row; without them events have no key
+	KeyColumns []string
+}
+
+// OperationSnapshot is the operation of rows read by the snapshot
+const OperationSnapshot = "SNAPSHOT"
+
+// Operation returns the operation of the record, or OperationSnapshot for a
+// snapshot row
+func (ev *Event) Operation() string {
+	if ev.Snapshot {
+		return OperationSnapshot
+	}
+	return ev.Record.Operation
+}
+
+// Row returns the values identifying the row of the event: the old values
+// of a DELETE, the new values otherwise
+func (ev *Event) Row() map[string]interface{} {
+	if ev.Record.Operation == "DELETE" {
+		return ev.Record.OldValues
+	}
+	return ev.Record.NewValues
+}
+
+// Formatter renders events as message keys and values
+type Formatter interface {
+	// Key returns the key of an event, or nil if it has no key columns
+	Key(ev *Event) ([]byte, error)
+
+	// Value returns the body of an event
+	Value(ev *Event) ([]byte, error)
+
+	// ContentType is the media type of the values
+	ContentType() string
+}
+
+// New returns the formatter of a serialization, defaulting to JSON
+func New(serialization string, opts Options) (Formatter, error) {
+	switch serialization {
+	case "", SerializationJSON:
+		return jsonFormatter{}, nil
+	case SerializationDebezium:
+		return debeziumFormatter{name: opts.Name, database: opts.Database}, nil
+	default:
+		return nil, fmt.Errorf("unsupported serialization %q", serialization)
+	}
+}
+
+// keyJSON renders the key columns of an event as a JSON object
+func keyJSON(ev *Event) ([]byte, error) {
+	if len(ev.KeyColumns) == 0 {
+		return nil, nil
+	}
+	row := ev.Row()
+	key := make(map[string]interface{}, len(ev.KeyColumns))
+	for _, col := range ev.KeyColumns {
+		key[col] = row[col]
+	}
+	return json.Marshal(key)
+}
+
+// jsonFormatter renders events as flat JSON objects with the row before and
+// after the change
+type jsonFormatter struct{}
+
+// jsonValue is the body of a JSON event
+type jsonValue struct {
+	Operation             string                 `json:"op"`
+	Schema                string                 `json:"schema"`
+	Table                 string                 `json:"table"`
+	LSN                   int64                  `json:"lsn,omitempty"`
+	CommitLSN             int64                  `json:"commit_lsn,omitempty"`
+	Seq                   *int                   `json:"seq,omitempty"`
+	CommitTime            *time.Time             `json:"commit_time,omitempty"`
+	XID                   uint32                 `json:"xid,omitempty"`
+	Before                map[string]interface{} `json:"before"`
+	After                 map[string]interface{} `json:"after"`
+	UnchangedToastColumns []string               `json:"unchanged_toast_columns,omitempty"`
+}
+
+func (jsonFormatter) Key(ev *Event) ([]byte, error) {
+	return keyJSON(ev)
+}
+
+func (jsonFormatter) Value(ev *Event) ([]byte, error) {
+	rec := ev.Record
+	value := jsonValue{
+		Operation:             ev.Operation(),
+		Schema:                rec.Schema,
+		Table:                 rec.Table,
+		LSN:                   rec.LSN,
+		CommitLSN:             rec.CommitLSN,
+		XID:                   rec.XID,
+		Before:                rec.OldValues,
+		After:                 rec.NewValues,
+		UnchangedToastColumns: rec.UnchangedToastColumns,
+	}
+	if !ev.Snapshot {
+		seq := rec.Seq
+		value.Seq = &seq
+	}
+	if !rec.CommitTime.IsZero() {
+		value.CommitTime = &rec.CommitTime
+	}
+	return json.Marshal(value)
+}
+
+func (jsonFormatter) ContentType() string {
+	return "application/json"
+}
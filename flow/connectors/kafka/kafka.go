@@ -0,0 +1,480 @@
+// Package kafka is a destination that publishes changes to Kafka, or to any
+// broker speaking the Kafka protocol such as Redpanda. Each destination
+// table is a topic, and each change a message keyed by the primary key of
+// its row, so the changes of a row stay in order on one partition.
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/format"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// PeerType is the peer type of Kafka peers
+const PeerType connectors.PeerType = "KAFKA"
+
+// Message headers carrying the position and kind of a change
+const (
+	HeaderLSN         = "bunny.lsn"
+	HeaderCommitLSN   = "bunny.commit_lsn"
+	HeaderCommitTime  = "bunny.commit_time"
+	HeaderOperation   = "bunny.operation"
+	HeaderXID         = "bunny.xid"
+	HeaderContentType = "content-type"
+)
+
+func init() {
+	connectors.Register(PeerType, connectors.Registration{
+		Validate: func(peer *connectors.Peer) error {
+			_, err := NewConfig(peer)
+			return err
+		},
+		NewDestination: func(ctx context.Context, peer *connectors.Peer) (connectors.Destination, error) {
+			return NewKafkaConnector(ctx, peer)
+		},
+	})
+}
+
+// Config holds the settings of a Kafka peer, from its config
+type Config struct {
+	// Brokers to bootstrap from; the peer's host and port if empty
+	Brokers []string `json:"brokers"`
+
+	// TopicPrefix is put before <schema>.<table> of the destination table,
+	// separated by a dot
+	TopicPrefix string `json:"topic_prefix"`
+
+	// Serialization of message keys and values: json (default) or
+	// debezium
+	Serialization string `json:"serialization"`
+
+	// Idempotent makes the broker drop messages the producer retries after
+	// they were written, so retries neither duplicate nor reorder changes.
+	// Defaults to true and needs acks "all".
+	Idempotent *bool `json:"idempotent"`
+
+	// Acks is all (default), leader or none
+	Acks string `json:"acks"`
+
+	// Compression of message batches: none (default), gzip, snappy, lz4
+	// or zstd
+	Compression string `json:"compression"`
+
+	// LingerMs is how long the producer waits for more messages before
+	// sending a batch
+	LingerMs int `json:"linger_ms"`
+
+	// DeliveryTimeoutSeconds bounds how long a message is retried before a
+	// batch fails. Default: 120.
+	DeliveryTimeoutSeconds int `json:"delivery_timeout_seconds"`
+
+	// CreateTopics creates missing topics when tables are set up, with the
+	// broker's default partitions and replication unless given. Defaults
+	// to true.
+	CreateTopics      *bool `json:"create_topics"`
+	Partitions        int32 `json:"partitions"`
+	ReplicationFactor int16 `json:"replication_factor"`
+
+	// Tombstones publishes a message without value after each DELETE, so
+	// compacted topics drop the row
+	Tombstones bool `json:"tombstones"`
+
+	// SASLMechanism authenticates with the peer's user and password:
+	// PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512. None if empty.
+	SASLMechanism string `json:"sasl_mechanism"`
+}
+
+// NewConfig reads and checks the settings of a Kafka peer
+func NewConfig(peer *connectors.Peer) (*Config, error) {
+	config := &Config{}
+	if err := peer.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+
+	if len(config.Brokers) == 0 {
+		if peer.Host == "" {
+			return nil, errors.New("brokers or host is required")
+		}
+		port := peer.Port
+		if port == 0 {
+			port = 9092
+		}
+		config.Brokers = []string{fmt.Sprintf("%s:%d", peer.Host, port)}
+	}
+	if config.Idempotent == nil {
+		idempotent := true
+		config.Idempotent = &idempotent
+	}
+	if config.CreateTopics == nil {
+		create := true
+		config.CreateTopics = &create
+	}
+	if config.Partitions == 0 {
+		config.Partitions = -1
+	}
+	if config.ReplicationFactor == 0 {
+		config.ReplicationFactor = -1
+	}
+	if config.DeliveryTimeoutSeconds == 0 {
+		config.DeliveryTimeoutSeconds = 120
+	}
+
+	if _, err := format.New(config.Serialization, format.Options{}); err != nil {
+		return nil, err
+	}
+	if _, err := config.acks(); err != nil {
+		return nil, err
+	}
+	if *config.Idempotent && config.Acks != "" && config.Acks != "all" {
+		return nil, errors.New(`an idempotent producer needs acks "all"`)
+	}
+	if _, err := config.compression(); err != nil {
+		return nil, err
+	}
+	switch config.SASLMechanism {
+	case "", "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+	default:
+		return nil, fmt.Errorf("unsupported sasl_mechanism %q", config.SASLMechanism)
+	}
+	return config, nil
+}
+
+func (c *Config) acks() (kgo.Acks, error) {
+	switch c.Acks {
+	case "", "all":
+		return kgo.AllISRAcks(), nil
+	case "leader":
+		return kgo.LeaderAck(), nil
+	case "none":
+		return kgo.NoAck(), nil
+	default:
+		return kgo.Acks{}, fmt.Errorf("unsupported acks %q", c.Acks)
+	}
+}
+
+func (c *Config) compression() (kgo.CompressionCodec, error) {
+	switch c.Compression {
+	case "", "none":
+		return kgo.NoCompression(), nil
+	case "gzip":
+		return kgo.GzipCompression(), nil
+	case "snappy":
+		return kgo.SnappyCompression(), nil
+	case "lz4":
+		return kgo.Lz4Compression(), nil
+	case "zstd":
+		return kgo.ZstdCompression(), nil
+	default:
+		return kgo.CompressionCodec{}, fmt.Errorf("unsupported compression %q", c.Compression)
+	}
+}
+
+// KafkaConnector publishes changes to Kafka topics
+type KafkaConnector struct {
+	logger    *slog.Logger
+	config    *Config
+	client    *kgo.Client
+	formatter format.Formatter
+
+	// tables holds the source schema of each destination table set up
+	// through EnsureTable, by <schema>.<table>
+	tables map[string]*postgres.TableSchema
+
+	mu      sync.Mutex
+	offsets map[string]int64
+}
+
+// NewKafkaConnector connects to the brokers of a Kafka peer
+func NewKafkaConnector(ctx context.Context, peer *connectors.Peer) (*KafkaConnector, error) {
+	config, err := NewConfig(peer)
+	if err != nil {
+		return nil, err
+	}
+	// Debezium names events after the topic prefix, as its connectors do
+	name := config.TopicPrefix
+	if name == "" {
+		name = peer.Name
+	}
+	formatter, err := format.New(config.Serialization, format.Options{Name: name, Database: peer.Database})
+	if err != nil {
+		return nil, err
+	}
+	acks, _ := config.acks()
+	compression, _ := config.compression()
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.RequiredAcks(acks),
+		kgo.ProducerBatchCompression(compression),
+		kgo.ProducerLinger(time.Duration(config.LingerMs) * time.Millisecond),
+		kgo.RecordDeliveryTimeout(time.Duration(config.DeliveryTimeoutSeconds) * time.Second),
+	}
+	if !*config.Idempotent {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+
+	switch peer.SSLMode {
+	case "require":
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+	case "verify-ca", "verify-full":
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{}))
+	}
+
+	switch config.SASLMechanism {
+	case "PLAIN":
+		opts = append(opts, kgo.SASL(plain.Auth{User: peer.User, Pass: peer.Password}.AsMechanism()))
+	case "SCRAM-SHA-256":
+		opts = append(opts, kgo.SASL(scram.Auth{User: peer.User, Pass: peer.Password}.AsSha256Mechanism()))
+	case "SCRAM-SHA-512":
+		opts = append(opts, kgo.SASL(scram.Auth{User: peer.User, Pass: peer.Password}.AsSha512Mechanism()))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	if err := client.Ping(ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to reach Kafka brokers %s: %w", strings.Join(config.Brokers, ","), err)
+	}
+
+	return &KafkaConnector{
+		logger:    slog.Default().With(slog.String("component", "kafka-connector"), slog.String("peer", peer.Name)),
+		config:    config,
+		client:    client,
+		formatter: formatter,
+		tables:    make(map[string]*postgres.TableSchema),
+		offsets:   make(map[string]int64),
+	}, nil
+}
+
+// Close flushes and closes the producer
+func (c *KafkaConnector) Close() error {
+	c.client.Close()
+	return nil
+}
+
+// Topic returns the topic of a destination table
+func (c *KafkaConnector) Topic(destSchema, destTable string) string {
+	topic := destSchema + "." + destTable
+	if c.config.TopicPrefix != "" {
+		topic = c.config.TopicPrefix + "." + topic
+	}
+	return topic
+}
+
+// EnsureTable creates the topic of a table if it does not exist and
+// remembers its schema for the changes published to it
+func (c *KafkaConnector) EnsureTable(ctx context.Context, schema *postgres.TableSchema, destSchema, destTable string, _ postgres.MirrorColumns) error {
+	c.RegisterTable(schema, destSchema, destTable)
+	if !*c.config.CreateTopics {
+		return nil
+	}
+
+	topic := c.Topic(destSchema, destTable)
+	resp, err := kadm.NewClient(c.client).CreateTopic(ctx, c.config.Partitions, c.config.ReplicationFactor, nil, topic)
+	if err == nil {
+		err = resp.Err
+	}
+	if err != nil && !errors.Is(err, kerr.TopicAlreadyExists) {
+		return fmt.Errorf("failed to create topic %s: %w", topic, err)
+	}
+	if err == nil {
+		c.logger.Info("created topic", slog.String("topic", topic))
+	}
+	return nil
+}
+
+// RegisterTable remembers the schema of a table for the changes published
+// to its topic
+func (c *KafkaConnector) RegisterTable(schema *postgres.TableSchema, destSchema, destTable string) {
+	c.tables[destSchema+"."+destTable] = schema
+}
+
+// TruncateTable does nothing: topics keep what was published to them, and
+// the rows of a new snapshot are published after it
+func (c *KafkaConnector) TruncateTable(_ context.Context, destSchema, destTable string) error {
+	c.logger.Info("not truncating topic before snapshot", slog.String("topic", c.Topic(destSchema, destTable)))
+	return nil
+}
+
+// BulkLoad publishes rows read by the snapshot
+func (c *KafkaConnector) BulkLoad(ctx context.Context, destSchema, destTable string, columns []string, rows [][]interface{}) error {
+	schema := c.tables[destSchema+"."+destTable]
+	if schema == nil {
+		return fmt.Errorf("table %s.%s was not set up before its snapshot", destSchema, destTable)
+	}
+
+	topic := c.Topic(destSchema, destTable)
+	now := time.Now()
+	records := make([]*kgo.Record, 0, len(rows))
+	for _, values := range rows {
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			row[col] = values[i]
+		}
+		ev := &format.Event{
+			Record: &postgres.CDCRecord{
+				Operation: "INSERT",
+				Schema:    schema.SchemaName,
+				Table:     schema.TableName,
+				Columns:   columns,
+				NewValues: row,
+			},
+			Snapshot:   true,
+			Table:      schema,
+			KeyColumns: schema.PrimaryKeyColumns,
+		}
+		rec, err := c.message(topic, ev, now)
+		if err != nil {
+			return err
+		}
+		records = append(records, rec)
+	}
+	return c.produce(ctx, records)
+}
+
+// ApplyChanges publishes the records of source transactions in commit
+// order. Schema changes are not published; the rows that follow them carry
+// the new columns. A TRUNCATE is published without key, to one partition.
+func (c *KafkaConnector) ApplyChanges(ctx context.Context, txns []*postgres.CDCTransaction, targets map[string]*postgres.ApplyTarget) error {
+	var records []*kgo.Record
+	for _, txn := range txns {
+		// The chunks of a spooled transaction are published one at a time
+		err := txn.Chunks(func() error {
+			for _, rec := range txn.Records {
+				target := postgres.ApplyTargetFor(targets, rec)
+				table := target.Schema + "." + target.Table
+
+				// Changes after a schema change are published with the
+				// columns and types it leaves
+				if rec.Operation == "SCHEMA" {
+					if schema := c.tables[table]; schema != nil && rec.SchemaDelta != nil {
+						c.tables[table] = rec.SchemaDelta.ApplyTo(schema)
+					}
+					continue
+				}
+
+				ev := &format.Event{
+					Record: rec,
+					Table:  c.tables[table],
+				}
+				if rec.Operation != "TRUNCATE" {
+					ev.KeyColumns = target.PKColumns
+				}
+
+				topic := c.Topic(target.Schema, target.Table)
+				msg, err := c.message(topic, ev, rec.CommitTime)
+				if err != nil {
+					return err
+				}
+				records = append(records, msg)
+
+				if rec.Operation == "DELETE" && c.config.Tombstones && msg.Key != nil {
+					records = append(records, &kgo.Record{Topic: topic, Key: msg.Key, Timestamp: msg.Timestamp})
+				}
+			}
+			if !txn.Spooled() {
+				return nil
+			}
+			err := c.produce(ctx, records)
+			records = nil
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return c.produce(ctx, records)
+}
+
+// ApplySchemaDelta does nothing: messages carry their own columns
+func (c *KafkaConnector) ApplySchemaDelta(_ context.Context, _ *postgres.SchemaDelta) error {
+	return nil
+}
+
+// Offsets returns the offset of the last message published to each
+// partition since the connector was created, by <topic>/<partition>
+func (c *KafkaConnector) Offsets() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offsets := make(map[string]int64, len(c.offsets))
+	for k, v := range c.offsets {
+		offsets[k] = v
+	}
+	return offsets
+}
+
+// message renders an event as a Kafka message with the change metadata in
+// its headers
+func (c *KafkaConnector) message(topic string, ev *format.Event, ts time.Time) (*kgo.Record, error) {
+	key, err := c.formatter.Key(ev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render key for %s: %w", topic, err)
+	}
+	value, err := c.formatter.Value(ev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render value for %s: %w", topic, err)
+	}
+
+	rec := ev.Record
+	headers := []kgo.RecordHeader{
+		{Key: HeaderOperation, Value: []byte(ev.Operation())},
+		{Key: HeaderContentType, Value: []byte(c.formatter.ContentType())},
+	}
+	if !ev.Snapshot {
+		headers = append(headers,
+			kgo.RecordHeader{Key: HeaderLSN, Value: []byte(strconv.FormatInt(rec.LSN, 10))},
+			kgo.RecordHeader{Key: HeaderCommitLSN, Value: []byte(strconv.FormatInt(rec.CommitLSN, 10))},
+			kgo.RecordHeader{Key: HeaderCommitTime, Value: []byte(rec.CommitTime.UTC().Format(time.RFC3339Nano))},
+			kgo.RecordHeader{Key: HeaderXID, Value: []byte(strconv.FormatUint(uint64(rec.XID), 10))},
+		)
+	}
+
+	return &kgo.Record{
+		Topic:     topic,
+		Key:       key,
+		Value:     value,
+		Headers:   headers,
+		Timestamp: ts,
+	}, nil
+}
+
+// produce publishes messages and waits until the brokers acknowledged all
+// of them. Messages to the same partition are written in order; if any
+// message fails, the batch fails and is published again on retry.
+func (c *KafkaConnector) produce(ctx context.Context, records []*kgo.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	results := c.client.ProduceSync(ctx, records...)
+	if err := results.FirstErr(); err != nil {
+		return fmt.Errorf("failed to publish %d messages: %w", len(records), err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, result := range results {
+		rec := result.Record
+		c.offsets[fmt.Sprintf("%s/%d", rec.Topic, rec.Partition)] = rec.Offset
+	}
+	return nil
+}
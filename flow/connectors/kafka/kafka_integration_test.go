@@ -0,0 +1,229 @@
+//go:build integration
+
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// The broker of the kafka profile of docker-compose.yml, unless
+// BUNNY_TEST_KAFKA_BROKERS is set:
+//
+//	docker compose --profile kafka up -d redpanda
+//	go test -tags integration ./connectors/kafka/
+func testBrokers() string {
+	if brokers := os.Getenv("BUNNY_TEST_KAFKA_BROKERS"); brokers != "" {
+		return brokers
+	}
+	return "localhost:19092"
+}
+
+func TestApplyChangesPublishesKeysHeadersAndOffsets(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	prefix := fmt.Sprintf("bunnytest%d", time.Now().UnixNano())
+	conn, err := NewKafkaConnector(ctx, &connectors.Peer{
+		Name: "kafka_test",
+		Type: PeerType,
+		Config: map[string]interface{}{
+			"brokers":      []string{testBrokers()},
+			"topic_prefix": prefix,
+			"partitions":   1,
+			"tombstones":   true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	schema := &postgres.TableSchema{
+		SchemaName:        "public",
+		TableName:         "users",
+		Columns:           []postgres.ColumnDefinition{{Name: "id", Type: "bigint"}, {Name: "name", Type: "text"}},
+		PrimaryKeyColumns: []string{"id"},
+	}
+	if err := conn.EnsureTable(ctx, schema, "public", "users", postgres.MirrorColumns{}); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	topic := conn.Topic("public", "users")
+	defer func() {
+		_, _ = kadm.NewClient(conn.client).DeleteTopics(context.Background(), topic)
+	}()
+
+	commitTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	txn := &postgres.CDCTransaction{XID: 731, CommitLSN: 5000, CommitTime: commitTime}
+	for i, rec := range []*postgres.CDCRecord{
+		{Operation: "INSERT", NewValues: map[string]interface{}{"id": int64(1), "name": "ada"}},
+		{Operation: "UPDATE", NewValues: map[string]interface{}{"id": int64(1), "name": "grace"}},
+		{Operation: "DELETE", OldValues: map[string]interface{}{"id": int64(1)}},
+	} {
+		rec.Schema, rec.Table = "public", "users"
+		rec.LSN = int64(4000 + i)
+		rec.XID, rec.CommitLSN, rec.CommitTime = txn.XID, txn.CommitLSN, commitTime
+		txn.Records = append(txn.Records, rec)
+	}
+	targets := map[string]*postgres.ApplyTarget{
+		"public.users": {Schema: "public", Table: "users", PKColumns: []string{"id"}},
+	}
+	if err := conn.ApplyChanges(ctx, []*postgres.CDCTransaction{txn}, targets); err != nil {
+		t.Fatalf("failed to publish changes: %v", err)
+	}
+
+	// Three changes and the tombstone of the DELETE, on the single partition
+	if got := conn.Offsets()[topic+"/0"]; got != 3 {
+		t.Errorf("offset of %s/0 = %d, want 3", topic, got)
+	}
+
+	consumer, err := kgo.NewClient(
+		kgo.SeedBrokers(testBrokers()),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	var msgs []*kgo.Record
+	for len(msgs) < 4 {
+		fetches := consumer.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("got %d messages before timeout, want 4", len(msgs))
+		}
+		fetches.EachError(func(_ string, _ int32, err error) {
+			t.Fatalf("failed to consume: %v", err)
+		})
+		msgs = append(msgs, fetches.Records()...)
+	}
+
+	for i, op := range []string{"INSERT", "UPDATE", "DELETE"} {
+		msg := msgs[i]
+		if msg.Offset != int64(i) {
+			t.Errorf("message %d has offset %d", i, msg.Offset)
+		}
+		if string(msg.Key) != `{"id":1}` {
+			t.Errorf("%s key = %s, want {\"id\":1}", op, msg.Key)
+		}
+		headers := make(map[string]string)
+		for _, h := range msg.Headers {
+			headers[h.Key] = string(h.Value)
+		}
+		want := map[string]string{
+			HeaderOperation:   op,
+			HeaderContentType: "application/json",
+			HeaderLSN:         strconv.Itoa(4000 + i),
+			HeaderCommitLSN:   "5000",
+			HeaderCommitTime:  "2026-03-01T12:00:00Z",
+			HeaderXID:         "731",
+		}
+		for k, v := range want {
+			if headers[k] != v {
+				t.Errorf("%s header %s = %q, want %q", op, k, headers[k], v)
+			}
+		}
+		if !msg.Timestamp.Equal(commitTime) {
+			t.Errorf("%s timestamp = %v, want %v", op, msg.Timestamp, commitTime)
+		}
+	}
+
+	tombstone := msgs[3]
+	if string(tombstone.Key) != `{"id":1}` || tombstone.Value != nil {
+		t.Errorf("tombstone key = %s, value = %s; want key {\"id\":1} and no value", tombstone.Key, tombstone.Value)
+	}
+}
+
+func TestSnapshotPartitionsArePublishedOnTheirOwnConnections(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	prefix := fmt.Sprintf("bunnytest%d", time.Now().UnixNano())
+	schema := &postgres.TableSchema{
+		SchemaName:        "public",
+		TableName:         "users",
+		Columns:           []postgres.ColumnDefinition{{Name: "id", Type: "bigint"}, {Name: "name", Type: "text"}},
+		PrimaryKeyColumns: []string{"id"},
+	}
+
+	// As the snapshot copies them, partition 0 sets the table up and the
+	// others only learn its schema
+	var conns []*KafkaConnector
+	for partition := 0; partition < 3; partition++ {
+		conn, err := NewKafkaConnector(ctx, &connectors.Peer{
+			Name: "kafka_test",
+			Type: PeerType,
+			Config: map[string]interface{}{
+				"brokers":      []string{testBrokers()},
+				"topic_prefix": prefix,
+				"partitions":   1,
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+
+		if partition == 0 {
+			if err := conn.EnsureTable(ctx, schema, "public", "users", postgres.MirrorColumns{}); err != nil {
+				t.Fatalf("failed to create topic: %v", err)
+			}
+		} else {
+			conn.RegisterTable(schema, "public", "users")
+		}
+	}
+	topic := conns[0].Topic("public", "users")
+	defer func() {
+		_, _ = kadm.NewClient(conns[0].client).DeleteTopics(context.Background(), topic)
+	}()
+
+	for partition, conn := range conns {
+		rows := [][]interface{}{{int64(partition*10 + 1), "a"}, {int64(partition*10 + 2), "b"}}
+		if err := conn.BulkLoad(ctx, "public", "users", []string{"id", "name"}, rows); err != nil {
+			t.Fatalf("failed to publish partition %d: %v", partition, err)
+		}
+	}
+
+	consumer, err := kgo.NewClient(
+		kgo.SeedBrokers(testBrokers()),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	keys := make(map[string]bool)
+	for len(keys) < 6 {
+		fetches := consumer.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("got %d messages before timeout, want 6", len(keys))
+		}
+		fetches.EachError(func(_ string, _ int32, err error) {
+			t.Fatalf("failed to consume: %v", err)
+		})
+		for _, msg := range fetches.Records() {
+			keys[string(msg.Key)] = true
+		}
+	}
+	for partition := range conns {
+		for _, id := range []int{partition*10 + 1, partition*10 + 2} {
+			if key := fmt.Sprintf(`{"id":%d}`, id); !keys[key] {
+				t.Errorf("no message with key %s", key)
+			}
+		}
+	}
+}
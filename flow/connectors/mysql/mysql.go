@@ -0,0 +1,889 @@
+// Package mysql is a destination that mirrors tables into MySQL or MariaDB.
+//
+// Source tables are created with their column types mapped to MySQL types
+// (see mysqlType) and follow the schema changes of the source. The
+// snapshot is loaded with LOAD DATA LOCAL INFILE, falling back to batched
+// INSERTs where the server does not allow it, and changes are applied
+// with INSERT ... ON DUPLICATE KEY UPDATE and DELETE, each source
+// transaction in one destination transaction.
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// PeerType is the peer type of MySQL and MariaDB peers
+const PeerType connectors.PeerType = "MYSQL"
+
+// Server errors telling LOAD DATA LOCAL INFILE is not allowed
+const (
+	errNotAllowedCommand        = 1148
+	errClientLocalFilesDisabled = 3948
+)
+
+func init() {
+	connectors.Register(PeerType, connectors.Registration{
+		Validate: func(peer *connectors.Peer) error {
+			_, err := NewConfig(peer)
+			return err
+		},
+		NewDestination: func(ctx context.Context, peer *connectors.Peer) (connectors.Destination, error) {
+			return NewMySQLConnector(ctx, peer)
+		},
+	})
+}
+
+// Config holds the settings of a MySQL peer, from its config
+type Config struct {
+	// TypeMapping replaces the MySQL type of Postgres types, by type name
+	// without modifiers, e.g. {"jsonb": "LONGTEXT"}
+	TypeMapping map[string]string `json:"type_mapping"`
+
+	// ColumnTypes sets the MySQL type of single columns, by
+	// <schema>.<table>.<column> of the destination table
+	ColumnTypes map[string]string `json:"column_types"`
+
+	// LoadDataLocalInfile loads snapshots with LOAD DATA LOCAL INFILE,
+	// which needs local_infile enabled on the server. Defaults to true;
+	// snapshots are inserted in batches without it.
+	LoadDataLocalInfile *bool `json:"load_data_local_infile"`
+}
+
+// NewConfig reads and checks the settings of a MySQL peer
+func NewConfig(peer *connectors.Peer) (*Config, error) {
+	config := &Config{}
+	if err := peer.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+
+	if peer.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	if peer.Database == "" {
+		return nil, errors.New("database is required")
+	}
+	for pgType, mysqlType := range config.TypeMapping {
+		if strings.TrimSpace(mysqlType) == "" {
+			return nil, fmt.Errorf("type_mapping of %s is empty", pgType)
+		}
+	}
+	for column, mysqlType := range config.ColumnTypes {
+		if strings.Count(column, ".") < 2 {
+			return nil, fmt.Errorf("column_types key %q is not <schema>.<table>.<column>", column)
+		}
+		if strings.TrimSpace(mysqlType) == "" {
+			return nil, fmt.Errorf("column_types of %s is empty", column)
+		}
+	}
+	if config.LoadDataLocalInfile == nil {
+		load := true
+		config.LoadDataLocalInfile = &load
+	}
+	return config, nil
+}
+
+// tlsConfig returns the driver's TLS setting for an SSL mode
+func tlsConfig(sslMode string) string {
+	switch sslMode {
+	case "disable":
+		return "false"
+	case "require":
+		return "skip-verify"
+	case "verify-ca", "verify-full":
+		return "true"
+	default:
+		return "preferred"
+	}
+}
+
+// table is a destination table set up through EnsureTable
+type table struct {
+	schema *postgres.TableSchema
+	oids   map[string]uint32
+}
+
+func newTable(schema *postgres.TableSchema) *table {
+	t := &table{schema: schema, oids: make(map[string]uint32, len(schema.Columns))}
+	for _, col := range schema.Columns {
+		t.oids[col.Name] = col.TypeOID
+	}
+	return t
+}
+
+// MySQLConnector writes changes to a MySQL or MariaDB database
+type MySQLConnector struct {
+	logger   *slog.Logger
+	config   *Config
+	db       *sql.DB
+	database string
+
+	// tables holds each destination table set up through EnsureTable, by
+	// <schema>.<table>, following the schema changes applied since
+	tables map[string]*table
+
+	// loadData is cleared once the server refuses LOAD DATA LOCAL INFILE
+	loadData bool
+}
+
+// readerSeq numbers the readers registered for LOAD DATA LOCAL INFILE
+var readerSeq atomic.Uint64
+
+// NewMySQLConnector connects to the database of a MySQL peer. Sessions use
+// UTC, so timestamps with time zone are stored in UTC.
+func NewMySQLConnector(ctx context.Context, peer *connectors.Peer) (*MySQLConnector, error) {
+	config, err := NewConfig(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	port := peer.Port
+	if port == 0 {
+		port = 3306
+	}
+	cfg := gomysql.NewConfig()
+	cfg.User = peer.User
+	cfg.Passwd = peer.Password
+	cfg.Net = "tcp"
+	cfg.Addr = fmt.Sprintf("%s:%d", peer.Host, port)
+	cfg.DBName = peer.Database
+	cfg.TLSConfig = tlsConfig(peer.SSLMode)
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	cfg.ClientFoundRows = true
+	cfg.Params = map[string]string{"time_zone": "'+00:00'"}
+
+	connector, err := gomysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MySQL connection settings: %w", err)
+	}
+	db := sql.OpenDB(connector)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", cfg.Addr, err)
+	}
+
+	return &MySQLConnector{
+		logger:   slog.Default().With(slog.String("component", "mysql-connector"), slog.String("peer", peer.Name)),
+		config:   config,
+		db:       db,
+		database: peer.Database,
+		tables:   make(map[string]*table),
+		loadData: *config.LoadDataLocalInfile,
+	}, nil
+}
+
+// Close closes the connections to the database
+func (c *MySQLConnector) Close() error {
+	return c.db.Close()
+}
+
+// quoteIdentifier quotes a MySQL identifier
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteIdentifiers(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdentifier(name)
+	}
+	return quoted
+}
+
+// databaseFor returns the database a destination schema is mirrored
+// into: the peer's database for public, a database of its name otherwise
+func (c *MySQLConnector) databaseFor(destSchema string) string {
+	if destSchema == "" || destSchema == "public" {
+		return c.database
+	}
+	return destSchema
+}
+
+// tableName returns the quoted name of a destination table
+func (c *MySQLConnector) tableName(destSchema, destTable string) string {
+	return quoteIdentifier(c.databaseFor(destSchema)) + "." + quoteIdentifier(destTable)
+}
+
+// columnType returns the MySQL type of a column of a destination table
+func (c *MySQLConnector) columnType(destSchema, destTable string, col postgres.ColumnDefinition) string {
+	if t, ok := c.config.ColumnTypes[destSchema+"."+destTable+"."+col.Name]; ok {
+		return t
+	}
+	return mysqlType(col.Type, col.IsPrimaryKey, c.config.TypeMapping)
+}
+
+// mirrorDefinitions renders the mirror columns for CREATE and ALTER TABLE
+func mirrorDefinitions(mirrorCols postgres.MirrorColumns) map[string]string {
+	defs := make(map[string]string)
+	if mirrorCols.SoftDelete != "" {
+		defs[mirrorCols.SoftDelete] = "BOOLEAN NOT NULL DEFAULT FALSE"
+	}
+	if mirrorCols.SyncedAt != "" {
+		defs[mirrorCols.SyncedAt] = "DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6)"
+	}
+	return defs
+}
+
+// EnsureTable creates the database and table a source table is mirrored
+// into, with its column types mapped to MySQL. Column defaults are not
+// carried over, since Postgres expressions rarely mean the same in MySQL.
+// The mirror columns are also added to a table that already exists.
+func (c *MySQLConnector) EnsureTable(ctx context.Context, schema *postgres.TableSchema, destSchema, destTable string, mirrorCols postgres.MirrorColumns) error {
+	if mirrorCols.History {
+		return errors.New("MySQL destinations do not support history tables")
+	}
+	for _, name := range mirrorCols.Names() {
+		for _, col := range schema.Columns {
+			if col.Name == name {
+				return fmt.Errorf("mirror column %s clashes with a column of %s.%s", name, schema.SchemaName, schema.TableName)
+			}
+		}
+	}
+
+	database := c.databaseFor(destSchema)
+	if database != c.database {
+		query := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s DEFAULT CHARACTER SET utf8mb4", quoteIdentifier(database))
+		if _, err := c.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to create database %s: %w", database, err)
+		}
+	}
+
+	var columnDefs []string
+	for _, col := range schema.Columns {
+		colDef := quoteIdentifier(col.Name) + " " + c.columnType(destSchema, destTable, col)
+		if !col.Nullable {
+			colDef += " NOT NULL"
+		}
+		columnDefs = append(columnDefs, colDef)
+	}
+	mirrorDefs := mirrorDefinitions(mirrorCols)
+	for _, name := range mirrorCols.Names() {
+		columnDefs = append(columnDefs, quoteIdentifier(name)+" "+mirrorDefs[name])
+	}
+	if len(schema.PrimaryKeyColumns) > 0 {
+		columnDefs = append(columnDefs, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(quoteIdentifiers(schema.PrimaryKeyColumns), ", ")))
+	}
+
+	name := c.tableName(destSchema, destTable)
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n) DEFAULT CHARACTER SET utf8mb4",
+		name, strings.Join(columnDefs, ",\n  "))
+	if _, err := c.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create table %s.%s: %w", database, destTable, err)
+	}
+
+	for _, colName := range mirrorCols.Names() {
+		exists, err := c.columnExists(ctx, destSchema, destTable, colName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", name, quoteIdentifier(colName), mirrorDefs[colName])
+		if _, err := c.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to add mirror column %s: %w", colName, err)
+		}
+	}
+
+	c.RegisterTable(schema, destSchema, destTable)
+	return nil
+}
+
+// RegisterTable remembers the source schema of a table, whose types
+// values are converted by
+func (c *MySQLConnector) RegisterTable(schema *postgres.TableSchema, destSchema, destTable string) {
+	c.tables[destSchema+"."+destTable] = newTable(schema)
+}
+
+// columnExists reports whether a destination table has a column
+func (c *MySQLConnector) columnExists(ctx context.Context, destSchema, destTable, column string) (bool, error) {
+	var n int
+	err := c.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = ? AND table_name = ? AND column_name = ?`,
+		c.databaseFor(destSchema), destTable, column).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up column %s of %s.%s: %w", column, destSchema, destTable, err)
+	}
+	return n > 0, nil
+}
+
+// TruncateTable removes the rows of a destination table
+func (c *MySQLConnector) TruncateTable(ctx context.Context, destSchema, destTable string) error {
+	query := "TRUNCATE TABLE " + c.tableName(destSchema, destTable)
+	if _, err := c.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to truncate %s.%s: %w", destSchema, destTable, err)
+	}
+	return nil
+}
+
+// columnOIDs returns the Postgres types of columns of a destination table,
+// 0 where unknown
+func (c *MySQLConnector) columnOIDs(destSchema, destTable string, columns []string) []uint32 {
+	oids := make([]uint32, len(columns))
+	if t := c.tables[destSchema+"."+destTable]; t != nil {
+		for i, col := range columns {
+			oids[i] = t.oids[col]
+		}
+	}
+	return oids
+}
+
+// BulkLoad writes rows read by the snapshot, replacing rows of the same key
+// so a partition copied again does not fail
+func (c *MySQLConnector) BulkLoad(ctx context.Context, destSchema, destTable string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	oids := c.columnOIDs(destSchema, destTable, columns)
+	converted := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		converted[i] = make([]interface{}, len(row))
+		for j, v := range row {
+			value, err := mysqlValue(oids[j], v)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", columns[j], err)
+			}
+			converted[i][j] = value
+		}
+	}
+
+	if c.loadData {
+		err := c.loadDataInfile(ctx, destSchema, destTable, columns, oids, converted)
+		var mysqlErr *gomysql.MySQLError
+		if err == nil || !errors.As(err, &mysqlErr) ||
+			(mysqlErr.Number != errNotAllowedCommand && mysqlErr.Number != errClientLocalFilesDisabled) {
+			return err
+		}
+		c.logger.Warn("LOAD DATA LOCAL INFILE is not allowed by the server, inserting snapshot rows instead",
+			slog.String("error", mysqlErr.Message))
+		c.loadData = false
+	}
+	return c.insertRows(ctx, destSchema, destTable, columns, converted)
+}
+
+// loadFieldEscaper escapes a field of a LOAD DATA file with the default
+// field and line terminators and escape character
+var loadFieldEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+
+// loadField renders a converted value as a field of a LOAD DATA file
+func loadField(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return `\N`
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return loadFieldEscaper.Replace(string(v))
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return loadFieldEscaper.Replace(v)
+	default:
+		return loadFieldEscaper.Replace(fmt.Sprint(v))
+	}
+}
+
+// loadDataInfile loads rows through LOAD DATA LOCAL INFILE, reading them
+// from memory. Binary columns go through user variables as hex, so the file
+// stays text. Data errors are only warnings with LOCAL; they are logged.
+func (c *MySQLConnector) loadDataInfile(ctx context.Context, destSchema, destTable string, columns []string, oids []uint32, rows [][]interface{}) error {
+	targets := make([]string, len(columns))
+	var sets []string
+	for i, col := range columns {
+		targets[i] = quoteIdentifier(col)
+		if oids[i] == pgtype.ByteaOID {
+			variable := fmt.Sprintf("@b%d", i)
+			targets[i] = variable
+			sets = append(sets, fmt.Sprintf("%s = UNHEX(%s)", quoteIdentifier(col), variable))
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, row := range rows {
+		for i, v := range row {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+			if b, ok := v.([]byte); ok && oids[i] == pgtype.ByteaOID {
+				fmt.Fprintf(&buf, "%X", b)
+				continue
+			}
+			buf.WriteString(loadField(v))
+		}
+		buf.WriteByte('\n')
+	}
+
+	reader := fmt.Sprintf("bunny-%d", readerSeq.Add(1))
+	gomysql.RegisterReaderHandler(reader, func() io.Reader { return &buf })
+	defer gomysql.DeregisterReaderHandler(reader)
+
+	query := fmt.Sprintf(`LOAD DATA LOCAL INFILE 'Reader::%s' REPLACE INTO TABLE %s
+		CHARACTER SET utf8mb4 FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n' (%s)`,
+		reader, c.tableName(destSchema, destTable), strings.Join(targets, ", "))
+	if len(sets) > 0 {
+		query += " SET " + strings.Join(sets, ", ")
+	}
+
+	// Warnings are per session, so they are read on the connection that
+	// loaded the rows
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	var warnings int
+	if err := conn.QueryRowContext(ctx, "SELECT @@warning_count").Scan(&warnings); err != nil || warnings == 0 {
+		return nil
+	}
+	var level, message string
+	var code int
+	if err := conn.QueryRowContext(ctx, "SHOW WARNINGS LIMIT 1").Scan(&level, &code, &message); err != nil {
+		return nil
+	}
+	c.logger.Warn("snapshot rows were loaded with warnings",
+		slog.String("table", destSchema+"."+destTable),
+		slog.Int("warnings", warnings),
+		slog.String("first", message))
+	return nil
+}
+
+// insertRows inserts rows in batches, replacing rows of the same key
+func (c *MySQLConnector) insertRows(ctx context.Context, destSchema, destTable string, columns []string, rows [][]interface{}) error {
+	// Placeholders are limited to 65535 per statement
+	batch := 65535 / len(columns)
+	if batch > 500 {
+		batch = 500
+	}
+	for start := 0; start < len(rows); start += batch {
+		end := min(start+batch, len(rows))
+		query := upsertQuery(c.tableName(destSchema, destTable), columns, nil, nil, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			args = append(args, row...)
+		}
+		if _, err := c.db.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertQuery returns an INSERT of rows of columns that updates the row of
+// the same key instead, if there is one. Mirror columns are set to the SQL
+// expressions mirrorExprs.
+func upsertQuery(table string, columns, mirrorCols, mirrorExprs []string, rows int) string {
+	exprs := make([]string, 0, len(columns)+len(mirrorCols))
+	for range columns {
+		exprs = append(exprs, "?")
+	}
+	exprs = append(exprs, mirrorExprs...)
+	row := "(" + strings.Join(exprs, ", ") + ")"
+
+	all := append(quoteIdentifiers(columns), quoteIdentifiers(mirrorCols)...)
+	updates := make([]string, len(all))
+	for i, col := range all {
+		updates[i] = fmt.Sprintf("%s = VALUES(%s)", col, col)
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON DUPLICATE KEY UPDATE %s",
+		table,
+		strings.Join(all, ", "),
+		strings.TrimSuffix(strings.Repeat(row+", ", rows), ", "),
+		strings.Join(updates, ", "))
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// ApplyChanges applies each source transaction in its own destination
+// transaction. Schema changes commit the records before them, since MySQL
+// commits implicitly around DDL. A record that fails rolls back its
+// transaction and stops the batch.
+func (c *MySQLConnector) ApplyChanges(ctx context.Context, txns []*postgres.CDCTransaction, targets map[string]*postgres.ApplyTarget) error {
+	for _, txn := range txns {
+		if err := c.applyTransaction(ctx, txn, targets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MySQLConnector) applyTransaction(ctx context.Context, txn *postgres.CDCTransaction, targets map[string]*postgres.ApplyTarget) error {
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// A spooled transaction is applied a chunk at a time
+	err := txn.Chunks(func() error {
+		for _, rec := range txn.Records {
+			target := postgres.ApplyTargetFor(targets, rec)
+
+			if rec.Operation == "SCHEMA" {
+				if tx != nil {
+					if err := tx.Commit(); err != nil {
+						return fmt.Errorf("failed to commit destination transaction (xid %d): %w", txn.XID, err)
+					}
+					tx = nil
+				}
+				if rec.SchemaDelta == nil {
+					continue
+				}
+				rec.SchemaDelta.DestinationTable = target.Schema + "." + target.Table
+				if err := c.alterTable(ctx, target.Schema, target.Table, rec.SchemaDelta); err != nil {
+					return fmt.Errorf("failed to apply schema change for %s.%s: %w", rec.Schema, rec.Table, err)
+				}
+				continue
+			}
+
+			if tx == nil {
+				var err error
+				if tx, err = c.db.BeginTx(ctx, nil); err != nil {
+					return fmt.Errorf("failed to begin destination transaction: %w", err)
+				}
+			}
+			if err := c.applyRecord(ctx, tx, rec, target); err != nil {
+				return fmt.Errorf("%s on %s.%s at LSN %d failed: %w", rec.Operation, rec.Schema, rec.Table, rec.LSN, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit destination transaction (xid %d): %w", txn.XID, err)
+		}
+		tx = nil
+	}
+	return nil
+}
+
+// applyRecord applies a row change. Tables with a primary key are written
+// with upserts, so a row missing or present after a replay is written all
+// the same; rows of other tables are found by their key or whole old row.
+func (c *MySQLConnector) applyRecord(ctx context.Context, db execer, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	if target.Changelog || target.History {
+		return errors.New("MySQL destinations do not support changelog or history tables")
+	}
+
+	keyed := len(target.PKColumns) > 0
+	if t := c.tables[target.Schema+"."+target.Table]; t != nil {
+		keyed = len(t.schema.PrimaryKeyColumns) > 0
+	}
+
+	switch rec.Operation {
+	case "INSERT":
+		return c.applyUpsert(ctx, db, rec, target)
+	case "UPDATE":
+		if !keyed || len(rec.UnchangedToastColumns) > 0 {
+			return c.applyUpdate(ctx, db, rec, target)
+		}
+		// A row whose key changed is moved
+		if keyChanged(rec, target.PKColumns) {
+			if err := c.applyDelete(ctx, db, rec, target); err != nil {
+				return err
+			}
+		}
+		return c.applyUpsert(ctx, db, rec, target)
+	case "DELETE":
+		return c.applyDelete(ctx, db, rec, target)
+	case "TRUNCATE":
+		return c.applyTruncate(ctx, db, target)
+	default:
+		return fmt.Errorf("unknown operation: %s", rec.Operation)
+	}
+}
+
+// keyChanged reports whether an UPDATE changed the key of its row
+func keyChanged(rec *postgres.CDCRecord, pkColumns []string) bool {
+	if rec.OldValues == nil {
+		return false
+	}
+	for _, pk := range pkColumns {
+		oldValue, ok := rec.OldValues[pk]
+		if ok && fmt.Sprint(oldValue) != fmt.Sprint(rec.NewValues[pk]) {
+			return true
+		}
+	}
+	return false
+}
+
+// values converts the values of columns of a record
+func (c *MySQLConnector) values(target *postgres.ApplyTarget, columns []string, values map[string]interface{}) ([]interface{}, error) {
+	oids := c.columnOIDs(target.Schema, target.Table, columns)
+	converted := make([]interface{}, len(columns))
+	for i, col := range columns {
+		v, err := mysqlValue(oids[i], values[col])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", col, err)
+		}
+		converted[i] = v
+	}
+	return converted, nil
+}
+
+// mirrorSets returns the assignments that maintain the mirror columns of a
+// row being written, or soft deleted if deleted is set
+func mirrorSets(target *postgres.ApplyTarget, deleted bool) []string {
+	var sets []string
+	if target.SoftDeleteColumn != "" {
+		sets = append(sets, fmt.Sprintf("%s = %t", quoteIdentifier(target.SoftDeleteColumn), deleted))
+	}
+	if target.SyncedAtColumn != "" {
+		sets = append(sets, quoteIdentifier(target.SyncedAtColumn)+" = NOW(6)")
+	}
+	return sets
+}
+
+// applyUpsert writes the new row of an INSERT or UPDATE
+func (c *MySQLConnector) applyUpsert(ctx context.Context, db execer, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	columns := make([]string, 0, len(rec.NewValues))
+	for _, col := range rec.Columns {
+		if _, ok := rec.NewValues[col]; ok {
+			columns = append(columns, col)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	args, err := c.values(target, columns, rec.NewValues)
+	if err != nil {
+		return err
+	}
+
+	var mirrorCols, mirrorExprs []string
+	if target.SoftDeleteColumn != "" {
+		mirrorCols = append(mirrorCols, target.SoftDeleteColumn)
+		mirrorExprs = append(mirrorExprs, "FALSE")
+	}
+	if target.SyncedAtColumn != "" {
+		mirrorCols = append(mirrorCols, target.SyncedAtColumn)
+		mirrorExprs = append(mirrorExprs, "NOW(6)")
+	}
+
+	query := upsertQuery(c.tableName(target.Schema, target.Table), columns, mirrorCols, mirrorExprs, 1)
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// rowMatch returns the condition finding the old row of a record, on its
+// key columns or, for IdentityFull tables without key, on the whole row
+func (c *MySQLConnector) rowMatch(rec *postgres.CDCRecord, target *postgres.ApplyTarget) (string, []interface{}, error) {
+	sourceValues := rec.OldValues
+	if sourceValues == nil {
+		sourceValues = rec.NewValues
+	}
+
+	var columns []string
+	for _, pk := range target.PKColumns {
+		if _, ok := sourceValues[pk]; ok {
+			columns = append(columns, pk)
+		}
+	}
+	op := "="
+	if len(columns) == 0 {
+		if !target.IdentityFull || rec.OldValues == nil {
+			return "", nil, fmt.Errorf("no primary key columns found for %s", rec.Operation)
+		}
+		for _, col := range rec.Columns {
+			if _, ok := rec.OldValues[col]; ok {
+				columns = append(columns, col)
+			}
+		}
+		// NULL-safe, since any column of the old row may be NULL
+		op = "<=>"
+	}
+
+	args, err := c.values(target, columns, sourceValues)
+	if err != nil {
+		return "", nil, err
+	}
+	conds := make([]string, len(columns))
+	for i, col := range columns {
+		conds[i] = fmt.Sprintf("%s %s ?", quoteIdentifier(col), op)
+	}
+	where := strings.Join(conds, " AND ")
+	if op == "<=>" {
+		where += " LIMIT 1"
+	}
+	return where, args, nil
+}
+
+// applyUpdate updates a row in place, for tables without primary key and
+// for rows whose unchanged TOAST values were not sent
+func (c *MySQLConnector) applyUpdate(ctx context.Context, db execer, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	columns := make([]string, 0, len(rec.NewValues))
+	for _, col := range rec.Columns {
+		if _, ok := rec.NewValues[col]; ok {
+			columns = append(columns, col)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	args, err := c.values(target, columns, rec.NewValues)
+	if err != nil {
+		return err
+	}
+	sets := make([]string, 0, len(columns)+2)
+	for _, col := range columns {
+		sets = append(sets, quoteIdentifier(col)+" = ?")
+	}
+	sets = append(sets, mirrorSets(target, false)...)
+
+	where, whereArgs, err := c.rowMatch(rec, target)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		c.tableName(target.Schema, target.Table), strings.Join(sets, ", "), where)
+	result, err := db.ExecContext(ctx, query, append(args, whereArgs...)...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return postgres.MissingRowError(rec, target)
+	}
+	return nil
+}
+
+// applyDelete deletes the old row of a record, or flags it as deleted
+func (c *MySQLConnector) applyDelete(ctx context.Context, db execer, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	if rec.OldValues == nil {
+		return fmt.Errorf("%s record has no old values", rec.Operation)
+	}
+	where, args, err := c.rowMatch(rec, target)
+	if err != nil {
+		return err
+	}
+
+	table := c.tableName(target.Schema, target.Table)
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", table, where)
+	if target.SoftDeleteColumn != "" && rec.Operation == "DELETE" {
+		query = fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(mirrorSets(target, true), ", "), where)
+	}
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// applyTruncate removes all rows of a table, or flags them as deleted.
+// Rows are deleted rather than truncated, since TRUNCATE TABLE commits
+// implicitly.
+func (c *MySQLConnector) applyTruncate(ctx context.Context, db execer, target *postgres.ApplyTarget) error {
+	table := c.tableName(target.Schema, target.Table)
+	query := "DELETE FROM " + table
+	if target.SoftDeleteColumn != "" {
+		query = fmt.Sprintf("UPDATE %s SET %s WHERE %s IS NOT TRUE",
+			table, strings.Join(mirrorSets(target, true), ", "), quoteIdentifier(target.SoftDeleteColumn))
+	}
+	_, err := db.ExecContext(ctx, query)
+	return err
+}
+
+// ApplySchemaDelta changes a table to follow a change of its source. Index
+// and foreign key changes are not carried over.
+func (c *MySQLConnector) ApplySchemaDelta(ctx context.Context, delta *postgres.SchemaDelta) error {
+	destSchema, destTable, ok := strings.Cut(delta.DestinationTable, ".")
+	if !ok {
+		destSchema, destTable = "public", delta.DestinationTable
+	}
+	return c.alterTable(ctx, destSchema, destTable, delta)
+}
+
+// alterTable adds, retypes and drops the columns of a schema change. Each
+// step checks the column first, since MySQL cannot skip existing or
+// missing columns itself and a change may be applied again after a crash.
+func (c *MySQLConnector) alterTable(ctx context.Context, destSchema, destTable string, delta *postgres.SchemaDelta) error {
+	name := c.tableName(destSchema, destTable)
+	t := c.tables[destSchema+"."+destTable]
+	isKey := make(map[string]bool)
+	if t != nil {
+		for _, pk := range t.schema.PrimaryKeyColumns {
+			isKey[pk] = true
+		}
+	}
+
+	for _, col := range delta.AddedColumns {
+		exists, err := c.columnExists(ctx, destSchema, destTable, col.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		// Rows already in the table have no value for the column
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s",
+			name, quoteIdentifier(col.Name), c.columnType(destSchema, destTable, col))
+		if _, err := c.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", col.Name, err)
+		}
+		c.logger.Info("added column", slog.String("table", destSchema+"."+destTable), slog.String("column", col.Name))
+	}
+
+	for _, change := range delta.TypeChanges {
+		col := postgres.ColumnDefinition{Name: change.ColumnName, Type: change.NewType, Nullable: true, IsPrimaryKey: isKey[change.ColumnName]}
+		colDef := quoteIdentifier(col.Name) + " " + c.columnType(destSchema, destTable, col)
+		if col.IsPrimaryKey {
+			colDef += " NOT NULL"
+		}
+		query := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s", name, colDef)
+		if _, err := c.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to change type of column %s: %w", change.ColumnName, err)
+		}
+	}
+
+	for _, colName := range delta.DroppedColumns {
+		exists, err := c.columnExists(ctx, destSchema, destTable, colName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", name, quoteIdentifier(colName))
+		if _, err := c.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to drop column %s: %w", colName, err)
+		}
+	}
+
+	if t != nil {
+		c.tables[destSchema+"."+destTable] = newTable(delta.ApplyTo(t.schema))
+	}
+	return nil
+}
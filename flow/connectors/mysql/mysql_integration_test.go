@@ -0,0 +1,206 @@
+//go:build integration
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// The server of the mysql profile of docker-compose.yml, unless
+// BUNNY_TEST_MYSQL_HOST and BUNNY_TEST_MYSQL_PORT are set:
+//
+//	docker compose --profile mysql up -d mysql
+//	go test -tags integration ./connectors/mysql/
+func testPeer(t *testing.T, config map[string]interface{}) *connectors.Peer {
+	host := os.Getenv("BUNNY_TEST_MYSQL_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	port := 13306
+	if p := os.Getenv("BUNNY_TEST_MYSQL_PORT"); p != "" {
+		var err error
+		if port, err = strconv.Atoi(p); err != nil {
+			t.Fatalf("invalid BUNNY_TEST_MYSQL_PORT: %v", err)
+		}
+	}
+	return &connectors.Peer{
+		Name:     "mysql_test",
+		Type:     PeerType,
+		Host:     host,
+		Port:     port,
+		User:     "root",
+		Password: "mysql",
+		Database: "dest_db",
+		SSLMode:  "disable",
+		Config:   config,
+	}
+}
+
+// storedRow is a row of the test table as MySQL has it
+type storedRow struct {
+	id        int64
+	name      string
+	bio       sql.NullString
+	active    bool
+	createdAt sql.NullTime
+	data      []byte
+	age       sql.NullInt64
+	deleted   bool
+}
+
+func TestSnapshotAndChangesAreAppliedAgainIdempotently(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn, err := NewMySQLConnector(ctx, testPeer(t, nil))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	table := fmt.Sprintf("bunnytest_%d", time.Now().UnixNano())
+	schema := &postgres.TableSchema{
+		SchemaName: "public",
+		TableName:  table,
+		Columns: []postgres.ColumnDefinition{
+			{Name: "id", Type: "bigint", TypeOID: pgtype.Int8OID, IsPrimaryKey: true},
+			{Name: "name", Type: "character varying(100)", TypeOID: pgtype.VarcharOID},
+			{Name: "bio", Type: "text", TypeOID: pgtype.TextOID, Nullable: true},
+			{Name: "active", Type: "boolean", TypeOID: pgtype.BoolOID},
+			{Name: "created_at", Type: "timestamp with time zone", TypeOID: pgtype.TimestamptzOID, Nullable: true},
+			{Name: "data", Type: "bytea", TypeOID: pgtype.ByteaOID, Nullable: true},
+		},
+		PrimaryKeyColumns: []string{"id"},
+	}
+	mirrorCols := postgres.MirrorColumns{SoftDelete: "_deleted"}
+	if err := conn.EnsureTable(ctx, schema, "public", table, mirrorCols); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	name := conn.tableName("public", table)
+	defer func() {
+		_, _ = conn.db.ExecContext(context.Background(), "DROP TABLE IF EXISTS "+name)
+	}()
+
+	// The first partition is loaded with LOAD DATA, the second by a
+	// connection of its own that inserts its rows
+	createdAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.FixedZone("", 3600))
+	columns := []string{"id", "name", "bio", "active", "created_at", "data"}
+	if err := conn.BulkLoad(ctx, "public", table, columns, [][]interface{}{
+		{int64(1), "ada", "tab\tnew\nline \\N", true, createdAt, []byte{0, '\t', '\\', 0xff}},
+		{int64(2), "grace", nil, "f", nil, nil},
+	}); err != nil {
+		t.Fatalf("failed to load first partition: %v", err)
+	}
+	second, err := NewMySQLConnector(ctx, testPeer(t, map[string]interface{}{"load_data_local_infile": false}))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer second.Close()
+	second.RegisterTable(schema, "public", table)
+	if err := second.BulkLoad(ctx, "public", table, columns, [][]interface{}{
+		{int64(3), "linus", nil, "t", "2026-03-01 12:00:00+01", `\x0102`},
+	}); err != nil {
+		t.Fatalf("failed to load second partition: %v", err)
+	}
+
+	change := func(op string, lsn int64, oldValues, newValues map[string]interface{}) *postgres.CDCRecord {
+		return &postgres.CDCRecord{
+			Operation: op, Schema: "public", Table: table, LSN: lsn,
+			Columns: columns, OldValues: oldValues, NewValues: newValues,
+		}
+	}
+	// bio of row 1 is TOASTed and unchanged, so it keeps the snapshot value
+	toasted := change("UPDATE", 102, nil, map[string]interface{}{"id": int64(1), "name": "ada lovelace", "active": true})
+	toasted.UnchangedToastColumns = []string{"bio"}
+	added := change("INSERT", 107, nil, map[string]interface{}{"id": int64(5), "name": "ken", "active": "t", "age": "81"})
+	added.Columns = append(columns, "age")
+	txns := []*postgres.CDCTransaction{
+		{XID: 10, EndLSN: 200, Records: []*postgres.CDCRecord{
+			change("INSERT", 101, nil, map[string]interface{}{"id": int64(4), "name": "barbara", "active": false}),
+			toasted,
+			// The key of row 2 changes
+			change("UPDATE", 103, map[string]interface{}{"id": int64(2)}, map[string]interface{}{"id": int64(20), "name": "grace", "active": false}),
+			change("DELETE", 104, map[string]interface{}{"id": int64(3)}, nil),
+		}},
+		{XID: 11, EndLSN: 300, Records: []*postgres.CDCRecord{
+			{Operation: "SCHEMA", Schema: "public", Table: table, LSN: 105, SchemaDelta: &postgres.SchemaDelta{
+				AddedColumns: []postgres.ColumnDefinition{{Name: "age", Type: "integer", TypeOID: pgtype.Int4OID, Nullable: true}},
+			}},
+			added,
+		}},
+	}
+	targets := map[string]*postgres.ApplyTarget{
+		"public." + table: {Schema: "public", Table: table, PKColumns: []string{"id"}, SoftDeleteColumn: "_deleted"},
+	}
+
+	// A batch applied again after a crash leaves the same rows
+	for i := 0; i < 2; i++ {
+		if err := conn.ApplyChanges(ctx, txns, targets); err != nil {
+			t.Fatalf("failed to apply changes (pass %d): %v", i+1, err)
+		}
+	}
+
+	want := []storedRow{
+		{id: 1, name: "ada lovelace", bio: sql.NullString{String: "tab\tnew\nline \\N", Valid: true}, active: true,
+			createdAt: sql.NullTime{Time: createdAt.UTC(), Valid: true}, data: []byte{0, '\t', '\\', 0xff}},
+		{id: 3, name: "linus", active: true, createdAt: sql.NullTime{Time: createdAt.UTC(), Valid: true}, data: []byte{1, 2}, deleted: true},
+		{id: 4, name: "barbara"},
+		{id: 5, name: "ken", active: true, age: sql.NullInt64{Int64: 81, Valid: true}},
+		{id: 20, name: "grace"},
+	}
+	got := readRows(ctx, t, conn, name)
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.id != w.id || g.name != w.name || g.bio != w.bio || g.active != w.active ||
+			g.createdAt.Valid != w.createdAt.Valid || !g.createdAt.Time.Equal(w.createdAt.Time) ||
+			!bytes.Equal(g.data, w.data) || g.age != w.age || g.deleted != w.deleted {
+			t.Errorf("row %d = %+v, want %+v", w.id, g, w)
+		}
+	}
+
+	// An update of a missing row whose unchanged TOAST values were not sent
+	// cannot be applied
+	missing := change("UPDATE", 301, nil, map[string]interface{}{"id": int64(99), "name": "nobody", "active": true})
+	missing.UnchangedToastColumns = []string{"bio"}
+	err = conn.ApplyChanges(ctx, []*postgres.CDCTransaction{{XID: 12, EndLSN: 400, Records: []*postgres.CDCRecord{missing}}}, targets)
+	if err == nil {
+		t.Error("update of a missing row with unchanged TOAST values succeeded")
+	}
+}
+
+// readRows returns the rows of a test table, by id
+func readRows(ctx context.Context, t *testing.T, conn *MySQLConnector, name string) []storedRow {
+	t.Helper()
+	rows, err := conn.db.QueryContext(ctx, "SELECT id, name, bio, active, created_at, data, age, _deleted FROM "+name+" ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+	defer rows.Close()
+	var got []storedRow
+	for rows.Next() {
+		var r storedRow
+		if err := rows.Scan(&r.id, &r.name, &r.bio, &r.active, &r.createdAt, &r.data, &r.age, &r.deleted); err != nil {
+			t.Fatalf("failed to read row: %v", err)
+		}
+		got = append(got, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+	return got
+}
@@ -0,0 +1,56 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+func TestLoadField(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, `\N`},
+		{true, "1"},
+		{false, "0"},
+		{int64(-42), "-42"},
+		{float32(0.1), "0.1"},
+		{1e21, "1e+21"},
+		{"plain", "plain"},
+		{"tab\tnew\nline\rreturn", `tab\tnew\nline\rreturn`},
+		{`back\slash`, `back\\slash`},
+		{"nul\x00byte", `nul\0byte`},
+		// \N in a value is not NULL once its backslash is escaped
+		{`\N`, `\\N`},
+		{[]byte("a\tb"), `a\tb`},
+		{time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC), "2026-03-01 12:30:00.123456"},
+		{time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC), "2026-03-01 12:30:00"},
+	}
+	for _, tt := range tests {
+		if got := loadField(tt.in); got != tt.want {
+			t.Errorf("loadField(%#v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestKeyChanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		oldValues map[string]interface{}
+		newValues map[string]interface{}
+		want      bool
+	}{
+		{"no old key", nil, map[string]interface{}{"id": int64(1)}, false},
+		{"same key", map[string]interface{}{"id": int64(1)}, map[string]interface{}{"id": int64(1)}, false},
+		{"same key as text", map[string]interface{}{"id": "1"}, map[string]interface{}{"id": int64(1)}, false},
+		{"new key", map[string]interface{}{"id": int64(1)}, map[string]interface{}{"id": int64(2)}, true},
+	}
+	for _, tt := range tests {
+		rec := &postgres.CDCRecord{Operation: "UPDATE", OldValues: tt.oldValues, NewValues: tt.newValues}
+		if got := keyChanged(rec, []string{"id"}); got != tt.want {
+			t.Errorf("%s: keyChanged = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package mysql
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// Types of text and binary key columns, which MySQL can only index with a
+// length
+const (
+	keyTextType   = "VARCHAR(255)"
+	keyBinaryType = "VARBINARY(255)"
+)
+
+// mysqlType returns the MySQL type of a Postgres type. Key columns of text
+// and binary types get a bounded type MySQL can index. Overrides replace
+// the default mapping by Postgres type name without modifiers.
+func mysqlType(pgType string, key bool, overrides map[string]string) string {
+	name, mods := postgres.BaseType(pgType)
+	if t, ok := overrides[name]; ok {
+		return t
+	}
+
+	// Arrays are kept in their Postgres text form
+	if strings.HasSuffix(name, "[]") {
+		return "LONGTEXT"
+	}
+
+	switch name {
+	case "smallint":
+		return "SMALLINT"
+	case "integer":
+		return "INT"
+	case "bigint":
+		return "BIGINT"
+	case "real":
+		return "FLOAT"
+	case "double precision":
+		return "DOUBLE"
+	case "numeric":
+		if len(mods) == 2 && mods[0] <= 65 && mods[1] <= 30 {
+			return fmt.Sprintf("DECIMAL(%d,%d)", mods[0], mods[1])
+		}
+		if len(mods) == 1 && mods[0] <= 65 {
+			return fmt.Sprintf("DECIMAL(%d,0)", mods[0])
+		}
+		return "DECIMAL(65,30)"
+	case "money":
+		return "DECIMAL(19,2)"
+	case "boolean":
+		return "BOOLEAN"
+	case "character varying":
+		if len(mods) == 1 && mods[0] <= 1024 && (!key || mods[0] <= 255) {
+			return fmt.Sprintf("VARCHAR(%d)", mods[0])
+		}
+	case "character":
+		if len(mods) == 1 && mods[0] <= 255 {
+			return fmt.Sprintf("CHAR(%d)", mods[0])
+		}
+	case "uuid":
+		return "CHAR(36)"
+	case "bytea":
+		if key {
+			return keyBinaryType
+		}
+		return "LONGBLOB"
+	case "date":
+		return "DATE"
+	case "timestamp without time zone", "timestamp with time zone":
+		return "DATETIME(6)"
+	case "time without time zone":
+		return "TIME(6)"
+	case "json", "jsonb":
+		return "JSON"
+	case "inet", "cidr", "macaddr", "macaddr8", "time with time zone", "interval":
+		return "VARCHAR(64)"
+	}
+
+	if key {
+		return keyTextType
+	}
+	return "LONGTEXT"
+}
+
+// Bounds of DATETIME, which infinite Postgres timestamps are clamped to
+var (
+	minDatetime = time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxDatetime = time.Date(9999, 12, 31, 23, 59, 59, 999999000, time.UTC)
+)
+
+// mysqlValue converts a value, typed or in Postgres text form, to a
+// parameter for a column of a Postgres type. Timestamps are written in
+// UTC; types without a close MySQL match are written in their Postgres
+// text form.
+func mysqlValue(oid uint32, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	text, isText := v.(string)
+
+	switch oid {
+	case pgtype.BoolOID:
+		if isText {
+			return text == "t" || text == "true", nil
+		}
+		return v, nil
+
+	case pgtype.ByteaOID:
+		if isText {
+			b, err := hex.DecodeString(strings.TrimPrefix(text, `\x`))
+			if err != nil {
+				return nil, fmt.Errorf("invalid bytea value: %w", err)
+			}
+			return b, nil
+		}
+		return v, nil
+
+	case pgtype.DateOID:
+		switch t := v.(type) {
+		case time.Time:
+			return t.Format("2006-01-02"), nil
+		case pgtype.InfinityModifier:
+			if t == pgtype.Infinity {
+				return "9999-12-31", nil
+			}
+			return "1000-01-01", nil
+		}
+		switch text {
+		case "infinity":
+			return "9999-12-31", nil
+		case "-infinity":
+			return "1000-01-01", nil
+		}
+		return v, nil
+
+	case pgtype.TimestampOID, pgtype.TimestamptzOID:
+		switch t := v.(type) {
+		case time.Time:
+			return t.UTC(), nil
+		case pgtype.InfinityModifier:
+			return clampInfinity(t), nil
+		}
+		switch text {
+		case "infinity":
+			return maxDatetime, nil
+		case "-infinity":
+			return minDatetime, nil
+		}
+		for _, layout := range []string{"2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00", "2006-01-02 15:04:05.999999-07:00:00"} {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		// Timestamps without time zone are written as they are
+		return v, nil
+
+	case pgtype.Float4OID, pgtype.Float8OID:
+		// MySQL cannot store NaN and infinities, they become NULL
+		switch text {
+		case "NaN", "Infinity", "-Infinity":
+			return nil, nil
+		}
+		if isNaN(v) {
+			return nil, nil
+		}
+		return v, nil
+
+	case pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID:
+		return v, nil
+	}
+
+	if isText {
+		return text, nil
+	}
+	if isNaN(v) {
+		return nil, nil
+	}
+	switch v.(type) {
+	case bool, int16, int32, int64, float32, float64, []byte, time.Time:
+		return v, nil
+	}
+	if oid != 0 {
+		buf, err := postgres.EncodeText(oid, v)
+		if err == nil {
+			if buf == nil {
+				return nil, nil
+			}
+			return string(buf), nil
+		}
+	}
+	return fmt.Sprint(v), nil
+}
+
+// clampInfinity returns the DATETIME bound of an infinite timestamp
+func clampInfinity(mod pgtype.InfinityModifier) interface{} {
+	switch mod {
+	case pgtype.Infinity:
+		return maxDatetime
+	case pgtype.NegativeInfinity:
+		return minDatetime
+	}
+	return nil
+}
+
+// isNaN reports whether a value is a float MySQL cannot store
+func isNaN(v interface{}) bool {
+	switch f := v.(type) {
+	case float32:
+		return math.IsNaN(float64(f)) || math.IsInf(float64(f), 0)
+	case float64:
+		return math.IsNaN(f) || math.IsInf(f, 0)
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package mysql
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestMySQLType(t *testing.T) {
+	tests := []struct {
+		pgType    string
+		key       bool
+		overrides map[string]string
+		want      string
+	}{
+		{pgType: "smallint", want: "SMALLINT"},
+		{pgType: "integer", want: "INT"},
+		{pgType: "bigint", key: true, want: "BIGINT"},
+		{pgType: "double precision", want: "DOUBLE"},
+		{pgType: "numeric(10,2)", want: "DECIMAL(10,2)"},
+		{pgType: "numeric(12)", want: "DECIMAL(12,0)"},
+		{pgType: "numeric", want: "DECIMAL(65,30)"},
+		{pgType: "numeric(70,2)", want: "DECIMAL(65,30)"},
+		{pgType: "character varying(100)", want: "VARCHAR(100)"},
+		{pgType: "character varying(1000)", want: "VARCHAR(1000)"},
+		{pgType: "character varying(1000)", key: true, want: keyTextType},
+		{pgType: "character varying(5000)", want: "LONGTEXT"},
+		{pgType: "character varying", want: "LONGTEXT"},
+		{pgType: "character(2)", want: "CHAR(2)"},
+		{pgType: "text", want: "LONGTEXT"},
+		{pgType: "text", key: true, want: keyTextType},
+		{pgType: "bytea", want: "LONGBLOB"},
+		{pgType: "bytea", key: true, want: keyBinaryType},
+		{pgType: "uuid", key: true, want: "CHAR(36)"},
+		{pgType: "timestamp(3) with time zone", want: "DATETIME(6)"},
+		{pgType: "timestamp without time zone", want: "DATETIME(6)"},
+		{pgType: "jsonb", want: "JSON"},
+		{pgType: "interval", want: "VARCHAR(64)"},
+		{pgType: "integer[]", want: "LONGTEXT"},
+		{pgType: "jsonb", overrides: map[string]string{"jsonb": "LONGTEXT"}, want: "LONGTEXT"},
+		{pgType: "numeric(10,2)", overrides: map[string]string{"numeric": "DOUBLE"}, want: "DOUBLE"},
+		{pgType: "citext", want: "LONGTEXT"},
+	}
+	for _, tt := range tests {
+		if got := mysqlType(tt.pgType, tt.key, tt.overrides); got != tt.want {
+			t.Errorf("mysqlType(%q, key %v) = %s, want %s", tt.pgType, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMySQLValue(t *testing.T) {
+	ts := time.Date(2026, 3, 1, 12, 30, 0, 0, time.FixedZone("", 2*3600))
+	tests := []struct {
+		name string
+		oid  uint32
+		in   interface{}
+		want interface{}
+	}{
+		{"null", pgtype.TextOID, nil, nil},
+		{"boolean text", pgtype.BoolOID, "t", true},
+		{"boolean false text", pgtype.BoolOID, "f", false},
+		{"boolean", pgtype.BoolOID, true, true},
+		{"bytea text", pgtype.ByteaOID, `\xdeadbeef`, []byte{0xde, 0xad, 0xbe, 0xef}},
+		{"bytea", pgtype.ByteaOID, []byte{1}, []byte{1}},
+		{"date", pgtype.DateOID, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), "2026-03-01"},
+		{"date text", pgtype.DateOID, "2026-03-01", "2026-03-01"},
+		{"infinite date", pgtype.DateOID, pgtype.Infinity, "9999-12-31"},
+		{"negative infinite date text", pgtype.DateOID, "-infinity", "1000-01-01"},
+		{"timestamptz in UTC", pgtype.TimestamptzOID, ts, ts.UTC()},
+		{"timestamptz text in UTC", pgtype.TimestamptzOID, "2026-03-01 12:30:00+02", ts.UTC()},
+		{"timestamp text as is", pgtype.TimestampOID, "2026-03-01 12:30:00", "2026-03-01 12:30:00"},
+		{"infinite timestamp", pgtype.TimestampOID, pgtype.Infinity, maxDatetime},
+		{"negative infinite timestamptz text", pgtype.TimestamptzOID, "-infinity", minDatetime},
+		{"NaN text", pgtype.Float8OID, "NaN", nil},
+		{"infinite float", pgtype.Float4OID, float32(math.Inf(1)), nil},
+		{"float", pgtype.Float8OID, 1.5, 1.5},
+		{"integer", pgtype.Int4OID, int32(7), int32(7)},
+		{"numeric text", pgtype.NumericOID, "12.50", "12.50"},
+		{"uuid", pgtype.UUIDOID, [16]byte{0xab}, "ab000000-0000-0000-0000-000000000000"},
+		{"typed NULL", pgtype.UUIDOID, pgtype.UUID{}, nil},
+		{"unknown type", 0, struct{ A int }{1}, "{1}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mysqlValue(tt.oid, tt.in)
+			if err != nil {
+				t.Fatalf("mysqlValue(%d, %#v) failed: %v", tt.oid, tt.in, err)
+			}
+			switch want := tt.want.(type) {
+			case []byte:
+				if b, ok := got.([]byte); !ok || !bytes.Equal(b, want) {
+					t.Errorf("mysqlValue(%d, %#v) = %#v, want %#v", tt.oid, tt.in, got, want)
+				}
+			case time.Time:
+				if g, ok := got.(time.Time); !ok || !g.Equal(want) || g.Location() != time.UTC {
+					t.Errorf("mysqlValue(%d, %#v) = %#v, want %v in UTC", tt.oid, tt.in, got, want)
+				}
+			default:
+				if got != tt.want {
+					t.Errorf("mysqlValue(%d, %#v) = %#v, want %#v", tt.oid, tt.in, got, tt.want)
+				}
+			}
+		})
+	}
+
+	if _, err := mysqlValue(pgtype.ByteaOID, `\xzz`); err == nil {
+		t.Error("invalid bytea text was accepted")
+	}
+}
@@ -0,0 +1,69 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// Postgres is built in: its package holds the record types the interfaces
+// are defined with, so it cannot register itself
+func init() {
+	Register(PeerTypePostgres, Registration{
+		Validate: func(peer *Peer) error {
+			if peer.Host == "" || peer.Database == "" {
+				return errors.New("host and database are required")
+			}
+			return nil
+		},
+		NewSource: func(ctx context.Context, peer *Peer) (Source, error) {
+			conn, err := postgres.NewPostgresConnector(ctx, PostgresConfig(peer))
+			if err != nil {
+				return nil, err
+			}
+			return &PostgresSource{conn}, nil
+		},
+		NewDestination: func(ctx context.Context, peer *Peer) (Destination, error) {
+			conn, err := postgres.NewPostgresConnector(ctx, PostgresConfig(peer))
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		},
+	})
+}
+
+// PostgresConfig returns the connection settings of a Postgres peer
+func PostgresConfig(peer *Peer) *postgres.PostgresConfig {
+	return &postgres.PostgresConfig{
+		Host:     peer.Host,
+		Port:     peer.Port,
+		User:     peer.User,
+		Password: peer.Password,
+		Database: peer.Database,
+		SSLMode:  peer.SSLMode,
+	}
+}
+
+// PostgresSource is a Postgres peer used as a source. Activities that need
+// more of the source than Source offers, such as publications and
+// snapshots, use the embedded connector.
+type PostgresSource struct {
+	*postgres.PostgresConnector
+}
+
+// OpenChangeStream starts streaming changes from a replication slot
+func (s *PostgresSource) OpenChangeStream(
+	ctx context.Context,
+	slotName string,
+	publicationName string,
+	startLSN int64,
+	opts postgres.ReplicationOptions,
+) (ChangeReader, error) {
+	reader, err := s.PostgresConnector.OpenChangeStream(ctx, slotName, publicationName, startLSN, opts)
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
@@ -1,7 +1,10 @@
 package postgres
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"strings"
@@ -11,20 +14,133 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 // CDCRecord represents a change data capture record
 type CDCRecord struct {
-	Operation   string            // INSERT, UPDATE, DELETE
-	Schema      string
-	Table       string
-	LSN         int64
-	Columns     []string
-	OldValues   map[string]interface{} // For UPDATE/DELETE
-	NewValues   map[string]interface{} // For INSERT/UPDATE
-	CommitTime  time.Time
+	Operation string // INSERT, UPDATE, DELETE, TRUNCATE, SCHEMA
+	Schema    string
+	Table     string
+	LSN       int64
+	Columns   []string
+	OldValues map[string]interface{} // For UPDATE/DELETE
+	NewValues map[string]interface{} // For INSERT/UPDATE
+	// UnchangedToastColumns lists columns of an UPDATE whose TOASTed value was
+	// not modified and therefore not sent; they are absent from NewValues
+	UnchangedToastColumns []string
+	// Options of a TRUNCATE record. Tables truncated by one statement share
+	// the same LSN and are applied together.
+	TruncateCascade         bool
+	TruncateRestartIdentity bool
+	// SchemaDelta of a SCHEMA record: the column changes between two Relation
+	// messages for the same table, applied before the rows that follow it
+	SchemaDelta *SchemaDelta
+	// Seq numbers the row changes of a transaction in WAL order, from 0.
+	// Changes of one transaction can share an LSN; together with the commit
+	// LSN, Seq tells them apart. SCHEMA records are not numbered.
+	Seq        int
+	XID        uint32
+	CommitLSN  int64
+	CommitTime time.Time
+
+	relation []ColumnInfo // Columns of the relation, for their type OIDs
 }
 
+// typeOID returns the type OID of a column of the record, or 0 if unknown
+func (rec *CDCRecord) typeOID(column string) uint32 {
+	for _, col := range rec.relation {
+		if col.Name == column {
+			return col.TypeOID
+		}
+	}
+	return 0
+}
+
+// DropColumns removes excluded columns from the record, including from the
+// column changes of a SCHEMA record
+func (rec *CDCRecord) DropColumns(excluded map[string]bool) {
+	keep := func(cols []string) []string {
+		kept := cols[:0]
+		for _, col := range cols {
+			if !excluded[col] {
+				kept = append(kept, col)
+			}
+		}
+		return kept
+	}
+
+	rec.Columns = keep(rec.Columns)
+	rec.UnchangedToastColumns = keep(rec.UnchangedToastColumns)
+	for col := range excluded {
+		delete(rec.OldValues, col)
+		delete(rec.NewValues, col)
+	}
+
+	if delta := rec.SchemaDelta; delta != nil {
+		added := delta.AddedColumns[:0]
+		for _, col := range delta.AddedColumns {
+			if !excluded[col.Name] {
+				added = append(added, col)
+			}
+		}
+		delta.AddedColumns = added
+
+		changes := delta.TypeChanges[:0]
+		for _, change := range delta.TypeChanges {
+			if !excluded[change.ColumnName] {
+				changes = append(changes, change)
+			}
+		}
+		delta.TypeChanges = changes
+
+		delta.DroppedColumns = keep(delta.DroppedColumns)
+	}
+}
+
+// CDCTransaction groups the records of a single source transaction,
+// delimited by the pgoutput Begin and Commit messages
+type CDCTransaction struct {
+	XID        uint32
+	BeginLSN   int64 // WAL position of the Begin message
+	CommitLSN  int64 // LSN of the commit record
+	EndLSN     int64 // End of the commit record, used as the checkpoint position
+	CommitTime time.Time
+	Records    []*CDCRecord
+
+	numChanges int // Row changes buffered so far, to number them
+	// spool holds the records of a transaction streamed before it committed,
+	// which are read a chunk at a time by Chunks instead of being in Records
+	spool *txnSpool
+	// PrepareChunk and ChunkApplied, if set, are run by Chunks on each chunk
+	// of a spooled transaction, before and after it is applied
+	PrepareChunk func(txn *CDCTransaction) error
+	ChunkApplied func(txn *CDCTransaction)
+}
+
+// StreamError is returned by PullRecords when a message of the replication
+// stream cannot be parsed or decoded. The transaction it belongs to cannot be
+// applied whole, so the reader stops for good: replication has to restart
+// from the last acknowledged position rather than skip the message.
+type StreamError struct {
+	XID uint32 // Transaction the message belongs to, 0 if unknown
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	if e.XID == 0 {
+		return fmt.Sprintf("failed to read replication stream: %v", e.Err)
+	}
+	return fmt.Sprintf("failed to read transaction %d from replication stream: %v", e.XID, e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// postgresEpoch is the reference point for pgoutput timestamps
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 // RelationInfo stores relation metadata received from pgoutput
 type RelationInfo struct {
 	RelationID uint32
@@ -44,37 +160,104 @@ type ColumnInfo struct {
 type CDCReader struct {
 	conn           *PostgresConnector
 	relations      map[uint32]*RelationInfo
+	baseline       map[string][]ColumnInfo // Layouts to diff first Relation messages against
 	logger         *slog.Logger
 	standbyTimeout time.Duration
 	lastStatusTime time.Time
-	clientXLogPos  pglogrepl.LSN
+
+	// clientXLogPos is the furthest WAL position received from the server.
+	// flushedLSN is the furthest position the destination has durably applied
+	// and the catalog has checkpointed; only this position is reported back as
+	// flushed, so the slot never moves past changes that have not landed.
+	clientXLogPos pglogrepl.LSN
+	flushedLSN    pglogrepl.LSN
+	deliveredLSN  pglogrepl.LSN // End LSN of the last transaction handed to the caller
+
+	// currentTxn buffers records between Begin and Commit. It survives across
+	// PullRecords calls so a transaction is never split between batches.
+	currentTxn *CDCTransaction
+
+	// streams holds the spooled changes of transactions streamed before
+	// commit (protocol version 2+), keyed by top-level xid. streamXID is the
+	// transaction of the open stream block, if inStream is set.
+	streams   map[uint32]*txnSpool
+	streamXID uint32
+	inStream  bool
+	// delivered holds the spools of the streamed transactions returned by
+	// the last pull, and chunkSize is how many of their records are read
+	// back at a time
+	delivered []*txnSpool
+	chunkSize int
+
+	// failed is set once a message could not be read; every later pull
+	// returns it, so nothing after the broken transaction is delivered
+	failed *StreamError
 }
 
-// NewCDCReader creates a new CDC reader
+// NewCDCReader creates a new CDC reader. The flushed position starts at the
+// offset replication was started from.
 func NewCDCReader(conn *PostgresConnector) *CDCReader {
+	startLSN := pglogrepl.LSN(conn.GetLastOffset())
 	return &CDCReader{
 		conn:           conn,
 		relations:      make(map[uint32]*RelationInfo),
+		streams:        make(map[uint32]*txnSpool),
 		logger:         slog.Default().With(slog.String("component", "cdc-reader")),
 		standbyTimeout: 10 * time.Second,
+		clientXLogPos:  startLSN,
+		flushedLSN:     startLSN,
+		deliveredLSN:   startLSN,
+	}
+}
+
+// AckLSN records that everything up to lsn has been applied to the destination
+// and checkpointed in the catalog. The next standby status update reports it
+// as flushed, allowing the server to release WAL up to that point.
+func (r *CDCReader) AckLSN(lsn int64) {
+	if pglogrepl.LSN(lsn) <= r.flushedLSN {
+		return
 	}
+	r.flushedLSN = pglogrepl.LSN(lsn)
+	r.lastStatusTime = time.Time{} // Report the new position promptly
+	r.conn.UpdateLastOffset(lsn)
 }
 
-// PullRecords reads records from the replication stream until timeout or maxRecords
-func (r *CDCReader) PullRecords(ctx context.Context, maxRecords int, timeout time.Duration) ([]*CDCRecord, int64, error) {
+// KeepAlive sends a standby status update if one is due. Callers that stop
+// pulling for a while, e.g. while applying is backed up, call it so that the
+// source does not time the connection out.
+func (r *CDCReader) KeepAlive(ctx context.Context) error {
+	return r.sendStandbyStatusIfNeeded(ctx)
+}
+
+// PullRecords reads committed transactions from the replication stream until
+// timeout or until at least maxRecords records have been collected. Only whole
+// transactions are returned; a transaction still in progress when the call
+// ends stays buffered in the reader and is returned by a later call. The
+// returned LSN is the end LSN of the last returned commit. A message that
+// cannot be read returns a *StreamError, and so does every later call.
+//
+// Transactions streamed before they committed are returned spooled, to be
+// read maxRecords records at a time with Chunks until the next call.
+func (r *CDCReader) PullRecords(ctx context.Context, maxRecords int, timeout time.Duration) ([]*CDCTransaction, int64, error) {
 	if r.conn.replConn == nil {
 		return nil, 0, fmt.Errorf("replication connection not set up")
 	}
+	if r.failed != nil {
+		return nil, 0, r.failed
+	}
+	r.releaseDelivered()
+	r.chunkSize = maxRecords
 
-	records := make([]*CDCRecord, 0, maxRecords)
+	var txns []*CDCTransaction
+	numRecords := 0
 	deadline := time.Now().Add(timeout)
 	var lastLSN int64
 
-	for len(records) < maxRecords && time.Now().Before(deadline) {
+	for numRecords < maxRecords && time.Now().Before(deadline) {
 		// Check context
 		select {
 		case <-ctx.Done():
-			return records, lastLSN, ctx.Err()
+			return txns, lastLSN, ctx.Err()
 		default:
 		}
 
@@ -102,14 +285,14 @@ func (r *CDCReader) PullRecords(ctx context.Context, maxRecords int, timeout tim
 				continue // Timeout is normal, keep trying
 			}
 			if ctx.Err() != nil {
-				return records, lastLSN, ctx.Err()
+				return txns, lastLSN, ctx.Err()
 			}
-			return records, lastLSN, fmt.Errorf("failed to receive message: %w", err)
+			return txns, lastLSN, fmt.Errorf("failed to receive message: %w", err)
 		}
 
 		// Process message
 		if errMsg, ok := rawMsg.(*pgproto3.ErrorResponse); ok {
-			return records, lastLSN, fmt.Errorf("postgres error: %s", errMsg.Message)
+			return txns, lastLSN, fmt.Errorf("postgres error: %s", errMsg.Message)
 		}
 
 		msg, ok := rawMsg.(*pgproto3.CopyData)
@@ -121,41 +304,69 @@ func (r *CDCReader) PullRecords(ctx context.Context, maxRecords int, timeout tim
 		case pglogrepl.PrimaryKeepaliveMessageByteID:
 			pkm, err := pglogrepl.ParsePrimaryKeepaliveMessage(msg.Data[1:])
 			if err != nil {
-				r.logger.Warn("failed to parse keepalive", slog.Any("error", err))
-				continue
+				return nil, 0, r.fail(fmt.Errorf("failed to parse keepalive: %w", err))
 			}
 			if pkm.ReplyRequested {
 				r.lastStatusTime = time.Time{} // Force status update
 			}
 
+			// With no transaction buffered and everything delivered already
+			// acknowledged, nothing before the server's WAL end is pending, so
+			// the slot can advance over WAL that produced no changes for us
+			if r.currentTxn == nil && r.flushedLSN >= r.deliveredLSN && pkm.ServerWALEnd > r.flushedLSN {
+				r.flushedLSN = pkm.ServerWALEnd
+				r.deliveredLSN = pkm.ServerWALEnd
+			}
+
 		case pglogrepl.XLogDataByteID:
 			xld, err := pglogrepl.ParseXLogData(msg.Data[1:])
 			if err != nil {
-				r.logger.Warn("failed to parse xlog data", slog.Any("error", err))
-				continue
+				return nil, 0, r.fail(fmt.Errorf("failed to parse xlog data: %w", err))
 			}
 
-			// Parse the pgoutput message
-			rec, err := r.parseXLogData(xld)
+			// Parse the pgoutput message. A message that cannot be read
+			// would leave a hole in its transaction, so the transactions
+			// pulled so far are not delivered either and nothing past the
+			// last acknowledged position is confirmed.
+			txn, err := r.parseXLogData(ctx, xld)
 			if err != nil {
-				r.logger.Warn("failed to parse xlog message", slog.Any("error", err))
-				continue
+				return nil, 0, r.fail(fmt.Errorf("failed to parse message at %s: %w", xld.WALStart, err))
 			}
 
-			if rec != nil {
-				records = append(records, rec)
-				lastLSN = rec.LSN
+			if txn != nil {
+				txns = append(txns, txn)
+				numRecords += txn.NumRecords()
+				lastLSN = txn.EndLSN
+				r.deliveredLSN = pglogrepl.LSN(txn.EndLSN)
 			}
 
-			// Update position
-			r.clientXLogPos = xld.WALStart + pglogrepl.LSN(len(xld.WALData))
+			// Update received position
+			if pos := xld.WALStart + pglogrepl.LSN(len(xld.WALData)); pos > r.clientXLogPos {
+				r.clientXLogPos = pos
+			}
 		}
 	}
 
-	return records, lastLSN, nil
+	return txns, lastLSN, nil
+}
+
+// fail stops the reader on a message it cannot read, discarding the open
+// transaction
+func (r *CDCReader) fail(err error) *StreamError {
+	r.failed = &StreamError{Err: err}
+	if r.inStream {
+		r.failed.XID = r.streamXID
+	} else if r.currentTxn != nil {
+		r.failed.XID = r.currentTxn.XID
+	}
+	r.currentTxn = nil
+	r.logger.Error("replication stream broken, stopping", slog.Any("error", r.failed))
+	return r.failed
 }
 
-// sendStandbyStatusIfNeeded sends status update to postgres
+// sendStandbyStatusIfNeeded sends status update to postgres. The write
+// position is what has been received; flush and apply positions only move
+// when AckLSN is called.
 func (r *CDCReader) sendStandbyStatusIfNeeded(ctx context.Context) error {
 	if time.Since(r.lastStatusTime) < r.standbyTimeout {
 		return nil
@@ -166,8 +377,8 @@ func (r *CDCReader) sendStandbyStatusIfNeeded(ctx context.Context) error {
 		r.conn.replConn.PgConn(),
 		pglogrepl.StandbyStatusUpdate{
 			WALWritePosition: r.clientXLogPos,
-			WALFlushPosition: r.clientXLogPos,
-			WALApplyPosition: r.clientXLogPos,
+			WALFlushPosition: r.flushedLSN,
+			WALApplyPosition: r.flushedLSN,
 			ClientTime:       time.Now(),
 			ReplyRequested:   false,
 		},
@@ -180,51 +391,201 @@ func (r *CDCReader) sendStandbyStatusIfNeeded(ctx context.Context) error {
 	return nil
 }
 
-// parseXLogData parses a pgoutput message
-func (r *CDCReader) parseXLogData(xld pglogrepl.XLogData) (*CDCRecord, error) {
+// parseXLogData parses a pgoutput message. Row changes are buffered in the
+// current transaction, which is returned once its Commit message arrives.
+func (r *CDCReader) parseXLogData(ctx context.Context, xld pglogrepl.XLogData) (*CDCTransaction, error) {
 	if len(xld.WALData) == 0 {
 		return nil, nil
 	}
 
 	msgType := xld.WALData[0]
 
+	// Inside a stream block, changes are prefixed with the xid of the
+	// (sub)transaction that made them and go to that transaction's spool
+	var streamedXID uint32
+	if r.inStream && isStreamedMessage(msgType) {
+		var err error
+		xld, streamedXID, err = unwrapStreamedMessage(xld)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	switch msgType {
 	case 'R': // Relation
 		rel, err := r.parseRelationMessage(xld.WALData[1:])
 		if err != nil {
 			return nil, err
 		}
+		if err := r.checkBinaryRelation(ctx, rel); err != nil {
+			return nil, err
+		}
+		prev := r.relations[rel.RelationID]
 		r.relations[rel.RelationID] = rel
-		return nil, nil // Relation messages don't produce records
+		if prev == nil {
+			// First sight of the relation: compare it with the layout the
+			// destination had when the reader started, if known
+			name := rel.Schema + "." + rel.Table
+			columns, ok := r.baseline[name]
+			if !ok {
+				return nil, nil
+			}
+			delete(r.baseline, name)
+			prev = &RelationInfo{RelationID: rel.RelationID, Schema: rel.Schema, Table: rel.Table, Columns: columns}
+		}
+
+		// A changed Relation message means the table was altered; its rows
+		// from here on use the new column layout
+		delta, err := r.relationDelta(ctx, prev, rel)
+		if err != nil || delta == nil {
+			return nil, err
+		}
+		return nil, r.bufferChange(streamedXID, &rowChange{Record: &CDCRecord{
+			Operation:   "SCHEMA",
+			Schema:      rel.Schema,
+			Table:       rel.Table,
+			LSN:         int64(xld.WALStart),
+			Columns:     getColumnNames(rel.Columns),
+			SchemaDelta: delta,
+		}}, nil)
 
 	case 'I': // Insert
-		return r.parseInsertMessage(xld)
+		ch, err := r.parseInsertMessage(xld)
+		return nil, r.bufferChange(streamedXID, ch, err)
 
 	case 'U': // Update
-		return r.parseUpdateMessage(xld)
+		ch, err := r.parseUpdateMessage(xld)
+		return nil, r.bufferChange(streamedXID, ch, err)
 
 	case 'D': // Delete
-		return r.parseDeleteMessage(xld)
+		ch, err := r.parseDeleteMessage(xld)
+		return nil, r.bufferChange(streamedXID, ch, err)
 
 	case 'B': // Begin
+		txn, err := r.parseBeginMessage(xld)
+		if err != nil {
+			return nil, err
+		}
+		if r.currentTxn != nil {
+			r.logger.Warn("begin received inside open transaction, discarding buffered records",
+				slog.Uint64("xid", uint64(r.currentTxn.XID)),
+				slog.Int("records", len(r.currentTxn.Records)))
+		}
+		r.currentTxn = txn
 		return nil, nil
 
 	case 'C': // Commit
-		return nil, nil
+		return r.parseCommitMessage(xld)
 
 	case 'O': // Origin
 		return nil, nil
 
 	case 'T': // Truncate
-		r.logger.Info("received truncate message")
+		recs, err := r.parseTruncateMessage(xld)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range recs {
+			if err := r.bufferChange(streamedXID, &rowChange{Record: rec}, nil); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+
+	case 'S': // Stream Start
+		return nil, r.parseStreamStartMessage(xld)
+
+	case 'E': // Stream Stop
+		r.inStream = false
+		r.streamXID = 0
 		return nil, nil
 
+	case 'c': // Stream Commit
+		return r.parseStreamCommitMessage(xld)
+
+	case 'A': // Stream Abort
+		return nil, r.parseStreamAbortMessage(xld)
+
 	default:
 		// Unknown message type, skip
 		return nil, nil
 	}
 }
 
+// bufferChange adds a parsed change to the open transaction, or to the spool
+// of the streamed transaction when inside a stream block. Spooled changes are
+// kept as sent and only decoded when read back.
+func (r *CDCReader) bufferChange(streamedXID uint32, ch *rowChange, err error) error {
+	if err != nil {
+		return err
+	}
+	if r.inStream {
+		return r.spoolChange(ch, streamedXID)
+	}
+	rec, err := r.decodeChange(ch)
+	if err != nil {
+		return err
+	}
+	if r.currentTxn == nil {
+		return fmt.Errorf("%s on %s.%s received outside of a transaction", rec.Operation, rec.Schema, rec.Table)
+	}
+	rec.XID = r.currentTxn.XID
+	if rec.Operation != "SCHEMA" {
+		rec.Seq = r.currentTxn.numChanges
+		r.currentTxn.numChanges++
+	}
+	r.currentTxn.Records = append(r.currentTxn.Records, rec)
+	return nil
+}
+
+// parseBeginMessage parses a begin message and opens a new transaction
+func (r *CDCReader) parseBeginMessage(xld pglogrepl.XLogData) (*CDCTransaction, error) {
+	// Format: FinalLSN (8) | CommitTimestamp (8) | XID (4)
+	data := xld.WALData[1:]
+	if len(data) < 20 {
+		return nil, fmt.Errorf("begin message too short")
+	}
+
+	return &CDCTransaction{
+		BeginLSN:   int64(xld.WALStart),
+		CommitLSN:  int64(binary.BigEndian.Uint64(data[0:8])),
+		CommitTime: pgTimestampToTime(int64(binary.BigEndian.Uint64(data[8:16]))),
+		XID:        binary.BigEndian.Uint32(data[16:20]),
+	}, nil
+}
+
+// parseCommitMessage parses a commit message and closes the open transaction,
+// stamping every buffered record with the commit position and time
+func (r *CDCReader) parseCommitMessage(xld pglogrepl.XLogData) (*CDCTransaction, error) {
+	// Format: Flags (1) | CommitLSN (8) | EndLSN (8) | CommitTimestamp (8)
+	data := xld.WALData[1:]
+	if len(data) < 25 {
+		return nil, fmt.Errorf("commit message too short")
+	}
+
+	txn := r.currentTxn
+	r.currentTxn = nil
+	if txn == nil {
+		return nil, fmt.Errorf("commit received without a matching begin")
+	}
+
+	txn.CommitLSN = int64(binary.BigEndian.Uint64(data[1:9]))
+	txn.EndLSN = int64(binary.BigEndian.Uint64(data[9:17]))
+	txn.CommitTime = pgTimestampToTime(int64(binary.BigEndian.Uint64(data[17:25])))
+
+	for _, rec := range txn.Records {
+		rec.CommitLSN = txn.CommitLSN
+		rec.CommitTime = txn.CommitTime
+	}
+
+	return txn, nil
+}
+
+// pgTimestampToTime converts microseconds since 2000-01-01 to a time.Time
+func pgTimestampToTime(micros int64) time.Time {
+	return postgresEpoch.Add(time.Duration(micros) * time.Microsecond)
+}
+
 // parseRelationMessage parses a relation message
 func (r *CDCReader) parseRelationMessage(data []byte) (*RelationInfo, error) {
 	// Format: RelationID (4) | Namespace (string) | RelationName (string) | ReplicaIdentity (1) | NumColumns (2) | Columns...
@@ -305,8 +666,121 @@ func (r *CDCReader) parseRelationMessage(data []byte) (*RelationInfo, error) {
 	return rel, nil
 }
 
+// checkBinaryRelation checks, in binary format, that the values of a relation
+// can be decoded. A column added since replication started may have a type
+// created since, so the custom types are loaded again before giving up.
+func (r *CDCReader) checkBinaryRelation(ctx context.Context, rel *RelationInfo) error {
+	if r.conn.replState == nil || !r.conn.replState.Binary {
+		return nil
+	}
+
+	columns := make(map[string]uint32, len(rel.Columns))
+	missing := false
+	for _, col := range rel.Columns {
+		columns[rel.Schema+"."+rel.Table+"."+col.Name] = col.TypeOID
+		if _, ok := r.conn.typeMap.TypeForOID(col.TypeOID); !ok {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	if err := r.conn.LoadCustomTypes(ctx); err != nil {
+		return fmt.Errorf("failed to load custom types: %w", err)
+	}
+	return r.conn.checkBinaryColumns(ctx, columns)
+}
+
+// relationDelta diffs a Relation message against the previous one for the
+// same relation. It returns nil when the columns did not change.
+//
+// Relation messages identify columns by name only, so a renamed column reads
+// as a dropped column and an added one. A column dropped and replaced at the
+// same position by one of the same type is reported as a likely rename: it
+// is still applied as a drop and an add, losing the column's values on the
+// destination until the table is resynced.
+func (r *CDCReader) relationDelta(ctx context.Context, prev, rel *RelationInfo) (*SchemaDelta, error) {
+	delta := &SchemaDelta{
+		SourceTable: rel.Schema + "." + rel.Table,
+	}
+
+	prevCols := make(map[string]ColumnInfo, len(prev.Columns))
+	for _, col := range prev.Columns {
+		prevCols[col.Name] = col
+	}
+	newCols := make(map[string]bool, len(rel.Columns))
+
+	for _, col := range rel.Columns {
+		newCols[col.Name] = true
+		old, exists := prevCols[col.Name]
+		if exists && old.TypeOID == col.TypeOID && old.Modifier == col.Modifier {
+			continue
+		}
+
+		newType, err := r.conn.formatType(ctx, col.TypeOID, col.Modifier)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			// Relation messages carry no nullability or defaults
+			delta.AddedColumns = append(delta.AddedColumns, ColumnDefinition{
+				Name:         col.Name,
+				Type:         newType,
+				TypeOID:      col.TypeOID,
+				TypeModifier: col.Modifier,
+				Nullable:     true,
+			})
+			continue
+		}
+
+		oldType, err := r.conn.formatType(ctx, old.TypeOID, old.Modifier)
+		if err != nil {
+			return nil, err
+		}
+		delta.TypeChanges = append(delta.TypeChanges, ColumnTypeChange{
+			ColumnName: col.Name,
+			OldType:    oldType,
+			NewType:    newType,
+		})
+	}
+
+	for _, col := range prev.Columns {
+		if !newCols[col.Name] {
+			delta.DroppedColumns = append(delta.DroppedColumns, col.Name)
+		}
+	}
+
+	for i := 0; i < len(prev.Columns) && i < len(rel.Columns); i++ {
+		old, col := prev.Columns[i], rel.Columns[i]
+		if _, kept := prevCols[col.Name]; kept || newCols[old.Name] ||
+			old.TypeOID != col.TypeOID || old.Modifier != col.Modifier {
+			continue
+		}
+		delta.LikelyRenames = append(delta.LikelyRenames, ColumnRename{OldName: old.Name, NewName: col.Name})
+		r.logger.Warn("column dropped and added at the same position with the same type, probably renamed; "+
+			"its values are not carried over, resync the table to copy them",
+			slog.String("table", delta.SourceTable),
+			slog.String("dropped", old.Name),
+			slog.String("added", col.Name))
+	}
+
+	if !delta.HasChanges() {
+		return nil, nil
+	}
+	delta.SourceColumns = rel.Columns
+
+	r.logger.Info("relation changed",
+		slog.String("table", delta.SourceTable),
+		slog.Int("added", len(delta.AddedColumns)),
+		slog.Int("dropped", len(delta.DroppedColumns)),
+		slog.Int("typeChanges", len(delta.TypeChanges)))
+
+	return delta, nil
+}
+
 // parseInsertMessage parses an insert message
-func (r *CDCReader) parseInsertMessage(xld pglogrepl.XLogData) (*CDCRecord, error) {
+func (r *CDCReader) parseInsertMessage(xld pglogrepl.XLogData) (*rowChange, error) {
 	data := xld.WALData[1:] // Skip message type byte
 	if len(data) < 5 {
 		return nil, fmt.Errorf("insert message too short")
@@ -327,23 +801,26 @@ func (r *CDCReader) parseInsertMessage(xld pglogrepl.XLogData) (*CDCRecord, erro
 	}
 	data = data[1:]
 
-	values, err := r.parseTupleData(data, rel.Columns)
+	tuple, _, err := readTuple(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse tuple: %w", err)
 	}
 
-	return &CDCRecord{
-		Operation: "INSERT",
-		Schema:    rel.Schema,
-		Table:     rel.Table,
-		LSN:       int64(xld.WALStart),
-		Columns:   getColumnNames(rel.Columns),
-		NewValues: values,
+	return &rowChange{
+		Record: &CDCRecord{
+			Operation: "INSERT",
+			Schema:    rel.Schema,
+			Table:     rel.Table,
+			LSN:       int64(xld.WALStart),
+			Columns:   getColumnNames(rel.Columns),
+		},
+		Relation: rel.Columns,
+		NewTuple: tuple,
 	}, nil
 }
 
 // parseUpdateMessage parses an update message
-func (r *CDCReader) parseUpdateMessage(xld pglogrepl.XLogData) (*CDCRecord, error) {
+func (r *CDCReader) parseUpdateMessage(xld pglogrepl.XLogData) (*rowChange, error) {
 	data := xld.WALData[1:] // Skip message type byte
 	if len(data) < 5 {
 		return nil, fmt.Errorf("update message too short")
@@ -358,22 +835,25 @@ func (r *CDCReader) parseUpdateMessage(xld pglogrepl.XLogData) (*CDCRecord, erro
 		return nil, fmt.Errorf("unknown relation ID: %d", relID)
 	}
 
-	rec := &CDCRecord{
-		Operation: "UPDATE",
-		Schema:    rel.Schema,
-		Table:     rel.Table,
-		LSN:       int64(xld.WALStart),
-		Columns:   getColumnNames(rel.Columns),
+	ch := &rowChange{
+		Record: &CDCRecord{
+			Operation: "UPDATE",
+			Schema:    rel.Schema,
+			Table:     rel.Table,
+			LSN:       int64(xld.WALStart),
+			Columns:   getColumnNames(rel.Columns),
+		},
+		Relation: rel.Columns,
 	}
 
 	// Check for old tuple (K or O)
 	if len(data) > 0 && (data[0] == 'K' || data[0] == 'O') {
-		data = data[1:]
-		oldValues, remaining, err := r.parseTupleDataWithRemaining(data, rel.Columns)
+		ch.OldKind = data[0]
+		tuple, remaining, err := readTuple(data[1:])
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse old tuple: %w", err)
 		}
-		rec.OldValues = oldValues
+		ch.OldTuple = tuple
 		data = remaining
 	}
 
@@ -383,17 +863,17 @@ func (r *CDCReader) parseUpdateMessage(xld pglogrepl.XLogData) (*CDCRecord, erro
 	}
 	data = data[1:]
 
-	newValues, err := r.parseTupleData(data, rel.Columns)
+	tuple, _, err := readTuple(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse new tuple: %w", err)
 	}
-	rec.NewValues = newValues
+	ch.NewTuple = tuple
 
-	return rec, nil
+	return ch, nil
 }
 
 // parseDeleteMessage parses a delete message
-func (r *CDCReader) parseDeleteMessage(xld pglogrepl.XLogData) (*CDCRecord, error) {
+func (r *CDCReader) parseDeleteMessage(xld pglogrepl.XLogData) (*rowChange, error) {
 	data := xld.WALData[1:] // Skip message type byte
 	if len(data) < 5 {
 		return nil, fmt.Errorf("delete message too short")
@@ -408,12 +888,15 @@ func (r *CDCReader) parseDeleteMessage(xld pglogrepl.XLogData) (*CDCRecord, erro
 		return nil, fmt.Errorf("unknown relation ID: %d", relID)
 	}
 
-	rec := &CDCRecord{
-		Operation: "DELETE",
-		Schema:    rel.Schema,
-		Table:     rel.Table,
-		LSN:       int64(xld.WALStart),
-		Columns:   getColumnNames(rel.Columns),
+	ch := &rowChange{
+		Record: &CDCRecord{
+			Operation: "DELETE",
+			Schema:    rel.Schema,
+			Table:     rel.Table,
+			LSN:       int64(xld.WALStart),
+			Columns:   getColumnNames(rel.Columns),
+		},
+		Relation: rel.Columns,
 	}
 
 	// Old tuple ('K' for key or 'O' for old)
@@ -423,25 +906,75 @@ func (r *CDCReader) parseDeleteMessage(xld pglogrepl.XLogData) (*CDCRecord, erro
 	if data[0] != 'K' && data[0] != 'O' {
 		return nil, fmt.Errorf("expected 'K' or 'O' for old tuple in delete, got %c", data[0])
 	}
-	data = data[1:]
+	ch.OldKind = data[0]
 
-	oldValues, err := r.parseTupleData(data, rel.Columns)
+	tuple, _, err := readTuple(data[1:])
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse old tuple: %w", err)
 	}
-	rec.OldValues = oldValues
+	ch.OldTuple = tuple
 
-	return rec, nil
+	return ch, nil
+}
+
+// parseTruncateMessage parses a truncate message into one record per relation
+func (r *CDCReader) parseTruncateMessage(xld pglogrepl.XLogData) ([]*CDCRecord, error) {
+	// Format: NumRelations (4) | Options (1) | RelationIDs (4 each)
+	data := xld.WALData[1:]
+	if len(data) < 5 {
+		return nil, fmt.Errorf("truncate message too short")
+	}
+
+	numRels := int(binary.BigEndian.Uint32(data[0:4]))
+	options := data[4]
+	data = data[5:]
+	if len(data) < numRels*4 {
+		return nil, fmt.Errorf("truncate message relation list too short")
+	}
+
+	recs := make([]*CDCRecord, 0, numRels)
+	for i := 0; i < numRels; i++ {
+		relID := binary.BigEndian.Uint32(data[i*4 : i*4+4])
+		rel, ok := r.relations[relID]
+		if !ok {
+			return nil, fmt.Errorf("unknown relation ID: %d", relID)
+		}
+
+		recs = append(recs, &CDCRecord{
+			Operation:               "TRUNCATE",
+			Schema:                  rel.Schema,
+			Table:                   rel.Table,
+			LSN:                     int64(xld.WALStart),
+			TruncateCascade:         options&1 != 0,
+			TruncateRestartIdentity: options&2 != 0,
+		})
+	}
+
+	r.logger.Info("received truncate message", slog.Int("relations", numRels))
+	return recs, nil
+}
+
+// rowChange is a parsed change whose tuples have not been decoded yet. It is
+// also the form streamed changes are spooled in, which holds any column
+// type since the values stay as sent.
+type rowChange struct {
+	Record   *CDCRecord   // The change without its values
+	Relation []ColumnInfo // Columns of the relation when the change was sent
+	OldKind  byte         // 'K' for a key-only old tuple, 'O' for a full one
+	OldTuple []tupleValue
+	NewTuple []tupleValue
 }
 
-// parseTupleData parses tuple data into a map
-func (r *CDCReader) parseTupleData(data []byte, columns []ColumnInfo) (map[string]interface{}, error) {
-	values, _, err := r.parseTupleDataWithRemaining(data, columns)
-	return values, err
+// tupleValue is a column of a tuple as pgoutput sends it: 'n' for NULL, 'u'
+// for an unchanged TOAST value, or 't' and 'b' for a text or binary value
+type tupleValue struct {
+	Kind byte
+	Data []byte
 }
 
-// parseTupleDataWithRemaining parses tuple data and returns remaining bytes
-func (r *CDCReader) parseTupleDataWithRemaining(data []byte, columns []ColumnInfo) (map[string]interface{}, []byte, error) {
+// readTuple splits tuple data into its column values and returns the bytes
+// that follow it
+func readTuple(data []byte) ([]tupleValue, []byte, error) {
 	if len(data) < 2 {
 		return nil, data, fmt.Errorf("tuple data too short")
 	}
@@ -450,52 +983,95 @@ func (r *CDCReader) parseTupleDataWithRemaining(data []byte, columns []ColumnInf
 	numCols := int(data[0])<<8 | int(data[1])
 	data = data[2:]
 
-	values := make(map[string]interface{}, numCols)
-
-	for i := 0; i < numCols && i < len(columns) && len(data) > 0; i++ {
-		colType := data[0]
+	tuple := make([]tupleValue, 0, numCols)
+	for i := 0; i < numCols && len(data) > 0; i++ {
+		val := tupleValue{Kind: data[0]}
 		data = data[1:]
 
-		switch colType {
-		case 'n': // NULL
-			values[columns[i].Name] = nil
-
-		case 'u': // TOAST unchanged
-			// Skip - value not changed
-			values[columns[i].Name] = nil
-
-		case 't': // Text value
+		switch val.Kind {
+		case 'n', 'u': // NULL, TOAST unchanged
+		case 't', 'b': // Text or binary value
 			if len(data) < 4 {
-				return values, data, fmt.Errorf("text value length too short")
+				return nil, data, fmt.Errorf("value length too short")
 			}
-			valLen := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+			valLen := int(binary.BigEndian.Uint32(data[0:4]))
 			data = data[4:]
 
 			if len(data) < valLen {
-				return values, data, fmt.Errorf("text value data too short")
+				return nil, data, fmt.Errorf("value data too short")
 			}
-			values[columns[i].Name] = string(data[:valLen])
+			val.Data = data[:valLen:valLen]
 			data = data[valLen:]
 
-		case 'b': // Binary value
-			if len(data) < 4 {
-				return values, data, fmt.Errorf("binary value length too short")
-			}
-			valLen := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
-			data = data[4:]
+		default:
+			return nil, data, fmt.Errorf("unknown column type: %c", val.Kind)
+		}
+		tuple = append(tuple, val)
+	}
 
-			if len(data) < valLen {
-				return values, data, fmt.Errorf("binary value data too short")
-			}
-			values[columns[i].Name] = data[:valLen]
-			data = data[valLen:]
+	return tuple, data, nil
+}
 
-		default:
-			return values, data, fmt.Errorf("unknown column type: %c", colType)
+// decodeChange decodes the tuples of a change into its record's values
+func (r *CDCReader) decodeChange(ch *rowChange) (*CDCRecord, error) {
+	rec := ch.Record
+	rec.relation = ch.Relation
+	if ch.OldTuple != nil {
+		oldValues, _, err := r.decodeTuple(ch.OldTuple, ch.Relation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode old tuple: %w", err)
+		}
+		rec.OldValues = oldValues
+	}
+	if ch.NewTuple == nil {
+		return rec, nil
+	}
+
+	newValues, unchanged, err := r.decodeTuple(ch.NewTuple, ch.Relation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode new tuple: %w", err)
+	}
+
+	// With REPLICA IDENTITY FULL the old tuple ('O') carries the complete row,
+	// so an unchanged TOAST value can be taken from it instead of being left
+	// out. A key-only tuple ('K') sends non-key columns as NULL and is no help.
+	for _, col := range unchanged {
+		if val, ok := rec.OldValues[col]; ok && ch.OldKind == 'O' {
+			newValues[col] = val
+			continue
 		}
+		rec.UnchangedToastColumns = append(rec.UnchangedToastColumns, col)
 	}
+	rec.NewValues = newValues
+	return rec, nil
+}
 
-	return values, data, nil
+// decodeTuple decodes tuple values into a map, also returning the names of
+// unchanged TOAST columns, which are left out of the map
+func (r *CDCReader) decodeTuple(tuple []tupleValue, columns []ColumnInfo) (map[string]interface{}, []string, error) {
+	values := make(map[string]interface{}, len(tuple))
+	var unchanged []string
+
+	for i := 0; i < len(tuple) && i < len(columns); i++ {
+		col := columns[i]
+		switch tuple[i].Kind {
+		case 'n':
+			values[col.Name] = nil
+		case 'u':
+			// The value was not sent; it must not be written as NULL
+			unchanged = append(unchanged, col.Name)
+		case 't':
+			values[col.Name] = string(tuple[i].Data)
+		case 'b':
+			val, err := r.conn.decodeValue(col.TypeOID, pgtype.BinaryFormatCode, tuple[i].Data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			values[col.Name] = val
+		}
+	}
+
+	return values, unchanged, nil
 }
 
 func getColumnNames(cols []ColumnInfo) []string {
@@ -506,22 +1082,457 @@ func getColumnNames(cols []ColumnInfo) []string {
 	return names
 }
 
+// execer is implemented by both *pgx.Conn and pgx.Tx
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// ApplyTarget describes where the records of one source table are applied.
+// PKColumns are the key columns used to find rows for UPDATE and DELETE: the
+// primary key, or the replica identity index columns. Without key columns,
+// IdentityFull tables are matched on the whole old row.
+//
+// With a SoftDeleteColumn, deleted rows are kept and flagged instead; with a
+// SyncedAtColumn, every written row is stamped with the apply time. Changes
+// of a Changelog target are appended to its changelog table instead of being
+// applied, and a History target keeps every version of its rows.
+type ApplyTarget struct {
+	Schema           string   `json:"schema"` // Destination schema
+	Table            string   `json:"table"`  // Destination table
+	PKColumns        []string `json:"pk_columns,omitempty"`
+	IdentityFull     bool     `json:"identity_full,omitempty"`
+	SoftDeleteColumn string   `json:"soft_delete_column,omitempty"`
+	SyncedAtColumn   string   `json:"synced_at_column,omitempty"`
+	Changelog        bool     `json:"changelog,omitempty"`
+	History          bool     `json:"history,omitempty"`
+	// CopyLSN is the source WAL position when the table's rows were last
+	// copied. Changes committed before it are replayed over rows that may
+	// already reflect them, or that were deleted since.
+	CopyLSN int64 `json:"copy_lsn,omitempty"`
+}
+
+// beforeCopy reports whether a change was committed before the table's rows
+// were copied, so its row may no longer exist on the destination
+func (t *ApplyTarget) beforeCopy(rec *CDCRecord) bool {
+	return rec.CommitLSN < t.CopyLSN
+}
+
+// MissingRowError returns the error for an UPDATE whose row was not found on
+// the destination, or nil if the row may be missing. The row cannot be
+// rebuilt from a record whose unchanged TOAST values were never sent; only a
+// table resync can restore it. A change committed before the table's rows
+// were copied is replayed over a row that may have been deleted on the
+// source since, and its DELETE follows.
+func MissingRowError(rec *CDCRecord, target *ApplyTarget) error {
+	if len(rec.UnchangedToastColumns) == 0 || target.beforeCopy(rec) {
+		return nil
+	}
+	return fmt.Errorf("row not found on destination and unchanged TOAST columns %v cannot be reconstructed; resync table %s.%s",
+		rec.UnchangedToastColumns, target.Schema, target.Table)
+}
+
+// mirrorSets returns the SET assignments that maintain the mirror columns of
+// a row being written, or soft deleted if deleted is set
+func (t *ApplyTarget) mirrorSets(deleted bool) []string {
+	var sets []string
+	if t.SoftDeleteColumn != "" {
+		sets = append(sets, fmt.Sprintf("%s = %t", quoteIdentifier(t.SoftDeleteColumn), deleted))
+	}
+	if t.SyncedAtColumn != "" {
+		sets = append(sets, fmt.Sprintf("%s = now()", quoteIdentifier(t.SyncedAtColumn)))
+	}
+	return sets
+}
+
+// mirrorInsert returns the mirror columns of an inserted row and the SQL
+// expressions of their values
+func (t *ApplyTarget) mirrorInsert() ([]string, []string) {
+	var cols, exprs []string
+	if t.SoftDeleteColumn != "" {
+		cols = append(cols, quoteIdentifier(t.SoftDeleteColumn))
+		exprs = append(exprs, "false")
+	}
+	if t.SyncedAtColumn != "" {
+		cols = append(cols, quoteIdentifier(t.SyncedAtColumn))
+		exprs = append(exprs, "now()")
+	}
+	return cols, exprs
+}
+
+// ApplyTargetFor looks up the target for a record by its source table,
+// falling back to a table of the same name when no mapping is known
+func ApplyTargetFor(targets map[string]*ApplyTarget, rec *CDCRecord) *ApplyTarget {
+	if target, ok := targets[rec.Schema+"."+rec.Table]; ok {
+		return target
+	}
+	return &ApplyTarget{Schema: rec.Schema, Table: rec.Table}
+}
+
+// RecordPayload is the JSON form of a CDC record and the target it was applied
+// to, kept so that a failed record can be inspected, edited and replayed.
+// Values are kept in their PostgreSQL text form, with the type OID of each
+// column, so they survive the round trip through JSON unchanged.
+type RecordPayload struct {
+	Operation               string            `json:"operation"`
+	Schema                  string            `json:"schema"`
+	Table                   string            `json:"table"`
+	LSN                     int64             `json:"lsn"`
+	CommitLSN               int64             `json:"commit_lsn,omitempty"`
+	Seq                     int               `json:"seq,omitempty"`
+	CommitTime              time.Time         `json:"commit_time"`
+	XID                     uint32            `json:"xid,omitempty"`
+	Columns                 []string          `json:"columns,omitempty"`
+	ColumnTypes             map[string]uint32 `json:"column_types,omitempty"`
+	OldValues               TextValues        `json:"old_values,omitempty"`
+	NewValues               TextValues        `json:"new_values,omitempty"`
+	UnchangedToastColumns   []string          `json:"unchanged_toast_columns,omitempty"`
+	TruncateCascade         bool              `json:"truncate_cascade,omitempty"`
+	TruncateRestartIdentity bool              `json:"truncate_restart_identity,omitempty"`
+	Target                  *ApplyTarget      `json:"target"`
+}
+
+// TextValues are column values in PostgreSQL text form, nil for NULL
+type TextValues map[string]*string
+
+// UnmarshalJSON reads text values. Other JSON values, as written by older
+// versions or sent in edits, are kept as their JSON text, so a number keeps
+// every digit.
+func (v *TextValues) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*v = nil
+		return nil
+	}
+
+	values := make(TextValues, len(raw))
+	for col, r := range raw {
+		var text *string
+		if err := json.Unmarshal(r, &text); err != nil {
+			s := string(bytes.TrimSpace(r))
+			text = &s
+		}
+		values[col] = text
+	}
+	*v = values
+	return nil
+}
+
+// NewRecordPayload captures a record and its target for later replay. Values
+// decoded from binary format are encoded back to text with typeMap.
+func NewRecordPayload(rec *CDCRecord, target *ApplyTarget, typeMap *pgtype.Map) (*RecordPayload, error) {
+	p := &RecordPayload{
+		Operation:               rec.Operation,
+		Schema:                  rec.Schema,
+		Table:                   rec.Table,
+		LSN:                     rec.LSN,
+		CommitLSN:               rec.CommitLSN,
+		Seq:                     rec.Seq,
+		CommitTime:              rec.CommitTime,
+		XID:                     rec.XID,
+		Columns:                 rec.Columns,
+		UnchangedToastColumns:   rec.UnchangedToastColumns,
+		TruncateCascade:         rec.TruncateCascade,
+		TruncateRestartIdentity: rec.TruncateRestartIdentity,
+		Target:                  target,
+	}
+
+	if len(rec.relation) > 0 {
+		p.ColumnTypes = make(map[string]uint32, len(rec.relation))
+		for _, col := range rec.relation {
+			p.ColumnTypes[col.Name] = col.TypeOID
+		}
+	}
+
+	var err error
+	if p.OldValues, err = recordText(rec, rec.OldValues, typeMap); err != nil {
+		return nil, err
+	}
+	if p.NewValues, err = recordText(rec, rec.NewValues, typeMap); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// recordText returns the PostgreSQL text form of a record's values. Values
+// decoded from binary format are encoded with typeMap.
+func recordText(rec *CDCRecord, values map[string]interface{}, typeMap *pgtype.Map) (TextValues, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	texts := make(TextValues, len(values))
+	for col, val := range values {
+		switch v := val.(type) {
+		case nil:
+			texts[col] = nil
+			continue
+		case string:
+			texts[col] = &v
+			continue
+		}
+
+		oid := rec.typeOID(col)
+		if typeMap == nil || oid == 0 {
+			return nil, fmt.Errorf("cannot encode %T value of column %s as text: type unknown", val, col)
+		}
+		buf, err := typeMap.Encode(oid, pgtype.TextFormatCode, val, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value of column %s as text: %w", col, err)
+		}
+		text := string(buf)
+		texts[col] = &text
+	}
+	return texts, nil
+}
+
+// Record rebuilds the CDC record and target from a payload. Values are text,
+// so they are bound as text and converted by the destination.
+func (p *RecordPayload) Record() (*CDCRecord, *ApplyTarget) {
+	rec := &CDCRecord{
+		Operation:               p.Operation,
+		Schema:                  p.Schema,
+		Table:                   p.Table,
+		LSN:                     p.LSN,
+		CommitLSN:               p.CommitLSN,
+		Seq:                     p.Seq,
+		CommitTime:              p.CommitTime,
+		XID:                     p.XID,
+		Columns:                 p.Columns,
+		OldValues:               p.OldValues.record(),
+		NewValues:               p.NewValues.record(),
+		UnchangedToastColumns:   p.UnchangedToastColumns,
+		TruncateCascade:         p.TruncateCascade,
+		TruncateRestartIdentity: p.TruncateRestartIdentity,
+	}
+	for col, oid := range p.ColumnTypes {
+		rec.relation = append(rec.relation, ColumnInfo{Name: col, TypeOID: oid})
+	}
+	target := p.Target
+	if target == nil {
+		target = &ApplyTarget{Schema: p.Schema, Table: p.Table}
+	}
+	return rec, target
+}
+
+// record returns the values as CDC record values
+func (v TextValues) record() map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	values := make(map[string]interface{}, len(v))
+	for col, text := range v {
+		if text == nil {
+			values[col] = nil
+		} else {
+			values[col] = *text
+		}
+	}
+	return values
+}
+
 // ApplyRecord applies a CDC record to the destination database
-func ApplyRecord(ctx context.Context, destConn *PostgresConnector, rec *CDCRecord, pkColumns []string) error {
+func ApplyRecord(ctx context.Context, destConn *PostgresConnector, rec *CDCRecord, target *ApplyTarget) error {
+	return applyRecord(ctx, destConn.conn, rec, target)
+}
+
+// ApplyTransaction applies all records of a source transaction inside a single
+// destination transaction, so readers of the destination never observe a
+// partially applied source transaction. Each record runs under its own
+// savepoint: a record that fails is rolled back and reported through onError.
+// If onError returns nil the record is skipped and the rest of the transaction
+// still commits; if it returns an error the whole destination transaction is
+// rolled back and that error returned. targets is keyed by the source
+// "schema.table".
+func ApplyTransaction(
+	ctx context.Context,
+	destConn *PostgresConnector,
+	txn *CDCTransaction,
+	targets map[string]*ApplyTarget,
+	onError func(rec *CDCRecord, target *ApplyTarget, err error) error,
+) error {
+	if txn.NumRecords() == 0 {
+		return nil
+	}
+
+	tx, err := destConn.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin destination transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// A spooled transaction is applied a chunk at a time, all in the one
+	// destination transaction
+	err = txn.Chunks(func() error {
+		for i := 0; i < len(txn.Records); {
+			// Tables truncated by a single source statement are truncated together,
+			// so foreign keys between them do not get in the way
+			group := txn.Records[i : i+1]
+			if group[0].Operation == "TRUNCATE" {
+				j := i + 1
+				for j < len(txn.Records) && txn.Records[j].Operation == "TRUNCATE" && txn.Records[j].LSN == group[0].LSN {
+					j++
+				}
+				group = txn.Records[i:j]
+			}
+			i += len(group)
+
+			if _, err := tx.Exec(ctx, "SAVEPOINT bunny_record"); err != nil {
+				return fmt.Errorf("failed to create savepoint: %w", err)
+			}
+
+			var applyErr error
+			if group[0].Operation == "TRUNCATE" {
+				applyErr = applyTruncate(ctx, tx, group, targets)
+			} else {
+				applyErr = applyRecord(ctx, tx, group[0], ApplyTargetFor(targets, group[0]))
+			}
+
+			// Rows after a schema change depend on it, so it cannot be skipped
+			if applyErr != nil && group[0].Operation == "SCHEMA" {
+				return fmt.Errorf("failed to apply schema change for %s.%s: %w", group[0].Schema, group[0].Table, applyErr)
+			}
+
+			if applyErr != nil {
+				if _, rbErr := tx.Exec(ctx, "ROLLBACK TO SAVEPOINT bunny_record"); rbErr != nil {
+					return fmt.Errorf("failed to roll back to savepoint: %w", rbErr)
+				}
+				if onError != nil {
+					for _, rec := range group {
+						if err := onError(rec, ApplyTargetFor(targets, rec), applyErr); err != nil {
+							return err
+						}
+					}
+				}
+				continue
+			}
+
+			if _, err := tx.Exec(ctx, "RELEASE SAVEPOINT bunny_record"); err != nil {
+				return fmt.Errorf("failed to release savepoint: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit destination transaction (xid %d): %w", txn.XID, err)
+	}
+	return nil
+}
+
+// applyRecord dispatches a CDC record to the matching apply function
+func applyRecord(ctx context.Context, db execer, rec *CDCRecord, target *ApplyTarget) error {
+	if target.Changelog {
+		return applyChangelog(ctx, db, rec, target)
+	}
+	if target.History {
+		return applyHistory(ctx, db, rec, target)
+	}
+
 	switch rec.Operation {
 	case "INSERT":
-		return applyInsert(ctx, destConn, rec)
+		return applyInsert(ctx, db, rec, target)
 	case "UPDATE":
-		return applyUpdate(ctx, destConn, rec, pkColumns)
+		return applyUpdate(ctx, db, rec, target)
 	case "DELETE":
-		return applyDelete(ctx, destConn, rec, pkColumns)
+		return applyDelete(ctx, db, rec, target)
+	case "TRUNCATE":
+		return applyTruncate(ctx, db, []*CDCRecord{rec}, map[string]*ApplyTarget{rec.Schema + "." + rec.Table: target})
+	case "SCHEMA":
+		return applySchemaChange(ctx, db, rec, target)
 	default:
 		return fmt.Errorf("unknown operation: %s", rec.Operation)
 	}
 }
 
+// applySchemaChange alters the destination table to follow a column change on
+// the source. The delta's destination table is set to the target applied to.
+func applySchemaChange(ctx context.Context, db execer, rec *CDCRecord, target *ApplyTarget) error {
+	delta := rec.SchemaDelta
+	if delta == nil {
+		return nil
+	}
+
+	table := fmt.Sprintf("%s.%s", quoteIdentifier(target.Schema), quoteIdentifier(target.Table))
+	delta.DestinationTable = target.Schema + "." + target.Table
+
+	for _, col := range delta.AddedColumns {
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+			table, quoteIdentifier(col.Name), col.Type)
+		if _, err := db.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", col.Name, err)
+		}
+	}
+
+	for _, change := range delta.TypeChanges {
+		col := quoteIdentifier(change.ColumnName)
+		query := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s",
+			table, col, change.NewType, col, change.NewType)
+		if _, err := db.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to change type of column %s: %w", change.ColumnName, err)
+		}
+	}
+
+	for _, name := range delta.DroppedColumns {
+		query := fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", table, quoteIdentifier(name))
+		if _, err := db.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to drop column %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// applyTruncate truncates the destination tables of a group of TRUNCATE
+// records that came from the same source statement. Tables with a soft
+// delete column have all their rows flagged as deleted instead, history
+// tables have their current versions closed, and changelog tables record
+// the TRUNCATE.
+func applyTruncate(ctx context.Context, db execer, recs []*CDCRecord, targets map[string]*ApplyTarget) error {
+	tables := make([]string, 0, len(recs))
+	for _, rec := range recs {
+		target := ApplyTargetFor(targets, rec)
+		if target.Changelog || target.History {
+			if err := applyRecord(ctx, db, rec, target); err != nil {
+				return err
+			}
+			continue
+		}
+		table := quoteIdentifier(target.Schema) + "." + quoteIdentifier(target.Table)
+		if target.SoftDeleteColumn != "" {
+			query := fmt.Sprintf("UPDATE %s SET %s WHERE %s IS NOT TRUE",
+				table, strings.Join(target.mirrorSets(true), ", "), quoteIdentifier(target.SoftDeleteColumn))
+			if _, err := db.Exec(ctx, query); err != nil {
+				return err
+			}
+			continue
+		}
+		tables = append(tables, table)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	query := "TRUNCATE TABLE ONLY " + strings.Join(tables, ", ")
+	if recs[0].TruncateRestartIdentity {
+		query += " RESTART IDENTITY"
+	}
+	if recs[0].TruncateCascade {
+		query += " CASCADE"
+	}
+
+	_, err := db.Exec(ctx, query)
+	return err
+}
+
 // applyInsert applies an INSERT record
-func applyInsert(ctx context.Context, destConn *PostgresConnector, rec *CDCRecord) error {
+func applyInsert(ctx context.Context, db execer, rec *CDCRecord, target *ApplyTarget) error {
 	if len(rec.NewValues) == 0 {
 		return nil
 	}
@@ -538,25 +1549,47 @@ func applyInsert(ctx context.Context, destConn *PostgresConnector, rec *CDCRecor
 		i++
 	}
 
+	// A row inserted again after a soft delete replaces the flagged row;
+	// live rows are left alone, as replays of the INSERT would be
+	conflict := "DO NOTHING"
+	if target.SoftDeleteColumn != "" && len(target.PKColumns) > 0 {
+		sets := make([]string, 0, len(columns))
+		for _, col := range columns {
+			sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+		}
+		sets = append(sets, target.mirrorSets(false)...)
+		conflict = fmt.Sprintf("(%s) DO UPDATE SET %s WHERE d.%s",
+			strings.Join(quoteIdentifiers(target.PKColumns), ", "),
+			strings.Join(sets, ", "),
+			quoteIdentifier(target.SoftDeleteColumn))
+	}
+
+	mirrorCols, mirrorExprs := target.mirrorInsert()
+	columns = append(columns, mirrorCols...)
+	placeholders = append(placeholders, mirrorExprs...)
+
 	query := fmt.Sprintf(
-		"INSERT INTO %s.%s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
-		quoteIdentifier(rec.Schema),
-		quoteIdentifier(rec.Table),
+		"INSERT INTO %s.%s AS d (%s) VALUES (%s) ON CONFLICT %s",
+		quoteIdentifier(target.Schema),
+		quoteIdentifier(target.Table),
 		strings.Join(columns, ", "),
 		strings.Join(placeholders, ", "),
+		conflict,
 	)
 
-	_, err := destConn.conn.Exec(ctx, query, values...)
+	_, err := db.Exec(ctx, query, values...)
 	return err
 }
 
 // applyUpdate applies an UPDATE record
-func applyUpdate(ctx context.Context, destConn *PostgresConnector, rec *CDCRecord, pkColumns []string) error {
+func applyUpdate(ctx context.Context, db execer, rec *CDCRecord, target *ApplyTarget) error {
+	pkColumns := target.PKColumns
 	if len(rec.NewValues) == 0 {
 		return nil
 	}
 
-	// Build SET clause
+	// Build SET clause. Unchanged TOAST columns are not in NewValues, so the
+	// destination keeps its current value for them.
 	setClauses := make([]string, 0, len(rec.NewValues))
 	values := make([]interface{}, 0, len(rec.NewValues)+len(pkColumns))
 	paramIdx := 1
@@ -566,6 +1599,7 @@ func applyUpdate(ctx context.Context, destConn *PostgresConnector, rec *CDCRecor
 		values = append(values, val)
 		paramIdx++
 	}
+	setClauses = append(setClauses, target.mirrorSets(false)...)
 
 	// Build WHERE clause using PK columns
 	whereClauses := make([]string, 0, len(pkColumns))
@@ -583,24 +1617,38 @@ func applyUpdate(ctx context.Context, destConn *PostgresConnector, rec *CDCRecor
 		}
 	}
 
+	where := strings.Join(whereClauses, " AND ")
 	if len(whereClauses) == 0 {
-		return fmt.Errorf("no primary key columns found for UPDATE")
+		if !target.IdentityFull || rec.OldValues == nil {
+			return fmt.Errorf("no primary key columns found for UPDATE")
+		}
+		var matchValues []interface{}
+		where, matchValues = fullRowMatch(target, rec, paramIdx)
+		values = append(values, matchValues...)
 	}
 
 	query := fmt.Sprintf(
 		"UPDATE %s.%s SET %s WHERE %s",
-		quoteIdentifier(rec.Schema),
-		quoteIdentifier(rec.Table),
+		quoteIdentifier(target.Schema),
+		quoteIdentifier(target.Table),
 		strings.Join(setClauses, ", "),
-		strings.Join(whereClauses, " AND "),
+		where,
 	)
 
-	_, err := destConn.conn.Exec(ctx, query, values...)
-	return err
+	tag, err := db.Exec(ctx, query, values...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return MissingRowError(rec, target)
+	}
+	return nil
 }
 
 // applyDelete applies a DELETE record
-func applyDelete(ctx context.Context, destConn *PostgresConnector, rec *CDCRecord, pkColumns []string) error {
+func applyDelete(ctx context.Context, db execer, rec *CDCRecord, target *ApplyTarget) error {
+	pkColumns := target.PKColumns
 	if rec.OldValues == nil {
 		return fmt.Errorf("DELETE record has no old values")
 	}
@@ -618,21 +1666,104 @@ func applyDelete(ctx context.Context, destConn *PostgresConnector, rec *CDCRecor
 		}
 	}
 
+	where := strings.Join(whereClauses, " AND ")
 	if len(whereClauses) == 0 {
-		return fmt.Errorf("no primary key columns found for DELETE")
+		if !target.IdentityFull {
+			return fmt.Errorf("no primary key columns found for DELETE")
+		}
+		where, values = fullRowMatch(target, rec, paramIdx)
 	}
 
 	query := fmt.Sprintf(
 		"DELETE FROM %s.%s WHERE %s",
-		quoteIdentifier(rec.Schema),
-		quoteIdentifier(rec.Table),
-		strings.Join(whereClauses, " AND "),
+		quoteIdentifier(target.Schema),
+		quoteIdentifier(target.Table),
+		where,
 	)
+	if target.SoftDeleteColumn != "" {
+		query = fmt.Sprintf(
+			"UPDATE %s.%s SET %s WHERE %s",
+			quoteIdentifier(target.Schema),
+			quoteIdentifier(target.Table),
+			strings.Join(target.mirrorSets(true), ", "),
+			where,
+		)
+	}
 
-	_, err := destConn.conn.Exec(ctx, query, values...)
+	_, err := db.Exec(ctx, query, values...)
 	return err
 }
 
+// textMatchTypes are the built-in types without an equality operator, or
+// whose = does not compare whole values (box and circle compare areas).
+// Full-row matches compare their values as text.
+var textMatchTypes = map[uint32]bool{
+	pgtype.JSONOID: true, pgtype.JSONArrayOID: true,
+	142: true, 143: true, // xml, xml[]
+	pgtype.PointOID: true, pgtype.PointArrayOID: true,
+	pgtype.LsegOID: true, pgtype.LsegArrayOID: true,
+	pgtype.PathOID: true, pgtype.PathArrayOID: true,
+	pgtype.BoxOID: true, pgtype.BoxArrayOID: true,
+	pgtype.PolygonOID: true, pgtype.PolygonArrayOID: true,
+	pgtype.LineOID: true, pgtype.LineArrayOID: true,
+	pgtype.CircleOID: true, pgtype.CircleArrayOID: true,
+}
+
+// firstUserOID is the first OID given to objects created after initdb
+const firstUserOID = 16384
+
+// matchAsText reports whether a full-row match compares a column as text.
+// User-defined types may lack equality too; the source and destination share
+// their definition, so their text forms compare the same.
+func matchAsText(typeOID uint32) bool {
+	return textMatchTypes[typeOID] || typeOID >= firstUserOID
+}
+
+// fullRowMatch builds a WHERE clause selecting one destination row equal to
+// the old tuple of a REPLICA IDENTITY FULL table, with parameters numbered
+// from paramIdx. Duplicate rows cannot be told apart, so only one of them is
+// touched, picked by ctid. Soft deleted rows are never matched.
+//
+// Columns of types without equality are compared as text. Their values are
+// text unless decoded from binary format; a decoded value that cannot be
+// encoded back to text is left out of the match.
+func fullRowMatch(target *ApplyTarget, rec *CDCRecord, paramIdx int) (string, []interface{}) {
+	var typeMap *pgtype.Map
+	cols := sortedColumns(rec.OldValues)
+	conds := make([]string, 0, len(cols)+1)
+	values := make([]interface{}, 0, len(cols))
+	for _, col := range cols {
+		val := rec.OldValues[col]
+		oid := rec.typeOID(col)
+		if !matchAsText(oid) {
+			conds = append(conds, fmt.Sprintf("%s IS NOT DISTINCT FROM $%d", quoteIdentifier(col), paramIdx))
+			values = append(values, val)
+			paramIdx++
+			continue
+		}
+
+		if _, ok := val.(string); !ok && val != nil {
+			if typeMap == nil {
+				typeMap = pgtype.NewMap()
+			}
+			buf, err := typeMap.Encode(oid, pgtype.TextFormatCode, val, nil)
+			if err != nil {
+				continue
+			}
+			val = string(buf)
+		}
+		conds = append(conds, fmt.Sprintf("%s::text IS NOT DISTINCT FROM $%d::text", quoteIdentifier(col), paramIdx))
+		values = append(values, val)
+		paramIdx++
+	}
+	if target.SoftDeleteColumn != "" {
+		conds = append(conds, quoteIdentifier(target.SoftDeleteColumn)+" IS NOT TRUE")
+	}
+
+	table := fmt.Sprintf("%s.%s", quoteIdentifier(target.Schema), quoteIdentifier(target.Table))
+	return fmt.Sprintf("ctid = (SELECT ctid FROM %s WHERE %s LIMIT 1)", table, strings.Join(conds, " AND ")), values
+}
+
 // ReplConn returns the replication connection
 func (c *PostgresConnector) ReplConn() *pgx.Conn {
 	return c.replConn
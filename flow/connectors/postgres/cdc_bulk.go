@@ -0,0 +1,509 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/shared"
+)
+
+// bulkChange is the latest change of one row within a batch: either the row
+// values to upsert, or a delete of its key. Values are in PostgreSQL text
+// form, nil for NULL.
+type bulkChange struct {
+	deleted bool
+	values  TextValues
+	// partial is set when unchanged TOAST columns are missing from values;
+	// such rows can only update an existing destination row
+	partial bool
+}
+
+// bulkTable collects the changes of one destination table, collapsed to the
+// latest change per primary key
+type bulkTable struct {
+	target  *ApplyTarget
+	changes map[string]*bulkChange
+	keys    []string          // Keys in first-seen order
+	types   map[string]string // Destination column types, loaded on first flush
+}
+
+// bulkApplier applies batches of CDC records through COPY into temporary
+// staging tables, one set-based statement per table and operation
+type bulkApplier struct {
+	tx       pgx.Tx
+	typeMap  *pgtype.Map // Encodes values decoded from binary format as text
+	useMerge bool
+	maxRows  int
+	tables   map[string]*bulkTable
+	order    []string // Table keys in first-seen order
+	stageSeq int
+}
+
+// ApplyBatch applies the records of several source transactions in a single
+// destination transaction. Changes are collapsed to the latest one per
+// primary key, copied as text into per-table staging tables and applied,
+// cast to the destination column types, with one MERGE (PG15+ destinations)
+// or INSERT ... ON CONFLICT, plus a DELETE ... USING, per table. A table is
+// flushed every maxRows keys, and before any TRUNCATE or schema change.
+// Records of tables without key columns, and of changelog and history
+// tables, are applied one by one.
+//
+// Any failure rolls back the whole batch and is returned; callers can then
+// apply the same transactions with ApplyTransaction to handle failing records
+// individually. Batches with spooled transactions are applied that way from
+// the start, see HasSpooled.
+func ApplyBatch(
+	ctx context.Context,
+	destConn *PostgresConnector,
+	txns []*CDCTransaction,
+	targets map[string]*ApplyTarget,
+	maxRows int,
+) error {
+	version, err := destConn.GetPGVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := destConn.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin destination transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	b := &bulkApplier{
+		tx:       tx,
+		typeMap:  destConn.conn.TypeMap(),
+		useMerge: version >= shared.POSTGRES_15,
+		maxRows:  maxRows,
+		tables:   make(map[string]*bulkTable),
+	}
+
+	for _, txn := range txns {
+		for i := 0; i < len(txn.Records); {
+			rec := txn.Records[i]
+			target := ApplyTargetFor(targets, rec)
+
+			switch {
+			case rec.Operation == "TRUNCATE":
+				j := i + 1
+				for j < len(txn.Records) && txn.Records[j].Operation == "TRUNCATE" && txn.Records[j].LSN == rec.LSN {
+					j++
+				}
+				if err := b.flushAll(ctx); err != nil {
+					return err
+				}
+				if err := applyTruncate(ctx, tx, txn.Records[i:j], targets); err != nil {
+					return fmt.Errorf("failed to apply truncate: %w", err)
+				}
+				i = j
+				continue
+
+			case target.Changelog || target.History:
+				// These tables keep every change, which collapsing per key
+				// would lose; they are only written by their own changes
+				if err := applyRecord(ctx, tx, rec, target); err != nil {
+					return fmt.Errorf("failed to record %s on %s.%s: %w", rec.Operation, rec.Schema, rec.Table, err)
+				}
+
+			case rec.Operation == "SCHEMA":
+				if err := b.flushAll(ctx); err != nil {
+					return err
+				}
+				if err := applySchemaChange(ctx, tx, rec, target); err != nil {
+					return fmt.Errorf("failed to apply schema change for %s.%s: %w", rec.Schema, rec.Table, err)
+				}
+				if t, ok := b.tables[rec.Schema+"."+rec.Table]; ok {
+					t.types = nil
+				}
+
+			case len(target.PKColumns) == 0:
+				if err := b.flush(ctx, rec.Schema+"."+rec.Table); err != nil {
+					return err
+				}
+				if err := applyRecord(ctx, tx, rec, target); err != nil {
+					return fmt.Errorf("failed to apply %s on %s.%s: %w", rec.Operation, rec.Schema, rec.Table, err)
+				}
+
+			default:
+				if err := b.add(ctx, rec, target); err != nil {
+					return err
+				}
+			}
+			i++
+		}
+	}
+
+	if err := b.flushAll(ctx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit destination batch: %w", err)
+	}
+	return nil
+}
+
+// add folds a row change into the pending changes of its table
+func (b *bulkApplier) add(ctx context.Context, rec *CDCRecord, target *ApplyTarget) error {
+	tableKey := rec.Schema + "." + rec.Table
+	t, ok := b.tables[tableKey]
+	if !ok {
+		t = &bulkTable{target: target, changes: make(map[string]*bulkChange)}
+		b.tables[tableKey] = t
+		b.order = append(b.order, tableKey)
+	}
+
+	oldValues, err := recordText(rec, rec.OldValues, b.typeMap)
+	if err != nil {
+		return fmt.Errorf("%s on %s: %w", rec.Operation, tableKey, err)
+	}
+	newValues, err := recordText(rec, rec.NewValues, b.typeMap)
+	if err != nil {
+		return fmt.Errorf("%s on %s: %w", rec.Operation, tableKey, err)
+	}
+
+	switch rec.Operation {
+	case "INSERT":
+		key, err := rowKey(target.PKColumns, newValues)
+		if err != nil {
+			return fmt.Errorf("INSERT on %s: %w", tableKey, err)
+		}
+		t.set(key, &bulkChange{values: newValues})
+
+	case "UPDATE":
+		key, err := rowKey(target.PKColumns, newValues)
+		if err != nil {
+			return fmt.Errorf("UPDATE on %s: %w", tableKey, err)
+		}
+		// The old key is only sent when it changed (or with REPLICA IDENTITY
+		// FULL); a changed key removes the old row
+		if oldValues != nil {
+			oldKey, err := rowKey(target.PKColumns, oldValues)
+			if err != nil {
+				return fmt.Errorf("UPDATE on %s: %w", tableKey, err)
+			}
+			if oldKey != key {
+				t.set(oldKey, &bulkChange{deleted: true, values: oldValues})
+			}
+		}
+
+		change := &bulkChange{values: newValues, partial: len(rec.UnchangedToastColumns) > 0}
+		if prev, ok := t.changes[key]; ok && !prev.deleted && change.partial {
+			// Unchanged TOAST values come from the earlier change of the row
+			merged := make(map[string]*string, len(prev.values))
+			for col, val := range prev.values {
+				merged[col] = val
+			}
+			for col, val := range newValues {
+				merged[col] = val
+			}
+			change = &bulkChange{values: merged, partial: prev.partial}
+		}
+		t.set(key, change)
+
+	case "DELETE":
+		key, err := rowKey(target.PKColumns, oldValues)
+		if err != nil {
+			return fmt.Errorf("DELETE on %s: %w", tableKey, err)
+		}
+		t.set(key, &bulkChange{deleted: true, values: oldValues})
+
+	default:
+		return fmt.Errorf("unknown operation: %s", rec.Operation)
+	}
+
+	if b.maxRows > 0 && len(t.keys) >= b.maxRows {
+		return b.flush(ctx, tableKey)
+	}
+	return nil
+}
+
+// set records the latest change of a key
+func (t *bulkTable) set(key string, change *bulkChange) {
+	if _, ok := t.changes[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.changes[key] = change
+}
+
+// recordKey renders the key columns of a record's values as a map key
+func recordKey(rec *CDCRecord, pkColumns []string, values map[string]interface{}, typeMap *pgtype.Map) (string, error) {
+	keyValues := make(map[string]interface{}, len(pkColumns))
+	for _, col := range pkColumns {
+		if val, ok := values[col]; ok {
+			keyValues[col] = val
+		}
+	}
+	texts, err := recordText(rec, keyValues, typeMap)
+	if err != nil {
+		return "", err
+	}
+	return rowKey(pkColumns, texts)
+}
+
+// rowKey renders the key columns of a row in text form as a map key, so
+// that equal keys match whatever Go values they were decoded into
+func rowKey(pkColumns []string, values TextValues) (string, error) {
+	parts := make([]string, len(pkColumns))
+	for i, col := range pkColumns {
+		text, ok := values[col]
+		if !ok || text == nil {
+			return "", fmt.Errorf("key column %s missing from record", col)
+		}
+		parts[i] = *text
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+// flushAll applies the pending changes of every table
+func (b *bulkApplier) flushAll(ctx context.Context) error {
+	for _, tableKey := range b.order {
+		if err := b.flush(ctx, tableKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush applies the pending changes of one table: deletes first, then the
+// upserts grouped by the set of columns they carry
+func (b *bulkApplier) flush(ctx context.Context, tableKey string) error {
+	t, ok := b.tables[tableKey]
+	if !ok || len(t.keys) == 0 {
+		return nil
+	}
+	target := t.target
+
+	if t.types == nil {
+		types, err := b.columnTypes(ctx, target)
+		if err != nil {
+			return err
+		}
+		t.types = types
+	}
+
+	var deletes [][]interface{}
+	groups := make(map[string][]*bulkChange)
+	var groupOrder []string
+	for _, key := range t.keys {
+		change := t.changes[key]
+		if change.deleted {
+			row := make([]interface{}, len(target.PKColumns))
+			for i, col := range target.PKColumns {
+				row[i] = change.values[col]
+			}
+			deletes = append(deletes, row)
+			continue
+		}
+
+		cols := sortedColumns(change.values)
+		sig := strings.Join(cols, "\x00")
+		if change.partial {
+			sig = "partial\x00" + sig
+		}
+		if _, ok := groups[sig]; !ok {
+			groupOrder = append(groupOrder, sig)
+		}
+		groups[sig] = append(groups[sig], change)
+	}
+
+	if len(deletes) > 0 {
+		if err := b.applyDeletes(ctx, t, deletes); err != nil {
+			return fmt.Errorf("failed to apply deletes on %s.%s: %w", target.Schema, target.Table, err)
+		}
+	}
+
+	for _, sig := range groupOrder {
+		changes := groups[sig]
+		if err := b.applyUpserts(ctx, t, changes); err != nil {
+			return fmt.Errorf("failed to apply upserts on %s.%s: %w", target.Schema, target.Table, err)
+		}
+	}
+
+	t.changes = make(map[string]*bulkChange)
+	t.keys = t.keys[:0]
+	return nil
+}
+
+// columnTypes returns the type of each column of a destination table
+func (b *bulkApplier) columnTypes(ctx context.Context, target *ApplyTarget) (map[string]string, error) {
+	table := fmt.Sprintf("%s.%s", quoteIdentifier(target.Schema), quoteIdentifier(target.Table))
+	rows, err := b.tx.Query(ctx, `
+		SELECT attname, format_type(atttypid, atttypmod)
+		FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query column types of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, fmt.Errorf("failed to scan column type: %w", err)
+		}
+		types[name] = typ
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate column types: %w", err)
+	}
+	return types, nil
+}
+
+// stage creates a temporary staging table of text columns, dropped at
+// commit, and copies the rows into it. Staging text and casting it in the
+// statement, rather than copying typed values, works for every type the
+// destination can parse, including enums, domains and extension types. It
+// returns the staged rows cast to the destination column types, as a FROM
+// item aliased s.
+func (b *bulkApplier) stage(ctx context.Context, t *bulkTable, columns []string, rows [][]interface{}) (string, error) {
+	b.stageSeq++
+	stageName := fmt.Sprintf("bunny_stage_%d", b.stageSeq)
+
+	defs := make([]string, len(columns))
+	casts := make([]string, len(columns))
+	for i, col := range columns {
+		typ, ok := t.types[col]
+		if !ok {
+			return "", fmt.Errorf("column %s not found in %s.%s", col, t.target.Schema, t.target.Table)
+		}
+		quoted := quoteIdentifier(col)
+		defs[i] = quoted + " text"
+		casts[i] = fmt.Sprintf("%s::%s AS %s", quoted, typ, quoted)
+	}
+
+	query := fmt.Sprintf("CREATE TEMP TABLE %s (%s) ON COMMIT DROP", stageName, strings.Join(defs, ", "))
+	if _, err := b.tx.Exec(ctx, query); err != nil {
+		return "", fmt.Errorf("failed to create staging table: %w", err)
+	}
+
+	if _, err := b.tx.CopyFrom(ctx, pgx.Identifier{stageName}, columns, pgx.CopyFromRows(rows)); err != nil {
+		return "", fmt.Errorf("failed to copy into staging table: %w", err)
+	}
+	return fmt.Sprintf("(SELECT %s FROM %s) s", strings.Join(casts, ", "), stageName), nil
+}
+
+// applyDeletes removes, or soft deletes, the rows whose keys are given
+func (b *bulkApplier) applyDeletes(ctx context.Context, t *bulkTable, keys [][]interface{}) error {
+	target := t.target
+	source, err := b.stage(ctx, t, target.PKColumns, keys)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s.%s d USING %s WHERE %s",
+		quoteIdentifier(target.Schema), quoteIdentifier(target.Table), source, keyJoin(target.PKColumns))
+	if target.SoftDeleteColumn != "" {
+		query = fmt.Sprintf("UPDATE %s.%s d SET %s FROM %s WHERE %s",
+			quoteIdentifier(target.Schema), quoteIdentifier(target.Table),
+			strings.Join(target.mirrorSets(true), ", "), source, keyJoin(target.PKColumns))
+	}
+	_, err = b.tx.Exec(ctx, query)
+	return err
+}
+
+// applyUpserts writes rows that all carry the same columns. Complete rows are
+// inserted or updated; partial rows only update existing rows, like a single
+// UPDATE record would.
+func (b *bulkApplier) applyUpserts(ctx context.Context, t *bulkTable, changes []*bulkChange) error {
+	target := t.target
+	columns := sortedColumns(changes[0].values)
+	rows := make([][]interface{}, len(changes))
+	for i, change := range changes {
+		row := make([]interface{}, len(columns))
+		for j, col := range columns {
+			row[j] = change.values[col]
+		}
+		rows[i] = row
+	}
+
+	source, err := b.stage(ctx, t, columns, rows)
+	if err != nil {
+		return err
+	}
+
+	isKey := make(map[string]bool, len(target.PKColumns))
+	for _, col := range target.PKColumns {
+		isKey[col] = true
+	}
+	quoted := make([]string, len(columns))
+	staged := make([]string, len(columns))
+	var sets, excluded []string
+	for i, col := range columns {
+		quoted[i] = quoteIdentifier(col)
+		staged[i] = "s." + quoted[i]
+		if !isKey[col] {
+			sets = append(sets, fmt.Sprintf("%s = s.%s", quoted[i], quoted[i]))
+			excluded = append(excluded, fmt.Sprintf("%s = EXCLUDED.%s", quoted[i], quoted[i]))
+		}
+	}
+
+	// Written rows are live and stamped; a soft deleted row is revived
+	sets = append(sets, target.mirrorSets(false)...)
+	excluded = append(excluded, target.mirrorSets(false)...)
+	mirrorCols, mirrorExprs := target.mirrorInsert()
+	insertCols := append(append([]string{}, quoted...), mirrorCols...)
+	insertVals := append(append([]string{}, staged...), mirrorExprs...)
+	selectVals := append(append([]string{}, quoted...), mirrorExprs...)
+
+	table := fmt.Sprintf("%s.%s", quoteIdentifier(target.Schema), quoteIdentifier(target.Table))
+	var query string
+	switch {
+	case changes[0].partial:
+		if len(sets) == 0 {
+			return nil
+		}
+		query = fmt.Sprintf("UPDATE %s d SET %s FROM %s WHERE %s",
+			table, strings.Join(sets, ", "), source, keyJoin(target.PKColumns))
+
+	case b.useMerge:
+		query = fmt.Sprintf("MERGE INTO %s d USING %s ON %s", table, source, keyJoin(target.PKColumns))
+		if len(sets) > 0 {
+			query += fmt.Sprintf(" WHEN MATCHED THEN UPDATE SET %s", strings.Join(sets, ", "))
+		}
+		query += fmt.Sprintf(" WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)",
+			strings.Join(insertCols, ", "), strings.Join(insertVals, ", "))
+
+	default:
+		quotedKeys := make([]string, len(target.PKColumns))
+		for i, col := range target.PKColumns {
+			quotedKeys[i] = quoteIdentifier(col)
+		}
+		conflict := "DO NOTHING"
+		if len(excluded) > 0 {
+			conflict = "DO UPDATE SET " + strings.Join(excluded, ", ")
+		}
+		query = fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT (%s) %s",
+			table, strings.Join(insertCols, ", "), strings.Join(selectVals, ", "), source,
+			strings.Join(quotedKeys, ", "), conflict)
+	}
+
+	_, err = b.tx.Exec(ctx, query)
+	return err
+}
+
+// keyJoin matches destination rows (d) to staged rows (s) on the key columns
+func keyJoin(pkColumns []string) string {
+	conds := make([]string, len(pkColumns))
+	for i, col := range pkColumns {
+		conds[i] = fmt.Sprintf("d.%s = s.%s", quoteIdentifier(col), quoteIdentifier(col))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// sortedColumns returns the column names of a row in a stable order
+func sortedColumns[V any](values map[string]V) []string {
+	cols := make([]string, 0, len(values))
+	for col := range values {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	return cols
+}
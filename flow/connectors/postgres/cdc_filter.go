@@ -0,0 +1,202 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maxFilterParams keeps a row filter query under the protocol's limit of
+// 65535 bind parameters
+const maxFilterParams = 60000
+
+// RowFilter evaluates a table's row filter against decoded row changes. It is
+// used for sources older than PG15, whose publications cannot filter rows.
+// The predicate is evaluated by the source server so it behaves exactly as
+// it does in the snapshot query.
+type RowFilter struct {
+	conn      *PostgresConnector
+	predicate string
+	columns   []ColumnDefinition
+}
+
+// NewRowFilter creates a filter for a table from its full schema
+func (c *PostgresConnector) NewRowFilter(schema *TableSchema, predicate string) *RowFilter {
+	return &RowFilter{conn: c, predicate: predicate, columns: schema.Columns}
+}
+
+// Match reports which rows satisfy the predicate. A predicate that evaluates
+// to NULL does not match, as in a WHERE clause. Columns missing from a row
+// are evaluated as NULL.
+func (f *RowFilter) Match(ctx context.Context, rows []map[string]interface{}) ([]bool, error) {
+	matches := make([]bool, len(rows))
+	if len(rows) == 0 {
+		return matches, nil
+	}
+
+	colNames := []string{"bunny_ord"}
+	for _, col := range f.columns {
+		colNames = append(colNames, quoteIdentifier(col.Name))
+	}
+
+	perRow := len(f.columns) + 1
+	chunk := maxFilterParams / perRow
+	if chunk < 1 {
+		chunk = 1
+	}
+
+	for start := 0; start < len(rows); start += chunk {
+		end := start + chunk
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		tuples := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*perRow)
+		for i := start; i < end; i++ {
+			placeholders := make([]string, 0, perRow)
+			args = append(args, i)
+			placeholders = append(placeholders, fmt.Sprintf("$%d::int", len(args)))
+			for _, col := range f.columns {
+				args = append(args, rows[i][col.Name])
+				placeholders = append(placeholders, fmt.Sprintf("$%d::%s", len(args), col.Type))
+			}
+			tuples = append(tuples, "("+strings.Join(placeholders, ", ")+")")
+		}
+
+		query := fmt.Sprintf("SELECT bunny_ord, COALESCE(%s, false) FROM (VALUES %s) AS t(%s)",
+			WrapRowFilter(f.predicate), strings.Join(tuples, ", "), strings.Join(colNames, ", "))
+
+		dbRows, err := f.conn.conn.Query(ctx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate row filter: %w", err)
+		}
+		for dbRows.Next() {
+			var ord int
+			var match bool
+			if err := dbRows.Scan(&ord, &match); err != nil {
+				dbRows.Close()
+				return nil, fmt.Errorf("failed to evaluate row filter: %w", err)
+			}
+			matches[ord] = match
+		}
+		dbRows.Close()
+		if err := dbRows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to evaluate row filter: %w", err)
+		}
+	}
+
+	return matches, nil
+}
+
+// FilterRows applies row filters, keyed by source schema.table, to a batch
+// of transactions in place. A change is judged by whether the row matched
+// the filter before and after it:
+//
+//   - an INSERT is kept if the new row matches, a DELETE if the old row did
+//   - an UPDATE whose old and new rows both match is kept
+//   - an UPDATE that moves a row into the filter becomes an INSERT
+//   - an UPDATE that moves a row out of the filter becomes a DELETE
+//   - any other change of a filtered table is dropped
+//
+// Without REPLICA IDENTITY FULL an UPDATE carries no old row unless its key
+// changed; the new row's key columns then stand in for it, which is why row
+// filters may only use identity columns on such tables.
+func FilterRows(ctx context.Context, txns []*CDCTransaction, filters map[string]*RowFilter) error {
+	if len(filters) == 0 {
+		return nil
+	}
+
+	// Collect the rows of each filter, evaluated in one round trip per table
+	type check struct {
+		rec *CDCRecord
+		old int // Index of the old row, -1 if none
+		new int // Index of the new row, -1 if none
+	}
+	rows := make(map[*RowFilter][]map[string]interface{})
+	checks := make(map[*RowFilter][]check)
+	add := func(f *RowFilter, row map[string]interface{}) int {
+		if row == nil {
+			return -1
+		}
+		rows[f] = append(rows[f], row)
+		return len(rows[f]) - 1
+	}
+
+	for _, txn := range txns {
+		for _, rec := range txn.Records {
+			f, ok := filters[rec.Schema+"."+rec.Table]
+			if !ok {
+				continue
+			}
+			c := check{rec: rec, old: -1, new: -1}
+			switch rec.Operation {
+			case "INSERT":
+				c.new = add(f, rec.NewValues)
+			case "UPDATE":
+				c.new = add(f, rec.NewValues)
+				if rec.OldValues != nil {
+					c.old = add(f, rec.OldValues)
+				} else {
+					c.old = c.new
+				}
+			case "DELETE":
+				c.old = add(f, rec.OldValues)
+			default:
+				continue
+			}
+			checks[f] = append(checks[f], c)
+		}
+	}
+
+	drop := make(map[*CDCRecord]bool)
+	for f, fc := range checks {
+		matches, err := f.Match(ctx, rows[f])
+		if err != nil {
+			return err
+		}
+		matched := func(i int) bool { return i >= 0 && matches[i] }
+
+		for _, c := range fc {
+			rec := c.rec
+			switch rec.Operation {
+			case "INSERT":
+				drop[rec] = !matched(c.new)
+			case "DELETE":
+				drop[rec] = !matched(c.old)
+			case "UPDATE":
+				oldMatch, newMatch := matched(c.old), matched(c.new)
+				switch {
+				case oldMatch && newMatch:
+				case newMatch:
+					// Unchanged TOASTed values were not sent and are
+					// inserted as NULL unless the table has REPLICA
+					// IDENTITY FULL, whose old row supplies them
+					rec.Operation = "INSERT"
+					rec.OldValues = nil
+					rec.UnchangedToastColumns = nil
+				case oldMatch:
+					rec.Operation = "DELETE"
+					if rec.OldValues == nil {
+						rec.OldValues = rec.NewValues
+					}
+					rec.NewValues = nil
+					rec.UnchangedToastColumns = nil
+				default:
+					drop[rec] = true
+				}
+			}
+		}
+	}
+
+	for _, txn := range txns {
+		kept := txn.Records[:0]
+		for _, rec := range txn.Records {
+			if !drop[rec] {
+				kept = append(kept, rec)
+			}
+		}
+		txn.Records = kept
+	}
+	return nil
+}
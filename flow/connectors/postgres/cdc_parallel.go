@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"hash/fnv"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// PartitionBatch splits a batch of transactions into n parts that can be
+// applied concurrently. Row changes are assigned by a hash of their
+// destination table and key, so all changes to a row stay in one part and in
+// order; rows of tables without key columns are assigned by table. Each part
+// keeps the boundaries and positions of the transactions it has records of.
+//
+// ok is false when the batch cannot be split and must be applied as a whole:
+// it contains a TRUNCATE, a schema change, or an update of a row's key, all
+// of which depend on changes that would land in other parts, or a spooled
+// transaction, whose records are not at hand.
+func PartitionBatch(txns []*CDCTransaction, targets map[string]*ApplyTarget, n int) ([][]*CDCTransaction, bool) {
+	if HasSpooled(txns) {
+		return nil, false
+	}
+	parts := make([][]*CDCTransaction, n)
+	typeMap := pgtype.NewMap()
+
+	for _, txn := range txns {
+		subs := make([]*CDCTransaction, n)
+		for _, rec := range txn.Records {
+			shard, ok := recordShard(rec, ApplyTargetFor(targets, rec), n, typeMap)
+			if !ok {
+				return nil, false
+			}
+
+			if subs[shard] == nil {
+				sub := *txn
+				sub.Records = nil
+				subs[shard] = &sub
+				parts[shard] = append(parts[shard], &sub)
+			}
+			subs[shard].Records = append(subs[shard].Records, rec)
+		}
+	}
+
+	return parts, true
+}
+
+// recordShard picks the part a row change is applied by. Keys are compared
+// in text form; typeMap encodes those decoded from binary format.
+func recordShard(rec *CDCRecord, target *ApplyTarget, n int, typeMap *pgtype.Map) (int, bool) {
+	h := fnv.New32a()
+	h.Write([]byte(target.Schema + "." + target.Table))
+
+	if len(target.PKColumns) > 0 {
+		var key string
+		var err error
+		switch rec.Operation {
+		case "INSERT":
+			key, err = recordKey(rec, target.PKColumns, rec.NewValues, typeMap)
+		case "UPDATE":
+			key, err = recordKey(rec, target.PKColumns, rec.NewValues, typeMap)
+			if err == nil && rec.OldValues != nil {
+				oldKey, oldErr := recordKey(rec, target.PKColumns, rec.OldValues, typeMap)
+				if oldErr != nil || oldKey != key {
+					return 0, false
+				}
+			}
+		case "DELETE":
+			key, err = recordKey(rec, target.PKColumns, rec.OldValues, typeMap)
+		default:
+			return 0, false
+		}
+		if err != nil {
+			return 0, false
+		}
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+	} else if rec.Operation != "INSERT" && rec.Operation != "UPDATE" && rec.Operation != "DELETE" {
+		return 0, false
+	}
+
+	return int(h.Sum32() % uint32(n)), true
+}
@@ -0,0 +1,187 @@
+package postgres
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var parallelTargets = map[string]*ApplyTarget{
+	"public.users":  {Schema: "public", Table: "users", PKColumns: []string{"id"}},
+	"public.events": {Schema: "public", Table: "events"},
+}
+
+func testRecord(op, table string, seq int, oldValues, newValues map[string]interface{}) *CDCRecord {
+	return &CDCRecord{
+		Operation: op, Schema: "public", Table: table, Seq: seq,
+		OldValues: oldValues, NewValues: newValues,
+		relation: []ColumnInfo{{Name: "id", TypeOID: pgtype.Int8OID}},
+	}
+}
+
+func idValues(id interface{}) map[string]interface{} {
+	return map[string]interface{}{"id": id}
+}
+
+func TestPartitionBatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		txns   []*CDCTransaction
+		wantOK bool
+	}{
+		{
+			name: "row changes",
+			txns: []*CDCTransaction{
+				{XID: 1, CommitLSN: 100, EndLSN: 108, Records: []*CDCRecord{
+					testRecord("INSERT", "users", 0, nil, idValues("1")),
+					testRecord("INSERT", "users", 1, nil, idValues("2")),
+					testRecord("INSERT", "events", 2, nil, idValues("9")),
+					testRecord("UPDATE", "users", 3, idValues("1"), idValues("1")),
+				}},
+				{XID: 2, CommitLSN: 200, EndLSN: 208, Records: []*CDCRecord{
+					testRecord("UPDATE", "users", 0, nil, idValues(int64(2))),
+					testRecord("DELETE", "users", 1, idValues(int64(1)), nil),
+					testRecord("INSERT", "users", 2, nil, idValues("3")),
+					testRecord("DELETE", "events", 3, idValues("9"), nil),
+				}},
+			},
+			wantOK: true,
+		},
+		{
+			name: "truncate",
+			txns: []*CDCTransaction{{XID: 1, Records: []*CDCRecord{
+				testRecord("INSERT", "users", 0, nil, idValues("1")),
+				testRecord("TRUNCATE", "users", 1, nil, nil),
+			}}},
+		},
+		{
+			name: "schema change",
+			txns: []*CDCTransaction{{XID: 1, Records: []*CDCRecord{
+				{Operation: "SCHEMA", Schema: "public", Table: "users", SchemaDelta: &SchemaDelta{}},
+			}}},
+		},
+		{
+			name: "key change",
+			txns: []*CDCTransaction{{XID: 1, Records: []*CDCRecord{
+				testRecord("UPDATE", "users", 0, idValues("1"), idValues("2")),
+			}}},
+		},
+		{
+			name: "key missing",
+			txns: []*CDCTransaction{{XID: 1, Records: []*CDCRecord{
+				testRecord("INSERT", "users", 0, nil, map[string]interface{}{"name": "ada"}),
+			}}},
+		},
+		{
+			name: "spooled transaction",
+			txns: []*CDCTransaction{{XID: 1, spool: &txnSpool{}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, ok := PartitionBatch(tt.txns, parallelTargets, 4)
+			if ok != tt.wantOK {
+				t.Fatalf("PartitionBatch ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+
+			// Every record lands in exactly one part, in a copy of its
+			// transaction, after the records of its row that came before it
+			byXID := make(map[uint32]*CDCTransaction)
+			for _, txn := range tt.txns {
+				byXID[txn.XID] = txn
+			}
+			rowPart := make(map[string]int)
+			seen := make(map[*CDCRecord]bool)
+			for i, part := range parts {
+				lastXID := uint32(0)
+				for _, sub := range part {
+					txn := byXID[sub.XID]
+					if sub.CommitLSN != txn.CommitLSN || sub.EndLSN != txn.EndLSN {
+						t.Errorf("part %d has xid %d at %d-%d, want %d-%d", i, sub.XID, sub.CommitLSN, sub.EndLSN, txn.CommitLSN, txn.EndLSN)
+					}
+					if sub.XID <= lastXID {
+						t.Errorf("part %d has xid %d after xid %d", i, sub.XID, lastXID)
+					}
+					lastXID = sub.XID
+					lastSeq := -1
+					for _, rec := range sub.Records {
+						if seen[rec] {
+							t.Errorf("record %d of xid %d is in two parts", rec.Seq, sub.XID)
+						}
+						seen[rec] = true
+						if rec.Seq <= lastSeq {
+							t.Errorf("part %d has record %d of xid %d after record %d", i, rec.Seq, sub.XID, lastSeq)
+						}
+						lastSeq = rec.Seq
+
+						row := rec.Table
+						if rec.Table == "users" {
+							values := rec.NewValues
+							if values == nil {
+								values = rec.OldValues
+							}
+							row += fmt.Sprint(values["id"])
+						}
+						if p, ok := rowPart[row]; ok && p != i {
+							t.Errorf("changes of %s are in parts %d and %d", row, p, i)
+						}
+						rowPart[row] = i
+					}
+				}
+			}
+			for _, txn := range tt.txns {
+				for _, rec := range txn.Records {
+					if !seen[rec] {
+						t.Errorf("record %d of xid %d is in no part", rec.Seq, txn.XID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRecordShard(t *testing.T) {
+	users := parallelTargets["public.users"]
+	events := parallelTargets["public.events"]
+	typeMap := pgtype.NewMap()
+	shard := func(rec *CDCRecord, target *ApplyTarget) int {
+		t.Helper()
+		s, ok := recordShard(rec, target, 8, typeMap)
+		if !ok {
+			t.Fatalf("%s on %s cannot be assigned to a part", rec.Operation, rec.Table)
+		}
+		return s
+	}
+
+	// A key decoded from text or binary format is the same row
+	for id := 0; id < 20; id++ {
+		text := shard(testRecord("INSERT", "users", 0, nil, idValues(fmt.Sprint(id))), users)
+		binary := shard(testRecord("INSERT", "users", 0, nil, idValues(int64(id))), users)
+		updated := shard(testRecord("UPDATE", "users", 0, idValues(int64(id)), idValues(fmt.Sprint(id))), users)
+		deleted := shard(testRecord("DELETE", "users", 0, idValues(fmt.Sprint(id)), nil), users)
+		if binary != text || updated != text || deleted != text {
+			t.Errorf("changes of row %d are assigned to parts %d, %d, %d and %d", id, text, binary, updated, deleted)
+		}
+	}
+
+	// Rows of a table without key columns stay together
+	first := shard(testRecord("INSERT", "events", 0, nil, idValues("1")), events)
+	for id := 2; id < 20; id++ {
+		if s := shard(testRecord("DELETE", "events", 0, idValues(fmt.Sprint(id)), nil), events); s != first {
+			t.Errorf("row %d of a keyless table is assigned to part %d, want %d", id, s, first)
+		}
+	}
+
+	// Keys spread over the parts
+	used := make(map[int]bool)
+	for id := 0; id < 100; id++ {
+		used[shard(testRecord("INSERT", "users", 0, nil, idValues(fmt.Sprint(id))), users)] = true
+	}
+	if len(used) < 4 {
+		t.Errorf("100 keys are assigned to %d of 8 parts", len(used))
+	}
+}
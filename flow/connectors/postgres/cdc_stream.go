@@ -0,0 +1,329 @@
+package postgres
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/jackc/pglogrepl"
+)
+
+// txnSpool holds the changes of a streamed transaction that has not committed
+// yet. Changes are appended to a temporary file so that large transactions
+// (and several of them interleaved) are not kept in memory while the source
+// keeps streaming. Their tuples are spooled as sent and decoded when read
+// back, so columns of any type can be spooled.
+type txnSpool struct {
+	xid     uint32
+	file    *os.File
+	enc     *gob.Encoder
+	count   int
+	counts  map[uint32]int  // Changes per (sub)transaction
+	aborted map[uint32]bool // Subtransactions rolled back while streaming
+
+	// Set when the transaction commits
+	decode    func(*rowChange) (*CDCRecord, error)
+	chunkSize int
+}
+
+// newTxnSpool creates the spool file for a streamed transaction
+func newTxnSpool(xid uint32) (*txnSpool, error) {
+	f, err := os.CreateTemp("", fmt.Sprintf("bunny-stream-%d-*", xid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	return &txnSpool{
+		xid:     xid,
+		file:    f,
+		enc:     gob.NewEncoder(f),
+		counts:  make(map[uint32]int),
+		aborted: make(map[uint32]bool),
+	}, nil
+}
+
+// append writes a change to the spool
+func (s *txnSpool) append(ch *rowChange) error {
+	if err := s.enc.Encode(ch); err != nil {
+		return fmt.Errorf("failed to spool change for xid %d: %w", s.xid, err)
+	}
+	s.count++
+	s.counts[ch.Record.XID]++
+	return nil
+}
+
+// numRecords returns the number of spooled changes that were not rolled back
+func (s *txnSpool) numRecords() int {
+	n := s.count
+	for xid := range s.aborted {
+		n -= s.counts[xid]
+	}
+	return n
+}
+
+// read decodes the spooled changes, skipping those of aborted
+// subtransactions, and passes them to fn chunkSize at a time. Tables truncated
+// by one statement are kept in the same chunk.
+func (s *txnSpool) read(fn func([]*CDCRecord) error) error {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind spool file: %w", err)
+	}
+
+	dec := gob.NewDecoder(bufio.NewReader(s.file))
+	chunk := make([]*CDCRecord, 0, s.chunkSize)
+	seq := 0
+	for i := 0; i < s.count; i++ {
+		ch := &rowChange{}
+		if err := dec.Decode(ch); err != nil {
+			return fmt.Errorf("failed to read spooled change %d for xid %d: %w", i, s.xid, err)
+		}
+		if s.aborted[ch.Record.XID] {
+			continue
+		}
+		rec, err := s.decode(ch)
+		if err != nil {
+			return fmt.Errorf("failed to decode spooled change %d for xid %d: %w", i, s.xid, err)
+		}
+		// Numbered as if the transaction had not been streamed
+		if rec.Operation != "SCHEMA" {
+			rec.Seq = seq
+			seq++
+		}
+
+		if n := len(chunk); n > 0 && n >= s.chunkSize && !sameTruncate(chunk[n-1], rec) {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			chunk = make([]*CDCRecord, 0, s.chunkSize)
+		}
+		chunk = append(chunk, rec)
+	}
+
+	if len(chunk) > 0 {
+		return fn(chunk)
+	}
+	return nil
+}
+
+// sameTruncate reports whether two records truncate tables in one statement
+func sameTruncate(a, b *CDCRecord) bool {
+	return a.Operation == "TRUNCATE" && b.Operation == "TRUNCATE" && a.LSN == b.LSN
+}
+
+// remove closes and deletes the spool file
+func (s *txnSpool) remove() {
+	name := s.file.Name()
+	s.file.Close()
+	os.Remove(name)
+}
+
+// Spooled reports whether the transaction was streamed before it committed
+// and its records wait on disk, to be read by Chunks
+func (t *CDCTransaction) Spooled() bool {
+	return t.spool != nil
+}
+
+// NumRecords returns the number of records of the transaction, including
+// spooled ones
+func (t *CDCTransaction) NumRecords() int {
+	if t.spool != nil {
+		return t.spool.numRecords()
+	}
+	return len(t.Records)
+}
+
+// Chunks calls fn with the records of the transaction in Records. A spooled
+// transaction is read back a chunk at a time, so it is never held in memory
+// whole: each chunk is passed through PrepareChunk, handed to fn and then to
+// ChunkApplied, and Records is empty again once all chunks are done. A
+// transaction held in memory is a single chunk, on which the hooks are not
+// run.
+func (t *CDCTransaction) Chunks(fn func() error) error {
+	if t.spool == nil {
+		return fn()
+	}
+	defer func() { t.Records = nil }()
+
+	return t.spool.read(func(records []*CDCRecord) error {
+		for _, rec := range records {
+			rec.XID = t.XID
+			rec.CommitLSN = t.CommitLSN
+			rec.CommitTime = t.CommitTime
+		}
+		t.Records = records
+
+		if t.PrepareChunk != nil {
+			if err := t.PrepareChunk(t); err != nil {
+				return err
+			}
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		if t.ChunkApplied != nil {
+			t.ChunkApplied(t)
+		}
+		return nil
+	})
+}
+
+// HasSpooled reports whether any of the transactions is spooled
+func HasSpooled(txns []*CDCTransaction) bool {
+	for _, txn := range txns {
+		if txn.spool != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseDelivered deletes the spools of the transactions returned by the
+// last pull, which the caller is done with once it pulls again
+func (r *CDCReader) releaseDelivered() {
+	for _, spool := range r.delivered {
+		spool.remove()
+	}
+	r.delivered = nil
+}
+
+// Close discards the spools of streamed transactions, including those that
+// never committed. The server streams them again from the start after a
+// restart.
+func (r *CDCReader) Close() {
+	r.releaseDelivered()
+	for xid, spool := range r.streams {
+		spool.remove()
+		delete(r.streams, xid)
+	}
+	r.streamXID = 0
+	r.inStream = false
+}
+
+// isStreamedMessage reports whether a message type carries a transaction id
+// when sent inside a stream block
+func isStreamedMessage(msgType byte) bool {
+	switch msgType {
+	case 'R', 'Y', 'I', 'U', 'D', 'T', 'O', 'M':
+		return true
+	}
+	return false
+}
+
+// unwrapStreamedMessage strips the transaction id from a message sent inside
+// a stream block, so it can be parsed like its non-streamed counterpart
+func unwrapStreamedMessage(xld pglogrepl.XLogData) (pglogrepl.XLogData, uint32, error) {
+	if len(xld.WALData) < 5 {
+		return xld, 0, fmt.Errorf("streamed message too short")
+	}
+	xid := binary.BigEndian.Uint32(xld.WALData[1:5])
+	data := make([]byte, 0, len(xld.WALData)-4)
+	data = append(data, xld.WALData[0])
+	data = append(data, xld.WALData[5:]...)
+	xld.WALData = data
+	return xld, xid, nil
+}
+
+// spoolChange appends a change received inside a stream block to the spool
+// of the transaction being streamed. xid is the (sub)transaction that made
+// the change, kept so that a subtransaction abort can discard it.
+func (r *CDCReader) spoolChange(ch *rowChange, xid uint32) error {
+	spool, ok := r.streams[r.streamXID]
+	if !ok {
+		return fmt.Errorf("%s on %s.%s received for unknown streamed transaction %d",
+			ch.Record.Operation, ch.Record.Schema, ch.Record.Table, r.streamXID)
+	}
+	ch.Record.XID = xid
+	return spool.append(ch)
+}
+
+// parseStreamStartMessage opens a stream block for a transaction, creating
+// its spool on the first segment
+func (r *CDCReader) parseStreamStartMessage(xld pglogrepl.XLogData) error {
+	// Format: XID (4) | FirstSegment (1)
+	data := xld.WALData[1:]
+	if len(data) < 5 {
+		return fmt.Errorf("stream start message too short")
+	}
+
+	xid := binary.BigEndian.Uint32(data[0:4])
+	if _, ok := r.streams[xid]; !ok {
+		spool, err := newTxnSpool(xid)
+		if err != nil {
+			return err
+		}
+		r.streams[xid] = spool
+		r.logger.Info("streaming in-progress transaction", slog.Uint64("xid", uint64(xid)))
+	}
+
+	r.streamXID = xid
+	r.inStream = true
+	return nil
+}
+
+// parseStreamCommitMessage closes a streamed transaction and returns it,
+// stamped with the commit position and time. Its records stay in the spool
+// until the caller reads them with Chunks.
+func (r *CDCReader) parseStreamCommitMessage(xld pglogrepl.XLogData) (*CDCTransaction, error) {
+	// Format: XID (4) | Flags (1) | CommitLSN (8) | EndLSN (8) | CommitTimestamp (8)
+	data := xld.WALData[1:]
+	if len(data) < 29 {
+		return nil, fmt.Errorf("stream commit message too short")
+	}
+
+	xid := binary.BigEndian.Uint32(data[0:4])
+	spool, ok := r.streams[xid]
+	if !ok {
+		return nil, fmt.Errorf("stream commit received for unknown transaction %d", xid)
+	}
+	delete(r.streams, xid)
+	r.delivered = append(r.delivered, spool)
+
+	spool.decode = r.decodeChange
+	spool.chunkSize = r.chunkSize
+
+	txn := &CDCTransaction{
+		XID:        xid,
+		BeginLSN:   int64(xld.WALStart),
+		CommitLSN:  int64(binary.BigEndian.Uint64(data[5:13])),
+		EndLSN:     int64(binary.BigEndian.Uint64(data[13:21])),
+		CommitTime: pgTimestampToTime(int64(binary.BigEndian.Uint64(data[21:29]))),
+		spool:      spool,
+	}
+
+	r.logger.Info("streamed transaction committed",
+		slog.Uint64("xid", uint64(xid)),
+		slog.Int("records", spool.numRecords()))
+
+	return txn, nil
+}
+
+// parseStreamAbortMessage discards a streamed transaction, or only the
+// changes of one of its subtransactions
+func (r *CDCReader) parseStreamAbortMessage(xld pglogrepl.XLogData) error {
+	// Format: XID (4) | SubXID (4)
+	data := xld.WALData[1:]
+	if len(data) < 8 {
+		return fmt.Errorf("stream abort message too short")
+	}
+
+	xid := binary.BigEndian.Uint32(data[0:4])
+	subXID := binary.BigEndian.Uint32(data[4:8])
+
+	spool, ok := r.streams[xid]
+	if !ok {
+		return nil
+	}
+
+	if xid == subXID {
+		delete(r.streams, xid)
+		spool.remove()
+		r.logger.Info("streamed transaction aborted", slog.Uint64("xid", uint64(xid)))
+		return nil
+	}
+
+	spool.aborted[subXID] = true
+	return nil
+}
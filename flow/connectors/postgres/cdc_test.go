@@ -0,0 +1,338 @@
+package postgres
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// newTestReader returns a reader that parses pgoutput messages without a
+// server, for text format values
+func newTestReader() *CDCReader {
+	return &CDCReader{
+		conn:      &PostgresConnector{},
+		relations: make(map[uint32]*RelationInfo),
+		streams:   make(map[uint32]*txnSpool),
+		logger:    slog.New(slog.DiscardHandler),
+	}
+}
+
+// feed parses messages as if received at consecutive WAL positions, and
+// returns the transactions they completed
+func feed(r *CDCReader, msgs ...[]byte) ([]*CDCTransaction, error) {
+	var txns []*CDCTransaction
+	for i, msg := range msgs {
+		xld := pglogrepl.XLogData{WALStart: pglogrepl.LSN(0x100 + 0x10*i), WALData: msg}
+		txn, err := r.parseXLogData(context.Background(), xld)
+		if err != nil {
+			return txns, err
+		}
+		if txn != nil {
+			txns = append(txns, txn)
+		}
+	}
+	return txns, nil
+}
+
+func relationMsg(relID uint32, table string, columns ...string) []byte {
+	msg := binary.BigEndian.AppendUint32([]byte{'R'}, relID)
+	msg = append(msg, "public\x00"+table+"\x00"...)
+	msg = append(msg, 'd')
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(columns)))
+	for _, col := range columns {
+		msg = append(msg, 0)
+		msg = append(msg, col+"\x00"...)
+		msg = binary.BigEndian.AppendUint32(msg, pgtype.TextOID)
+		msg = binary.BigEndian.AppendUint32(msg, 0xffffffff)
+	}
+	return msg
+}
+
+func beginMsg(xid uint32) []byte {
+	msg := binary.BigEndian.AppendUint64([]byte{'B'}, 0)
+	msg = binary.BigEndian.AppendUint64(msg, 0)
+	return binary.BigEndian.AppendUint32(msg, xid)
+}
+
+func commitMsg(commitLSN, endLSN uint64) []byte {
+	msg := binary.BigEndian.AppendUint64([]byte{'C', 0}, commitLSN)
+	msg = binary.BigEndian.AppendUint64(msg, endLSN)
+	return binary.BigEndian.AppendUint64(msg, 86400*1000000)
+}
+
+func insertMsg(relID uint32, values ...string) []byte {
+	msg := binary.BigEndian.AppendUint32([]byte{'I'}, relID)
+	msg = append(msg, 'N')
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(values)))
+	for _, v := range values {
+		msg = append(msg, 't')
+		msg = binary.BigEndian.AppendUint32(msg, uint32(len(v)))
+		msg = append(msg, v...)
+	}
+	return msg
+}
+
+func truncateMsg(relIDs ...uint32) []byte {
+	msg := binary.BigEndian.AppendUint32([]byte{'T'}, uint32(len(relIDs)))
+	msg = append(msg, 0)
+	for _, id := range relIDs {
+		msg = binary.BigEndian.AppendUint32(msg, id)
+	}
+	return msg
+}
+
+// streamed prefixes a message with the (sub)transaction that sent it, as
+// inside a stream block
+func streamed(xid uint32, msg []byte) []byte {
+	out := binary.BigEndian.AppendUint32([]byte{msg[0]}, xid)
+	return append(out, msg[1:]...)
+}
+
+func streamStartMsg(xid uint32, first bool) []byte {
+	msg := binary.BigEndian.AppendUint32([]byte{'S'}, xid)
+	if first {
+		return append(msg, 1)
+	}
+	return append(msg, 0)
+}
+
+func streamStopMsg() []byte {
+	return []byte{'E'}
+}
+
+func streamAbortMsg(xid, subXID uint32) []byte {
+	msg := binary.BigEndian.AppendUint32([]byte{'A'}, xid)
+	return binary.BigEndian.AppendUint32(msg, subXID)
+}
+
+func streamCommitMsg(xid uint32, commitLSN, endLSN uint64) []byte {
+	msg := binary.BigEndian.AppendUint32([]byte{'c'}, xid)
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint64(msg, commitLSN)
+	msg = binary.BigEndian.AppendUint64(msg, endLSN)
+	return binary.BigEndian.AppendUint64(msg, 86400*1000000)
+}
+
+// describe renders a record as its operation, table, key and Seq
+func describe(rec *CDCRecord) string {
+	switch rec.Operation {
+	case "INSERT":
+		return fmt.Sprintf("INSERT %s %v #%d", rec.Table, rec.NewValues["id"], rec.Seq)
+	case "SCHEMA":
+		return "SCHEMA " + rec.Table
+	default:
+		return fmt.Sprintf("%s %s #%d", rec.Operation, rec.Table, rec.Seq)
+	}
+}
+
+func TestTransactionGrouping(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgs    [][]byte
+		want    [][]string // Records of each completed transaction
+		wantErr bool
+	}{
+		{
+			name: "one transaction",
+			msgs: [][]byte{
+				relationMsg(1, "users", "id"),
+				beginMsg(7), insertMsg(1, "1"), insertMsg(1, "2"), commitMsg(0x500, 0x508),
+			},
+			want: [][]string{{"INSERT users 1 #0", "INSERT users 2 #1"}},
+		},
+		{
+			name: "seq restarts in each transaction",
+			msgs: [][]byte{
+				relationMsg(1, "users", "id"),
+				beginMsg(7), insertMsg(1, "1"), commitMsg(0x500, 0x508),
+				beginMsg(8), insertMsg(1, "2"), insertMsg(1, "3"), commitMsg(0x600, 0x608),
+			},
+			want: [][]string{
+				{"INSERT users 1 #0"},
+				{"INSERT users 2 #0", "INSERT users 3 #1"},
+			},
+		},
+		{
+			name: "truncate of two tables",
+			msgs: [][]byte{
+				relationMsg(1, "users", "id"), relationMsg(2, "orders", "id"),
+				beginMsg(7), insertMsg(1, "1"), truncateMsg(1, 2), insertMsg(2, "5"), commitMsg(0x500, 0x508),
+			},
+			want: [][]string{{"INSERT users 1 #0", "TRUNCATE users #1", "TRUNCATE orders #2", "INSERT orders 5 #3"}},
+		},
+		{
+			name: "schema changes are not numbered",
+			msgs: [][]byte{
+				relationMsg(1, "users", "id", "name"),
+				beginMsg(7), insertMsg(1, "1", "ada"), relationMsg(1, "users", "id"), insertMsg(1, "2"), commitMsg(0x500, 0x508),
+			},
+			want: [][]string{{"INSERT users 1 #0", "SCHEMA users", "INSERT users 2 #1"}},
+		},
+		{
+			name: "unfinished transaction stays buffered",
+			msgs: [][]byte{
+				relationMsg(1, "users", "id"),
+				beginMsg(7), insertMsg(1, "1"), commitMsg(0x500, 0x508),
+				beginMsg(8), insertMsg(1, "2"),
+			},
+			want: [][]string{{"INSERT users 1 #0"}},
+		},
+		{
+			name:    "change outside a transaction",
+			msgs:    [][]byte{relationMsg(1, "users", "id"), insertMsg(1, "1")},
+			wantErr: true,
+		},
+		{
+			name:    "commit without begin",
+			msgs:    [][]byte{commitMsg(0x500, 0x508)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txns, err := feed(newTestReader(), tt.msgs...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("messages were read without error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to read messages: %v", err)
+			}
+
+			var got [][]string
+			for _, txn := range txns {
+				var recs []string
+				for _, rec := range txn.Records {
+					recs = append(recs, describe(rec))
+					if rec.XID != txn.XID || rec.CommitLSN != txn.CommitLSN || !rec.CommitTime.Equal(txn.CommitTime) {
+						t.Errorf("%s is stamped xid %d commit %d at %v, want xid %d commit %d at %v",
+							describe(rec), rec.XID, rec.CommitLSN, rec.CommitTime, txn.XID, txn.CommitLSN, txn.CommitTime)
+					}
+				}
+				got = append(got, recs)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("transactions = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// streamedTxnMsgs streams transaction 100 in three blocks, with changes of
+// subtransactions 101 and 102, rolls back 101 and commits
+func streamedTxnMsgs() [][]byte {
+	return [][]byte{
+		relationMsg(1, "users", "id"), relationMsg(2, "orders", "id"),
+		streamStartMsg(100, true),
+		streamed(100, insertMsg(1, "1")),
+		streamed(101, insertMsg(1, "2")),
+		streamed(100, insertMsg(1, "3")),
+		streamStopMsg(),
+		streamStartMsg(100, false),
+		streamed(102, insertMsg(1, "4")),
+		streamStopMsg(),
+		streamAbortMsg(100, 101),
+		streamStartMsg(100, false),
+		streamed(100, truncateMsg(1, 2)),
+		streamed(100, insertMsg(2, "5")),
+		streamStopMsg(),
+		streamCommitMsg(100, 0x900, 0x908),
+	}
+}
+
+func TestStreamedTransactionSpool(t *testing.T) {
+	tests := []struct {
+		chunkSize int
+		want      [][]string // Records of each chunk
+	}{
+		{1, [][]string{
+			{"INSERT users 1 #0"}, {"INSERT users 3 #1"}, {"INSERT users 4 #2"},
+			{"TRUNCATE users #3", "TRUNCATE orders #4"}, {"INSERT orders 5 #5"},
+		}},
+		{2, [][]string{
+			{"INSERT users 1 #0", "INSERT users 3 #1"},
+			{"INSERT users 4 #2", "TRUNCATE users #3", "TRUNCATE orders #4"},
+			{"INSERT orders 5 #5"},
+		}},
+		{4, [][]string{
+			{"INSERT users 1 #0", "INSERT users 3 #1", "INSERT users 4 #2", "TRUNCATE users #3", "TRUNCATE orders #4"},
+			{"INSERT orders 5 #5"},
+		}},
+		{100, [][]string{
+			{"INSERT users 1 #0", "INSERT users 3 #1", "INSERT users 4 #2", "TRUNCATE users #3", "TRUNCATE orders #4", "INSERT orders 5 #5"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("chunks of %d", tt.chunkSize), func(t *testing.T) {
+			r := newTestReader()
+			defer r.Close()
+			r.chunkSize = tt.chunkSize
+
+			txns, err := feed(r, streamedTxnMsgs()...)
+			if err != nil {
+				t.Fatalf("failed to read messages: %v", err)
+			}
+			if len(txns) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(txns))
+			}
+			txn := txns[0]
+			if !txn.Spooled() || txn.NumRecords() != 6 {
+				t.Fatalf("transaction spooled %v with %d records, want spooled with 6", txn.Spooled(), txn.NumRecords())
+			}
+
+			var got [][]string
+			err = txn.Chunks(func() error {
+				var recs []string
+				for _, rec := range txn.Records {
+					recs = append(recs, describe(rec))
+					if rec.XID != 100 || rec.CommitLSN != 0x900 {
+						t.Errorf("%s is stamped xid %d commit %d, want xid 100 commit %d", describe(rec), rec.XID, rec.CommitLSN, 0x900)
+					}
+				}
+				got = append(got, recs)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("failed to read chunks: %v", err)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("chunks = %q, want %q", got, tt.want)
+			}
+			if txn.Records != nil {
+				t.Errorf("%d records left in the transaction after its chunks", len(txn.Records))
+			}
+		})
+	}
+}
+
+func TestStreamedTransactionAbort(t *testing.T) {
+	r := newTestReader()
+	defer r.Close()
+
+	txns, err := feed(r,
+		relationMsg(1, "users", "id"),
+		streamStartMsg(100, true),
+		streamed(100, insertMsg(1, "1")),
+		streamStopMsg(),
+		streamAbortMsg(100, 100),
+	)
+	if err != nil {
+		t.Fatalf("failed to read messages: %v", err)
+	}
+	if len(txns) != 0 || len(r.streams) != 0 {
+		t.Fatalf("aborted transaction left %d transactions and %d spools", len(txns), len(r.streams))
+	}
+
+	// Its commit can no longer arrive
+	if _, err := feed(r, streamCommitMsg(100, 0x900, 0x908)); err == nil {
+		t.Error("commit of an aborted streamed transaction was accepted")
+	}
+}
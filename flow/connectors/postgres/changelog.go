@@ -0,0 +1,194 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// changelogColumns are the columns of a changelog table. Rows are keyed by
+// the commit of their source transaction and the change's place in it, as
+// changes of one transaction can share an LSN.
+var changelogColumns = []string{
+	"lsn bigint NOT NULL",
+	"commit_lsn bigint NOT NULL",
+	"seq integer NOT NULL",
+	"commit_time timestamp with time zone NOT NULL",
+	"xid bigint NOT NULL",
+	"operation text NOT NULL",
+	"old_values jsonb",
+	"new_values jsonb",
+	"PRIMARY KEY (commit_time, commit_lsn, seq)",
+}
+
+// CreateChangelogTable creates a changelog table if it does not exist. It is
+// partitioned by range of commit_time, one partition per UTC day, created by
+// ChangelogPartitions as changes arrive.
+func (c *PostgresConnector) CreateChangelogTable(ctx context.Context, destSchema, destTable string) error {
+	query := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s.%s (\n  %s\n) PARTITION BY RANGE (commit_time)",
+		quoteIdentifier(destSchema),
+		quoteIdentifier(destTable),
+		strings.Join(changelogColumns, ",\n  "),
+	)
+
+	c.logger.Info("creating changelog table", "schema", destSchema, "table", destTable)
+
+	if _, err := c.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to create changelog table %s.%s: %w", destSchema, destTable, err)
+	}
+	return nil
+}
+
+// ChangelogPartitions creates the daily partitions of changelog tables before
+// changes are written to them. Partitions are created outside the apply
+// transaction, so they stay when a batch is rolled back, and are remembered
+// once created. It is safe for concurrent use by apply workers.
+type ChangelogPartitions struct {
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+// NewChangelogPartitions creates an empty partition cache
+func NewChangelogPartitions() *ChangelogPartitions {
+	return &ChangelogPartitions{created: make(map[string]bool)}
+}
+
+// Ensure creates the partitions the changelog records of a batch go to
+func (p *ChangelogPartitions) Ensure(
+	ctx context.Context,
+	conn *PostgresConnector,
+	txns []*CDCTransaction,
+	targets map[string]*ApplyTarget,
+) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, txn := range txns {
+		// The tables a spooled transaction changes are not known before it
+		// is read, so every changelog table gets the partition of its commit
+		if txn.Spooled() {
+			for _, target := range targets {
+				if target.Changelog {
+					if err := p.ensure(ctx, conn, target, txn.CommitTime); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+
+		for _, rec := range txn.Records {
+			target := ApplyTargetFor(targets, rec)
+			if !target.Changelog || rec.Operation == "SCHEMA" {
+				continue
+			}
+			if err := p.ensure(ctx, conn, target, rec.CommitTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensure creates the partition of a changelog table for the day of a commit
+func (p *ChangelogPartitions) ensure(ctx context.Context, conn *PostgresConnector, target *ApplyTarget, commitTime time.Time) error {
+	t := commitTime.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	partition := target.Table + "_p" + day.Format("20060102")
+	key := target.Schema + "." + partition
+	if p.created[key] {
+		return nil
+	}
+
+	query := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s.%s PARTITION OF %s.%s FOR VALUES FROM ('%s') TO ('%s')",
+		quoteIdentifier(target.Schema),
+		quoteIdentifier(partition),
+		quoteIdentifier(target.Schema),
+		quoteIdentifier(target.Table),
+		day.Format(time.RFC3339),
+		day.AddDate(0, 0, 1).Format(time.RFC3339),
+	)
+	if _, err := conn.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to create changelog partition %s: %w", key, err)
+	}
+	p.created[key] = true
+	return nil
+}
+
+// applyChangelog appends a change to a changelog table. A change replayed
+// after a crash overwrites the row it recorded before instead of adding
+// another. Schema changes need no DDL, as row values are kept as JSON;
+// unchanged TOAST columns of an UPDATE are missing from its new values.
+func applyChangelog(ctx context.Context, db execer, rec *CDCRecord, target *ApplyTarget) error {
+	if rec.Operation == "SCHEMA" {
+		return nil
+	}
+
+	oldValues, err := changelogValues(rec, rec.OldValues)
+	if err != nil {
+		return err
+	}
+	newValues, err := changelogValues(rec, rec.NewValues)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(
+		"INSERT INTO %s.%s (lsn, commit_lsn, seq, commit_time, xid, operation, old_values, new_values) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) "+
+			"ON CONFLICT (commit_time, commit_lsn, seq) DO UPDATE SET "+
+			"lsn = EXCLUDED.lsn, xid = EXCLUDED.xid, operation = EXCLUDED.operation, "+
+			"old_values = EXCLUDED.old_values, new_values = EXCLUDED.new_values",
+		quoteIdentifier(target.Schema),
+		quoteIdentifier(target.Table),
+	)
+
+	_, err = db.Exec(ctx, query, rec.LSN, rec.CommitLSN, rec.Seq, rec.CommitTime, int64(rec.XID), rec.Operation, oldValues, newValues)
+	return err
+}
+
+// changelogValues encodes the values of a row as a JSON object, or returns
+// nil for a row the change does not carry. Values are kept in their
+// PostgreSQL text form, so a row reads the same whether it was decoded from
+// text or binary format; json and jsonb values are embedded as JSON.
+func changelogValues(rec *CDCRecord, values map[string]interface{}) ([]byte, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	row := make(map[string]interface{}, len(values))
+	for col, val := range values {
+		oid := rec.typeOID(col)
+		text, ok := val.(string)
+		switch {
+		case val == nil:
+			row[col] = nil
+			continue
+		case !ok:
+			buf, err := EncodeText(oid, val)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode value of column %s as text: %w", col, err)
+			}
+			text = string(buf)
+		}
+
+		if oid == pgtype.JSONOID || oid == pgtype.JSONBOID {
+			row[col] = json.RawMessage(text)
+		} else {
+			row[col] = text
+		}
+	}
+
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode row values: %w", err)
+	}
+	return data, nil
+}
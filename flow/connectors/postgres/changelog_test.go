@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestChangelogValues(t *testing.T) {
+	rec := &CDCRecord{relation: []ColumnInfo{
+		{Name: "id", TypeOID: pgtype.UUIDOID},
+		{Name: "price", TypeOID: pgtype.NumericOID},
+		{Name: "active", TypeOID: pgtype.BoolOID},
+		{Name: "doc", TypeOID: pgtype.JSONBOID},
+		{Name: "note", TypeOID: pgtype.TextOID},
+	}}
+	id := [16]byte{0x12, 0x34}
+
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		want   string
+	}{
+		{"no row", nil, ""},
+		{
+			"text format",
+			map[string]interface{}{
+				"id": "12340000-0000-0000-0000-000000000000", "price": "12.50", "active": "t",
+				"doc": `{"a": [1, "x"]}`, "note": nil,
+			},
+			`{"active":"t","doc":{"a":[1,"x"]},"id":"12340000-0000-0000-0000-000000000000","note":null,"price":"12.50"}`,
+		},
+		{
+			// Decoded from binary format, the row reads the same
+			"binary format",
+			map[string]interface{}{
+				"id": id, "price": pgtype.Numeric{Int: big.NewInt(1250), Exp: -2, Valid: true}, "active": true,
+				"doc": map[string]interface{}{"a": []interface{}{1, "x"}}, "note": nil,
+			},
+			`{"active":"t","doc":{"a":[1,"x"]},"id":"12340000-0000-0000-0000-000000000000","note":null,"price":"12.50"}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := changelogValues(rec, tt.values)
+		if err != nil {
+			t.Fatalf("%s: failed to encode values: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: changelogValues = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
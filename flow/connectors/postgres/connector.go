@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -47,10 +48,35 @@ type PostgresConfig struct {
 
 // ReplState holds the replication state
 type ReplState struct {
-	Slot        string
-	Publication string
-	Offset      int64
-	LastOffset  atomic.Int64
+	Slot         string
+	Publication  string
+	Offset       int64
+	LastOffset   atomic.Int64
+	ProtoVersion int
+	Streaming    bool
+	Binary       bool
+}
+
+// ReplicationOptions controls the pgoutput features requested when starting
+// replication
+type ReplicationOptions struct {
+	// StreamInProgress asks the server (PG14+) to stream large transactions
+	// before they commit instead of spilling them to disk on the source.
+	// Streamed changes are spooled by the reader until the commit or abort.
+	StreamInProgress bool
+
+	// Binary asks the server (PG14+) to send column values in binary format.
+	// Values are decoded with the connector's type map into typed Go values;
+	// call LoadCustomTypes first so user-defined types can be decoded.
+	// Replication does not start if a published column has a type the type
+	// map cannot decode, such as an extension type.
+	Binary bool
+
+	// Relations are the column layouts, keyed by schema.table, that the
+	// destination tables match. The first Relation message of each of these
+	// tables is diffed against its layout, so a column change made before
+	// the reader started still reaches the destination.
+	Relations map[string][]ColumnInfo
 }
 
 // NewPostgresConnector creates a new PostgreSQL connector
@@ -113,7 +139,7 @@ func NewPostgresConnector(ctx context.Context, config *PostgresConfig) (*Postgre
 		conn:              conn,
 		config:            config,
 		metadataSchema:    metadataSchema,
-		typeMap:           pgtype.NewMap(),
+		typeMap:           newDecodeTypeMap(),
 		customTypeMapping: make(map[uint32]shared.CustomDataType),
 	}, nil
 }
@@ -204,13 +230,33 @@ func (c *PostgresConnector) StartReplication(
 	slotName string,
 	publicationName string,
 	lastOffset int64,
+	replOpts ReplicationOptions,
 ) error {
 	if c.replConn == nil {
 		return errors.New("replication connection not set up")
 	}
 
+	protoVersion := 1
+	streaming := false
+	if replOpts.StreamInProgress {
+		switch {
+		case c.pgVersion >= shared.POSTGRES_16:
+			protoVersion = 4
+		case c.pgVersion >= shared.POSTGRES_15:
+			protoVersion = 3
+		case c.pgVersion >= shared.POSTGRES_14:
+			protoVersion = 2
+		}
+		if protoVersion > 1 {
+			streaming = true
+		} else {
+			c.logger.Warn("streaming of in-progress transactions requires PostgreSQL 14+, using protocol version 1",
+				slog.Int("pgVersion", int(c.pgVersion)))
+		}
+	}
+
 	pluginArgs := []string{
-		"proto_version '1'",
+		fmt.Sprintf("proto_version '%d'", protoVersion),
 		fmt.Sprintf("publication_names '%s'", publicationName),
 	}
 
@@ -219,6 +265,24 @@ func (c *PostgresConnector) StartReplication(
 		pluginArgs = append(pluginArgs, "messages 'true'")
 	}
 
+	if streaming {
+		pluginArgs = append(pluginArgs, "streaming 'on'")
+	}
+
+	binaryFormat := false
+	if replOpts.Binary {
+		if c.pgVersion >= shared.POSTGRES_14 {
+			if err := c.checkBinaryPublication(ctx, publicationName); err != nil {
+				return err
+			}
+			binaryFormat = true
+			pluginArgs = append(pluginArgs, "binary 'true'")
+		} else {
+			c.logger.Warn("binary replication format requires PostgreSQL 14+, using text format",
+				slog.Int("pgVersion", int(c.pgVersion)))
+		}
+	}
+
 	opts := pglogrepl.StartReplicationOptions{
 		PluginArgs: pluginArgs,
 	}
@@ -238,15 +302,21 @@ func (c *PostgresConnector) StartReplication(
 	}
 
 	c.replState = &ReplState{
-		Slot:        slotName,
-		Publication: publicationName,
-		Offset:      lastOffset,
+		Slot:         slotName,
+		Publication:  publicationName,
+		Offset:       lastOffset,
+		ProtoVersion: protoVersion,
+		Streaming:    streaming,
+		Binary:       binaryFormat,
 	}
 	c.replState.LastOffset.Store(lastOffset)
 
 	c.logger.Info("started replication",
 		slog.String("slot", slotName),
-		slog.Int64("startLSN", int64(startLSN)))
+		slog.Int64("startLSN", int64(startLSN)),
+		slog.Int("protoVersion", protoVersion),
+		slog.Bool("streaming", streaming),
+		slog.Bool("binary", binaryFormat))
 
 	return nil
 }
@@ -272,11 +342,177 @@ func (c *PostgresConnector) GetPGVersion(ctx context.Context) (shared.PGVersion,
 	return c.pgVersion, nil
 }
 
+// PublicationTable is a table in a mirror's publication
+type PublicationTable struct {
+	Name    string   // schema.table
+	Columns []string // Published columns (PG15+); empty publishes all columns
+	Where   string   // Row filter (PG15+); empty publishes all rows
+}
+
+// String renders the table as written in CREATE/ALTER PUBLICATION
+func (t PublicationTable) String() string {
+	s := t.Name
+	if len(t.Columns) > 0 {
+		s += fmt.Sprintf(" (%s)", strings.Join(quoteIdentifiers(t.Columns), ", "))
+	}
+	if t.Where != "" {
+		s += " WHERE " + WrapRowFilter(t.Where)
+	}
+	return s
+}
+
+// PublishedRelations returns the columns of each table in a publication, as
+// its Relation messages list them now: without generated columns, and only
+// the published ones when the publication has a column list
+func (c *PostgresConnector) PublishedRelations(ctx context.Context, publicationName string) (map[string][]ColumnInfo, error) {
+	version, err := c.GetPGVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	columnList := ""
+	if version >= shared.POSTGRES_15 {
+		columnList = "AND a.attname = ANY(pt.attnames)"
+	}
+
+	rows, err := c.conn.Query(ctx, fmt.Sprintf(`
+		SELECT pt.schemaname || '.' || pt.tablename, a.attname, a.atttypid, a.atttypmod
+		FROM pg_publication_tables pt
+		JOIN pg_namespace n ON n.nspname = pt.schemaname
+		JOIN pg_class cl ON cl.relnamespace = n.oid AND cl.relname = pt.tablename
+		JOIN pg_attribute a ON a.attrelid = cl.oid
+		WHERE pt.pubname = $1 AND a.attnum > 0 AND NOT a.attisdropped AND a.attgenerated = ''
+		  %s
+		ORDER BY 1, a.attnum
+	`, columnList), publicationName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query published columns: %w", err)
+	}
+	defer rows.Close()
+
+	relations := make(map[string][]ColumnInfo)
+	for rows.Next() {
+		var table string
+		var col ColumnInfo
+		if err := rows.Scan(&table, &col.Name, &col.TypeOID, &col.Modifier); err != nil {
+			return nil, fmt.Errorf("failed to scan published column: %w", err)
+		}
+		relations[table] = append(relations[table], col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate published columns: %w", err)
+	}
+	return relations, nil
+}
+
+// PublicationTableFor builds the publication entry of a table with excluded
+// columns and a row filter.
+//
+// On PG15+ the remaining columns are published as a column list, so excluded
+// values never leave the source. Tables with REPLICA IDENTITY FULL must
+// publish every column; their excluded columns are only dropped by the
+// mirror after decoding.
+//
+// The row filter becomes the publication's WHERE clause on PG15+; older
+// sources are filtered by the mirror. Either way an UPDATE is only judged
+// correctly when the old row can be evaluated, so the filter may only use
+// replica identity columns unless the table has REPLICA IDENTITY FULL.
+func (c *PostgresConnector) PublicationTableFor(ctx context.Context, schema *TableSchema, exclude []string, rowFilter string) (PublicationTable, error) {
+	table := PublicationTable{Name: schema.SchemaName + "." + schema.TableName}
+
+	filtered, err := schema.ExcludeColumns(exclude)
+	if err != nil {
+		return table, err
+	}
+
+	if rowFilter != "" {
+		if err := c.checkRowFilter(ctx, schema, rowFilter); err != nil {
+			return table, err
+		}
+	}
+
+	version, err := c.GetPGVersion(ctx)
+	if err != nil {
+		return table, err
+	}
+	if version < shared.POSTGRES_15 {
+		if len(exclude) > 0 || rowFilter != "" {
+			c.logger.Warn("publication column lists and row filters require PG15+, the mirror filters instead",
+				slog.String("table", table.Name))
+		}
+		return table, nil
+	}
+
+	table.Where = rowFilter
+	if len(exclude) > 0 {
+		if schema.ReplicaIdentity == ReplicaIdentityFull {
+			c.logger.Warn("table has replica identity full, excluded columns are filtered by the mirror",
+				slog.String("table", table.Name))
+		} else {
+			table.Columns = filtered.ColumnNames()
+		}
+	}
+	return table, nil
+}
+
+// WrapRowFilter parenthesizes a row filter for use as a condition, with its
+// parentheses on lines of their own so that a filter cannot close them or
+// comment them out. Filters are checked and used only in this form.
+func WrapRowFilter(rowFilter string) string {
+	return "(\n" + rowFilter + "\n)"
+}
+
+// checkRowFilter validates a row filter against the table, and checks that
+// it only uses replica identity columns unless the identity is FULL. The
+// filter is only parsed and planned, never run: it must be a single boolean
+// expression, both as the only column of a query and as its WHERE clause,
+// so that it cannot add clauses, columns or statements where it is used.
+func (c *PostgresConnector) checkRowFilter(ctx context.Context, schema *TableSchema, rowFilter string) error {
+	table := fmt.Sprintf("%s.%s", quoteIdentifier(schema.SchemaName), quoteIdentifier(schema.TableName))
+	condition := WrapRowFilter(rowFilter)
+
+	query := fmt.Sprintf("SELECT %s::bool FROM %s LIMIT 0", condition, table)
+	desc, err := c.conn.PgConn().Prepare(ctx, "", query, nil)
+	if err != nil {
+		return fmt.Errorf("invalid row filter for %s.%s: %w", schema.SchemaName, schema.TableName, err)
+	}
+	if len(desc.Fields) != 1 {
+		return fmt.Errorf("row filter for %s.%s is not a single boolean expression", schema.SchemaName, schema.TableName)
+	}
+	query = fmt.Sprintf("SELECT 1 FROM %s WHERE %s LIMIT 0", table, condition)
+	if err := c.prepareUnnamed(ctx, query); err != nil {
+		return fmt.Errorf("invalid row filter for %s.%s: %w", schema.SchemaName, schema.TableName, err)
+	}
+
+	if schema.ReplicaIdentity == ReplicaIdentityFull {
+		return nil
+	}
+
+	// The filter only compiles against the identity columns if it uses no others
+	identityOnly := fmt.Sprintf("SELECT 1 FROM (SELECT %s FROM %s) t WHERE %s LIMIT 0",
+		strings.Join(quoteIdentifiers(schema.ReplicaIdentityColumns), ", "), table, condition)
+	if len(schema.ReplicaIdentityColumns) == 0 {
+		identityOnly = fmt.Sprintf("SELECT 1 WHERE %s LIMIT 0", condition)
+	}
+	if err := c.prepareUnnamed(ctx, identityOnly); err != nil {
+		return fmt.Errorf("row filter for %s.%s uses columns outside the replica identity; "+
+			"set REPLICA IDENTITY FULL on the table (or set_replica_identity_full on the mirror)",
+			schema.SchemaName, schema.TableName)
+	}
+	return nil
+}
+
+// prepareUnnamed parses and plans a query as the unnamed prepared statement
+// without running it. The extended protocol rejects more than one statement.
+func (c *PostgresConnector) prepareUnnamed(ctx context.Context, query string) error {
+	_, err := c.conn.PgConn().Prepare(ctx, "", query, nil)
+	return err
+}
+
 // CreatePublication creates a publication for the given tables
 func (c *PostgresConnector) CreatePublication(
 	ctx context.Context,
 	publicationName string,
-	tables []string,
+	tables []PublicationTable,
 ) error {
 	// Check if publication exists
 	var exists bool
@@ -293,16 +529,7 @@ func (c *PostgresConnector) CreatePublication(
 		return nil
 	}
 
-	// Build table list
-	tableList := ""
-	for i, t := range tables {
-		if i > 0 {
-			tableList += ", "
-		}
-		tableList += t
-	}
-
-	query := fmt.Sprintf("CREATE PUBLICATION \"%s\" FOR TABLE %s", publicationName, tableList)
+	query := fmt.Sprintf("CREATE PUBLICATION \"%s\" FOR TABLE %s", publicationName, publicationTableList(tables))
 	_, err = c.conn.Exec(ctx, query)
 	if err != nil {
 		return fmt.Errorf("failed to create publication: %w", err)
@@ -312,6 +539,25 @@ func (c *PostgresConnector) CreatePublication(
 	return nil
 }
 
+// SetPublicationTables replaces the tables of an existing publication
+func (c *PostgresConnector) SetPublicationTables(ctx context.Context, publicationName string, tables []PublicationTable) error {
+	query := fmt.Sprintf("ALTER PUBLICATION %s SET TABLE %s", quoteIdentifier(publicationName), publicationTableList(tables))
+	if _, err := c.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to update publication: %w", err)
+	}
+
+	c.logger.Info("updated publication tables", slog.String("name", publicationName), slog.Int("tables", len(tables)))
+	return nil
+}
+
+func publicationTableList(tables []PublicationTable) string {
+	list := make([]string, len(tables))
+	for i, t := range tables {
+		list[i] = t.String()
+	}
+	return strings.Join(list, ", ")
+}
+
 // CreateReplicationSlot creates a replication slot
 func (c *PostgresConnector) CreateReplicationSlot(
 	ctx context.Context,
@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// EnsureTable creates the schema and table a source table is mirrored into,
+// if they do not exist
+func (c *PostgresConnector) EnsureTable(ctx context.Context, schema *TableSchema, destSchema, destTable string, mirrorCols MirrorColumns) error {
+	if err := c.EnsureSchemaExists(ctx, destSchema); err != nil {
+		return fmt.Errorf("failed to create destination schema: %w", err)
+	}
+	return c.CreateTableFromSchema(ctx, schema, destSchema, destTable, mirrorCols)
+}
+
+// TruncateTable removes the rows of a destination table, and of the tables
+// referencing it
+func (c *PostgresConnector) TruncateTable(ctx context.Context, destSchema, destTable string) error {
+	query := fmt.Sprintf("TRUNCATE TABLE %s.%s CASCADE", quoteIdentifier(destSchema), quoteIdentifier(destTable))
+	if _, err := c.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to truncate %s.%s: %w", destSchema, destTable, err)
+	}
+	return nil
+}
+
+// BulkLoad inserts rows read by the snapshot
+func (c *PostgresConnector) BulkLoad(ctx context.Context, destSchema, destTable string, columns []string, rows [][]interface{}) error {
+	placeholders := make([]string, len(columns))
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	query := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (%s)",
+		quoteIdentifier(destSchema),
+		quoteIdentifier(destTable),
+		strings.Join(quoteIdentifiers(columns), ", "),
+		strings.Join(placeholders, ", "))
+
+	for _, values := range rows {
+		if _, err := c.conn.Exec(ctx, query, values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyChanges applies each source transaction in its own destination
+// transaction. A record that fails rolls back its transaction and stops the
+// batch; mirrors with an error policy use ApplyTransaction directly.
+func (c *PostgresConnector) ApplyChanges(ctx context.Context, txns []*CDCTransaction, targets map[string]*ApplyTarget) error {
+	for _, txn := range txns {
+		err := ApplyTransaction(ctx, c, txn, targets, func(rec *CDCRecord, _ *ApplyTarget, err error) error {
+			return fmt.Errorf("%s on %s.%s at LSN %d failed: %w", rec.Operation, rec.Schema, rec.Table, rec.LSN, err)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
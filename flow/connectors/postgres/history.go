@@ -0,0 +1,248 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Validity columns of a history table
+const (
+	HistoryValidFrom = "valid_from"
+	HistoryValidTo   = "valid_to"
+	HistoryIsCurrent = "is_current"
+)
+
+// historyColumnDefinitions renders the validity columns. Rows copied by the
+// snapshot have been valid since before the mirror started, which also keeps
+// them older than any change CDC applies after it.
+var historyColumnDefinitions = []string{
+	HistoryValidFrom + " timestamp with time zone NOT NULL DEFAULT '-infinity'",
+	HistoryValidTo + " timestamp with time zone",
+	HistoryIsCurrent + " boolean NOT NULL DEFAULT true",
+}
+
+// createCurrentVersionIndex makes sure a history table has at most one
+// current version per key, and finds it quickly
+func (c *PostgresConnector) createCurrentVersionIndex(ctx context.Context, pkColumns []string, destSchema, destTable string) error {
+	query := fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s.%s (%s) WHERE %s",
+		quoteIdentifier(destTable+"_current"),
+		quoteIdentifier(destSchema),
+		quoteIdentifier(destTable),
+		strings.Join(quoteIdentifiers(pkColumns), ", "),
+		HistoryIsCurrent)
+	if _, err := c.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to create current version index on %s.%s: %w", destSchema, destTable, err)
+	}
+	return nil
+}
+
+// applyHistory applies a change to a history table, with versions stamped by
+// the source commit time: an INSERT adds a current version, an UPDATE closes
+// the current version and adds a new one, a DELETE or TRUNCATE only closes
+// it. A version added earlier in the same source transaction was never
+// visible on the source, so it is revised or removed instead.
+//
+// Only versions older than the change are closed, and versions that already
+// exist are not added again, so a replayed batch leaves the history as it is.
+func applyHistory(ctx context.Context, db execer, rec *CDCRecord, target *ApplyTarget) error {
+	switch rec.Operation {
+	case "INSERT":
+		if len(rec.NewValues) == 0 {
+			return nil
+		}
+		return insertVersion(ctx, db, target, rec.NewValues, rec.CommitTime)
+	case "UPDATE":
+		return applyHistoryUpdate(ctx, db, rec, target)
+	case "DELETE":
+		if rec.OldValues == nil {
+			return fmt.Errorf("DELETE record has no old values")
+		}
+		return closeVersions(ctx, db, target, rec.OldValues, rec.CommitTime)
+	case "TRUNCATE":
+		return closeVersions(ctx, db, target, nil, rec.CommitTime)
+	case "SCHEMA":
+		return applySchemaChange(ctx, db, rec, target)
+	default:
+		return fmt.Errorf("unknown operation: %s", rec.Operation)
+	}
+}
+
+// applyHistoryUpdate closes the current version of a row and adds the new
+// one. Unchanged TOAST values are carried over from the closed version.
+func applyHistoryUpdate(ctx context.Context, db execer, rec *CDCRecord, target *ApplyTarget) error {
+	if len(rec.NewValues) == 0 {
+		return nil
+	}
+	oldValues := rec.OldValues
+	if oldValues == nil {
+		oldValues = rec.NewValues
+	}
+	table := quoteIdentifier(target.Schema) + "." + quoteIdentifier(target.Table)
+
+	// Revise a version added by the same source transaction
+	sets := make([]string, 0, len(rec.NewValues))
+	values := make([]interface{}, 0, len(rec.NewValues)+len(target.PKColumns)+1)
+	for _, col := range sortedColumns(rec.NewValues) {
+		values = append(values, rec.NewValues[col])
+		sets = append(sets, fmt.Sprintf("%s = $%d", quoteIdentifier(col), len(values)))
+	}
+	sets = append(sets, target.mirrorSets(false)...)
+	where, keyValues, err := historyKeyMatch(target, oldValues, len(values)+1)
+	if err != nil {
+		return fmt.Errorf("UPDATE: %w", err)
+	}
+	values = append(values, keyValues...)
+	values = append(values, rec.CommitTime)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s AND %s AND %s = $%d",
+		table, strings.Join(sets, ", "), where, HistoryIsCurrent, HistoryValidFrom, len(values))
+	tag, err := db.Exec(ctx, query, values...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	if len(rec.UnchangedToastColumns) == 0 {
+		if err := closeVersions(ctx, db, target, oldValues, rec.CommitTime); err != nil {
+			return err
+		}
+		return insertVersion(ctx, db, target, rec.NewValues, rec.CommitTime)
+	}
+
+	// Close the current version, reading back the values it keeps
+	where, keyValues, err = historyKeyMatch(target, oldValues, 2)
+	if err != nil {
+		return fmt.Errorf("UPDATE: %w", err)
+	}
+	closeSets := append([]string{
+		fmt.Sprintf("%s = $1", HistoryValidTo),
+		HistoryIsCurrent + " = false",
+	}, target.mirrorSets(false)...)
+	query = fmt.Sprintf("UPDATE %s SET %s WHERE %s AND %s AND %s < $1 RETURNING %s",
+		table, strings.Join(closeSets, ", "), where, HistoryIsCurrent, HistoryValidFrom,
+		strings.Join(quoteIdentifiers(rec.UnchangedToastColumns), ", "))
+
+	kept := make([]interface{}, len(rec.UnchangedToastColumns))
+	dest := make([]interface{}, len(kept))
+	for i := range kept {
+		dest[i] = &kept[i]
+	}
+	err = db.QueryRow(ctx, query, append([]interface{}{rec.CommitTime}, keyValues...)...).Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Nothing to close is fine if this version was added before, or if
+		// the change predates the table copy and the row was deleted since
+		where, keyValues, err := historyKeyMatch(target, rec.NewValues, 2)
+		if err != nil {
+			return fmt.Errorf("UPDATE: %w", err)
+		}
+		var exists bool
+		query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s AND %s = $1)", table, where, HistoryValidFrom)
+		if err := db.QueryRow(ctx, query, append([]interface{}{rec.CommitTime}, keyValues...)...).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		return MissingRowError(rec, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	newValues := make(map[string]interface{}, len(rec.NewValues)+len(kept))
+	for col, val := range rec.NewValues {
+		newValues[col] = val
+	}
+	for i, col := range rec.UnchangedToastColumns {
+		newValues[col] = kept[i]
+	}
+	return insertVersion(ctx, db, target, newValues, rec.CommitTime)
+}
+
+// insertVersion adds a current version of a row, valid from the commit time,
+// unless it already exists
+func insertVersion(ctx context.Context, db execer, target *ApplyTarget, values map[string]interface{}, validFrom time.Time) error {
+	columns := make([]string, 0, len(values)+2)
+	placeholders := make([]string, 0, len(values)+2)
+	args := make([]interface{}, 0, len(values)+1)
+	for col, val := range values {
+		args = append(args, val)
+		columns = append(columns, quoteIdentifier(col))
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+	args = append(args, validFrom)
+	columns = append(columns, HistoryValidFrom, HistoryIsCurrent)
+	placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)), "true")
+
+	mirrorCols, mirrorExprs := target.mirrorInsert()
+	columns = append(columns, mirrorCols...)
+	placeholders = append(placeholders, mirrorExprs...)
+
+	query := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
+		quoteIdentifier(target.Schema),
+		quoteIdentifier(target.Table),
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "))
+
+	_, err := db.Exec(ctx, query, args...)
+	return err
+}
+
+// closeVersions ends the current version of the row with the given key, or
+// of every row if keyValues is nil, at the commit time. A current version
+// added by the same source transaction is removed.
+func closeVersions(ctx context.Context, db execer, target *ApplyTarget, keyValues map[string]interface{}, validTo time.Time) error {
+	table := quoteIdentifier(target.Schema) + "." + quoteIdentifier(target.Table)
+
+	where := "true"
+	var args []interface{}
+	if keyValues != nil {
+		var err error
+		where, args, err = historyKeyMatch(target, keyValues, 2)
+		if err != nil {
+			return err
+		}
+	}
+	args = append([]interface{}{validTo}, args...)
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s AND %s AND %s = $1",
+		table, where, HistoryIsCurrent, HistoryValidFrom)
+	if _, err := db.Exec(ctx, query, args...); err != nil {
+		return err
+	}
+
+	sets := append([]string{
+		fmt.Sprintf("%s = $1", HistoryValidTo),
+		HistoryIsCurrent + " = false",
+	}, target.mirrorSets(false)...)
+	query = fmt.Sprintf("UPDATE %s SET %s WHERE %s AND %s AND %s < $1",
+		table, strings.Join(sets, ", "), where, HistoryIsCurrent, HistoryValidFrom)
+	_, err := db.Exec(ctx, query, args...)
+	return err
+}
+
+// historyKeyMatch builds a condition selecting the versions of one row by
+// its key columns, with parameters numbered from paramIdx
+func historyKeyMatch(target *ApplyTarget, values map[string]interface{}, paramIdx int) (string, []interface{}, error) {
+	if len(target.PKColumns) == 0 {
+		return "", nil, fmt.Errorf("history table %s.%s has no key columns", target.Schema, target.Table)
+	}
+	conds := make([]string, 0, len(target.PKColumns))
+	args := make([]interface{}, 0, len(target.PKColumns))
+	for _, col := range target.PKColumns {
+		val, ok := values[col]
+		if !ok {
+			return "", nil, fmt.Errorf("key column %s missing from record", col)
+		}
+		conds = append(conds, fmt.Sprintf("%s = $%d", quoteIdentifier(col), paramIdx))
+		args = append(args, val)
+		paramIdx++
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
@@ -0,0 +1,175 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// recordingExecer records the statements run on a history table. Revising a
+// version of the same transaction touches reviseRows rows.
+type recordingExecer struct {
+	reviseRows int
+	statements []string
+}
+
+func (e *recordingExecer) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	var stmt string
+	switch {
+	case strings.HasPrefix(sql, "INSERT"):
+		stmt = fmt.Sprintf("insert %v", args[0])
+	case strings.HasPrefix(sql, "DELETE"):
+		stmt = "delete " + closedKey(args)
+	case strings.Contains(sql, HistoryIsCurrent+" = false"):
+		stmt = "close " + closedKey(args)
+	default:
+		e.statements = append(e.statements, fmt.Sprintf("revise %v", args[len(args)-2]))
+		return pgconn.NewCommandTag(fmt.Sprintf("UPDATE %d", e.reviseRows)), nil
+	}
+	e.statements = append(e.statements, stmt)
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (e *recordingExecer) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	panic("unexpected query: " + sql)
+}
+
+// closedKey renders the key a close or delete is restricted to: the
+// parameter after the commit time, if any
+func closedKey(args []any) string {
+	if len(args) < 2 {
+		return "all"
+	}
+	return fmt.Sprint(args[1])
+}
+
+func TestApplyHistory(t *testing.T) {
+	target := &ApplyTarget{Schema: "public", Table: "users_history", PKColumns: []string{"id"}, History: true}
+	commitTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	id := func(v int) map[string]interface{} { return map[string]interface{}{"id": v} }
+
+	tests := []struct {
+		name       string
+		rec        *CDCRecord
+		reviseRows int
+		want       []string
+	}{
+		{
+			name: "insert adds a version",
+			rec:  &CDCRecord{Operation: "INSERT", NewValues: id(1)},
+			want: []string{"insert 1"},
+		},
+		{
+			name: "update closes the current version before adding one",
+			rec:  &CDCRecord{Operation: "UPDATE", NewValues: id(1)},
+			want: []string{"revise 1", "delete 1", "close 1", "insert 1"},
+		},
+		{
+			name:       "update revises a version of the same transaction",
+			rec:        &CDCRecord{Operation: "UPDATE", NewValues: id(1)},
+			reviseRows: 1,
+			want:       []string{"revise 1"},
+		},
+		{
+			name: "key change closes the old key",
+			rec:  &CDCRecord{Operation: "UPDATE", OldValues: id(1), NewValues: id(2)},
+			want: []string{"revise 1", "delete 1", "close 1", "insert 2"},
+		},
+		{
+			name: "delete closes the current version",
+			rec:  &CDCRecord{Operation: "DELETE", OldValues: id(1)},
+			want: []string{"delete 1", "close 1"},
+		},
+		{
+			name: "truncate closes every current version",
+			rec:  &CDCRecord{Operation: "TRUNCATE"},
+			want: []string{"delete all", "close all"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &recordingExecer{reviseRows: tt.reviseRows}
+			tt.rec.CommitTime = commitTime
+			if err := applyHistory(context.Background(), db, tt.rec, target); err != nil {
+				t.Fatalf("failed to apply %s: %v", tt.rec.Operation, err)
+			}
+			if !slices.Equal(db.statements, tt.want) {
+				t.Errorf("statements = %q, want %q", db.statements, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryKeyMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		pkColumns []string
+		values    map[string]interface{}
+		paramIdx  int
+		wantWhere string
+		wantArgs  []interface{}
+		wantErr   bool
+	}{
+		{
+			name:      "single key",
+			pkColumns: []string{"id"},
+			values:    map[string]interface{}{"id": 1, "name": "ada"},
+			paramIdx:  1,
+			wantWhere: `"id" = $1`,
+			wantArgs:  []interface{}{1},
+		},
+		{
+			name:      "composite key after other parameters",
+			pkColumns: []string{"tenant", "id"},
+			values:    map[string]interface{}{"id": 1, "tenant": "acme"},
+			paramIdx:  3,
+			wantWhere: `"tenant" = $3 AND "id" = $4`,
+			wantArgs:  []interface{}{"acme", 1},
+		},
+		{
+			name:      "null key value",
+			pkColumns: []string{"id"},
+			values:    map[string]interface{}{"id": nil},
+			paramIdx:  2,
+			wantWhere: `"id" = $2`,
+			wantArgs:  []interface{}{nil},
+		},
+		{
+			name:      "key column missing",
+			pkColumns: []string{"tenant", "id"},
+			values:    map[string]interface{}{"id": 1},
+			paramIdx:  1,
+			wantErr:   true,
+		},
+		{
+			name:     "no key columns",
+			values:   map[string]interface{}{"id": 1},
+			paramIdx: 1,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &ApplyTarget{Schema: "public", Table: "users_history", PKColumns: tt.pkColumns}
+			where, args, err := historyKeyMatch(target, tt.values, tt.paramIdx)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("historyKeyMatch = %q, want an error", where)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("historyKeyMatch failed: %v", err)
+			}
+			if where != tt.wantWhere || !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("historyKeyMatch = %q %v, want %q %v", where, args, tt.wantWhere, tt.wantArgs)
+			}
+		})
+	}
+}
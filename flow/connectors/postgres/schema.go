@@ -15,6 +15,66 @@ type TableSchema struct {
 	Columns               []ColumnDefinition
 	PrimaryKeyColumns     []string
 	IsReplicaIdentityFull bool
+
+	// ReplicaIdentity is pg_class.relreplident: 'd' (default, primary key),
+	// 'f' (full), 'i' (index) or 'n' (nothing). ReplicaIdentityColumns are
+	// the key columns the source sends for UPDATE/DELETE: the primary key for
+	// 'd', the replica identity index columns for 'i', none otherwise.
+	ReplicaIdentity        string
+	ReplicaIdentityColumns []string
+}
+
+// Replica identity settings (pg_class.relreplident)
+const (
+	ReplicaIdentityDefault = "d"
+	ReplicaIdentityFull    = "f"
+	ReplicaIdentityIndex   = "i"
+	ReplicaIdentityNothing = "n"
+)
+
+// SupportsUpdates reports whether the source can identify rows for UPDATE
+// and DELETE. Without a usable replica identity, PostgreSQL rejects UPDATE
+// and DELETE on a table that is published for them.
+func (s *TableSchema) SupportsUpdates() bool {
+	return s.ReplicaIdentity == ReplicaIdentityFull || len(s.ReplicaIdentityColumns) > 0
+}
+
+// ColumnNames returns the names of the columns in table order
+func (s *TableSchema) ColumnNames() []string {
+	names := make([]string, len(s.Columns))
+	for i, col := range s.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// ExcludeColumns returns a copy of the schema without the given columns.
+// Key columns cannot be excluded since rows are matched on them.
+func (s *TableSchema) ExcludeColumns(exclude []string) (*TableSchema, error) {
+	if len(exclude) == 0 {
+		return s, nil
+	}
+
+	excluded := make(map[string]bool, len(exclude))
+	for _, col := range exclude {
+		excluded[col] = true
+	}
+	for _, keys := range [][]string{s.PrimaryKeyColumns, s.ReplicaIdentityColumns} {
+		for _, col := range keys {
+			if excluded[col] {
+				return nil, fmt.Errorf("cannot exclude key column %s of %s.%s", col, s.SchemaName, s.TableName)
+			}
+		}
+	}
+
+	filtered := *s
+	filtered.Columns = make([]ColumnDefinition, 0, len(s.Columns))
+	for _, col := range s.Columns {
+		if !excluded[col.Name] {
+			filtered.Columns = append(filtered.Columns, col)
+		}
+	}
+	return &filtered, nil
 }
 
 // ColumnDefinition represents a column definition
@@ -54,17 +114,30 @@ func (c *PostgresConnector) GetTableSchema(ctx context.Context, schemaName, tabl
 	}
 
 	// Get replica identity
-	isFullReplica, err := c.isReplicaIdentityFull(ctx, schemaName, tableName)
+	replIdent, err := c.getReplicaIdentity(ctx, schemaName, tableName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get replica identity: %w", err)
 	}
 
+	var identityCols []string
+	switch replIdent {
+	case ReplicaIdentityDefault:
+		identityCols = pkCols
+	case ReplicaIdentityIndex:
+		identityCols, err = c.getReplicaIdentityIndexColumns(ctx, schemaName, tableName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get replica identity index columns: %w", err)
+		}
+	}
+
 	return &TableSchema{
-		SchemaName:            schemaName,
-		TableName:             tableName,
-		Columns:               columns,
-		PrimaryKeyColumns:     pkCols,
-		IsReplicaIdentityFull: isFullReplica,
+		SchemaName:             schemaName,
+		TableName:              tableName,
+		Columns:                columns,
+		PrimaryKeyColumns:      pkCols,
+		IsReplicaIdentityFull:  replIdent == ReplicaIdentityFull,
+		ReplicaIdentity:        replIdent,
+		ReplicaIdentityColumns: identityCols,
 	}, nil
 }
 
@@ -138,7 +211,7 @@ func (c *PostgresConnector) getPrimaryKeyColumns(ctx context.Context, schemaName
 	return pgx.CollectRows(rows, pgx.RowTo[string])
 }
 
-func (c *PostgresConnector) isReplicaIdentityFull(ctx context.Context, schemaName, tableName string) (bool, error) {
+func (c *PostgresConnector) getReplicaIdentity(ctx context.Context, schemaName, tableName string) (string, error) {
 	var relreplident string
 	err := c.conn.QueryRow(ctx, `
 		SELECT c.relreplident::text
@@ -147,10 +220,41 @@ func (c *PostgresConnector) isReplicaIdentityFull(ctx context.Context, schemaNam
 		WHERE n.nspname = $1 AND c.relname = $2
 	`, schemaName, tableName).Scan(&relreplident)
 	if err != nil {
-		return false, err
+		return "", err
+	}
+
+	return relreplident, nil
+}
+
+func (c *PostgresConnector) getReplicaIdentityIndexColumns(ctx context.Context, schemaName, tableName string) ([]string, error) {
+	query := `
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		JOIN pg_class c ON c.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1
+			AND c.relname = $2
+			AND i.indisreplident
+		ORDER BY array_position(i.indkey, a.attnum::int2)
+	`
+
+	rows, err := c.conn.Query(ctx, query, schemaName, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query replica identity index: %w", err)
 	}
 
-	return relreplident == "f", nil
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// SetReplicaIdentityFull makes the source log the full old row for UPDATE and
+// DELETE, for tables that have no primary key or usable replica identity index
+func (c *PostgresConnector) SetReplicaIdentityFull(ctx context.Context, schemaName, tableName string) error {
+	query := fmt.Sprintf("ALTER TABLE %s.%s REPLICA IDENTITY FULL", quoteIdentifier(schemaName), quoteIdentifier(tableName))
+	if _, err := c.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to set replica identity full on %s.%s: %w", schemaName, tableName, err)
+	}
+	return nil
 }
 
 // GetAllTables returns all tables in the database
@@ -259,6 +363,15 @@ type SchemaDelta struct {
 
 	AddedFKs   []ForeignKeyDefinition
 	DroppedFKs []string
+
+	// LikelyRenames pairs dropped and added columns that are probably one
+	// renamed column, set for changes read from the replication stream. They
+	// are applied as a drop and an add all the same.
+	LikelyRenames []ColumnRename
+
+	// SourceColumns is the source table's column layout after the change,
+	// set for changes read from the replication stream
+	SourceColumns []ColumnInfo
 }
 
 // ColumnTypeChange represents a column type change
@@ -268,6 +381,12 @@ type ColumnTypeChange struct {
 	NewType    string
 }
 
+// ColumnRename represents a column renamed on the source
+type ColumnRename struct {
+	OldName string
+	NewName string
+}
+
 // HasChanges returns true if there are any schema changes
 func (d *SchemaDelta) HasChanges() bool {
 	return len(d.AddedColumns) > 0 ||
@@ -279,6 +398,35 @@ func (d *SchemaDelta) HasChanges() bool {
 		len(d.DroppedFKs) > 0
 }
 
+// ApplyTo returns a copy of a schema with the column changes of the delta
+// applied, for destinations that follow the source schema as it changes.
+// Columns of changed types have no known type OID from then on.
+func (d *SchemaDelta) ApplyTo(schema *TableSchema) *TableSchema {
+	updated := *schema
+	updated.Columns = nil
+
+	dropped := make(map[string]bool, len(d.DroppedColumns))
+	for _, col := range d.DroppedColumns {
+		dropped[col] = true
+	}
+	retyped := make(map[string]string, len(d.TypeChanges))
+	for _, tc := range d.TypeChanges {
+		retyped[tc.ColumnName] = tc.NewType
+	}
+	for _, col := range schema.Columns {
+		if dropped[col.Name] {
+			continue
+		}
+		if newType, ok := retyped[col.Name]; ok {
+			col.Type = newType
+			col.TypeOID = 0
+		}
+		updated.Columns = append(updated.Columns, col)
+	}
+	updated.Columns = append(updated.Columns, d.AddedColumns...)
+	return &updated
+}
+
 // ApplySchemaDelta applies schema changes to the destination
 func (c *PostgresConnector) ApplySchemaDelta(ctx context.Context, delta *SchemaDelta) error {
 	// Apply column additions
@@ -330,8 +478,58 @@ func QuoteIdentifier(name string) string {
 	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
 }
 
-// CreateTableFromSchema creates a table in the destination database based on source schema
-func (c *PostgresConnector) CreateTableFromSchema(ctx context.Context, schema *TableSchema, destSchema, destTable string) error {
+// MirrorColumns names the columns a mirror maintains on destination tables
+// besides the replicated ones. Empty names are not added.
+type MirrorColumns struct {
+	SoftDelete string // Set to true instead of deleting the row
+	SyncedAt   string // Stamped with the time the row was last applied
+	// History makes the table a history table, keeping every version of a
+	// row with the validity columns
+	History bool
+}
+
+// Names returns the configured mirror column names
+func (m MirrorColumns) Names() []string {
+	var names []string
+	for _, name := range []string{m.SoftDelete, m.SyncedAt} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if m.History {
+		names = append(names, HistoryValidFrom, HistoryValidTo, HistoryIsCurrent)
+	}
+	return names
+}
+
+// definitions renders the mirror columns for CREATE and ALTER TABLE. Rows
+// copied by the snapshot get their values from the defaults.
+func (m MirrorColumns) definitions() []string {
+	var defs []string
+	if m.SoftDelete != "" {
+		defs = append(defs, quoteIdentifier(m.SoftDelete)+" boolean NOT NULL DEFAULT false")
+	}
+	if m.SyncedAt != "" {
+		defs = append(defs, quoteIdentifier(m.SyncedAt)+" timestamp with time zone DEFAULT now()")
+	}
+	if m.History {
+		defs = append(defs, historyColumnDefinitions...)
+	}
+	return defs
+}
+
+// CreateTableFromSchema creates a table in the destination database based on
+// source schema, with the mirror's own columns added. The mirror columns are
+// also added to a table that already exists.
+func (c *PostgresConnector) CreateTableFromSchema(ctx context.Context, schema *TableSchema, destSchema, destTable string, mirrorCols MirrorColumns) error {
+	for _, name := range mirrorCols.Names() {
+		for _, col := range schema.Columns {
+			if col.Name == name {
+				return fmt.Errorf("mirror column %s clashes with a column of %s.%s", name, schema.SchemaName, schema.TableName)
+			}
+		}
+	}
+
 	// Build column definitions
 	var columnDefs []string
 	for _, col := range schema.Columns {
@@ -347,13 +545,21 @@ func (c *PostgresConnector) CreateTableFromSchema(ctx context.Context, schema *T
 
 		columnDefs = append(columnDefs, colDef)
 	}
+	columnDefs = append(columnDefs, mirrorCols.definitions()...)
 
-	// Add primary key constraint if present
+	// Add primary key constraint if present. A history table keeps one row
+	// per version, told apart by when it became valid.
+	if mirrorCols.History && len(schema.PrimaryKeyColumns) == 0 {
+		return fmt.Errorf("history table for %s.%s requires a primary key on the source", schema.SchemaName, schema.TableName)
+	}
 	if len(schema.PrimaryKeyColumns) > 0 {
 		pkCols := make([]string, len(schema.PrimaryKeyColumns))
 		for i, col := range schema.PrimaryKeyColumns {
 			pkCols[i] = quoteIdentifier(col)
 		}
+		if mirrorCols.History {
+			pkCols = append(pkCols, HistoryValidFrom)
+		}
 		columnDefs = append(columnDefs, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(pkCols, ", ")))
 	}
 
@@ -371,6 +577,20 @@ func (c *PostgresConnector) CreateTableFromSchema(ctx context.Context, schema *T
 		return fmt.Errorf("failed to create table %s.%s: %w", destSchema, destTable, err)
 	}
 
+	for _, def := range mirrorCols.definitions() {
+		alter := fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS %s",
+			quoteIdentifier(destSchema), quoteIdentifier(destTable), def)
+		if _, err := c.conn.Exec(ctx, alter); err != nil {
+			return fmt.Errorf("failed to add mirror column to %s.%s: %w", destSchema, destTable, err)
+		}
+	}
+
+	if mirrorCols.History {
+		if err := c.createCurrentVersionIndex(ctx, schema.PrimaryKeyColumns, destSchema, destTable); err != nil {
+			return err
+		}
+	}
+
 	c.logger.Info("table created successfully", "schema", destSchema, "table", destTable)
 	return nil
 }
@@ -0,0 +1,40 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// OpenChangeStream sets up the replication connection, starts replication
+// from the slot after startLSN and returns a reader for the stream
+func (c *PostgresConnector) OpenChangeStream(
+	ctx context.Context,
+	slotName string,
+	publicationName string,
+	startLSN int64,
+	opts ReplicationOptions,
+) (*CDCReader, error) {
+	if err := c.SetupReplConn(ctx); err != nil {
+		return nil, fmt.Errorf("failed to setup replication connection: %w", err)
+	}
+
+	// The version decides the protocol features replication asks for
+	if _, err := c.GetPGVersion(ctx); err != nil {
+		c.logger.Warn("failed to get PG version", slog.Any("error", err))
+	}
+
+	// Custom types must be known to decode their binary values
+	if opts.Binary {
+		if err := c.LoadCustomTypes(ctx); err != nil {
+			return nil, fmt.Errorf("failed to load custom types: %w", err)
+		}
+	}
+
+	if err := c.StartReplication(ctx, slotName, publicationName, startLSN, opts); err != nil {
+		return nil, fmt.Errorf("failed to start replication: %w", err)
+	}
+	reader := NewCDCReader(c)
+	reader.baseline = opts.Relations
+	return reader, nil
+}
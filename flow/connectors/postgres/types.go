@@ -0,0 +1,272 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/shared"
+)
+
+// LoadCustomTypes discovers the user-defined types of the database (enums,
+// domains, composites, ranges and arrays of them) and registers them in the
+// connector's type map so that binary replication values can be decoded.
+// Types that pgx cannot load are still recorded in customTypeMapping.
+func (c *PostgresConnector) LoadCustomTypes(ctx context.Context) error {
+	// Arrays are loaded last since their element type must be known first
+	rows, err := c.conn.Query(ctx, `
+		SELECT t.oid, format_type(t.oid, NULL), t.typdelim::text
+		FROM pg_type t
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		LEFT JOIN pg_class cl ON cl.oid = t.typrelid
+		WHERE t.oid >= 16384
+		  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		  AND n.nspname NOT LIKE 'pg_toast%'
+		  AND t.typtype IN ('b', 'c', 'd', 'e', 'r', 'm')
+		  AND (t.typrelid = 0 OR cl.relkind = 'c')
+		ORDER BY t.typcategory = 'A', t.oid
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query custom types: %w", err)
+	}
+	defer rows.Close()
+
+	var types []shared.CustomDataType
+	for rows.Next() {
+		var dt shared.CustomDataType
+		if err := rows.Scan(&dt.OID, &dt.Name, &dt.Delim); err != nil {
+			return fmt.Errorf("failed to scan custom type: %w", err)
+		}
+		types = append(types, dt)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate custom types: %w", err)
+	}
+
+	for _, dt := range types {
+		c.customTypeMapping[dt.OID] = dt
+
+		typ, err := c.conn.LoadType(ctx, dt.Name)
+		if err != nil {
+			c.logger.Warn("failed to load custom type, its values will be decoded as text",
+				slog.String("type", dt.Name),
+				slog.Any("error", err))
+			continue
+		}
+		// Register on the connection too: LoadType resolves array elements
+		// and composite fields through the connection's own type map
+		c.conn.TypeMap().RegisterType(typ)
+		c.typeMap.RegisterType(typ)
+	}
+
+	c.logger.Info("loaded custom types", slog.Int("count", len(types)))
+	return nil
+}
+
+// newDecodeTypeMap returns the type map replication values are decoded with.
+// json and jsonb values are kept as their text, as decoding them into Go
+// values would round large integers and decimals to float64.
+func newDecodeTypeMap() *pgtype.Map {
+	m := pgtype.NewMap()
+	jsonType := &pgtype.Type{Name: "json", OID: pgtype.JSONOID, Codec: &pgtype.JSONCodec{
+		Marshal: json.Marshal, Unmarshal: unmarshalJSONText,
+	}}
+	jsonbType := &pgtype.Type{Name: "jsonb", OID: pgtype.JSONBOID, Codec: &pgtype.JSONBCodec{
+		Marshal: json.Marshal, Unmarshal: unmarshalJSONText,
+	}}
+	m.RegisterType(jsonType)
+	m.RegisterType(jsonbType)
+	m.RegisterType(&pgtype.Type{Name: "_json", OID: pgtype.JSONArrayOID, Codec: &pgtype.ArrayCodec{ElementType: jsonType}})
+	m.RegisterType(&pgtype.Type{Name: "_jsonb", OID: pgtype.JSONBArrayOID, Codec: &pgtype.ArrayCodec{ElementType: jsonbType}})
+	return m
+}
+
+// unmarshalJSONText is the json codecs' Unmarshal. Values decoded without a
+// target type become the JSON text as a string.
+func unmarshalJSONText(data []byte, v any) error {
+	if dst, ok := v.(*any); ok {
+		*dst = string(data)
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// undecodableBinaryTypes returns the names, by OID, of the types among
+// typeOIDs whose values the server sends in binary format but the type map
+// has no codec for, such as citext, hstore or PostGIS types. Types without a
+// binary send function are sent as text and can always be read.
+func (c *PostgresConnector) undecodableBinaryTypes(ctx context.Context, typeOIDs []uint32) (map[uint32]string, error) {
+	var unknown []uint32
+	for _, oid := range typeOIDs {
+		if _, ok := c.typeMap.TypeForOID(oid); !ok {
+			unknown = append(unknown, oid)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil, nil
+	}
+
+	rows, err := c.conn.Query(ctx, `
+		SELECT oid, format_type(oid, NULL)
+		FROM pg_type
+		WHERE oid = ANY($1) AND typsend::oid <> 0
+	`, unknown)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query binary send functions: %w", err)
+	}
+	defer rows.Close()
+
+	names := make(map[uint32]string)
+	for rows.Next() {
+		var oid uint32
+		var name string
+		if err := rows.Scan(&oid, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan type: %w", err)
+		}
+		names[oid] = name
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate types: %w", err)
+	}
+	return names, nil
+}
+
+// checkBinaryColumns returns an error naming the columns whose values would
+// arrive in a binary format the type map cannot decode. columns maps each
+// column name to its type OID.
+func (c *PostgresConnector) checkBinaryColumns(ctx context.Context, columns map[string]uint32) error {
+	var typeOIDs []uint32
+	for _, oid := range columns {
+		typeOIDs = append(typeOIDs, oid)
+	}
+	names, err := c.undecodableBinaryTypes(ctx, typeOIDs)
+	if err != nil || len(names) == 0 {
+		return err
+	}
+
+	var undecodable []string
+	for col, oid := range columns {
+		if name, ok := names[oid]; ok {
+			undecodable = append(undecodable, fmt.Sprintf("%s (%s)", col, name))
+		}
+	}
+	sort.Strings(undecodable)
+	return fmt.Errorf("binary format cannot be decoded for columns %s, disable binary_format for this mirror",
+		strings.Join(undecodable, ", "))
+}
+
+// checkBinaryPublication checks that every column of the tables in the
+// publication can be decoded in binary format, so that binary format is
+// refused up front instead of failing on the first such row
+func (c *PostgresConnector) checkBinaryPublication(ctx context.Context, publicationName string) error {
+	rows, err := c.conn.Query(ctx, `
+		SELECT format('%I.%I.%I', pt.schemaname, pt.tablename, a.attname), a.atttypid
+		FROM pg_publication_tables pt
+		JOIN pg_namespace n ON n.nspname = pt.schemaname
+		JOIN pg_class cl ON cl.relnamespace = n.oid AND cl.relname = pt.tablename
+		JOIN pg_attribute a ON a.attrelid = cl.oid
+		WHERE pt.pubname = $1 AND a.attnum > 0 AND NOT a.attisdropped
+	`, publicationName)
+	if err != nil {
+		return fmt.Errorf("failed to query published columns: %w", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]uint32)
+	for rows.Next() {
+		var name string
+		var typeOID uint32
+		if err := rows.Scan(&name, &typeOID); err != nil {
+			return fmt.Errorf("failed to scan published column: %w", err)
+		}
+		columns[name] = typeOID
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate published columns: %w", err)
+	}
+
+	return c.checkBinaryColumns(ctx, columns)
+}
+
+// decodeValue decodes a column value from the replication stream into a Go
+// value using the connector's type map. Values in text format of types
+// unknown to the type map are returned as strings. Binary values of such
+// types cannot be interpreted and return an error; binary format is refused
+// up front for tables that have them.
+func (c *PostgresConnector) decodeValue(typeOID uint32, format int16, data []byte) (interface{}, error) {
+	if dt, ok := c.typeMap.TypeForOID(typeOID); ok {
+		val, err := dt.Codec.DecodeValue(c.typeMap, typeOID, format, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s value: %w", dt.Name, err)
+		}
+		// Codecs may alias the input, which is the reused receive buffer
+		if b, ok := val.([]byte); ok {
+			val = bytes.Clone(b)
+		}
+		return val, nil
+	}
+
+	if format == pgtype.TextFormatCode {
+		return string(data), nil
+	}
+
+	if custom, ok := c.customTypeMapping[typeOID]; ok {
+		return nil, fmt.Errorf("no binary decoder registered for type %s (oid %d)", custom.Name, typeOID)
+	}
+	return nil, fmt.Errorf("no binary decoder registered for type oid %d", typeOID)
+}
+
+// formatType returns the SQL name of a type including its modifier, as
+// rendered by format_type on this database
+func (c *PostgresConnector) formatType(ctx context.Context, typeOID uint32, modifier int32) (string, error) {
+	var name string
+	if err := c.conn.QueryRow(ctx, "SELECT format_type($1, $2)", typeOID, modifier).Scan(&name); err != nil {
+		return "", fmt.Errorf("failed to format type oid %d: %w", typeOID, err)
+	}
+	return name, nil
+}
+
+// typeModifiers matches the modifiers of a formatted type, such as (255) or
+// (10,2)
+var typeModifiers = regexp.MustCompile(`\(([0-9, ]+)\)`)
+
+// BaseType splits a formatted type, as in ColumnDefinition.Type, into its
+// name without modifiers and its modifiers, for destinations that map
+// Postgres types to their own
+func BaseType(pgType string) (string, []int) {
+	var mods []int
+	if m := typeModifiers.FindStringSubmatch(pgType); m != nil {
+		for _, s := range strings.Split(m[1], ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+				mods = append(mods, n)
+			}
+		}
+	}
+	name := typeModifiers.ReplaceAllString(pgType, "")
+	return strings.Join(strings.Fields(name), " "), mods
+}
+
+// textTypeMap renders typed values of built-in types in their text form. A
+// type map caches encode plans and is not safe for concurrent use.
+var (
+	textTypeMapMu sync.Mutex
+	textTypeMap   = pgtype.NewMap()
+)
+
+// EncodeText returns the text form of a value of a built-in type, as the
+// server would render it, or nil for a typed NULL. It fails for values the
+// type cannot encode, such as those of types unknown to pgx.
+func EncodeText(typeOID uint32, v interface{}) ([]byte, error) {
+	textTypeMapMu.Lock()
+	defer textTypeMapMu.Unlock()
+	return textTypeMap.Encode(typeOID, pgtype.TextFormatCode, v, nil)
+}
@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBaseType(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantName string
+		wantMods []int
+	}{
+		{"integer", "integer", nil},
+		{"character varying(255)", "character varying", []int{255}},
+		{"numeric(10,2)", "numeric", []int{10, 2}},
+		{"numeric(10, 2)", "numeric", []int{10, 2}},
+		{"timestamp(3) with time zone", "timestamp with time zone", []int{3}},
+		{"time(6) without time zone", "time without time zone", []int{6}},
+		{"character varying(20)[]", "character varying[]", []int{20}},
+		{"public.my_type", "public.my_type", nil},
+	}
+	for _, tt := range tests {
+		name, mods := BaseType(tt.in)
+		if name != tt.wantName || !slices.Equal(mods, tt.wantMods) {
+			t.Errorf("BaseType(%q) = %q, %v; want %q, %v", tt.in, name, mods, tt.wantName, tt.wantMods)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Registration describes what a peer type can be used for
+type Registration struct {
+	// Validate checks the settings of a peer before it is stored. Optional.
+	Validate func(peer *Peer) error
+
+	// NewSource connects to a peer as a source; nil if the type cannot be
+	// one
+	NewSource func(ctx context.Context, peer *Peer) (Source, error)
+
+	// NewDestination connects to a peer as a destination; nil if the type
+	// cannot be one
+	NewDestination func(ctx context.Context, peer *Peer) (Destination, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[PeerType]Registration)
+)
+
+// Register makes a peer type available. Connector packages call it from
+// init; registering a type twice panics.
+func Register(peerType PeerType, reg Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, dup := registry[peerType]; dup {
+		panic(fmt.Sprintf("connectors: peer type %s registered twice", peerType))
+	}
+	registry[peerType] = reg
+}
+
+// Lookup returns the registration of a peer type
+func Lookup(peerType PeerType) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	reg, ok := registry[peerType]
+	return reg, ok
+}
+
+// PeerTypes returns the registered peer types, sorted
+func PeerTypes() []PeerType {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	types := make([]PeerType, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// ParsePeerType normalizes a peer type given by a user, defaulting to
+// Postgres, and checks that it is registered
+func ParsePeerType(s string) (PeerType, error) {
+	if s == "" {
+		return PeerTypePostgres, nil
+	}
+	peerType := PeerType(strings.ToUpper(s))
+	if _, ok := Lookup(peerType); !ok {
+		return "", fmt.Errorf("unsupported peer type %q", s)
+	}
+	return peerType, nil
+}
+
+// Validate checks the settings of a peer against its type
+func Validate(peer *Peer) error {
+	reg, ok := Lookup(peer.Type)
+	if !ok {
+		return fmt.Errorf("unsupported peer type %q", peer.Type)
+	}
+	if reg.Validate == nil {
+		return nil
+	}
+	return reg.Validate(peer)
+}
+
+// NewSource connects to a peer as a source
+func NewSource(ctx context.Context, peer *Peer) (Source, error) {
+	reg, ok := Lookup(peer.Type)
+	if !ok {
+		return nil, fmt.Errorf("unsupported peer type %q", peer.Type)
+	}
+	if reg.NewSource == nil {
+		return nil, fmt.Errorf("peer %s of type %s cannot be a source", peer.Name, peer.Type)
+	}
+	return reg.NewSource(ctx, peer)
+}
+
+// NewDestination connects to a peer as a destination
+func NewDestination(ctx context.Context, peer *Peer) (Destination, error) {
+	reg, ok := Lookup(peer.Type)
+	if !ok {
+		return nil, fmt.Errorf("unsupported peer type %q", peer.Type)
+	}
+	if reg.NewDestination == nil {
+		return nil, fmt.Errorf("peer %s of type %s cannot be a destination", peer.Name, peer.Type)
+	}
+	return reg.NewDestination(ctx, peer)
+}
+
+// LoadPeer reads a peer from the catalog
+func LoadPeer(ctx context.Context, catalog *pgxpool.Pool, name string) (*Peer, error) {
+	var peerType, host, username, database, sslMode string
+	var port int
+	var password *string
+	var config map[string]interface{}
+
+	err := catalog.QueryRow(ctx, `
+		SELECT peer_type, host, port, username, password, database, COALESCE(ssl_mode, 'disable'), config
+		FROM bunny_internal.peers WHERE name = $1
+	`, name).Scan(&peerType, &host, &port, &username, &password, &database, &sslMode, &config)
+	if err != nil {
+		return nil, fmt.Errorf("peer not found: %s: %w", name, err)
+	}
+
+	peer := &Peer{
+		Name:     name,
+		Type:     PeerType(peerType),
+		Host:     host,
+		Port:     port,
+		User:     username,
+		Database: database,
+		SSLMode:  sslMode,
+		Config:   config,
+	}
+	if password != nil {
+		peer.Password = *password
+	}
+	return peer, nil
+}
@@ -0,0 +1,426 @@
+package s3
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// File formats
+const (
+	FileFormatParquet = "parquet"
+	FileFormatNDJSON  = "ndjson"
+)
+
+// Columns describing the change of each row, before the columns of the
+// table
+var metaColumns = []fileColumn{
+	{Name: "_bunny_op", TypeOID: pgtype.TextOID},
+	{Name: "_bunny_lsn", TypeOID: pgtype.Int8OID},
+	{Name: "_bunny_commit_lsn", TypeOID: pgtype.Int8OID},
+	{Name: "_bunny_commit_time", TypeOID: pgtype.TimestamptzOID},
+	{Name: "_bunny_xid", TypeOID: pgtype.Int8OID},
+	{Name: "_bunny_unchanged_toast_columns", TypeOID: pgtype.TextOID},
+}
+
+// fileColumn is a column of the rows written to a file
+type fileColumn struct {
+	Name string
+
+	// TypeOID decides the Parquet type; 0 for unknown types, written as
+	// strings
+	TypeOID uint32
+}
+
+// fileColumns returns the columns of the files of a table
+func fileColumns(schema *postgres.TableSchema) []fileColumn {
+	columns := append([]fileColumn(nil), metaColumns...)
+	for _, col := range schema.Columns {
+		columns = append(columns, fileColumn{Name: col.Name, TypeOID: col.TypeOID})
+	}
+	return columns
+}
+
+// metaValues returns the values of the meta columns for a change record
+func metaValues(rec *postgres.CDCRecord) []interface{} {
+	var unchanged interface{}
+	if len(rec.UnchangedToastColumns) > 0 {
+		unchanged = strings.Join(rec.UnchangedToastColumns, ",")
+	}
+	return []interface{}{rec.Operation, rec.LSN, rec.CommitLSN, rec.CommitTime, int64(rec.XID), unchanged}
+}
+
+// fileWriter writes rows of a fixed set of columns to a file
+type fileWriter interface {
+	Write(row []interface{}) error
+
+	// Close finishes the file; it does not close the underlying writer
+	Close() error
+}
+
+// fileExtension returns the extension of the files of a format
+func fileExtension(fileFormat, compression string) string {
+	if fileFormat == FileFormatNDJSON {
+		if compression == "gzip" {
+			return ".ndjson.gz"
+		}
+		return ".ndjson"
+	}
+	return ".parquet"
+}
+
+// contentType returns the media type of the files of a format
+func contentType(fileFormat string) string {
+	if fileFormat == FileFormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "application/vnd.apache.parquet"
+}
+
+// newFileWriter starts a file of a format on w
+func newFileWriter(fileFormat, compression string, w io.Writer, columns []fileColumn) (fileWriter, error) {
+	if fileFormat == FileFormatNDJSON {
+		return newNDJSONWriter(compression, w, columns), nil
+	}
+	return newParquetWriter(compression, w, columns)
+}
+
+// parquetCompression returns the codec of a compression setting
+func parquetCompression(compression string) (parquet.CompressionCodec, error) {
+	switch compression {
+	case "", "snappy":
+		return parquet.CompressionCodec_SNAPPY, nil
+	case "gzip":
+		return parquet.CompressionCodec_GZIP, nil
+	case "zstd":
+		return parquet.CompressionCodec_ZSTD, nil
+	case "none":
+		return parquet.CompressionCodec_UNCOMPRESSED, nil
+	default:
+		return 0, fmt.Errorf("unsupported compression %q for parquet files", compression)
+	}
+}
+
+// parquetWriter writes rows as a Parquet file with one row group per 64 MB
+type parquetWriter struct {
+	pw      *writer.CSVWriter
+	columns []fileColumn
+}
+
+func newParquetWriter(compression string, w io.Writer, columns []fileColumn) (*parquetWriter, error) {
+	md := make([]string, len(columns))
+	for i, col := range columns {
+		if strings.ContainsAny(col.Name, ",=") {
+			return nil, fmt.Errorf("column name %q cannot be written to parquet", col.Name)
+		}
+		md[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", col.Name, parquetType(col.TypeOID))
+	}
+
+	codec, err := parquetCompression(compression)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start parquet file: %w", err)
+	}
+	pw.CompressionType = codec
+	pw.RowGroupSize = 64 * 1024 * 1024
+	return &parquetWriter{pw: pw, columns: columns}, nil
+}
+
+func (w *parquetWriter) Write(row []interface{}) error {
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		pv, err := parquetValue(w.columns[i].TypeOID, v)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", w.columns[i].Name, err)
+		}
+		values[i] = pv
+	}
+	return w.pw.Write(values)
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.pw.WriteStop(); err != nil {
+		return fmt.Errorf("failed to finish parquet file: %w", err)
+	}
+	return nil
+}
+
+// parquetType returns the Parquet type of a Postgres type. Types without a
+// close match are stored as strings in their Postgres text form.
+func parquetType(oid uint32) string {
+	switch oid {
+	case pgtype.BoolOID:
+		return "type=BOOLEAN"
+	case pgtype.Int2OID, pgtype.Int4OID:
+		return "type=INT32"
+	case pgtype.Int8OID, pgtype.OIDOID:
+		return "type=INT64"
+	case pgtype.Float4OID:
+		return "type=FLOAT"
+	case pgtype.Float8OID:
+		return "type=DOUBLE"
+	case pgtype.DateOID:
+		return "type=INT32, convertedtype=DATE"
+	case pgtype.TimestampOID, pgtype.TimestamptzOID:
+		return "type=INT64, convertedtype=TIMESTAMP_MICROS"
+	case pgtype.ByteaOID:
+		return "type=BYTE_ARRAY"
+	default:
+		return "type=BYTE_ARRAY, convertedtype=UTF8"
+	}
+}
+
+// parquetValue converts a value, typed or in Postgres text form, to the Go
+// type parquet-go writes for the Parquet type of its column. Dates and
+// timestamps that are infinite are written as nulls.
+func parquetValue(oid uint32, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	text, isText := v.(string)
+
+	switch oid {
+	case pgtype.BoolOID:
+		if isText {
+			return text == "t" || text == "true", nil
+		}
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+
+	case pgtype.Int2OID, pgtype.Int4OID:
+		n, err := toInt64(v)
+		return int32(n), err
+
+	case pgtype.Int8OID, pgtype.OIDOID:
+		return toInt64(v)
+
+	case pgtype.Float4OID, pgtype.Float8OID:
+		var f float64
+		switch n := v.(type) {
+		case string:
+			parsed, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return nil, err
+			}
+			f = parsed
+		case float32:
+			f = float64(n)
+		case float64:
+			f = n
+		default:
+			return nil, fmt.Errorf("unexpected float value of type %T", v)
+		}
+		if oid == pgtype.Float4OID {
+			return float32(f), nil
+		}
+		return f, nil
+
+	case pgtype.ByteaOID:
+		if isText {
+			b, err := hex.DecodeString(strings.TrimPrefix(text, `\x`))
+			if err != nil {
+				return nil, fmt.Errorf("invalid bytea value: %w", err)
+			}
+			return string(b), nil
+		}
+		if b, ok := v.([]byte); ok {
+			return string(b), nil
+		}
+
+	case pgtype.DateOID:
+		t, ok, err := toTime(v, "2006-01-02")
+		if !ok {
+			return nil, err
+		}
+		return int32(t.Unix() / 86400), nil
+
+	case pgtype.TimestampOID, pgtype.TimestamptzOID:
+		t, ok, err := toTime(v, "2006-01-02 15:04:05.999999", "2006-01-02 15:04:05.999999-07",
+			"2006-01-02 15:04:05.999999-07:00", "2006-01-02 15:04:05.999999-07:00:00")
+		if !ok {
+			return nil, err
+		}
+		return t.UnixMicro(), nil
+
+	default:
+		return textValue(oid, v), nil
+	}
+	return nil, fmt.Errorf("unexpected value of type %T", v)
+}
+
+// toInt64 reads an integer, typed or in text form
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	case int16:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	default:
+		return 0, fmt.Errorf("unexpected integer value of type %T", v)
+	}
+}
+
+// toTime reads a date or timestamp, typed or in Postgres text form in one
+// of the layouts. It reports false without error for infinite values.
+func toTime(v interface{}, layouts ...string) (time.Time, bool, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true, nil
+	case string:
+		if t == "infinity" || t == "-infinity" {
+			return time.Time{}, false, nil
+		}
+		for _, layout := range layouts {
+			if parsed, err := time.Parse(layout, t); err == nil {
+				return parsed, true, nil
+			}
+		}
+		return time.Time{}, false, fmt.Errorf("invalid date/time value %q", t)
+	case pgtype.Date, pgtype.Timestamp, pgtype.Timestamptz:
+		return time.Time{}, false, nil
+	default:
+		return time.Time{}, false, fmt.Errorf("unexpected date/time value of type %T", v)
+	}
+}
+
+// textValue returns the Postgres text form of a value, or nil for a typed
+// NULL
+func textValue(oid uint32, v interface{}) interface{} {
+	switch s := v.(type) {
+	case string:
+		return s
+	case fmt.Stringer:
+		if oid == 0 {
+			return s.String()
+		}
+	}
+	if oid != 0 {
+		buf, err := postgres.EncodeText(oid, v)
+		if err == nil {
+			if buf == nil {
+				return nil
+			}
+			return string(buf)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// ndjsonWriter writes rows as JSON objects, one per line, with the columns
+// in order
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	gz      *gzip.Writer
+	columns []fileColumn
+	names   [][]byte
+}
+
+func newNDJSONWriter(compression string, w io.Writer, columns []fileColumn) *ndjsonWriter {
+	nw := &ndjsonWriter{columns: columns}
+	if compression == "gzip" {
+		nw.gz = gzip.NewWriter(w)
+		w = nw.gz
+	}
+	nw.w = bufio.NewWriter(w)
+	for _, col := range columns {
+		name, _ := json.Marshal(col.Name)
+		nw.names = append(nw.names, name)
+	}
+	return nw
+}
+
+func (w *ndjsonWriter) Write(row []interface{}) error {
+	w.w.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+		value, err := json.Marshal(jsonValue(w.columns[i].TypeOID, v))
+		if err != nil {
+			return fmt.Errorf("column %s: %w", w.columns[i].Name, err)
+		}
+		w.w.Write(w.names[i])
+		w.w.WriteByte(':')
+		w.w.Write(value)
+	}
+	w.w.WriteString("}\n")
+	return nil
+}
+
+func (w *ndjsonWriter) Close() error {
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// jsonValue returns a value as JSON represents it: booleans, numbers and
+// timestamps as such, bytea in base64 and other types in their Postgres
+// text form
+func jsonValue(oid uint32, v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, bool, int16, int32, int64, int, uint32, []byte:
+		return v
+	case float32:
+		return jsonFloat(float64(t))
+	case float64:
+		return jsonFloat(t)
+	case time.Time:
+		if oid == pgtype.DateOID {
+			return t.Format("2006-01-02")
+		}
+		return t
+	case string:
+		switch oid {
+		case pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID, pgtype.OIDOID, pgtype.Float4OID, pgtype.Float8OID:
+			if f, err := strconv.ParseFloat(t, 64); err == nil {
+				return jsonFloat(f)
+			}
+		case pgtype.BoolOID:
+			return t == "t" || t == "true"
+		case pgtype.ByteaOID:
+			if b, err := hex.DecodeString(strings.TrimPrefix(t, `\x`)); err == nil {
+				return b
+			}
+		}
+		return t
+	}
+	return textValue(oid, v)
+}
+
+// jsonFloat returns a float as a JSON number, or as a string for NaN and
+// infinities, which JSON cannot represent
+func jsonFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return f
+}
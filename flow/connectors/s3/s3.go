@@ -0,0 +1,592 @@
+// Package s3 is a destination that writes changes as files to S3 or any
+// S3-compatible object storage, such as MinIO, for data lakes to ingest.
+//
+// The snapshot writes one file per table partition. Changes are held and
+// written as one file per table and commit date when the held changes
+// reach a size or age, followed by a manifest listing the files and the
+// LSN range they cover. Files are Parquet or newline-delimited JSON.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// PeerType is the peer type of S3 peers
+const PeerType connectors.PeerType = "S3"
+
+func init() {
+	connectors.Register(PeerType, connectors.Registration{
+		Validate: func(peer *connectors.Peer) error {
+			_, err := NewConfig(peer)
+			return err
+		},
+		NewDestination: func(ctx context.Context, peer *connectors.Peer) (connectors.Destination, error) {
+			return NewS3Connector(ctx, peer)
+		},
+	})
+}
+
+// Config holds the settings of an S3 peer, from its config
+type Config struct {
+	// Bucket the files are written to
+	Bucket string `json:"bucket"`
+
+	// Prefix is put before the keys of all files, without trailing slash
+	Prefix string `json:"prefix"`
+
+	// Endpoint of the storage as host:port; the peer's host and port, or
+	// AWS S3 if the peer has no host
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region"`
+
+	// PathStyle addresses buckets in the path instead of the host name, as
+	// MinIO and most other S3-compatible stores expect
+	PathStyle bool `json:"path_style"`
+
+	// CreateBucket creates the bucket if it does not exist
+	CreateBucket bool `json:"create_bucket"`
+
+	// FileFormat is parquet (default) or ndjson
+	FileFormat string `json:"file_format"`
+
+	// Compression of parquet files: snappy (default), gzip, zstd or none.
+	// NDJSON files are gzipped with gzip and uncompressed by default.
+	Compression string `json:"compression"`
+
+	// Changes are held until they reach MaxFileSizeMB in memory or the
+	// oldest is RollIntervalSeconds old, then written. Defaults: 128 and
+	// 300.
+	MaxFileSizeMB       int `json:"max_file_size_mb"`
+	RollIntervalSeconds int `json:"roll_interval_seconds"`
+}
+
+// NewConfig reads and checks the settings of an S3 peer
+func NewConfig(peer *connectors.Peer) (*Config, error) {
+	config := &Config{}
+	if err := peer.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+
+	if config.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+	if config.Endpoint == "" {
+		switch {
+		case peer.Host == "":
+			config.Endpoint = "s3.amazonaws.com"
+		case peer.Port != 0:
+			config.Endpoint = fmt.Sprintf("%s:%d", peer.Host, peer.Port)
+		default:
+			config.Endpoint = peer.Host
+		}
+	}
+	if config.MaxFileSizeMB <= 0 {
+		config.MaxFileSizeMB = 128
+	}
+	if config.RollIntervalSeconds <= 0 {
+		config.RollIntervalSeconds = 300
+	}
+
+	switch config.FileFormat {
+	case "":
+		config.FileFormat = FileFormatParquet
+		fallthrough
+	case FileFormatParquet:
+		if _, err := parquetCompression(config.Compression); err != nil {
+			return nil, err
+		}
+	case FileFormatNDJSON:
+		if config.Compression != "" && config.Compression != "none" && config.Compression != "gzip" {
+			return nil, fmt.Errorf("unsupported compression %q for ndjson files", config.Compression)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file_format %q", config.FileFormat)
+	}
+	return config, nil
+}
+
+// segment holds the changes of a table committed on one day, with one
+// set of columns
+type segment struct {
+	table   string
+	date    string
+	version int
+	columns []fileColumn
+	rows    [][]interface{}
+
+	firstLSN int64
+	lastLSN  int64
+}
+
+// partitionFile is a snapshot partition being written to a local file
+// until it is complete
+type partitionFile struct {
+	partition uint32
+	columns   []fileColumn
+	file      *os.File
+	writer    fileWriter
+}
+
+// Manifest lists the files written for a batch of changes
+type Manifest struct {
+	StartLSN  int64          `json:"start_lsn"`
+	EndLSN    int64          `json:"end_lsn"`
+	Records   int            `json:"records"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file of a batch of changes
+type ManifestFile struct {
+	Key     string `json:"key"`
+	Table   string `json:"table"`
+	Records int    `json:"records"`
+	Bytes   int64  `json:"bytes"`
+
+	// LSN range of the changes in the file
+	MinLSN int64 `json:"min_lsn"`
+	MaxLSN int64 `json:"max_lsn"`
+}
+
+// S3Connector writes changes as files to a bucket
+type S3Connector struct {
+	logger  *slog.Logger
+	config  *Config
+	client  *minio.Client
+	created time.Time
+
+	// tables holds the schema of each destination table set up through
+	// EnsureTable, by <schema>.<table>, following the schema changes seen
+	// since
+	tables     map[string]*postgres.TableSchema
+	partitions map[string]*partitionFile
+
+	segments    []*segment
+	heldBytes   int
+	heldSince   time.Time
+	heldRecords int
+	firstLSN    int64
+	receivedLSN int64
+	flushedLSN  int64
+
+	// tableVersion counts the schema changes of each table, which start
+	// new segments
+	tableVersion map[string]int
+}
+
+// NewS3Connector connects to the storage of an S3 peer. The peer's user
+// and password are the access key and secret key; without them,
+// credentials are taken from the AWS environment variables or the instance
+// role.
+func NewS3Connector(ctx context.Context, peer *connectors.Peer) (*S3Connector, error) {
+	config, err := NewConfig(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := credentials.NewStaticV4(peer.User, peer.Password, "")
+	if peer.User == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{&credentials.EnvAWS{}, &credentials.IAM{}})
+	}
+	opts := &minio.Options{
+		Creds:  creds,
+		Secure: peer.SSLMode != "disable",
+		Region: config.Region,
+	}
+	if config.PathStyle {
+		opts.BucketLookup = minio.BucketLookupPath
+	}
+	if peer.SSLMode == "require" {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		opts.Transport = transport
+	}
+
+	client, err := minio.New(config.Endpoint, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach bucket %s at %s: %w", config.Bucket, config.Endpoint, err)
+	}
+	if !exists {
+		if !config.CreateBucket {
+			return nil, fmt.Errorf("bucket %s does not exist", config.Bucket)
+		}
+		if err := client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", config.Bucket, err)
+		}
+	}
+
+	return &S3Connector{
+		logger:       slog.Default().With(slog.String("component", "s3-connector"), slog.String("peer", peer.Name)),
+		config:       config,
+		client:       client,
+		created:      time.Now(),
+		tables:       make(map[string]*postgres.TableSchema),
+		partitions:   make(map[string]*partitionFile),
+		tableVersion: make(map[string]int),
+	}, nil
+}
+
+// Close removes the local files of unfinished snapshot partitions. Held
+// changes are dropped; they were not checkpointed and are written again by
+// the next sync.
+func (c *S3Connector) Close() error {
+	for table, pf := range c.partitions {
+		pf.file.Close()
+		os.Remove(pf.file.Name())
+		delete(c.partitions, table)
+	}
+	return nil
+}
+
+// tablePath returns the key prefix of the files of a destination table
+func (c *S3Connector) tablePath(destSchema, destTable string) string {
+	return path.Join(c.config.Prefix, destSchema, destTable)
+}
+
+// EnsureTable remembers the schema of a table for the files written for
+// it; the bucket needs nothing set up per table
+func (c *S3Connector) EnsureTable(_ context.Context, schema *postgres.TableSchema, destSchema, destTable string, _ postgres.MirrorColumns) error {
+	c.RegisterTable(schema, destSchema, destTable)
+	return nil
+}
+
+// RegisterTable remembers the schema of a table for the files written for
+// it
+func (c *S3Connector) RegisterTable(schema *postgres.TableSchema, destSchema, destTable string) {
+	c.tables[destSchema+"."+destTable] = schema
+}
+
+// TruncateTable removes the snapshot files of a table written before this
+// connection was opened, so the partitions of the snapshot being copied
+// are kept. Files of changes are kept: they are a log.
+func (c *S3Connector) TruncateTable(ctx context.Context, destSchema, destTable string) error {
+	prefix := c.tablePath(destSchema, destTable) + "/snapshot/"
+
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for obj := range c.client.ListObjects(ctx, c.config.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if obj.Err != nil || obj.LastModified.Before(c.created) {
+				objects <- obj
+			}
+		}
+	}()
+
+	removed := 0
+	for obj := range objects {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list snapshot files of %s.%s: %w", destSchema, destTable, obj.Err)
+		}
+		if err := c.client.RemoveObject(ctx, c.config.Bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", obj.Key, err)
+		}
+		removed++
+	}
+	c.logger.Info("removed earlier snapshot files", slog.String("prefix", prefix), slog.Int("files", removed))
+	return nil
+}
+
+// StartPartition starts the local file a snapshot partition is written to
+func (c *S3Connector) StartPartition(_ context.Context, destSchema, destTable string, partition uint32) error {
+	table := destSchema + "." + destTable
+	schema := c.tables[table]
+	if schema == nil {
+		return fmt.Errorf("table %s was not set up before its snapshot", table)
+	}
+	if pf := c.partitions[table]; pf != nil {
+		return fmt.Errorf("partition %d of %s is still being written", pf.partition, table)
+	}
+
+	file, err := os.CreateTemp("", "bunny-s3-*")
+	if err != nil {
+		return fmt.Errorf("failed to create local file for %s: %w", table, err)
+	}
+	columns := fileColumns(schema)
+	w, err := newFileWriter(c.config.FileFormat, c.config.Compression, file, columns)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	c.partitions[table] = &partitionFile{partition: partition, columns: columns, file: file, writer: w}
+	return nil
+}
+
+// BulkLoad writes rows read by the snapshot to the file of the current
+// partition, starting partition 0 if none was started
+func (c *S3Connector) BulkLoad(ctx context.Context, destSchema, destTable string, columns []string, rows [][]interface{}) error {
+	table := destSchema + "." + destTable
+	if c.partitions[table] == nil {
+		if err := c.StartPartition(ctx, destSchema, destTable, 0); err != nil {
+			return err
+		}
+	}
+	pf := c.partitions[table]
+
+	index := make(map[string]int, len(columns))
+	for i, col := range columns {
+		index[col] = i
+	}
+	for _, values := range rows {
+		row := make([]interface{}, len(pf.columns))
+		row[0] = "SNAPSHOT"
+		for i := len(metaColumns); i < len(pf.columns); i++ {
+			if j, ok := index[pf.columns[i].Name]; ok {
+				row[i] = values[j]
+			}
+		}
+		if err := pf.writer.Write(row); err != nil {
+			return fmt.Errorf("failed to write snapshot row of %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// FinishPartition completes the file of a snapshot partition and uploads
+// it as snapshot/part-<partition>, replacing an earlier copy
+func (c *S3Connector) FinishPartition(ctx context.Context, destSchema, destTable string) error {
+	table := destSchema + "." + destTable
+	pf := c.partitions[table]
+	if pf == nil {
+		return nil
+	}
+	delete(c.partitions, table)
+	defer os.Remove(pf.file.Name())
+	defer pf.file.Close()
+
+	if err := pf.writer.Close(); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/snapshot/part-%05d%s", c.tablePath(destSchema, destTable), pf.partition,
+		fileExtension(c.config.FileFormat, c.config.Compression))
+	info, err := c.client.FPutObject(ctx, c.config.Bucket, key, pf.file.Name(),
+		minio.PutObjectOptions{ContentType: contentType(c.config.FileFormat)})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	c.logger.Info("wrote snapshot file", slog.String("key", key), slog.Int64("bytes", info.Size))
+	return nil
+}
+
+// ApplyChanges adds the records of source transactions to the held
+// changes, and writes them if they are due. A schema change starts new
+// files for its table.
+func (c *S3Connector) ApplyChanges(ctx context.Context, txns []*postgres.CDCTransaction, targets map[string]*postgres.ApplyTarget) error {
+	for _, txn := range txns {
+		// The chunks of a spooled transaction are written as they add up
+		err := txn.Chunks(func() error {
+			for _, rec := range txn.Records {
+				target := postgres.ApplyTargetFor(targets, rec)
+				table := target.Schema + "." + target.Table
+
+				if rec.Operation == "SCHEMA" {
+					if rec.SchemaDelta != nil {
+						c.followSchema(table, rec.SchemaDelta)
+					}
+					continue
+				}
+				c.hold(table, rec)
+			}
+			if txn.Spooled() {
+				return c.Flush(ctx, false)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		c.receivedLSN = txn.EndLSN
+	}
+	return c.Flush(ctx, false)
+}
+
+// followSchema applies a schema change to the columns of the files of a
+// table. Columns of changed types are written as strings from then on.
+func (c *S3Connector) followSchema(table string, delta *postgres.SchemaDelta) {
+	schema := c.tables[table]
+	if schema == nil {
+		return
+	}
+	c.tables[table] = delta.ApplyTo(schema)
+	c.tableVersion[table]++
+}
+
+// hold adds a change to the segment of its table and commit date
+func (c *S3Connector) hold(table string, rec *postgres.CDCRecord) {
+	date := rec.CommitTime.UTC().Format("2006-01-02")
+	version := c.tableVersion[table]
+	var seg *segment
+	for _, s := range c.segments {
+		if s.table == table && s.date == date && s.version == version {
+			seg = s
+			break
+		}
+	}
+	if seg == nil {
+		seg = &segment{table: table, date: date, version: version, columns: c.columnsOf(table, rec), firstLSN: rec.LSN}
+		c.segments = append(c.segments, seg)
+	}
+
+	values := rec.NewValues
+	if rec.Operation == "DELETE" {
+		values = rec.OldValues
+	}
+	row := append(metaValues(rec), make([]interface{}, len(seg.columns)-len(metaColumns))...)
+	for i := len(metaColumns); i < len(seg.columns); i++ {
+		v := values[seg.columns[i].Name]
+		row[i] = v
+		if s, ok := v.(string); ok {
+			c.heldBytes += len(s)
+		} else {
+			c.heldBytes += 8
+		}
+	}
+	seg.rows = append(seg.rows, row)
+	seg.lastLSN = rec.LSN
+
+	if c.heldRecords == 0 {
+		c.heldSince = time.Now()
+		c.firstLSN = rec.LSN
+	}
+	c.heldRecords++
+}
+
+// columnsOf returns the current columns of the files of a table, from the
+// record's columns for tables not set up through EnsureTable
+func (c *S3Connector) columnsOf(table string, rec *postgres.CDCRecord) []fileColumn {
+	if schema := c.tables[table]; schema != nil {
+		return fileColumns(schema)
+	}
+	columns := append([]fileColumn(nil), metaColumns...)
+	for _, name := range rec.Columns {
+		columns = append(columns, fileColumn{Name: name})
+	}
+	return columns
+}
+
+// ApplySchemaDelta does nothing: schema changes are followed as they are
+// read from the change stream
+func (c *S3Connector) ApplySchemaDelta(_ context.Context, _ *postgres.SchemaDelta) error {
+	return nil
+}
+
+// Flush writes the held changes once they reach max_file_size_mb or are
+// roll_interval_seconds old, or right away if all is set
+func (c *S3Connector) Flush(ctx context.Context, all bool) error {
+	if c.heldRecords == 0 {
+		c.flushedLSN = c.receivedLSN
+		return nil
+	}
+	due := c.heldBytes >= c.config.MaxFileSizeMB*1024*1024 ||
+		time.Since(c.heldSince) >= time.Duration(c.config.RollIntervalSeconds)*time.Second
+	if !all && !due {
+		return nil
+	}
+
+	// Changes of a spooled transaction are written before it has been
+	// received whole
+	endLSN := c.receivedLSN
+	for _, seg := range c.segments {
+		endLSN = max(endLSN, seg.lastLSN)
+	}
+	manifest := &Manifest{
+		StartLSN:  c.firstLSN,
+		EndLSN:    endLSN,
+		Records:   c.heldRecords,
+		CreatedAt: time.Now().UTC(),
+	}
+	ext := fileExtension(c.config.FileFormat, c.config.Compression)
+	for _, seg := range c.segments {
+		schema, table, _ := strings.Cut(seg.table, ".")
+		key := fmt.Sprintf("%s/changes/date=%s/%016X-%016X%s", c.tablePath(schema, table), seg.date, seg.firstLSN, seg.lastLSN, ext)
+		size, err := c.writeSegment(ctx, key, seg)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Key:     key,
+			Table:   seg.table,
+			Records: len(seg.rows),
+			Bytes:   size,
+			MinLSN:  seg.firstLSN,
+			MaxLSN:  seg.lastLSN,
+		})
+	}
+
+	// The manifest is written last: files without one belong to a batch
+	// that was not completed and is written again
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	key := fmt.Sprintf("%s/%016X-%016X.json", path.Join(c.config.Prefix, "_manifests"), manifest.StartLSN, manifest.EndLSN)
+	if _, err := c.client.PutObject(ctx, c.config.Bucket, key, bytes.NewReader(body), int64(len(body)),
+		minio.PutObjectOptions{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("failed to upload manifest %s: %w", key, err)
+	}
+
+	c.logger.Info("wrote change files",
+		slog.String("manifest", key),
+		slog.Int("files", len(manifest.Files)),
+		slog.Int("records", manifest.Records))
+
+	c.segments = nil
+	c.heldBytes = 0
+	c.heldRecords = 0
+	c.flushedLSN = c.receivedLSN
+	return nil
+}
+
+// writeSegment writes the changes of a segment as one file and returns its
+// size
+func (c *S3Connector) writeSegment(ctx context.Context, key string, seg *segment) (int64, error) {
+	var buf bytes.Buffer
+	w, err := newFileWriter(c.config.FileFormat, c.config.Compression, &buf, seg.columns)
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range seg.rows {
+		if err := w.Write(row); err != nil {
+			return 0, fmt.Errorf("failed to write change of %s: %w", seg.table, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+
+	size := int64(buf.Len())
+	if _, err := c.client.PutObject(ctx, c.config.Bucket, key, &buf, size,
+		minio.PutObjectOptions{ContentType: contentType(c.config.FileFormat)}); err != nil {
+		return 0, fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return size, nil
+}
+
+// FlushedLSN returns the end LSN of the last transaction whose changes are
+// in files listed by a manifest
+func (c *S3Connector) FlushedLSN() int64 {
+	return c.flushedLSN
+}
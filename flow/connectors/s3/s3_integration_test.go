@@ -0,0 +1,272 @@
+//go:build integration
+
+package s3
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// The MinIO server of the s3 profile of docker-compose.yml, unless
+// BUNNY_TEST_S3_ENDPOINT is set:
+//
+//	docker compose --profile s3 up -d minio
+//	go test -tags integration ./connectors/s3/
+func testPeer(prefix string) *connectors.Peer {
+	endpoint := os.Getenv("BUNNY_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "localhost:19000"
+	}
+	return &connectors.Peer{
+		Name:     "s3_test",
+		Type:     PeerType,
+		User:     "minioadmin",
+		Password: "minioadmin",
+		SSLMode:  "disable",
+		Config: map[string]interface{}{
+			"bucket":        "bunny-test",
+			"prefix":        prefix,
+			"endpoint":      endpoint,
+			"path_style":    true,
+			"create_bucket": true,
+			"file_format":   FileFormatNDJSON,
+		},
+	}
+}
+
+func TestFlushWritesFilesAndManifestWithLSNRanges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	prefix := fmt.Sprintf("bunnytest%d", time.Now().UnixNano())
+	conn, err := NewS3Connector(ctx, testPeer(prefix))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	defer func() {
+		for obj := range conn.client.ListObjects(context.Background(), conn.config.Bucket, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true}) {
+			_ = conn.client.RemoveObject(context.Background(), conn.config.Bucket, obj.Key, minio.RemoveObjectOptions{})
+		}
+	}()
+
+	for _, table := range []string{"orders", "items"} {
+		schema := &postgres.TableSchema{
+			SchemaName:        "public",
+			TableName:         table,
+			Columns:           []postgres.ColumnDefinition{{Name: "id", Type: "bigint", TypeOID: pgtype.Int8OID}},
+			PrimaryKeyColumns: []string{"id"},
+		}
+		if err := conn.EnsureTable(ctx, schema, "public", table, postgres.MirrorColumns{}); err != nil {
+			t.Fatalf("failed to set up %s: %v", table, err)
+		}
+	}
+
+	commitTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	change := func(table, op string, lsn, id int64) *postgres.CDCRecord {
+		rec := &postgres.CDCRecord{Operation: op, Schema: "public", Table: table, LSN: lsn, Columns: []string{"id"}}
+		if op == "DELETE" {
+			rec.OldValues = map[string]interface{}{"id": id}
+		} else {
+			rec.NewValues = map[string]interface{}{"id": id}
+		}
+		return rec
+	}
+	txns := []*postgres.CDCTransaction{
+		{XID: 10, CommitLSN: 0x1100, EndLSN: 0x1108, Records: []*postgres.CDCRecord{
+			change("orders", "INSERT", 0x1000, 1),
+			change("items", "INSERT", 0x1010, 7),
+		}},
+		{XID: 11, CommitLSN: 0x2100, EndLSN: 0x2108, Records: []*postgres.CDCRecord{
+			change("orders", "UPDATE", 0x2000, 1),
+			change("orders", "DELETE", 0x2010, 1),
+		}},
+	}
+	for _, txn := range txns {
+		txn.CommitTime = commitTime
+		for _, rec := range txn.Records {
+			rec.XID, rec.CommitLSN, rec.CommitTime = txn.XID, txn.CommitLSN, commitTime
+		}
+	}
+
+	if err := conn.ApplyChanges(ctx, txns, nil); err != nil {
+		t.Fatalf("failed to hold changes: %v", err)
+	}
+	if err := conn.Flush(ctx, true); err != nil {
+		t.Fatalf("failed to write changes: %v", err)
+	}
+	if got := conn.FlushedLSN(); got != 0x2108 {
+		t.Errorf("flushed LSN = %X, want 2108", got)
+	}
+
+	var manifestKeys []string
+	for obj := range conn.client.ListObjects(ctx, conn.config.Bucket, minio.ListObjectsOptions{Prefix: prefix + "/_manifests/"}) {
+		if obj.Err != nil {
+			t.Fatalf("failed to list manifests: %v", obj.Err)
+		}
+		manifestKeys = append(manifestKeys, obj.Key)
+	}
+	wantKey := prefix + "/_manifests/0000000000001000-0000000000002108.json"
+	if len(manifestKeys) != 1 || manifestKeys[0] != wantKey {
+		t.Fatalf("manifests = %v, want [%s]", manifestKeys, wantKey)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(readObject(ctx, t, conn, wantKey), &manifest); err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	if manifest.StartLSN != 0x1000 || manifest.EndLSN != 0x2108 || manifest.Records != 4 {
+		t.Errorf("manifest covers %X-%X with %d records, want 1000-2108 with 4",
+			manifest.StartLSN, manifest.EndLSN, manifest.Records)
+	}
+
+	want := map[string]ManifestFile{
+		"public.orders": {
+			Key:     prefix + "/public/orders/changes/date=2026-03-01/0000000000001000-0000000000002010.ndjson",
+			Records: 3, MinLSN: 0x1000, MaxLSN: 0x2010,
+		},
+		"public.items": {
+			Key:     prefix + "/public/items/changes/date=2026-03-01/0000000000001010-0000000000001010.ndjson",
+			Records: 1, MinLSN: 0x1010, MaxLSN: 0x1010,
+		},
+	}
+	if len(manifest.Files) != len(want) {
+		t.Fatalf("manifest lists %d files, want %d", len(manifest.Files), len(want))
+	}
+	for _, f := range manifest.Files {
+		w, ok := want[f.Table]
+		if !ok {
+			t.Errorf("unexpected file for table %s", f.Table)
+			continue
+		}
+		if f.Key != w.Key || f.Records != w.Records || f.MinLSN != w.MinLSN || f.MaxLSN != w.MaxLSN {
+			t.Errorf("file of %s = %s with %d records in %X-%X, want %s with %d in %X-%X", f.Table,
+				f.Key, f.Records, f.MinLSN, f.MaxLSN, w.Key, w.Records, w.MinLSN, w.MaxLSN)
+		}
+
+		// The rows of the file stay within its LSN range, in order
+		var lsns []int64
+		scanner := bufio.NewScanner(strings.NewReader(string(readObject(ctx, t, conn, f.Key))))
+		for scanner.Scan() {
+			var row struct {
+				LSN int64 `json:"_bunny_lsn"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("failed to parse row of %s: %v", f.Key, err)
+			}
+			lsns = append(lsns, row.LSN)
+		}
+		if len(lsns) != f.Records {
+			t.Errorf("%s has %d rows, manifest says %d", f.Key, len(lsns), f.Records)
+		}
+		for i, lsn := range lsns {
+			if lsn < f.MinLSN || lsn > f.MaxLSN || (i > 0 && lsn <= lsns[i-1]) {
+				t.Errorf("%s row %d has LSN %X outside %X-%X or out of order", f.Key, i, lsn, f.MinLSN, f.MaxLSN)
+			}
+		}
+	}
+}
+
+func TestSnapshotPartitionsAreWrittenOnTheirOwnConnections(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	prefix := fmt.Sprintf("bunnytest%d", time.Now().UnixNano())
+	schema := &postgres.TableSchema{
+		SchemaName:        "public",
+		TableName:         "orders",
+		Columns:           []postgres.ColumnDefinition{{Name: "id", Type: "bigint", TypeOID: pgtype.Int8OID}},
+		PrimaryKeyColumns: []string{"id"},
+	}
+
+	// As the snapshot copies them, partition 0 sets the table up and the
+	// others only learn its schema
+	var conns []*S3Connector
+	for partition := 0; partition < 3; partition++ {
+		conn, err := NewS3Connector(ctx, testPeer(prefix))
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+
+		if partition == 0 {
+			if err := conn.EnsureTable(ctx, schema, "public", "orders", postgres.MirrorColumns{}); err != nil {
+				t.Fatalf("failed to set up table: %v", err)
+			}
+			if err := conn.TruncateTable(ctx, "public", "orders"); err != nil {
+				t.Fatalf("failed to truncate table: %v", err)
+			}
+		} else {
+			conn.RegisterTable(schema, "public", "orders")
+		}
+	}
+	defer func() {
+		for obj := range conns[0].client.ListObjects(context.Background(), conns[0].config.Bucket, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true}) {
+			_ = conns[0].client.RemoveObject(context.Background(), conns[0].config.Bucket, obj.Key, minio.RemoveObjectOptions{})
+		}
+	}()
+
+	for partition, conn := range conns {
+		if err := conn.StartPartition(ctx, "public", "orders", uint32(partition)); err != nil {
+			t.Fatalf("failed to start partition %d: %v", partition, err)
+		}
+		rows := [][]interface{}{{int64(partition*10 + 1)}, {int64(partition*10 + 2)}}
+		if err := conn.BulkLoad(ctx, "public", "orders", []string{"id"}, rows); err != nil {
+			t.Fatalf("failed to load partition %d: %v", partition, err)
+		}
+		if err := conn.FinishPartition(ctx, "public", "orders"); err != nil {
+			t.Fatalf("failed to finish partition %d: %v", partition, err)
+		}
+	}
+
+	conn := conns[0]
+	for partition := 0; partition < len(conns); partition++ {
+		key := fmt.Sprintf("%s/public/orders/snapshot/part-%05d%s", prefix, partition,
+			fileExtension(conn.config.FileFormat, conn.config.Compression))
+		var ids []int64
+		scanner := bufio.NewScanner(strings.NewReader(string(readObject(ctx, t, conn, key))))
+		for scanner.Scan() {
+			var row struct {
+				ID int64 `json:"id"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("failed to parse row of %s: %v", key, err)
+			}
+			ids = append(ids, row.ID)
+		}
+		want := []int64{int64(partition*10 + 1), int64(partition*10 + 2)}
+		if !slices.Equal(ids, want) {
+			t.Errorf("%s has ids %v, want %v", key, ids, want)
+		}
+	}
+}
+
+// readObject returns the content of an object of the test bucket
+func readObject(ctx context.Context, t *testing.T, conn *S3Connector, key string) []byte {
+	t.Helper()
+	obj, err := conn.client.GetObject(ctx, conn.config.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", key, err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", key, err)
+	}
+	return data
+}
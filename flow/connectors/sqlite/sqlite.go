@@ -0,0 +1,598 @@
+// Package sqlite is a destination that mirrors tables into a SQLite
+// database file, for a local copy of a few tables to query without a
+// Postgres server, and for running mirrors end to end in tests.
+//
+// The driver is pure Go, so the file destination works in binaries built
+// without cgo. There is no DuckDB destination, as its drivers need cgo;
+// DuckDB can still query the SQLite file through its sqlite extension.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// PeerType is the peer type of SQLite peers
+const PeerType connectors.PeerType = "SQLITE"
+
+func init() {
+	connectors.Register(PeerType, connectors.Registration{
+		Validate: func(peer *connectors.Peer) error {
+			_, err := NewConfig(peer)
+			return err
+		},
+		NewDestination: func(ctx context.Context, peer *connectors.Peer) (connectors.Destination, error) {
+			return NewSQLiteConnector(ctx, peer)
+		},
+	})
+}
+
+// Config holds the settings of a SQLite peer, from its config
+type Config struct {
+	// TypeMapping replaces the declared SQLite type of Postgres types, by
+	// type name without modifiers, e.g. {"numeric": "TEXT"}
+	TypeMapping map[string]string `json:"type_mapping"`
+
+	// BusyTimeoutMs is how long a write waits for another connection to
+	// the file to finish its own. Default: 5000.
+	BusyTimeoutMs int `json:"busy_timeout_ms"`
+}
+
+// NewConfig reads and checks the settings of a SQLite peer
+func NewConfig(peer *connectors.Peer) (*Config, error) {
+	config := &Config{}
+	if err := peer.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+
+	if peer.Database == "" {
+		return nil, errors.New("database is required, as the path of the database file")
+	}
+	if config.BusyTimeoutMs == 0 {
+		config.BusyTimeoutMs = 5000
+	}
+	return config, nil
+}
+
+// SQLiteConnector writes changes to a SQLite database file
+type SQLiteConnector struct {
+	logger *slog.Logger
+	config *Config
+	db     *sql.DB
+
+	// oids holds the Postgres types of the columns of each destination
+	// table set up through EnsureTable, by <schema>.<table>
+	oids map[string]map[string]uint32
+}
+
+// NewSQLiteConnector opens the database file of a SQLite peer, creating it
+// and its directory if missing. The file is written in WAL mode, so it can
+// be read while changes are applied.
+func NewSQLiteConnector(ctx context.Context, peer *connectors.Peer) (*SQLiteConnector, error) {
+	config, err := NewConfig(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	path := peer.Database
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create directory of %s: %w", path, err)
+		}
+	}
+	dsn := fmt.Sprintf("%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)",
+		path, config.BusyTimeoutMs)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	// SQLite has one writer per file
+	db.SetMaxOpenConns(1)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	return &SQLiteConnector{
+		logger: slog.Default().With(slog.String("component", "sqlite-connector"), slog.String("peer", peer.Name)),
+		config: config,
+		db:     db,
+		oids:   make(map[string]map[string]uint32),
+	}, nil
+}
+
+// Close closes the database file
+func (c *SQLiteConnector) Close() error {
+	return c.db.Close()
+}
+
+// quoteIdentifier quotes a SQLite identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteIdentifiers(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdentifier(name)
+	}
+	return quoted
+}
+
+// tableName returns the quoted name of a destination table. SQLite has no
+// schemas: tables of public keep their name, and tables of other schemas
+// are named <schema>_<table>.
+func tableName(destSchema, destTable string) string {
+	return quoteIdentifier(unquotedTableName(destSchema, destTable))
+}
+
+func unquotedTableName(destSchema, destTable string) string {
+	if destSchema == "" || destSchema == "public" {
+		return destTable
+	}
+	return destSchema + "_" + destTable
+}
+
+// nowExpr is the current time in the layout of timestamptz values
+const nowExpr = "strftime('%Y-%m-%d %H:%M:%fZ', 'now')"
+
+// mirrorDefinitions renders the mirror columns for CREATE TABLE, or for
+// ALTER TABLE, which only takes constant defaults
+func mirrorDefinitions(mirrorCols postgres.MirrorColumns, alter bool) map[string]string {
+	defs := make(map[string]string)
+	if mirrorCols.SoftDelete != "" {
+		defs[mirrorCols.SoftDelete] = "INTEGER NOT NULL DEFAULT 0"
+	}
+	if mirrorCols.SyncedAt != "" {
+		defs[mirrorCols.SyncedAt] = "TEXT DEFAULT (" + nowExpr + ")"
+		if alter {
+			defs[mirrorCols.SyncedAt] = "TEXT"
+		}
+	}
+	return defs
+}
+
+// EnsureTable creates the table a source table is mirrored into, with the
+// declared types of its columns mapped to SQLite. Column defaults are not
+// carried over. The mirror columns are also added to a table that already
+// exists.
+func (c *SQLiteConnector) EnsureTable(ctx context.Context, schema *postgres.TableSchema, destSchema, destTable string, mirrorCols postgres.MirrorColumns) error {
+	if mirrorCols.History {
+		return errors.New("SQLite destinations do not support history tables")
+	}
+	for _, name := range mirrorCols.Names() {
+		for _, col := range schema.Columns {
+			if col.Name == name {
+				return fmt.Errorf("mirror column %s clashes with a column of %s.%s", name, schema.SchemaName, schema.TableName)
+			}
+		}
+	}
+
+	var columnDefs []string
+	for _, col := range schema.Columns {
+		colDef := quoteIdentifier(col.Name) + " " + sqliteType(col.Type, c.config.TypeMapping)
+		if !col.Nullable {
+			colDef += " NOT NULL"
+		}
+		columnDefs = append(columnDefs, colDef)
+	}
+	mirrorDefs := mirrorDefinitions(mirrorCols, false)
+	for _, name := range mirrorCols.Names() {
+		columnDefs = append(columnDefs, quoteIdentifier(name)+" "+mirrorDefs[name])
+	}
+	if len(schema.PrimaryKeyColumns) > 0 {
+		columnDefs = append(columnDefs, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(quoteIdentifiers(schema.PrimaryKeyColumns), ", ")))
+	}
+
+	name := tableName(destSchema, destTable)
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n)", name, strings.Join(columnDefs, ",\n  "))
+	if _, err := c.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create table %s.%s: %w", destSchema, destTable, err)
+	}
+
+	existing, err := columnNames(ctx, c.db, destSchema, destTable)
+	if err != nil {
+		return err
+	}
+	alterDefs := mirrorDefinitions(mirrorCols, true)
+	for _, colName := range mirrorCols.Names() {
+		if existing[colName] {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", name, quoteIdentifier(colName), alterDefs[colName])
+		if _, err := c.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to add mirror column %s: %w", colName, err)
+		}
+	}
+
+	c.RegisterTable(schema, destSchema, destTable)
+	return nil
+}
+
+// RegisterTable remembers the Postgres types of the columns of a table,
+// which values are converted by
+func (c *SQLiteConnector) RegisterTable(schema *postgres.TableSchema, destSchema, destTable string) {
+	oids := make(map[string]uint32, len(schema.Columns))
+	for _, col := range schema.Columns {
+		oids[col.Name] = col.TypeOID
+	}
+	c.oids[destSchema+"."+destTable] = oids
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// columnNames returns the names of the columns of a destination table
+func columnNames(ctx context.Context, db querier, destSchema, destTable string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", unquotedTableName(destSchema, destTable))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s.%s: %w", destSchema, destTable, err)
+	}
+	defer rows.Close()
+	names := make(map[string]bool)
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		names[col] = true
+	}
+	return names, rows.Err()
+}
+
+// TruncateTable removes the rows of a destination table
+func (c *SQLiteConnector) TruncateTable(ctx context.Context, destSchema, destTable string) error {
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM "+tableName(destSchema, destTable)); err != nil {
+		return fmt.Errorf("failed to truncate %s.%s: %w", destSchema, destTable, err)
+	}
+	return nil
+}
+
+// values converts the values of columns of a destination table
+func (c *SQLiteConnector) values(destSchema, destTable string, columns []string, values []interface{}) ([]interface{}, error) {
+	oids := c.oids[destSchema+"."+destTable]
+	converted := make([]interface{}, len(values))
+	for i, v := range values {
+		value, err := sqliteValue(oids[columns[i]], v)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", columns[i], err)
+		}
+		converted[i] = value
+	}
+	return converted, nil
+}
+
+// BulkLoad inserts rows read by the snapshot in one transaction, replacing
+// rows of the same key so a partition copied again does not fail
+func (c *SQLiteConnector) BulkLoad(ctx context.Context, destSchema, destTable string, columns []string, rows [][]interface{}) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	query := fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (%s)",
+		tableName(destSchema, destTable), strings.Join(quoteIdentifiers(columns), ", "), placeholders)
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		args, err := c.values(destSchema, destTable, columns, row)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ApplyChanges applies each source transaction in its own destination
+// transaction, schema changes included. A record that fails rolls back its
+// transaction and stops the batch.
+func (c *SQLiteConnector) ApplyChanges(ctx context.Context, txns []*postgres.CDCTransaction, targets map[string]*postgres.ApplyTarget) error {
+	for _, txn := range txns {
+		if txn.NumRecords() == 0 {
+			continue
+		}
+		tx, err := c.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin destination transaction: %w", err)
+		}
+		// A spooled transaction is applied a chunk at a time
+		err = txn.Chunks(func() error {
+			for _, rec := range txn.Records {
+				if err := c.applyRecord(ctx, tx, rec, postgres.ApplyTargetFor(targets, rec)); err != nil {
+					return fmt.Errorf("%s on %s.%s at LSN %d failed: %w", rec.Operation, rec.Schema, rec.Table, rec.LSN, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit destination transaction (xid %d): %w", txn.XID, err)
+		}
+	}
+	return nil
+}
+
+// applyRecord dispatches a CDC record to the matching apply function
+func (c *SQLiteConnector) applyRecord(ctx context.Context, db querier, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	if target.Changelog || target.History {
+		return errors.New("SQLite destinations do not support changelog or history tables")
+	}
+
+	switch rec.Operation {
+	case "INSERT":
+		return c.applyInsert(ctx, db, rec, target)
+	case "UPDATE":
+		return c.applyUpdate(ctx, db, rec, target)
+	case "DELETE":
+		return c.applyDelete(ctx, db, rec, target)
+	case "TRUNCATE":
+		return c.applyTruncate(ctx, db, target)
+	case "SCHEMA":
+		if rec.SchemaDelta == nil {
+			return nil
+		}
+		rec.SchemaDelta.DestinationTable = target.Schema + "." + target.Table
+		return c.alterTable(ctx, db, target.Schema, target.Table, rec.SchemaDelta)
+	default:
+		return fmt.Errorf("unknown operation: %s", rec.Operation)
+	}
+}
+
+// recordColumns returns the columns of a record that have a value in
+// values, in table order
+func recordColumns(rec *postgres.CDCRecord, values map[string]interface{}) ([]string, []interface{}) {
+	columns := make([]string, 0, len(values))
+	args := make([]interface{}, 0, len(values))
+	for _, col := range rec.Columns {
+		if v, ok := values[col]; ok {
+			columns = append(columns, col)
+			args = append(args, v)
+		}
+	}
+	return columns, args
+}
+
+// mirrorSets returns the assignments that maintain the mirror columns of a
+// row being written, or soft deleted if deleted is set
+func mirrorSets(target *postgres.ApplyTarget, deleted bool) []string {
+	var sets []string
+	if target.SoftDeleteColumn != "" {
+		flag := 0
+		if deleted {
+			flag = 1
+		}
+		sets = append(sets, fmt.Sprintf("%s = %d", quoteIdentifier(target.SoftDeleteColumn), flag))
+	}
+	if target.SyncedAtColumn != "" {
+		sets = append(sets, quoteIdentifier(target.SyncedAtColumn)+" = "+nowExpr)
+	}
+	return sets
+}
+
+// applyInsert inserts a row, or updates the row of the same key
+func (c *SQLiteConnector) applyInsert(ctx context.Context, db querier, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	columns, values := recordColumns(rec, rec.NewValues)
+	if len(columns) == 0 {
+		return nil
+	}
+	args, err := c.values(target.Schema, target.Table, columns, values)
+	if err != nil {
+		return err
+	}
+
+	cols := quoteIdentifiers(columns)
+	exprs := make([]string, len(columns))
+	for i := range exprs {
+		exprs[i] = "?"
+	}
+	if target.SoftDeleteColumn != "" {
+		cols = append(cols, quoteIdentifier(target.SoftDeleteColumn))
+		exprs = append(exprs, "0")
+	}
+	if target.SyncedAtColumn != "" {
+		cols = append(cols, quoteIdentifier(target.SyncedAtColumn))
+		exprs = append(exprs, nowExpr)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		tableName(target.Schema, target.Table), strings.Join(cols, ", "), strings.Join(exprs, ", "))
+	if len(target.PKColumns) > 0 {
+		updates := make([]string, len(cols))
+		for i, col := range cols {
+			updates[i] = fmt.Sprintf("%s = excluded.%s", col, col)
+		}
+		query += fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s",
+			strings.Join(quoteIdentifiers(target.PKColumns), ", "), strings.Join(updates, ", "))
+	}
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// rowMatch returns the condition finding the old row of a record, on its
+// key columns or, for IdentityFull tables without key, on the whole row
+func (c *SQLiteConnector) rowMatch(rec *postgres.CDCRecord, target *postgres.ApplyTarget) (string, []interface{}, error) {
+	sourceValues := rec.OldValues
+	if sourceValues == nil {
+		sourceValues = rec.NewValues
+	}
+
+	var columns []string
+	var values []interface{}
+	for _, pk := range target.PKColumns {
+		if v, ok := sourceValues[pk]; ok {
+			columns = append(columns, pk)
+			values = append(values, v)
+		}
+	}
+	fullRow := len(columns) == 0
+	if fullRow {
+		if !target.IdentityFull || rec.OldValues == nil {
+			return "", nil, fmt.Errorf("no primary key columns found for %s", rec.Operation)
+		}
+		columns, values = recordColumns(rec, rec.OldValues)
+	}
+
+	args, err := c.values(target.Schema, target.Table, columns, values)
+	if err != nil {
+		return "", nil, err
+	}
+	conds := make([]string, len(columns))
+	for i, col := range columns {
+		// IS matches NULLs too
+		conds[i] = quoteIdentifier(col) + " IS ?"
+	}
+	where := strings.Join(conds, " AND ")
+	if fullRow {
+		where = fmt.Sprintf("rowid = (SELECT rowid FROM %s WHERE %s LIMIT 1)", tableName(target.Schema, target.Table), where)
+	}
+	return where, args, nil
+}
+
+// applyUpdate updates a row. Unchanged TOAST columns are not in NewValues,
+// so the row keeps its current value for them.
+func (c *SQLiteConnector) applyUpdate(ctx context.Context, db querier, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	columns, values := recordColumns(rec, rec.NewValues)
+	if len(columns) == 0 {
+		return nil
+	}
+	args, err := c.values(target.Schema, target.Table, columns, values)
+	if err != nil {
+		return err
+	}
+	sets := make([]string, 0, len(columns)+2)
+	for _, col := range columns {
+		sets = append(sets, quoteIdentifier(col)+" = ?")
+	}
+	sets = append(sets, mirrorSets(target, false)...)
+
+	where, whereArgs, err := c.rowMatch(rec, target)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		tableName(target.Schema, target.Table), strings.Join(sets, ", "), where)
+	result, err := db.ExecContext(ctx, query, append(args, whereArgs...)...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return postgres.MissingRowError(rec, target)
+	}
+	return nil
+}
+
+// applyDelete deletes the old row of a record, or flags it as deleted
+func (c *SQLiteConnector) applyDelete(ctx context.Context, db querier, rec *postgres.CDCRecord, target *postgres.ApplyTarget) error {
+	if rec.OldValues == nil {
+		return errors.New("DELETE record has no old values")
+	}
+	where, args, err := c.rowMatch(rec, target)
+	if err != nil {
+		return err
+	}
+
+	table := tableName(target.Schema, target.Table)
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", table, where)
+	if target.SoftDeleteColumn != "" {
+		query = fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(mirrorSets(target, true), ", "), where)
+	}
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// applyTruncate removes all rows of a table, or flags them as deleted
+func (c *SQLiteConnector) applyTruncate(ctx context.Context, db querier, target *postgres.ApplyTarget) error {
+	table := tableName(target.Schema, target.Table)
+	query := "DELETE FROM " + table
+	if target.SoftDeleteColumn != "" {
+		query = fmt.Sprintf("UPDATE %s SET %s WHERE %s = 0",
+			table, strings.Join(mirrorSets(target, true), ", "), quoteIdentifier(target.SoftDeleteColumn))
+	}
+	_, err := db.ExecContext(ctx, query)
+	return err
+}
+
+// ApplySchemaDelta changes a table to follow a change of its source. Index
+// and foreign key changes are not carried over.
+func (c *SQLiteConnector) ApplySchemaDelta(ctx context.Context, delta *postgres.SchemaDelta) error {
+	destSchema, destTable, ok := strings.Cut(delta.DestinationTable, ".")
+	if !ok {
+		destSchema, destTable = "public", delta.DestinationTable
+	}
+	return c.alterTable(ctx, c.db, destSchema, destTable, delta)
+}
+
+// alterTable adds and drops the columns of a schema change. SQLite columns
+// hold values of any type, so type changes only change how new values are
+// converted.
+func (c *SQLiteConnector) alterTable(ctx context.Context, db querier, destSchema, destTable string, delta *postgres.SchemaDelta) error {
+	name := tableName(destSchema, destTable)
+	existing, err := columnNames(ctx, db, destSchema, destTable)
+	if err != nil {
+		return err
+	}
+	oids := c.oids[destSchema+"."+destTable]
+	if oids == nil {
+		oids = make(map[string]uint32)
+		c.oids[destSchema+"."+destTable] = oids
+	}
+
+	for _, col := range delta.AddedColumns {
+		oids[col.Name] = col.TypeOID
+		if existing[col.Name] {
+			continue
+		}
+		// Rows already in the table have no value for the column
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s",
+			name, quoteIdentifier(col.Name), sqliteType(col.Type, c.config.TypeMapping))
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", col.Name, err)
+		}
+		c.logger.Info("added column", slog.String("table", destSchema+"."+destTable), slog.String("column", col.Name))
+	}
+
+	// The new type is only known by name, so values of the column are
+	// written as they come, in their text form for changes
+	for _, change := range delta.TypeChanges {
+		oids[change.ColumnName] = 0
+	}
+
+	for _, colName := range delta.DroppedColumns {
+		delete(oids, colName)
+		if !existing[colName] {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", name, quoteIdentifier(colName))
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to drop column %s: %w", colName, err)
+		}
+	}
+	return nil
+}
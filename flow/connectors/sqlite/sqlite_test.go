@@ -0,0 +1,244 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// row is a row of the test table as stored
+type row struct {
+	id        int64
+	name      string
+	bio       sql.NullString
+	updatedAt sql.NullString
+	age       sql.NullInt64
+	deleted   int64
+}
+
+func TestMirrorIntoDatabaseFile(t *testing.T) {
+	ctx := context.Background()
+	conn, err := NewSQLiteConnector(ctx, &connectors.Peer{
+		Name:     "sqlite_test",
+		Type:     PeerType,
+		Database: filepath.Join(t.TempDir(), "data", "mirror.db"),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer conn.Close()
+
+	schema := &postgres.TableSchema{
+		SchemaName: "public",
+		TableName:  "users",
+		Columns: []postgres.ColumnDefinition{
+			{Name: "id", Type: "bigint", TypeOID: pgtype.Int8OID, IsPrimaryKey: true},
+			{Name: "name", Type: "character varying(100)", TypeOID: pgtype.VarcharOID},
+			{Name: "bio", Type: "text", TypeOID: pgtype.TextOID, Nullable: true},
+			{Name: "updated_at", Type: "timestamp with time zone", TypeOID: pgtype.TimestamptzOID, Nullable: true},
+		},
+		PrimaryKeyColumns: []string{"id"},
+	}
+	mirrorCols := postgres.MirrorColumns{SoftDelete: "_deleted"}
+	if err := conn.EnsureTable(ctx, schema, "public", "users", mirrorCols); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	// Setting up an existing table again keeps it
+	if err := conn.EnsureTable(ctx, schema, "public", "users", mirrorCols); err != nil {
+		t.Fatalf("failed to set up existing table: %v", err)
+	}
+
+	updatedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.FixedZone("", 3600))
+	columns := []string{"id", "name", "bio", "updated_at"}
+	if err := conn.BulkLoad(ctx, "public", "users", columns, [][]interface{}{
+		{int64(1), "ada", "a long bio", updatedAt},
+		{int64(2), "grace", nil, nil},
+		{int64(3), "linus", nil, nil},
+	}); err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+
+	target := &postgres.ApplyTarget{Schema: "public", Table: "users", PKColumns: []string{"id"}, SoftDeleteColumn: "_deleted"}
+	targets := map[string]*postgres.ApplyTarget{"public.users": target}
+	change := func(op string, lsn int64, oldValues, newValues map[string]interface{}) *postgres.CDCRecord {
+		return &postgres.CDCRecord{
+			Operation: op, Schema: "public", Table: "users", LSN: lsn,
+			Columns: columns, OldValues: oldValues, NewValues: newValues,
+		}
+	}
+
+	// bio of row 1 is TOASTed and unchanged, so it is not in the update
+	toasted := change("UPDATE", 102, nil, map[string]interface{}{"id": int64(1), "name": "ada lovelace", "updated_at": "2026-03-02 09:30:00+02"})
+	toasted.UnchangedToastColumns = []string{"bio"}
+	added := change("INSERT", 106, nil, map[string]interface{}{"id": int64(5), "name": "ken", "age": "81"})
+	added.Columns = append(columns, "age")
+	txns := []*postgres.CDCTransaction{
+		{XID: 10, EndLSN: 200, Records: []*postgres.CDCRecord{
+			change("INSERT", 101, nil, map[string]interface{}{"id": int64(4), "name": "barbara"}),
+			toasted,
+			// The key of row 2 changes
+			change("UPDATE", 103, map[string]interface{}{"id": int64(2)}, map[string]interface{}{"id": int64(20), "name": "grace", "bio": nil, "updated_at": nil}),
+			change("DELETE", 104, map[string]interface{}{"id": int64(3)}, nil),
+		}},
+		{XID: 11, EndLSN: 300, Records: []*postgres.CDCRecord{
+			{Operation: "SCHEMA", Schema: "public", Table: "users", LSN: 105, SchemaDelta: &postgres.SchemaDelta{
+				AddedColumns: []postgres.ColumnDefinition{{Name: "age", Type: "integer", TypeOID: pgtype.Int4OID, Nullable: true}},
+			}},
+			added,
+		}},
+	}
+	if err := conn.ApplyChanges(ctx, txns, targets); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+
+	want := []row{
+		{id: 1, name: "ada lovelace", bio: sql.NullString{String: "a long bio", Valid: true},
+			updatedAt: sql.NullString{String: "2026-03-02 07:30:00Z", Valid: true}},
+		{id: 3, name: "linus", deleted: 1},
+		{id: 4, name: "barbara"},
+		{id: 5, name: "ken", age: sql.NullInt64{Int64: 81, Valid: true}},
+		{id: 20, name: "grace"},
+	}
+	if got := readRows(ctx, t, conn); !slices.Equal(got, want) {
+		t.Errorf("rows after changes = %+v, want %+v", got, want)
+	}
+
+	// An update of a missing row is skipped, unless the row cannot be
+	// rebuilt for values of unchanged TOAST columns
+	missing := change("UPDATE", 301, nil, map[string]interface{}{"id": int64(99), "name": "nobody"})
+	if err := conn.ApplyChanges(ctx, []*postgres.CDCTransaction{{XID: 12, EndLSN: 400, Records: []*postgres.CDCRecord{missing}}}, targets); err != nil {
+		t.Errorf("update of a missing row failed: %v", err)
+	}
+	missing.UnchangedToastColumns = []string{"bio"}
+	if err := conn.ApplyChanges(ctx, []*postgres.CDCTransaction{{XID: 12, EndLSN: 400, Records: []*postgres.CDCRecord{missing}}}, targets); err == nil {
+		t.Error("update of a missing row with unchanged TOAST values succeeded")
+	}
+
+	// With soft delete, a truncate flags every row as deleted
+	truncate := &postgres.CDCRecord{Operation: "TRUNCATE", Schema: "public", Table: "users", LSN: 401}
+	if err := conn.ApplyChanges(ctx, []*postgres.CDCTransaction{{XID: 13, EndLSN: 500, Records: []*postgres.CDCRecord{truncate}}}, targets); err != nil {
+		t.Fatalf("failed to apply truncate: %v", err)
+	}
+	for _, r := range readRows(ctx, t, conn) {
+		if r.deleted != 1 {
+			t.Errorf("row %d is not flagged as deleted after truncate", r.id)
+		}
+	}
+}
+
+// readRows returns the rows of the test table, by id
+func readRows(ctx context.Context, t *testing.T, conn *SQLiteConnector) []row {
+	t.Helper()
+	rows, err := conn.db.QueryContext(ctx, `SELECT id, name, bio, updated_at, age, _deleted FROM users ORDER BY id`)
+	if err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+	defer rows.Close()
+	var got []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.name, &r.bio, &r.updatedAt, &r.age, &r.deleted); err != nil {
+			t.Fatalf("failed to read row: %v", err)
+		}
+		got = append(got, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+	return got
+}
+
+func TestSnapshotPartitionsKeepColumnTypes(t *testing.T) {
+	ctx := context.Background()
+	peer := &connectors.Peer{Name: "sqlite_test", Type: PeerType, Database: filepath.Join(t.TempDir(), "mirror.db")}
+	schema := &postgres.TableSchema{
+		SchemaName: "public",
+		TableName:  "flags",
+		Columns: []postgres.ColumnDefinition{
+			{Name: "id", Type: "bigint", TypeOID: pgtype.Int8OID},
+			{Name: "on", Type: "boolean", TypeOID: pgtype.BoolOID},
+		},
+		PrimaryKeyColumns: []string{"id"},
+	}
+
+	first, err := NewSQLiteConnector(ctx, peer)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer first.Close()
+	if err := first.EnsureTable(ctx, schema, "public", "flags", postgres.MirrorColumns{}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	// A later partition learns the types without creating the table
+	second, err := NewSQLiteConnector(ctx, peer)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer second.Close()
+	second.RegisterTable(schema, "public", "flags")
+	if err := second.BulkLoad(ctx, "public", "flags", []string{"id", "on"}, [][]interface{}{{int64(1), "t"}}); err != nil {
+		t.Fatalf("failed to load partition: %v", err)
+	}
+
+	var on interface{}
+	if err := first.db.QueryRowContext(ctx, `SELECT "on" FROM flags WHERE id = 1`).Scan(&on); err != nil {
+		t.Fatalf("failed to read row: %v", err)
+	}
+	if on != int64(1) {
+		t.Errorf("boolean stored as %#v, want 1", on)
+	}
+}
+
+func TestSQLiteValue(t *testing.T) {
+	ts := time.Date(2026, 3, 1, 12, 30, 0, 500000000, time.FixedZone("", -5*3600))
+	tests := []struct {
+		name string
+		oid  uint32
+		in   interface{}
+		want interface{}
+	}{
+		{"null", pgtype.TextOID, nil, nil},
+		{"boolean text", pgtype.BoolOID, "t", true},
+		{"boolean", pgtype.BoolOID, false, false},
+		{"bytea text", pgtype.ByteaOID, `\x0102`, []byte{1, 2}},
+		{"date", pgtype.DateOID, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), "2026-03-01"},
+		{"timestamptz in UTC", pgtype.TimestamptzOID, ts, "2026-03-01 17:30:00.5Z"},
+		{"timestamptz text", pgtype.TimestamptzOID, "2026-03-01 12:30:00.5-05", "2026-03-01 17:30:00.5Z"},
+		{"NaN", pgtype.Float8OID, math.NaN(), "NaN"},
+		{"integer", pgtype.Int8OID, int64(42), int64(42)},
+		{"infinity", pgtype.DateOID, pgtype.Infinity, "infinity"},
+		{"uuid", pgtype.UUIDOID, [16]byte{0x12, 0x34}, "12340000-0000-0000-0000-000000000000"},
+		{"text of unknown type", 0, "abc", "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sqliteValue(tt.oid, tt.in)
+			if err != nil {
+				t.Fatalf("sqliteValue(%d, %#v) failed: %v", tt.oid, tt.in, err)
+			}
+			if b, ok := tt.want.([]byte); ok {
+				if !slices.Equal(got.([]byte), b) {
+					t.Errorf("sqliteValue(%d, %#v) = %#v, want %#v", tt.oid, tt.in, got, tt.want)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("sqliteValue(%d, %#v) = %#v, want %#v", tt.oid, tt.in, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := sqliteValue(pgtype.ByteaOID, `\xzz`); err == nil {
+		t.Error("invalid bytea text was accepted")
+	}
+}
@@ -0,0 +1,129 @@
+package sqlite
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// sqliteType returns the declared SQLite type of a Postgres type, which
+// gives the column its affinity. Overrides replace the default mapping by
+// Postgres type name without modifiers.
+func sqliteType(pgType string, overrides map[string]string) string {
+	name, _ := postgres.BaseType(pgType)
+	if t, ok := overrides[name]; ok {
+		return t
+	}
+	if strings.HasSuffix(name, "[]") {
+		return "TEXT"
+	}
+
+	switch name {
+	case "smallint", "integer", "bigint", "boolean":
+		return "INTEGER"
+	case "real", "double precision":
+		return "REAL"
+	case "numeric", "money":
+		return "NUMERIC"
+	case "bytea":
+		return "BLOB"
+	}
+	return "TEXT"
+}
+
+// Layouts of timestamps as written: with time zone in UTC, and without as
+// they are. SQLite's date and time functions read both.
+const (
+	timestamptzLayout = "2006-01-02 15:04:05.999999Z07:00"
+	timestampLayout   = "2006-01-02 15:04:05.999999"
+	dateLayout        = "2006-01-02"
+)
+
+// sqliteValue converts a value, typed or in Postgres text form, of a
+// column of a Postgres type to the value stored. Booleans are 0 and 1,
+// bytea is a blob, dates and timestamps are ISO 8601 text and other types
+// are stored in their Postgres text form.
+func sqliteValue(oid uint32, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	text, isText := v.(string)
+
+	switch oid {
+	case pgtype.BoolOID:
+		if isText {
+			return text == "t" || text == "true", nil
+		}
+		return v, nil
+
+	case pgtype.ByteaOID:
+		if isText {
+			b, err := hex.DecodeString(strings.TrimPrefix(text, `\x`))
+			if err != nil {
+				return nil, fmt.Errorf("invalid bytea value: %w", err)
+			}
+			return b, nil
+		}
+		return v, nil
+
+	case pgtype.DateOID:
+		if t, ok := v.(time.Time); ok {
+			return t.Format(dateLayout), nil
+		}
+
+	case pgtype.TimestampOID:
+		if t, ok := v.(time.Time); ok {
+			return t.Format(timestampLayout), nil
+		}
+
+	case pgtype.TimestamptzOID:
+		if t, ok := v.(time.Time); ok {
+			return t.UTC().Format(timestamptzLayout), nil
+		}
+		for _, layout := range []string{"2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00", "2006-01-02 15:04:05.999999-07:00:00"} {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t.UTC().Format(timestamptzLayout), nil
+			}
+		}
+
+	case pgtype.Float4OID, pgtype.Float8OID:
+		// SQLite stores NaN as NULL, so it is kept as text like infinities
+		switch f := v.(type) {
+		case float32:
+			if math.IsNaN(float64(f)) {
+				return "NaN", nil
+			}
+		case float64:
+			if math.IsNaN(f) {
+				return "NaN", nil
+			}
+		}
+		return v, nil
+	}
+
+	if isText {
+		return text, nil
+	}
+	switch v.(type) {
+	case bool, int16, int32, int64, float32, float64, []byte:
+		return v, nil
+	case pgtype.InfinityModifier:
+		return v.(pgtype.InfinityModifier).String(), nil
+	}
+	if oid != 0 {
+		buf, err := postgres.EncodeText(oid, v)
+		if err == nil {
+			if buf == nil {
+				return nil, nil
+			}
+			return string(buf), nil
+		}
+	}
+	return fmt.Sprint(v), nil
+}
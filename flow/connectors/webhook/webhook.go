@@ -0,0 +1,516 @@
+// Package webhook is a destination that POSTs changes to an HTTP endpoint
+// in batches. Batches are retried with backoff while the endpoint fails or
+// is unreachable; a batch it rejects is set aside for the dead-letter queue
+// so one bad change does not stop the mirror.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/format"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// PeerType is the peer type of webhook peers
+const PeerType connectors.PeerType = "WEBHOOK"
+
+// Request headers identifying and signing a batch
+const (
+	HeaderSignature = "X-Bunny-Signature"
+	HeaderTimestamp = "X-Bunny-Timestamp"
+	HeaderBatch     = "X-Bunny-Batch"
+)
+
+func init() {
+	connectors.Register(PeerType, connectors.Registration{
+		Validate: func(peer *connectors.Peer) error {
+			_, err := NewConfig(peer)
+			return err
+		},
+		NewDestination: func(ctx context.Context, peer *connectors.Peer) (connectors.Destination, error) {
+			return NewWebhookConnector(ctx, peer)
+		},
+	})
+}
+
+// Config holds the settings of a webhook peer, from its config
+type Config struct {
+	// URL the batches are POSTed to
+	URL string `json:"url"`
+
+	// Headers are added to every request, e.g. for authorization
+	Headers map[string]string `json:"headers"`
+
+	// Serialization of the events: json (default) or debezium
+	Serialization string `json:"serialization"`
+
+	// BatchSize is the most events sent in one request. Default: 500.
+	BatchSize int `json:"batch_size"`
+
+	// LingerMs is how long changes are held for a fuller batch before
+	// they are sent anyway. Default: 1000.
+	LingerMs int `json:"linger_ms"`
+
+	// TimeoutSeconds bounds each request. Default: 30.
+	TimeoutSeconds int `json:"timeout_seconds"`
+
+	// MaxRetries is how often a failed request is retried before the batch
+	// fails, backing off exponentially from InitialBackoffMs up to
+	// MaxBackoffMs. Defaults: 8, 500 and 30000.
+	MaxRetries       int `json:"max_retries"`
+	InitialBackoffMs int `json:"initial_backoff_ms"`
+	MaxBackoffMs     int `json:"max_backoff_ms"`
+}
+
+// NewConfig reads and checks the settings of a webhook peer
+func NewConfig(peer *connectors.Peer) (*Config, error) {
+	config := &Config{}
+	if err := peer.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+
+	if config.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("url must be http or https, got %q", u.Scheme)
+	}
+	if config.BatchSize < 0 || config.LingerMs < 0 || config.MaxRetries < 0 {
+		return nil, errors.New("batch_size, linger_ms and max_retries must not be negative")
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = 500
+	}
+	if config.LingerMs == 0 {
+		config.LingerMs = 1000
+	}
+	if config.TimeoutSeconds <= 0 {
+		config.TimeoutSeconds = 30
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 8
+	}
+	if config.InitialBackoffMs <= 0 {
+		config.InitialBackoffMs = 500
+	}
+	if config.MaxBackoffMs <= 0 {
+		config.MaxBackoffMs = 30000
+	}
+
+	if _, err := format.New(config.Serialization, format.Options{}); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// payload is the body of a request
+type payload struct {
+	Events []json.RawMessage `json:"events"`
+}
+
+// event is one change in a payload
+type event struct {
+	// Table is the destination table, as <schema>.<table>
+	Table string          `json:"table"`
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// pendingEvent is a rendered change held for the next batch
+type pendingEvent struct {
+	body   json.RawMessage
+	record *postgres.CDCRecord
+	target *postgres.ApplyTarget
+
+	// endLSN is the end LSN of the transaction if the event is its last
+	endLSN int64
+}
+
+// WebhookConnector sends changes to an HTTP endpoint
+type WebhookConnector struct {
+	logger    *slog.Logger
+	config    *Config
+	client    *http.Client
+	formatter format.Formatter
+
+	// secret signs the requests; nothing is signed if empty
+	secret []byte
+
+	// tables holds the source schema of each destination table set up
+	// through EnsureTable, by <schema>.<table>
+	tables map[string]*postgres.TableSchema
+
+	pending      []pendingEvent
+	pendingSince time.Time
+	receivedLSN  int64
+	flushedLSN   int64
+	rejected     []connectors.RejectedChange
+}
+
+// NewWebhookConnector creates the HTTP client of a webhook peer. The
+// peer's password, if set, is the secret requests are signed with.
+func NewWebhookConnector(_ context.Context, peer *connectors.Peer) (*WebhookConnector, error) {
+	config, err := NewConfig(peer)
+	if err != nil {
+		return nil, err
+	}
+	formatter, err := format.New(config.Serialization, format.Options{Name: peer.Name, Database: peer.Database})
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if peer.SSLMode == "require" {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &WebhookConnector{
+		logger: slog.Default().With(slog.String("component", "webhook-connector"), slog.String("peer", peer.Name)),
+		config: config,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(config.TimeoutSeconds) * time.Second,
+		},
+		formatter: formatter,
+		secret:    []byte(peer.Password),
+		tables:    make(map[string]*postgres.TableSchema),
+	}, nil
+}
+
+// Close drops changes still held; they were not checkpointed and are sent
+// again by the next sync
+func (c *WebhookConnector) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// EnsureTable remembers the schema of a table for the changes sent for it
+func (c *WebhookConnector) EnsureTable(_ context.Context, schema *postgres.TableSchema, destSchema, destTable string, _ postgres.MirrorColumns) error {
+	c.RegisterTable(schema, destSchema, destTable)
+	return nil
+}
+
+// RegisterTable remembers the schema of a table for the changes sent for it
+func (c *WebhookConnector) RegisterTable(schema *postgres.TableSchema, destSchema, destTable string) {
+	c.tables[destSchema+"."+destTable] = schema
+}
+
+// TruncateTable does nothing: the rows of a new snapshot are sent after it
+func (c *WebhookConnector) TruncateTable(_ context.Context, destSchema, destTable string) error {
+	c.logger.Info("not truncating webhook table before snapshot", slog.String("table", destSchema+"."+destTable))
+	return nil
+}
+
+// BulkLoad sends rows read by the snapshot right away, in batches. Snapshot
+// rows are not dead-lettered: a rejected batch fails the snapshot.
+func (c *WebhookConnector) BulkLoad(ctx context.Context, destSchema, destTable string, columns []string, rows [][]interface{}) error {
+	schema := c.tables[destSchema+"."+destTable]
+	if schema == nil {
+		return fmt.Errorf("table %s.%s was not set up before its snapshot", destSchema, destTable)
+	}
+
+	events := make([]json.RawMessage, 0, len(rows))
+	for _, values := range rows {
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			row[col] = values[i]
+		}
+		body, err := c.render(destSchema+"."+destTable, &format.Event{
+			Record: &postgres.CDCRecord{
+				Operation: "INSERT",
+				Schema:    schema.SchemaName,
+				Table:     schema.TableName,
+				Columns:   columns,
+				NewValues: row,
+			},
+			Snapshot:   true,
+			Table:      schema,
+			KeyColumns: schema.PrimaryKeyColumns,
+		})
+		if err != nil {
+			return err
+		}
+		events = append(events, body)
+	}
+
+	for start := 0; start < len(events); start += c.config.BatchSize {
+		end := min(start+c.config.BatchSize, len(events))
+		batchID := fmt.Sprintf("snapshot-%s.%s-%d", destSchema, destTable, time.Now().UnixNano())
+		if err := c.send(ctx, batchID, events[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyChanges adds the records of source transactions to the pending
+// batch and sends the batches that are full. Schema changes are not sent;
+// the rows that follow them carry the new columns.
+func (c *WebhookConnector) ApplyChanges(ctx context.Context, txns []*postgres.CDCTransaction, targets map[string]*postgres.ApplyTarget) error {
+	for _, txn := range txns {
+		// The chunks of a spooled transaction are sent as they fill batches
+		err := txn.Chunks(func() error {
+			for _, rec := range txn.Records {
+				target := postgres.ApplyTargetFor(targets, rec)
+				table := target.Schema + "." + target.Table
+
+				// Changes after a schema change are sent with the
+				// columns and types it leaves
+				if rec.Operation == "SCHEMA" {
+					if schema := c.tables[table]; schema != nil && rec.SchemaDelta != nil {
+						c.tables[table] = rec.SchemaDelta.ApplyTo(schema)
+					}
+					continue
+				}
+
+				ev := &format.Event{
+					Record: rec,
+					Table:  c.tables[table],
+				}
+				if rec.Operation != "TRUNCATE" {
+					ev.KeyColumns = target.PKColumns
+				}
+				body, err := c.render(table, ev)
+				if err != nil {
+					return err
+				}
+
+				if len(c.pending) == 0 {
+					c.pendingSince = time.Now()
+				}
+				c.pending = append(c.pending, pendingEvent{body: body, record: rec, target: target})
+			}
+			if txn.Spooled() {
+				return c.Flush(ctx, false)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if n := len(c.pending); n > 0 {
+			c.pending[n-1].endLSN = txn.EndLSN
+		}
+		c.receivedLSN = txn.EndLSN
+	}
+	return c.Flush(ctx, false)
+}
+
+// ApplySchemaDelta does nothing: events carry their own columns
+func (c *WebhookConnector) ApplySchemaDelta(_ context.Context, _ *postgres.SchemaDelta) error {
+	return nil
+}
+
+// Flush sends the full batches, and the rest once it has waited linger_ms
+// or if all is set
+func (c *WebhookConnector) Flush(ctx context.Context, all bool) error {
+	for len(c.pending) > 0 {
+		n := len(c.pending)
+		if n > c.config.BatchSize {
+			n = c.config.BatchSize
+		} else if n < c.config.BatchSize && !all &&
+			time.Since(c.pendingSince) < time.Duration(c.config.LingerMs)*time.Millisecond {
+			break
+		}
+		if err := c.sendPending(ctx, c.pending[:n]); err != nil {
+			return err
+		}
+		c.pending = c.pending[n:]
+	}
+	if len(c.pending) == 0 {
+		c.pending = nil
+		c.flushedLSN = c.receivedLSN
+	}
+	return nil
+}
+
+// FlushedLSN returns the end LSN of the last transaction all of whose
+// changes were sent or rejected
+func (c *WebhookConnector) FlushedLSN() int64 {
+	return c.flushedLSN
+}
+
+// TakeRejected returns the changes of the batches the endpoint rejected
+func (c *WebhookConnector) TakeRejected() []connectors.RejectedChange {
+	rejected := c.rejected
+	c.rejected = nil
+	return rejected
+}
+
+// sendPending sends a batch of held changes, setting them aside as
+// rejected if the endpoint rejects them as invalid. Any other failure fails
+// the sync, and the changes are sent again when it is retried.
+func (c *WebhookConnector) sendPending(ctx context.Context, batch []pendingEvent) error {
+	events := make([]json.RawMessage, len(batch))
+	for i, pe := range batch {
+		events[i] = pe.body
+	}
+	// Retries of the batch keep its ID. A batch sent again after a restart
+	// may be cut differently, so receivers drop duplicates per event.
+	batchID := fmt.Sprintf("%d-%d", batch[0].record.LSN, batch[len(batch)-1].record.LSN)
+
+	err := c.send(ctx, batchID, events)
+	var rejectErr *rejectedError
+	if errors.As(err, &rejectErr) {
+		c.logger.Warn("endpoint rejected batch",
+			slog.String("batch", batchID),
+			slog.Int("events", len(batch)),
+			slog.Int("status", rejectErr.status))
+		for _, pe := range batch {
+			c.rejected = append(c.rejected, connectors.RejectedChange{Record: pe.record, Target: pe.target, Err: err})
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for _, pe := range batch {
+		if pe.endLSN > c.flushedLSN {
+			c.flushedLSN = pe.endLSN
+		}
+	}
+	return nil
+}
+
+// rejectedError is a response refusing the changes of a batch, which sending
+// them again would not change
+type rejectedError struct {
+	status int
+	body   string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("endpoint rejected batch with status %d: %s", e.status, e.body)
+}
+
+// refusedError is a response refusing the request rather than its changes,
+// such as failed authentication or a wrong URL. Retrying right away would
+// not change it, but the changes are not at fault.
+type refusedError struct {
+	status int
+	body   string
+}
+
+func (e *refusedError) Error() string {
+	return fmt.Sprintf("endpoint refused batch with status %d: %s", e.status, e.body)
+}
+
+// send POSTs a batch of events, retrying with exponential backoff and
+// jitter on network errors, 5xx, 408 and 429 responses. 400 and 422
+// responses are returned as a rejectedError and other 4xx responses as a
+// refusedError, without retrying.
+func (c *WebhookConnector) send(ctx context.Context, batchID string, events []json.RawMessage) error {
+	body, err := json.Marshal(payload{Events: events})
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+
+	backoff := time.Duration(c.config.InitialBackoffMs) * time.Millisecond
+	maxBackoff := time.Duration(c.config.MaxBackoffMs) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err = c.post(ctx, batchID, body)
+		var rejectErr *rejectedError
+		var refuseErr *refusedError
+		if err == nil || errors.As(err, &rejectErr) || errors.As(err, &refuseErr) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= c.config.MaxRetries {
+			return fmt.Errorf("failed to send batch of %d events after %d attempts: %w", len(events), attempt+1, err)
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		c.logger.Warn("failed to send batch, retrying",
+			slog.String("batch", batchID),
+			slog.Int("attempt", attempt+1),
+			slog.Duration("wait", wait),
+			slog.Any("error", err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post makes one request. Retryable failures are returned as plain errors.
+func (c *WebhookConnector) post(ctx context.Context, batchID string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range c.config.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderBatch, batchID)
+	if len(c.secret) > 0 {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, "sha256="+Sign(c.secret, ts, body))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity:
+		return &rejectedError{status: resp.StatusCode, body: string(respBody)}
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return &refusedError{status: resp.StatusCode, body: string(respBody)}
+	default:
+		return fmt.Errorf("endpoint returned status %d: %s", resp.StatusCode, respBody)
+	}
+}
+
+// render encodes an event with its key for a payload
+func (c *WebhookConnector) render(table string, ev *format.Event) (json.RawMessage, error) {
+	key, err := c.formatter.Key(ev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render key for %s: %w", table, err)
+	}
+	value, err := c.formatter.Value(ev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render value for %s: %w", table, err)
+	}
+	if key == nil {
+		key = json.RawMessage("null")
+	}
+	return json.Marshal(event{Table: table, Key: key, Value: value})
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" with secret, as
+// sent in the X-Bunny-Signature header
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
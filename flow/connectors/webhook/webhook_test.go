@@ -0,0 +1,327 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bunnydb/bunnydb/flow/connectors"
+	"github.com/bunnydb/bunnydb/flow/connectors/postgres"
+)
+
+// request is a request received by a test endpoint
+type request struct {
+	header http.Header
+	body   []byte
+	events []json.RawMessage
+}
+
+// endpoint is a test server answering each request with the next status of
+// statuses, then with 200
+type endpoint struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []request
+}
+
+func newEndpoint(t *testing.T, statuses ...int) *endpoint {
+	t.Helper()
+	e := &endpoint{statuses: statuses}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read request: %v", err)
+		}
+		var p payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("failed to parse request: %v", err)
+		}
+
+		e.mu.Lock()
+		e.requests = append(e.requests, request{header: r.Header, body: body, events: p.Events})
+		status := http.StatusOK
+		if len(e.statuses) > 0 {
+			status, e.statuses = e.statuses[0], e.statuses[1:]
+		}
+		e.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+// received returns the requests received so far
+func (e *endpoint) received() []request {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]request(nil), e.requests...)
+}
+
+// newTestConnector returns a connector sending to an endpoint, retrying
+// right away
+func newTestConnector(t *testing.T, e *endpoint, password string, config map[string]interface{}) *WebhookConnector {
+	t.Helper()
+	peerConfig := map[string]interface{}{
+		"url":                e.URL,
+		"initial_backoff_ms": 1,
+		"max_backoff_ms":     2,
+	}
+	for k, v := range config {
+		peerConfig[k] = v
+	}
+	conn, err := NewWebhookConnector(context.Background(), &connectors.Peer{
+		Name:     "webhook_test",
+		Type:     PeerType,
+		Password: password,
+		Config:   peerConfig,
+	})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	schema := &postgres.TableSchema{
+		SchemaName:        "public",
+		TableName:         "users",
+		Columns:           []postgres.ColumnDefinition{{Name: "id", Type: "bigint"}},
+		PrimaryKeyColumns: []string{"id"},
+	}
+	if err := conn.EnsureTable(context.Background(), schema, "public", "users", postgres.MirrorColumns{}); err != nil {
+		t.Fatalf("failed to set up table: %v", err)
+	}
+	return conn
+}
+
+// insertTxn returns a transaction ending at endLSN that inserts a row per
+// id, at LSNs just before it
+func insertTxn(endLSN int64, ids ...int64) *postgres.CDCTransaction {
+	txn := &postgres.CDCTransaction{XID: uint32(endLSN), CommitLSN: endLSN - 8, EndLSN: endLSN}
+	for i, id := range ids {
+		lsn := endLSN - 100 + int64(i)
+		txn.Records = append(txn.Records, &postgres.CDCRecord{
+			Operation: "INSERT",
+			Schema:    "public",
+			Table:     "users",
+			LSN:       lsn,
+			Columns:   []string{"id"},
+			NewValues: map[string]interface{}{"id": id},
+			Seq:       i,
+			XID:       txn.XID,
+			CommitLSN: txn.CommitLSN,
+		})
+	}
+	return txn
+}
+
+var targets = map[string]*postgres.ApplyTarget{
+	"public.users": {Schema: "public", Table: "users", PKColumns: []string{"id"}},
+}
+
+func TestBatchesAreSentWhenFullOrLingeredOrFlushed(t *testing.T) {
+	ctx := context.Background()
+	e := newEndpoint(t)
+	conn := newTestConnector(t, e, "", map[string]interface{}{"batch_size": 2, "linger_ms": 50})
+
+	txns := []*postgres.CDCTransaction{insertTxn(1000, 1), insertTxn(2000, 2), insertTxn(3000, 3)}
+	if err := conn.ApplyChanges(ctx, txns, targets); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+
+	// The full batch is sent; the last change waits for more
+	got := e.received()
+	if len(got) != 1 || len(got[0].events) != 2 {
+		t.Fatalf("got %d requests, want 1 with 2 events", len(got))
+	}
+	if lsn := conn.FlushedLSN(); lsn != 2000 {
+		t.Errorf("flushed LSN = %d, want 2000", lsn)
+	}
+	if err := conn.Flush(ctx, false); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if n := len(e.received()); n != 1 {
+		t.Errorf("got %d requests before linger_ms passed, want 1", n)
+	}
+
+	// Once linger_ms passed, the rest is sent too
+	time.Sleep(60 * time.Millisecond)
+	if err := conn.Flush(ctx, false); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	got = e.received()
+	if len(got) != 2 || len(got[1].events) != 1 {
+		t.Fatalf("got %d requests after linger_ms, want 2, the last with 1 event", len(got))
+	}
+	if lsn := conn.FlushedLSN(); lsn != 3000 {
+		t.Errorf("flushed LSN = %d, want 3000", lsn)
+	}
+
+	// Flushing everything sends a partial batch without waiting
+	if err := conn.ApplyChanges(ctx, []*postgres.CDCTransaction{insertTxn(4000, 4)}, targets); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+	if err := conn.Flush(ctx, true); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if n := len(e.received()); n != 3 {
+		t.Errorf("got %d requests after flushing all, want 3", n)
+	}
+	if lsn := conn.FlushedLSN(); lsn != 4000 {
+		t.Errorf("flushed LSN = %d, want 4000", lsn)
+	}
+}
+
+func TestRequestsAreSigned(t *testing.T) {
+	e := newEndpoint(t)
+	conn := newTestConnector(t, e, "signing-secret", nil)
+	if err := conn.ApplyChanges(context.Background(), []*postgres.CDCTransaction{insertTxn(1000, 1)}, targets); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+	if err := conn.Flush(context.Background(), true); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	got := e.received()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	ts := got[0].header.Get(HeaderTimestamp)
+	if ts == "" {
+		t.Fatalf("no %s header", HeaderTimestamp)
+	}
+	mac := hmac.New(sha256.New, []byte("signing-secret"))
+	mac.Write([]byte(ts + "."))
+	mac.Write(got[0].body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if sig := got[0].header.Get(HeaderSignature); sig != want {
+		t.Errorf("%s = %s, want %s", HeaderSignature, sig, want)
+	}
+	if batch := got[0].header.Get(HeaderBatch); batch != "900-900" {
+		t.Errorf("%s = %s, want 900-900", HeaderBatch, batch)
+	}
+}
+
+func TestUnsignedRequestsHaveNoSignature(t *testing.T) {
+	e := newEndpoint(t)
+	conn := newTestConnector(t, e, "", nil)
+	if err := conn.ApplyChanges(context.Background(), []*postgres.CDCTransaction{insertTxn(1000, 1)}, targets); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+	if err := conn.Flush(context.Background(), true); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	for _, req := range e.received() {
+		if req.header.Get(HeaderSignature) != "" || req.header.Get(HeaderTimestamp) != "" {
+			t.Errorf("unsigned request has headers %v", req.header)
+		}
+	}
+}
+
+func TestResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+
+		wantRequests int
+		wantRejected int
+		wantRefused  bool
+		wantErr      bool
+	}{
+		{name: "server errors are retried", statuses: []int{500, 503}, wantRequests: 3},
+		{name: "timeouts are retried", statuses: []int{408}, wantRequests: 2},
+		{name: "throttling is retried", statuses: []int{429, 429}, wantRequests: 3},
+		{name: "retries run out", statuses: []int{500, 500, 500, 500}, wantRequests: 3, wantErr: true},
+		{name: "bad request is rejected", statuses: []int{400}, wantRequests: 1, wantRejected: 2},
+		{name: "unprocessable entity is rejected", statuses: []int{422}, wantRequests: 1, wantRejected: 2},
+		{name: "unauthorized is refused", statuses: []int{401}, wantRequests: 1, wantRefused: true, wantErr: true},
+		{name: "not found is refused", statuses: []int{404}, wantRequests: 1, wantRefused: true, wantErr: true},
+		{name: "too large is refused", statuses: []int{413}, wantRequests: 1, wantRefused: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEndpoint(t, tt.statuses...)
+			conn := newTestConnector(t, e, "", map[string]interface{}{"max_retries": 2})
+
+			err := conn.ApplyChanges(context.Background(), []*postgres.CDCTransaction{insertTxn(1000, 1, 2)}, targets)
+			if err == nil {
+				err = conn.Flush(context.Background(), true)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			var refuseErr *refusedError
+			if errors.As(err, &refuseErr) != tt.wantRefused {
+				t.Errorf("err = %v, want refused: %v", err, tt.wantRefused)
+			}
+
+			got := e.received()
+			if len(got) != tt.wantRequests {
+				t.Fatalf("got %d requests, want %d", len(got), tt.wantRequests)
+			}
+			// Retries send the same batch
+			for _, req := range got[1:] {
+				if string(req.body) != string(got[0].body) || req.header.Get(HeaderBatch) != got[0].header.Get(HeaderBatch) {
+					t.Errorf("retry sent %s as batch %s, want %s as batch %s",
+						req.body, req.header.Get(HeaderBatch), got[0].body, got[0].header.Get(HeaderBatch))
+				}
+			}
+
+			rejected := conn.TakeRejected()
+			if len(rejected) != tt.wantRejected {
+				t.Errorf("got %d rejected changes, want %d", len(rejected), tt.wantRejected)
+			}
+			for _, rc := range rejected {
+				if rc.Target == nil || rc.Target.Table != "users" || rc.Err == nil {
+					t.Errorf("rejected change has target %+v and error %v", rc.Target, rc.Err)
+				}
+			}
+
+			// The checkpoint only moves once the endpoint answered for the
+			// changes, accepting or rejecting them
+			wantLSN := int64(1000)
+			if tt.wantErr {
+				wantLSN = 0
+			}
+			if lsn := conn.FlushedLSN(); lsn != wantLSN {
+				t.Errorf("flushed LSN = %d, want %d", lsn, wantLSN)
+			}
+		})
+	}
+}
+
+func TestFailedBatchIsSentAgainOnFlush(t *testing.T) {
+	ctx := context.Background()
+	e := newEndpoint(t, 503, 503)
+	conn := newTestConnector(t, e, "", map[string]interface{}{"max_retries": 1})
+
+	if err := conn.ApplyChanges(ctx, []*postgres.CDCTransaction{insertTxn(1000, 1), insertTxn(2000, 2)}, targets); err != nil {
+		t.Fatalf("failed to hold changes: %v", err)
+	}
+	if err := conn.Flush(ctx, true); err == nil {
+		t.Fatal("flush succeeded while the endpoint failed")
+	}
+	if lsn := conn.FlushedLSN(); lsn != 0 {
+		t.Errorf("flushed LSN = %d before the endpoint accepted the batch, want 0", lsn)
+	}
+
+	if err := conn.Flush(ctx, true); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	got := e.received()
+	if len(got) != 3 || len(got[2].events) != 2 {
+		t.Fatalf("got %d requests, want 3, the last with both changes", len(got))
+	}
+	if lsn := conn.FlushedLSN(); lsn != 2000 {
+		t.Errorf("flushed LSN = %d, want 2000", lsn)
+	}
+}
@@ -29,6 +29,62 @@ const (
 	ResyncStrategySwap     ResyncStrategy = "swap"
 )
 
+// ApplyErrorPolicy decides what happens to a CDC record that fails to apply
+type ApplyErrorPolicy string
+
+const (
+	// ApplyErrorPolicyDLQ stores the record in the dead-letter queue and continues
+	ApplyErrorPolicyDLQ ApplyErrorPolicy = "dlq"
+	// ApplyErrorPolicySkip logs the failure and drops the record
+	ApplyErrorPolicySkip ApplyErrorPolicy = "skip"
+	// ApplyErrorPolicyHalt pauses the mirror at the first failure without moving past it
+	ApplyErrorPolicyHalt ApplyErrorPolicy = "halt"
+)
+
+// TableMode is how a table mapping writes source changes to its destination
+// table
+type TableMode string
+
+const (
+	// TableModeMirror keeps the destination table equal to the source table
+	TableModeMirror TableMode = "mirror"
+	// TableModeChangelog appends every change as a row of a changelog table,
+	// partitioned by the day of the source commit
+	TableModeChangelog TableMode = "changelog"
+	// TableModeHistory keeps every version of a row with its validity period
+	// (slowly changing dimension type 2)
+	TableModeHistory TableMode = "history"
+)
+
+// ColumnTransformType is how a transformed column's values are rewritten
+type ColumnTransformType string
+
+const (
+	// ColumnTransformHash writes the SHA-256 hex digest of the value
+	ColumnTransformHash ColumnTransformType = "hash"
+	// ColumnTransformRedact masks every character except the last Length
+	ColumnTransformRedact ColumnTransformType = "redact"
+	// ColumnTransformFixed writes Value (NULL if empty) in place of every value
+	ColumnTransformFixed ColumnTransformType = "fixed"
+	// ColumnTransformTruncate keeps the first Length characters of text values
+	ColumnTransformTruncate ColumnTransformType = "truncate"
+	// ColumnTransformFakeEmail writes a stable fake address of the same shape
+	ColumnTransformFakeEmail ColumnTransformType = "fake_email"
+	// ColumnTransformFakePhone replaces the digits of a phone number, keeping its format
+	ColumnTransformFakePhone ColumnTransformType = "fake_phone"
+	// ColumnTransformTokenize writes a token keyed by the transform secret
+	ColumnTransformTokenize ColumnTransformType = "tokenize"
+)
+
+// ColumnTransform rewrites the values of one column before they reach the
+// destination, in both the snapshot and CDC
+type ColumnTransform struct {
+	Column string
+	Type   ColumnTransformType
+	Value  string // For fixed
+	Length int    // For truncate and redact
+}
+
 // CDCFlowState represents the state of a CDC workflow
 type CDCFlowState struct {
 	MirrorName string
@@ -79,6 +135,9 @@ type TableMapping struct {
 	DestinationTable  string
 	PartitionKey      string
 	ExcludeColumns    []string
+	RowFilter         string // SQL predicate selecting the rows to replicate
+	ColumnTransforms  []ColumnTransform
+	Mode              TableMode // Empty means TableModeMirror
 }
 
 // FullSourceName returns the full source table name
@@ -91,6 +150,12 @@ func (t *TableMapping) FullDestinationName() string {
 	return t.DestinationSchema + "." + t.DestinationTable
 }
 
+// MirrorsRows reports whether the destination table holds one row per source
+// row, so that the source's indexes and foreign keys apply to it
+func (t *TableMapping) MirrorsRows() bool {
+	return t.Mode == "" || t.Mode == TableModeMirror
+}
+
 // NewCDCFlowState creates a new CDC flow state
 func NewCDCFlowState(mirrorName string) *CDCFlowState {
 	now := time.Now()
@@ -29,6 +29,9 @@ type Config struct {
 	JWTSecret     string
 	AdminUser     string
 	AdminPassword string
+
+	// TransformSecret keys tokenized and faked column values
+	TransformSecret string
 }
 
 // LoadConfig loads configuration from environment variables
@@ -45,6 +48,7 @@ func LoadConfig() (*Config, error) {
 		JWTSecret:         getEnvOrDefault("BUNNY_JWT_SECRET", ""),
 		AdminUser:         getEnvOrDefault("BUNNY_ADMIN_USER", "admin"),
 		AdminPassword:     getEnvOrDefault("BUNNY_ADMIN_PASSWORD", ""),
+		TransformSecret:   getEnvOrDefault("BUNNY_TRANSFORM_SECRET", ""),
 	}
 
 	// Generate a random JWT secret if not provided
@@ -48,6 +48,32 @@ type CDCFlowInput struct {
 
 	// Resync strategy: "truncate" (default) or "swap" (zero-downtime)
 	ResyncStrategy model.ResyncStrategy
+
+	// IgnoreTruncate skips source TRUNCATEs so the destination keeps its rows
+	IgnoreTruncate bool
+
+	// StreamLargeTransactions streams in-progress transactions from the source
+	StreamLargeTransactions bool
+
+	// BinaryFormat replicates column values in binary format as typed values
+	BinaryFormat bool
+
+	// ApplyErrorPolicy decides what happens to records that fail to apply
+	ApplyErrorPolicy model.ApplyErrorPolicy
+
+	// SetReplicaIdentityFull fixes source tables without a replica identity
+	SetReplicaIdentityFull bool
+
+	// BulkApply applies each batch through COPY into staging tables
+	BulkApply bool
+
+	// ApplyWorkers applies changes in parallel, sharded by primary key
+	ApplyWorkers uint32
+
+	// Mirror columns added to destination tables: a soft delete flag set
+	// instead of deleting rows, and the time each row was last applied
+	SoftDeleteColName string
+	SyncedAtColName   string
 }
 
 // CDCFlowWorkflow is the main CDC replication workflow
@@ -176,7 +202,8 @@ func CDCFlowWorkflow(
 			DestinationPeer:      input.DestinationPeer,
 			TableMappings:        input.TableMappings,
 			ReplicateIndexes:     input.ReplicateIndexes,
-			ReplicateForeignKeys: input.ReplicateForeignKeys,
+			ReplicateForeignKeys:   input.ReplicateForeignKeys,
+			SetReplicaIdentityFull: input.SetReplicaIdentityFull,
 		}).Get(setupCtx, &setupOutput)
 
 		if err != nil {
@@ -212,6 +239,8 @@ func CDCFlowWorkflow(
 				NumTablesInParallel:         input.SnapshotNumTablesInParallel,
 				ReplicateIndexes:            input.ReplicateIndexes,
 				ReplicateForeignKeys:        input.ReplicateForeignKeys,
+				SoftDeleteColName:           input.SoftDeleteColName,
+				SyncedAtColName:             input.SyncedAtColName,
 			}).Get(snapshotCtx, nil)
 
 			if err != nil {
@@ -241,15 +270,23 @@ func CDCFlowWorkflow(
 
 	// Start sync activity
 	syncFuture := workflow.ExecuteActivity(syncCtx, activities.SyncFlowActivity, &activities.SyncInput{
-		MirrorName:      input.MirrorName,
-		SourcePeer:      input.SourcePeer,
-		DestinationPeer: input.DestinationPeer,
-		SlotName:        state.SlotName,
-		PublicationName: state.PublicationName,
-		LastLSN:         state.LastLSN,
-		BatchSize:       state.SyncFlowOptions.BatchSize,
-		IdleTimeout:     state.SyncFlowOptions.IdleTimeoutSeconds,
-		TableMappings:   state.SyncFlowOptions.TableMappings,
+		MirrorName:              input.MirrorName,
+		SourcePeer:              input.SourcePeer,
+		DestinationPeer:         input.DestinationPeer,
+		SlotName:                state.SlotName,
+		PublicationName:         state.PublicationName,
+		LastLSN:                 state.LastLSN,
+		BatchSize:               state.SyncFlowOptions.BatchSize,
+		IdleTimeout:             state.SyncFlowOptions.IdleTimeoutSeconds,
+		TableMappings:           state.SyncFlowOptions.TableMappings,
+		IgnoreTruncate:          input.IgnoreTruncate,
+		StreamLargeTransactions: input.StreamLargeTransactions,
+		BinaryFormat:            input.BinaryFormat,
+		ApplyErrorPolicy:        input.ApplyErrorPolicy,
+		BulkApply:               input.BulkApply,
+		ApplyWorkers:            input.ApplyWorkers,
+		SoftDeleteColName:       input.SoftDeleteColName,
+		SyncedAtColName:         input.SyncedAtColName,
 	})
 	_ = cancelSync // Will be used in signal handlers
 
@@ -418,11 +455,13 @@ func CDCFlowWorkflow(
 
 		var syncOutput activities.SyncSchemaOutput
 		err := workflow.ExecuteActivity(syncSchemaCtx, activities.SyncSchemaActivity, &activities.SyncSchemaInput{
-			MirrorName:       input.MirrorName,
-			SourcePeer:       input.SourcePeer,
-			DestinationPeer:  input.DestinationPeer,
-			TableMappings:    input.TableMappings,
-			ReplicateIndexes: input.ReplicateIndexes,
+			MirrorName:        input.MirrorName,
+			SourcePeer:        input.SourcePeer,
+			DestinationPeer:   input.DestinationPeer,
+			TableMappings:     input.TableMappings,
+			ReplicateIndexes:  input.ReplicateIndexes,
+			SoftDeleteColName: input.SoftDeleteColName,
+			SyncedAtColName:   input.SyncedAtColName,
 		}).Get(ctx, &syncOutput)
 		if err != nil {
 			logger.Error("schema sync activity failed", slog.Any("error", err))
@@ -437,6 +476,16 @@ func CDCFlowWorkflow(
 		})
 	}
 
+	// A record failed under the halt policy: pause the mirror until an operator
+	// resumes it, rather than retrying automatically
+	var appErr *temporal.ApplicationError
+	if syncErr != nil && errors.As(syncErr, &appErr) && appErr.Type() == activities.ErrTypeApplyHalted {
+		logger.Error("mirror halted on apply failure", slog.Any("error", syncErr))
+		state.ActiveSignal = model.PauseSignal
+		state.UpdateStatus(model.MirrorStatusPaused)
+		return state, workflow.NewContinueAsNewError(ctx, CDCFlowWorkflow, input, state)
+	}
+
 	// Handle sync error with backoff
 	if syncErr != nil {
 		// Calculate backoff based on error count
@@ -22,6 +22,15 @@ type TableResyncInput struct {
 	CDCState        *model.CDCFlowState
 }
 
+// mirrorColumns returns the soft-delete and synced-at column names of the
+// mirror, or none when the resync was started without its CDC input
+func mirrorColumns(cdcInput *CDCFlowInput) (softDeleteCol, syncedAtCol string) {
+	if cdcInput == nil {
+		return "", ""
+	}
+	return cdcInput.SoftDeleteColName, cdcInput.SyncedAtColName
+}
+
 // TableResyncWorkflow resyncs a single table without disrupting the full mirror
 func TableResyncWorkflow(ctx workflow.Context, input *TableResyncInput) error {
 	logger := workflow.GetLogger(ctx)
@@ -52,11 +61,23 @@ func TableResyncWorkflow(ctx workflow.Context, input *TableResyncInput) error {
 		return fmt.Errorf("table %s not found in mirror configuration", input.TableName)
 	}
 
+	// A changelog table holds the changes already recorded, which a copy of
+	// the source cannot restore; CDC carries on as before
+	if tableMapping.Mode == model.TableModeChangelog {
+		logger.Warn("changelog tables are not resynced", slog.String("table", input.TableName))
+		input.CDCState.ActiveSignal = model.NoopSignal
+		input.CDCState.ResyncTableName = ""
+		input.CDCState.UpdateStatus(model.MirrorStatusRunning)
+		return workflow.NewContinueAsNewError(ctx, CDCFlowWorkflow, input.CDCInput, input.CDCState)
+	}
+
 	// Branch based on resync strategy
 	if input.CDCInput != nil && input.CDCInput.ResyncStrategy == model.ResyncStrategySwap {
 		return tableResyncSwap(ctx, input, tableMapping)
 	}
 
+	softDeleteCol, syncedAtCol := mirrorColumns(input.CDCInput)
+
 	// Step 1: Mark table as resyncing in catalog
 	err := workflow.ExecuteActivity(ctx, activities.UpdateTableSyncStatusActivity, &activities.UpdateTableStatusInput{
 		MirrorName: input.MirrorName,
@@ -92,10 +113,12 @@ func TableResyncWorkflow(ctx workflow.Context, input *TableResyncInput) error {
 	// Step 4: Copy table data
 	logger.Info("copying table data", slog.String("table", input.TableName))
 	err = workflow.ExecuteActivity(ctx, activities.CopyTableActivity, &activities.CopyTableInput{
-		MirrorName:      input.MirrorName,
-		SourcePeer:      input.SourcePeer,
-		DestinationPeer: input.DestinationPeer,
-		TableMapping:    *tableMapping,
+		MirrorName:        input.MirrorName,
+		SourcePeer:        input.SourcePeer,
+		DestinationPeer:   input.DestinationPeer,
+		TableMapping:      *tableMapping,
+		SoftDeleteColName: softDeleteCol,
+		SyncedAtColName:   syncedAtCol,
 	}).Get(ctx, nil)
 	if err != nil {
 		// Mark as error and continue with CDC
@@ -121,18 +144,21 @@ func TableResyncWorkflow(ctx workflow.Context, input *TableResyncInput) error {
 		logger.Warn("failed to create indexes", slog.Any("error", err))
 	}
 
-	// Step 7: Recreate FKs for this table
-	logger.Info("recreating foreign keys for table", slog.String("table", input.TableName))
-	err = workflow.ExecuteActivity(ctx, activities.RecreateTableForeignKeysActivity, &activities.RecreateTableFKInput{
-		MirrorName:      input.MirrorName,
-		SourcePeer:      input.SourcePeer,
-		DestinationPeer: input.DestinationPeer,
-		TableName:       tableMapping.FullDestinationName(),
-		MakeDeferrable:  true,
-		Validate:        true,
-	}).Get(ctx, nil)
-	if err != nil {
-		logger.Warn("failed to recreate FKs", slog.Any("error", err))
+	// Step 7: Recreate FKs for this table; history tables keep rows whose
+	// references are gone and take no FKs
+	if tableMapping.MirrorsRows() {
+		logger.Info("recreating foreign keys for table", slog.String("table", input.TableName))
+		err = workflow.ExecuteActivity(ctx, activities.RecreateTableForeignKeysActivity, &activities.RecreateTableFKInput{
+			MirrorName:      input.MirrorName,
+			SourcePeer:      input.SourcePeer,
+			DestinationPeer: input.DestinationPeer,
+			TableName:       tableMapping.FullDestinationName(),
+			MakeDeferrable:  true,
+			Validate:        true,
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Warn("failed to recreate FKs", slog.Any("error", err))
+		}
 	}
 
 	// Step 8: Mark table as synced
@@ -164,6 +190,8 @@ func TableResyncWorkflow(ctx workflow.Context, input *TableResyncInput) error {
 // populating it, then atomically swapping it into place.
 func tableResyncSwap(ctx workflow.Context, input *TableResyncInput, tableMapping *model.TableMapping) error {
 	logger := workflow.GetLogger(ctx)
+	softDeleteCol, syncedAtCol := mirrorColumns(input.CDCInput)
+
 	// Step 1: Mark table as resyncing
 	err := workflow.ExecuteActivity(ctx, activities.UpdateTableSyncStatusActivity, &activities.UpdateTableStatusInput{
 		MirrorName: input.MirrorName,
@@ -177,10 +205,12 @@ func tableResyncSwap(ctx workflow.Context, input *TableResyncInput, tableMapping
 	// Step 2: Create _resync shadow table (drops existing one first)
 	logger.Info("creating resync shadow table", slog.String("table", input.TableName))
 	err = workflow.ExecuteActivity(ctx, activities.CreateResyncTableActivity, &activities.CreateResyncTableInput{
-		MirrorName:      input.MirrorName,
-		SourcePeer:      input.SourcePeer,
-		DestinationPeer: input.DestinationPeer,
-		TableMapping:    *tableMapping,
+		MirrorName:        input.MirrorName,
+		SourcePeer:        input.SourcePeer,
+		DestinationPeer:   input.DestinationPeer,
+		TableMapping:      *tableMapping,
+		SoftDeleteColName: softDeleteCol,
+		SyncedAtColName:   syncedAtCol,
 	}).Get(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create resync table: %w", err)
@@ -194,10 +224,12 @@ func tableResyncSwap(ctx workflow.Context, input *TableResyncInput, tableMapping
 		slog.String("source", tableMapping.FullSourceName()),
 		slog.String("dest", resyncMapping.FullDestinationName()))
 	err = workflow.ExecuteActivity(ctx, activities.CopyTableActivity, &activities.CopyTableInput{
-		MirrorName:      input.MirrorName,
-		SourcePeer:      input.SourcePeer,
-		DestinationPeer: input.DestinationPeer,
-		TableMapping:    resyncMapping,
+		MirrorName:        input.MirrorName,
+		SourcePeer:        input.SourcePeer,
+		DestinationPeer:   input.DestinationPeer,
+		TableMapping:      resyncMapping,
+		SoftDeleteColName: softDeleteCol,
+		SyncedAtColName:   syncedAtCol,
 	}).Get(ctx, nil)
 	if err != nil {
 		// Cleanup: drop the resync table on failure
@@ -235,7 +267,7 @@ func tableResyncSwap(ctx workflow.Context, input *TableResyncInput, tableMapping
 		SourcePeer:      input.SourcePeer,
 		DestinationPeer: input.DestinationPeer,
 		TableMapping:    *tableMapping,
-		RecreateFKs:     true,
+		RecreateFKs:     tableMapping.MirrorsRows(),
 	}).Get(ctx, nil)
 	if err != nil {
 		// Cleanup on swap failure
@@ -305,14 +337,26 @@ func FullSwapResyncWorkflow(ctx workflow.Context, input *FullSwapResyncInput) er
 	}
 	ctx = workflow.WithActivityOptions(ctx, activityOpts)
 
-	// Phase 1: Create all _resync shadow tables
+	// Changelog tables keep their recorded changes and are not swapped
+	var tableMappings []model.TableMapping
 	for _, tm := range input.TableMappings {
+		if tm.Mode != model.TableModeChangelog {
+			tableMappings = append(tableMappings, tm)
+		}
+	}
+
+	softDeleteCol, syncedAtCol := mirrorColumns(input.CDCInput)
+
+	// Phase 1: Create all _resync shadow tables
+	for _, tm := range tableMappings {
 		logger.Info("creating resync table", slog.String("table", tm.FullSourceName()))
 		err := workflow.ExecuteActivity(ctx, activities.CreateResyncTableActivity, &activities.CreateResyncTableInput{
-			MirrorName:      input.MirrorName,
-			SourcePeer:      input.SourcePeer,
-			DestinationPeer: input.DestinationPeer,
-			TableMapping:    tm,
+			MirrorName:        input.MirrorName,
+			SourcePeer:        input.SourcePeer,
+			DestinationPeer:   input.DestinationPeer,
+			TableMapping:      tm,
+			SoftDeleteColName: softDeleteCol,
+			SyncedAtColName:   syncedAtCol,
 		}).Get(ctx, nil)
 		if err != nil {
 			return fmt.Errorf("failed to create resync table for %s: %w", tm.FullSourceName(), err)
@@ -320,20 +364,22 @@ func FullSwapResyncWorkflow(ctx workflow.Context, input *FullSwapResyncInput) er
 	}
 
 	// Phase 2: Copy data into all _resync tables
-	for _, tm := range input.TableMappings {
+	for _, tm := range tableMappings {
 		resyncMapping := tm
 		resyncMapping.DestinationTable = tm.DestinationTable + "_resync"
 
 		logger.Info("copying data to resync table", slog.String("table", tm.FullSourceName()))
 		err := workflow.ExecuteActivity(ctx, activities.CopyTableActivity, &activities.CopyTableInput{
-			MirrorName:      input.MirrorName,
-			SourcePeer:      input.SourcePeer,
-			DestinationPeer: input.DestinationPeer,
-			TableMapping:    resyncMapping,
+			MirrorName:        input.MirrorName,
+			SourcePeer:        input.SourcePeer,
+			DestinationPeer:   input.DestinationPeer,
+			TableMapping:      resyncMapping,
+			SoftDeleteColName: softDeleteCol,
+			SyncedAtColName:   syncedAtCol,
 		}).Get(ctx, nil)
 		if err != nil {
 			// Cleanup all resync tables on failure
-			for _, cleanTm := range input.TableMappings {
+			for _, cleanTm := range tableMappings {
 				_ = workflow.ExecuteActivity(ctx, activities.DropResyncTableActivity, &activities.DropResyncTableInput{
 					MirrorName:      input.MirrorName,
 					DestinationPeer: input.DestinationPeer,
@@ -345,7 +391,7 @@ func FullSwapResyncWorkflow(ctx workflow.Context, input *FullSwapResyncInput) er
 	}
 
 	// Phase 3: Create indexes on all _resync tables
-	for _, tm := range input.TableMappings {
+	for _, tm := range tableMappings {
 		resyncMapping := tm
 		resyncMapping.DestinationTable = tm.DestinationTable + "_resync"
 
@@ -363,14 +409,14 @@ func FullSwapResyncWorkflow(ctx workflow.Context, input *FullSwapResyncInput) er
 	}
 
 	// Phase 4: Swap all tables atomically (one by one — each swap is atomic per table)
-	for _, tm := range input.TableMappings {
+	for _, tm := range tableMappings {
 		logger.Info("swapping table", slog.String("table", tm.FullDestinationName()))
 		err := workflow.ExecuteActivity(ctx, activities.SwapTablesActivity, &activities.SwapTablesInput{
 			MirrorName:      input.MirrorName,
 			SourcePeer:      input.SourcePeer,
 			DestinationPeer: input.DestinationPeer,
 			TableMapping:    tm,
-			RecreateFKs:     true,
+			RecreateFKs:     tm.MirrorsRows(),
 		}).Get(ctx, nil)
 		if err != nil {
 			return fmt.Errorf("failed to swap table %s: %w", tm.FullDestinationName(), err)
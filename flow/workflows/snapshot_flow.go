@@ -25,6 +25,8 @@ type SnapshotFlowInput struct {
 	NumTablesInParallel uint32
 	ReplicateIndexes    bool
 	ReplicateForeignKeys bool
+	SoftDeleteColName   string
+	SyncedAtColName     string
 }
 
 // SnapshotFlowWorkflow performs the initial snapshot
@@ -152,8 +154,11 @@ func SnapshotFlowWorkflow(ctx workflow.Context, input *SnapshotFlowInput) error
 			DestinationPeer:     input.DestinationPeer,
 			TableMapping:        mapping,
 			SnapshotName:        snapshotName, // Use the snapshot from our long-lived session
+			SnapshotLSN:         snapshotOutput.SnapshotLSN,
 			NumRowsPerPartition: input.NumRowsPerPartition,
 			MaxParallelWorkers:  input.MaxParallelWorkers,
+			SoftDeleteColName:   input.SoftDeleteColName,
+			SyncedAtColName:     input.SyncedAtColName,
 		})
 		childFutures = append(childFutures, future)
 	}
@@ -240,8 +245,11 @@ type CloneTableInput struct {
 	DestinationPeer     string
 	TableMapping        model.TableMapping
 	SnapshotName        string
+	SnapshotLSN         int64
 	NumRowsPerPartition uint32
 	MaxParallelWorkers  uint32
+	SoftDeleteColName   string
+	SyncedAtColName     string
 }
 
 // CloneTableWorkflow clones a single table from source to destination
@@ -278,11 +286,14 @@ func CloneTableWorkflow(ctx workflow.Context, input *CloneTableInput) error {
 		logger.Info("copying table without partitioning", slog.String("table", tableName))
 
 		err := workflow.ExecuteActivity(ctx, activities.CopyTableActivity, &activities.CopyTableInput{
-			MirrorName:      input.MirrorName,
-			SourcePeer:      input.SourcePeer,
-			DestinationPeer: input.DestinationPeer,
-			TableMapping:    input.TableMapping,
-			SnapshotName:    input.SnapshotName,
+			MirrorName:        input.MirrorName,
+			SourcePeer:        input.SourcePeer,
+			DestinationPeer:   input.DestinationPeer,
+			TableMapping:      input.TableMapping,
+			SnapshotName:      input.SnapshotName,
+			SnapshotLSN:       input.SnapshotLSN,
+			SoftDeleteColName: input.SoftDeleteColName,
+			SyncedAtColName:   input.SyncedAtColName,
 		}).Get(ctx, nil)
 
 		if err != nil {
@@ -299,16 +310,19 @@ func CloneTableWorkflow(ctx workflow.Context, input *CloneTableInput) error {
 		var partitionFutures []workflow.Future
 		for i := uint32(0); i < partitions.NumPartitions; i++ {
 			future := workflow.ExecuteActivity(ctx, activities.CopyPartitionActivity, &activities.CopyPartitionInput{
-				MirrorName:      input.MirrorName,
-				SourcePeer:      input.SourcePeer,
-				DestinationPeer: input.DestinationPeer,
-				TableMapping:    input.TableMapping,
-				SnapshotName:    input.SnapshotName,
-				PartitionKey:    partitions.PartitionKey,
-				PartitionNum:    i,
-				TotalPartitions: partitions.NumPartitions,
-				MinValue:        partitions.MinValue,
-				MaxValue:        partitions.MaxValue,
+				MirrorName:        input.MirrorName,
+				SourcePeer:        input.SourcePeer,
+				DestinationPeer:   input.DestinationPeer,
+				TableMapping:      input.TableMapping,
+				SnapshotName:      input.SnapshotName,
+				SnapshotLSN:       input.SnapshotLSN,
+				PartitionKey:      partitions.PartitionKey,
+				PartitionNum:      i,
+				TotalPartitions:   partitions.NumPartitions,
+				MinValue:          partitions.MinValue,
+				MaxValue:          partitions.MaxValue,
+				SoftDeleteColName: input.SoftDeleteColName,
+				SyncedAtColName:   input.SyncedAtColName,
 			})
 			partitionFutures = append(partitionFutures, future)
 		}
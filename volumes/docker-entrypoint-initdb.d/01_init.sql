@@ -43,6 +43,8 @@ CREATE TABLE IF NOT EXISTS bunny_internal.table_mappings (
     destination_table VARCHAR(255) NOT NULL,
     partition_key VARCHAR(255),
     exclude_columns TEXT[],
+    row_filter TEXT,
+    mode VARCHAR(32),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     UNIQUE(mirror_id, source_schema, source_table)
 );
@@ -100,6 +102,7 @@ CREATE TABLE IF NOT EXISTS bunny_internal.mirror_state (
     last_lsn BIGINT DEFAULT 0,
     last_sync_batch_id BIGINT DEFAULT 0,
     last_normalize_batch_id BIGINT DEFAULT 0,
+    destination_offsets JSONB,  -- Positions assigned by the destination, e.g. Kafka offsets
     status VARCHAR(50) DEFAULT 'CREATED',
     error_message TEXT,
     error_count INT DEFAULT 0,
@@ -142,6 +145,7 @@ CREATE TABLE IF NOT EXISTS bunny_stats.table_sync_status (
     rows_updated BIGINT DEFAULT 0,
     last_synced_at TIMESTAMP WITH TIME ZONE,
     last_resync_requested_at TIMESTAMP WITH TIME ZONE,
+    copy_lsn BIGINT,  -- Source WAL position the table's rows were last copied at
     error_message TEXT,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
@@ -158,6 +162,23 @@ CREATE TABLE IF NOT EXISTS bunny_stats.mirror_logs (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- Dead-letter queue: CDC records that failed to apply, kept for retry or discard
+CREATE TABLE IF NOT EXISTS bunny_internal.dead_letter_queue (
+    id BIGSERIAL PRIMARY KEY,
+    mirror_name VARCHAR(255) NOT NULL,
+    table_name VARCHAR(512) NOT NULL,  -- Source schema.table
+    operation VARCHAR(20) NOT NULL,    -- INSERT, UPDATE, DELETE, TRUNCATE
+    lsn BIGINT NOT NULL,
+    commit_lsn BIGINT,
+    xid BIGINT,
+    payload JSONB NOT NULL,            -- Record values and destination target
+    error_message TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 1,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING',  -- PENDING, RESOLVED, DISCARDED
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
 -- Users table: stores authentication credentials
 CREATE TABLE IF NOT EXISTS bunny_internal.users (
     id SERIAL PRIMARY KEY,
@@ -175,6 +196,7 @@ CREATE INDEX IF NOT EXISTS idx_table_sync_status_mirror ON bunny_stats.table_syn
 CREATE INDEX IF NOT EXISTS idx_index_definitions_mirror ON bunny_internal.index_definitions(mirror_name, table_name);
 CREATE INDEX IF NOT EXISTS idx_fk_definitions_mirror ON bunny_internal.fk_definitions(mirror_name, source_table);
 CREATE INDEX IF NOT EXISTS idx_mirror_logs_mirror ON bunny_stats.mirror_logs(mirror_name, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_dead_letter_queue_mirror ON bunny_internal.dead_letter_queue(mirror_name, status, id);
 
 -- Functions
 
@@ -204,6 +226,10 @@ CREATE TRIGGER tr_table_sync_status_updated_at
     BEFORE UPDATE ON bunny_stats.table_sync_status
     FOR EACH ROW EXECUTE FUNCTION bunny_internal.update_updated_at();
 
+CREATE TRIGGER tr_dead_letter_queue_updated_at
+    BEFORE UPDATE ON bunny_internal.dead_letter_queue
+    FOR EACH ROW EXECUTE FUNCTION bunny_internal.update_updated_at();
+
 -- Grant permissions (for non-superuser access if needed)
 GRANT ALL ON SCHEMA bunny_internal TO postgres;
 GRANT ALL ON SCHEMA bunny_stats TO postgres;